@@ -0,0 +1,61 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(p)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		line := fmt.Sprintf("%s %s \"%s %s\" %d %d %s %s",
+			clientIP(r), requestIdentity(r), r.Method, r.URL.RequestURI(),
+			rec.status, rec.bytes, time.Since(start).Round(time.Millisecond), requestID(r))
+		if s.vhost != "" {
+			line += " vhost=" + s.vhost
+		}
+		s.accessLogger.Print(line)
+	})
+}
+
+func (s *Server) ReopenLogs() {
+	if s.accessLogFile != nil {
+		if err := s.accessLogFile.Reopen(); err != nil {
+			errorf(areaServer, "Failed to reopen access log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// The users file (-users-file) names users with their credentials and
+// lists rules granting them access to path prefixes:
+//
+//	{
+//	  "users": {
+//	    "alice": {"password": "pbkdf2-sha256$600000$...", "tokens": ["sha256:9f86d0..."]},
+//	    "bob":   {"password": "pbkdf2-sha256$600000$..."}
+//	  },
+//	  "rules": [
+//	    {"user": "alice", "path": "/incoming", "access": "write"},
+//	    {"user": "alice", "path": "/", "access": "read"},
+//	    {"user": "bob", "path": "/public", "access": "read"}
+//	  ]
+//	}
+//
+// Rules are tried in order and the first whose user and path match wins;
+// without one, access is denied. "user" is a name, "@authenticated" for
+// any user, "*" for everyone including anonymous requests, or
+// "group:NAME" for members of a group from OIDC or an auth proxy. With
+// either, names not in "users" are identities from there. Passwords are
+// HashPassword hashes; tokens, sent as "Authorization: Bearer", are
+// stored as sha256:HEX of the token.
+type usersFile struct {
+	Users map[string]aclUser `json:"users"`
+	Rules []aclRule          `json:"rules"`
+}
+
+type aclUser struct {
+	Password string   `json:"password"`
+	Tokens   []string `json:"tokens"`
+}
+
+type aclRule struct {
+	User   string `json:"user"`
+	Path   string `json:"path"`
+	Access string `json:"access"`
+}
+
+type aclAccess int
+
+const (
+	aclDeny aclAccess = iota
+	aclRead
+	aclWrite // implies read
+)
+
+var aclAccessNames = map[string]aclAccess{"deny": aclDeny, "read": aclRead, "write": aclWrite}
+
+// accessList is a loaded users file; it is replaced whole on reload.
+type accessList struct {
+	file     string
+	external bool // OIDC or auth proxy identities may appear in rules
+	users    map[string]aclUser
+	rules    []aclRule
+	access   []aclAccess // per rule
+}
+
+func loadAccessList(file string, external bool) (*accessList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %v", err)
+	}
+	var parsed usersFile
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %v", file, err)
+	}
+	l := &accessList{file: file, external: external, users: parsed.Users, rules: parsed.Rules}
+	for i, rule := range parsed.Rules {
+		access, ok := aclAccessNames[rule.Access]
+		if !ok {
+			return nil, fmt.Errorf("users file rule %d: unknown access %q, want read, write or deny", i+1, rule.Access)
+		}
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("users file rule %d: path %q must start with /", i+1, rule.Path)
+		}
+		_, known := l.users[rule.User]
+		if !known && !external && rule.User != "*" && rule.User != "@authenticated" && !strings.HasPrefix(rule.User, "group:") {
+			return nil, fmt.Errorf("users file rule %d: unknown user %q", i+1, rule.User)
+		}
+		l.rules[i].Path = path.Clean(rule.Path)
+		l.access = append(l.access, access)
+	}
+	for name, u := range l.users {
+		for _, token := range u.Tokens {
+			if _, err := hex.DecodeString(strings.TrimPrefix(token, "sha256:")); err != nil || !strings.HasPrefix(token, "sha256:") {
+				return nil, fmt.Errorf("users file: token of %s must be sha256:HEX", name)
+			}
+		}
+	}
+	return l, nil
+}
+
+// aclSubject is who a request is: a user name ("" when anonymous) and
+// the groups an OIDC login or auth proxy reported.
+type aclSubject struct {
+	user   string
+	groups []string
+}
+
+func (l *accessList) ruleMatches(rule aclRule, who aclSubject) bool {
+	switch rule.User {
+	case "*":
+		return true
+	case "@authenticated":
+		return who.user != ""
+	}
+	if group, ok := strings.CutPrefix(rule.User, "group:"); ok {
+		return slices.Contains(who.groups, group)
+	}
+	return rule.User == who.user
+}
+
+// decide returns the access who has to requestPath and the index of the
+// deciding rule, -1 when none matched.
+func (l *accessList) decide(who aclSubject, requestPath string) (aclAccess, int) {
+	for i, rule := range l.rules {
+		if l.ruleMatches(rule, who) && underPrefix(requestPath, rule.Path) {
+			return l.access[i], i
+		}
+	}
+	return aclDeny, -1
+}
+
+// describe names rule i for logs.
+func (l *accessList) describe(i int) string {
+	if i < 0 {
+		return "no matching rule"
+	}
+	r := l.rules[i]
+	return fmt.Sprintf("rule %d (%s %s %s)", i+1, r.User, r.Path, r.Access)
+}
+
+// visible reports whether an entry shows up in listings for who: it is
+// readable, or a directory some rule grants access below, so users can
+// browse down to what they may read.
+func (l *accessList) visible(who aclSubject, requestPath string, isDir bool) bool {
+	if access, _ := l.decide(who, requestPath); access >= aclRead {
+		return true
+	}
+	if !isDir {
+		return false
+	}
+	for i, rule := range l.rules {
+		if l.access[i] >= aclRead && l.ruleMatches(rule, who) && !samePath(rule.Path, requestPath) && underPrefix(rule.Path, requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword and checkToken authenticate against the users file.
+func (l *accessList) checkPassword(user, password string) bool {
+	u, ok := l.users[user]
+	return ok && u.Password != "" && checkPassword(u.Password, password)
+}
+
+func (l *accessList) checkToken(token string) (string, bool) {
+	sum := sha256.Sum256([]byte(token))
+	want := "sha256:" + hex.EncodeToString(sum[:])
+	for name, u := range l.users {
+		for _, t := range u.Tokens {
+			if subtle.ConstantTimeCompare([]byte(strings.ToLower(t)), []byte(want)) == 1 {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// aclAuth is the AuthFunc of the users file. Requests without credentials
+// pass as anonymous; the rules decide what they may see.
+func (s *Server) aclAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
+	l := s.acl.Load()
+	if user, password, ok := r.BasicAuth(); ok {
+		if l.checkPassword(user, password) {
+			return user, true
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="fileserver"`)
+		return "", false
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if user, ok := l.checkToken(token); ok {
+			return user, true
+		}
+		return "", false
+	}
+	return "", true
+}
+
+// ReloadUsers re-reads the users files of the server and its virtual
+// hosts (on SIGHUP). A file that fails to load leaves the previous rules
+// in place.
+func (s *Server) ReloadUsers() error {
+	var errs []error
+	for _, host := range s.virtualHostNames() {
+		if err := s.vhosts[host].ReloadUsers(); err != nil {
+			errs = append(errs, fmt.Errorf("virtual host %s: %v", host, err))
+		}
+	}
+	if err := s.reloadUsers(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) reloadUsers() error {
+	old := s.acl.Load()
+	if old == nil {
+		return nil
+	}
+	l, err := loadAccessList(old.file, old.external)
+	if err != nil {
+		return err
+	}
+	s.acl.Store(l)
+	infof(areaAuth, "Reloaded users file %s: %d users, %d rules", l.file, len(l.users), len(l.rules))
+	return nil
+}
+
+// authorize is the one check of the users file every handler makes before
+// touching requestPath; PUT asks for aclWrite. A denied anonymous request
+// is asked to log in; a denied user gets 403, with the deciding rule in the
+// log.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, fail replyFunc, requestPath string, want aclAccess) bool {
+	l := s.acl.Load()
+	if l == nil {
+		return true
+	}
+	info := getRequestInfo(r)
+	user := info.identity
+	who := aclSubject{user: user, groups: info.groups}
+	access, rule := l.decide(who, requestPath)
+	// Reading extends to directories leading to something readable, whose
+	// listings then show only that; no rule grants access below a file,
+	// so treating every path as a possible directory is safe
+	if access >= want || want == aclRead && l.visible(who, requestPath, true) {
+		return true
+	}
+	detail := fmt.Sprintf("%s denied to %q by %s", requestPath, user, l.describe(rule))
+	if user == "" {
+		if s.sessions != nil && strings.Contains(r.Header.Get("Accept"), "text/html") && r.Method == http.MethodGet {
+			logHTTPError(r, areaAuth, http.StatusUnauthorized, "Login required", detail)
+			s.redirectToLogin(w, r)
+			return false
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="fileserver"`)
+		fail(w, r, areaAuth, http.StatusUnauthorized, "Authentication required", detail)
+		return false
+	}
+	fail(w, r, areaAuth, http.StatusForbidden, "Access denied", detail)
+	return false
+}
+
+// readable filters entries by the users file for the requester.
+func (s *Server) readable(r *http.Request, entries []apiEntry) []apiEntry {
+	visible := s.aclVisibleFunc(r.Context())
+	if visible == nil {
+		return entries
+	}
+	kept := []apiEntry{}
+	for _, e := range entries {
+		if visible(e.Path, e.Type == "dir") {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// aclVisibleFunc returns the listing filter for the requester in ctx, nil
+// when every entry is visible.
+func (s *Server) aclVisibleFunc(ctx context.Context) func(requestPath string, isDir bool) bool {
+	l := s.acl.Load()
+	if l == nil || ctx.Value(aclUnfilteredKey) != nil {
+		return nil
+	}
+	var who aclSubject
+	user, ok := ctx.Value(aclUserKey).(string)
+	if info, isReq := ctx.Value(requestInfoKey).(*requestInfo); !ok && isReq {
+		who = aclSubject{user: info.identity, groups: info.groups}
+	} else {
+		who.user = user
+	}
+	return func(requestPath string, isDir bool) bool {
+		return l.visible(who, requestPath, isDir)
+	}
+}
@@ -0,0 +1,78 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestAccessList(t *testing.T, content string) *accessList {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := loadAccessList(file, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestACLDecide(t *testing.T) {
+	l := loadTestAccessList(t, `{"users": {"al": {}, "bo": {}}, "rules": [
+		{"user": "*", "path": "/private", "access": "deny"},
+		{"user": "al", "path": "/shared/al", "access": "write"},
+		{"user": "@authenticated", "path": "/shared", "access": "read"},
+		{"user": "*", "path": "/", "access": "read"}]}`)
+
+	// On case-insensitive platforms other spellings name the same files
+	// and must be decided alike
+	caseVariant := aclRead
+	if caseInsensitivePaths {
+		caseVariant = aclDeny
+	}
+	tests := []struct {
+		user, path string
+		want       aclAccess
+	}{
+		{"", "/public/a", aclRead},
+		{"", "/private", aclDeny},
+		{"", "/private/x", aclDeny},
+		{"al", "/private/x", aclDeny},
+		{"", "/private-not/x", aclRead},
+		{"", "/PRIVATE/x", caseVariant},
+		{"", "/Private", caseVariant},
+		{"al", "/shared/al/f", aclWrite},
+		{"bo", "/shared/al/f", aclRead},
+		{"", "/shared/al/f", aclRead},
+	}
+	for _, tt := range tests {
+		if got, _ := l.decide(aclSubject{user: tt.user}, tt.path); got != tt.want {
+			t.Errorf("decide(%q, %q) = %v, want %v", tt.user, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestACLVisible(t *testing.T) {
+	l := loadTestAccessList(t, `{"users": {"al": {}}, "rules": [
+		{"user": "al", "path": "/home/al", "access": "read"}]}`)
+	al := aclSubject{user: "al"}
+	tests := []struct {
+		who   aclSubject
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{al, "/home", true, true},
+		{al, "/home/al", true, true},
+		{al, "/home/bo", true, false},
+		{al, "/home", false, false},
+		{aclSubject{}, "/home", true, false},
+	}
+	for _, tt := range tests {
+		if got := l.visible(tt.who, tt.path, tt.isDir); got != tt.want {
+			t.Errorf("visible(%q, %q, %v) = %v, want %v", tt.who.user, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
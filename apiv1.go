@@ -0,0 +1,501 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The versioned JSON API lives under /_api/v1/ so it never shares a URL
+// with content. Every reply is an envelope carrying the API version and
+// either data or an error.
+const (
+	apiV1Prefix = "/_api/v1/"
+
+	apiDefaultPageSize  = 1000
+	apiMaxPageSize      = 10000
+	apiMaxSearchResults = 1000
+	apiMaxTreeDepth     = 10
+	apiMaxTreeNodes     = 10000
+)
+
+type apiResponse[T any] struct {
+	APIVersion string `json:"apiVersion"`
+	Data       T      `json:"data"`
+}
+
+type apiErrorResponse struct {
+	APIVersion string   `json:"apiVersion"`
+	Error      apiError `json:"error"`
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type apiEntry struct {
+	Name    string    `json:"name"`
+	RawName string    `json:"rawName,omitempty"` // base64 of a name that is not UTF-8
+	Path    string    `json:"path"`
+	Type    string    `json:"type"` // "file" or "dir"
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Entries of a directory with -dir-counts, and whether there are more
+	Items     *int `json:"items,omitempty"`
+	MoreItems bool `json:"moreItems,omitempty"`
+	// Modified within -highlight-recent
+	Recent bool `json:"recent,omitempty"`
+	// Category section with group=type
+	Group string `json:"group,omitempty"`
+	// From a sidecar file with -descriptions
+	Description string `json:"description,omitempty"`
+
+	fields map[string]bool // projection from ?fields=, nil for all
+}
+
+// MarshalJSON applies the ?fields= projection.
+func (e apiEntry) MarshalJSON() ([]byte, error) {
+	type plain apiEntry
+	data, err := json.Marshal(plain(e))
+	if err != nil || e.fields == nil {
+		return data, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for key := range all {
+		if !e.fields[key] {
+			delete(all, key)
+		}
+	}
+	return json.Marshal(all)
+}
+
+var apiEntryFields = map[string]bool{"name": true, "path": true, "type": true, "size": true, "modTime": true, "items": true, "recent": true, "group": true, "description": true}
+
+// parseFields reads the ?fields= projection of entries; nil means all.
+func parseFields(query url.Values) (map[string]bool, error) {
+	list := query.Get("fields")
+	if list == "" {
+		return nil, nil
+	}
+	fields := map[string]bool{}
+	for _, field := range strings.Split(list, ",") {
+		if !apiEntryFields[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		fields[field] = true
+	}
+	// The raw bytes belong with the name they stand for
+	fields["rawName"] = fields["name"]
+	fields["moreItems"] = fields["items"]
+	return fields, nil
+}
+
+// fieldsNeedStat reports whether a projection asks for more than the
+// directory read gives, so entries have to be stat'ed.
+func fieldsNeedStat(fields map[string]bool) bool {
+	return fields == nil || fields["size"] || fields["modTime"] || fields["items"] || fields["recent"]
+}
+
+type apiListing struct {
+	Path       string         `json:"path"`
+	Total      int            `json:"total"` // entries matching the filters
+	Offset     int            `json:"offset"`
+	NextOffset int            `json:"nextOffset,omitempty"`
+	Truncated  bool           `json:"truncated,omitempty"` // recursive walk hit its limits
+	Groups     map[string]int `json:"groups,omitempty"`    // entries per group over all pages with group=type
+	Entries    []apiEntry     `json:"entries"`
+}
+
+type apiSearchResult struct {
+	Query     string     `json:"query"`
+	Path      string     `json:"path"`
+	Results   []apiEntry `json:"results"`
+	Truncated bool       `json:"truncated"`
+}
+
+type apiTreeNode struct {
+	apiEntry
+	Children []*apiTreeNode `json:"children,omitempty"`
+}
+
+type apiTree struct {
+	Root      *apiTreeNode `json:"root"`
+	Truncated bool         `json:"truncated"`
+}
+
+func (s *Server) registerAPIv1(mux *http.ServeMux) {
+	mux.HandleFunc(apiV1Prefix+"list", s.handleAPIList)
+	mux.HandleFunc(apiV1Prefix+"stat", s.handleAPIStat)
+	mux.HandleFunc(apiV1Prefix+"search", s.handleAPISearch)
+	mux.HandleFunc(apiV1Prefix+"tree", s.handleAPITree)
+	mux.HandleFunc(apiV1Prefix+"preview", s.handleAPIPreview)
+	if s.stats != nil {
+		mux.HandleFunc(apiV1Prefix+"stats/top", s.handleAPIStatsTop)
+	}
+	mux.HandleFunc(apiV1Prefix, func(w http.ResponseWriter, r *http.Request) {
+		apiFail(w, r, areaRequest, http.StatusNotFound, "Unknown API endpoint", "")
+	})
+}
+
+func writeAPI[T any](w http.ResponseWriter, r *http.Request, data T) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(w).Encode(apiResponse[T]{APIVersion: "v1", Data: data}); err != nil {
+		reqLogf(r, levelWarn, areaRequest, "Failed to write API response: %v", err)
+	}
+}
+
+// apiFail is httpError for the API: same logging, JSON envelope body.
+func apiFail(w http.ResponseWriter, r *http.Request, area string, status int, message, detail string) {
+	logHTTPError(r, area, status, message, detail)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{APIVersion: "v1", Error: apiError{Status: status, Message: message}})
+}
+
+// apiLookup resolves the path query parameter with the same checks as a
+// content request. On failure it has already replied.
+func (s *Server) apiLookup(w http.ResponseWriter, r *http.Request) (requestPath, fullPath string, info fs.FileInfo, ok bool) {
+	return s.lookupParam(w, r, apiFail)
+}
+
+// lookupParam is apiLookup replying through fail, e.g. httpError.
+func (s *Server) lookupParam(w http.ResponseWriter, r *http.Request,
+	fail replyFunc,
+) (requestPath, fullPath string, info fs.FileInfo, ok bool) {
+	requestPath = cleanURLPath(r.URL.Query().Get("path"))
+	if strings.ContainsRune(requestPath, 0) {
+		fail(w, r, areaRequest, http.StatusBadRequest, "Bad request", "NUL byte in path from "+clientIP(r))
+		return "", "", nil, false
+	}
+	if !s.isPathSafe(requestPath) {
+		fail(w, r, areaRequest, http.StatusForbidden, "Access denied", "unsafe path access attempt from "+clientIP(r))
+		return "", "", nil, false
+	}
+	requestPath = s.canonicalPath(r.Context(), requestPath)
+	if s.hiddenPath(requestPath) {
+		fail(w, r, areaRequest, http.StatusNotFound, "Not found", "hidden path "+requestPath)
+		return "", "", nil, false
+	}
+	if s.mount.unavailable() != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(mountRetryAfter))
+		fail(w, r, areaMount, http.StatusServiceUnavailable, "Storage temporarily unavailable", "")
+		return "", "", nil, false
+	}
+	if dir, hash := s.protectingDir(r.Context(), requestPath); dir != "" && !s.dirUnlocked(r, dir, hash) {
+		fail(w, r, areaAuth, http.StatusUnauthorized, "Passphrase required", requestPath+" is under protected "+dir)
+		return "", "", nil, false
+	}
+	if !s.authorize(w, r, fail, requestPath, aclRead) {
+		return "", "", nil, false
+	}
+
+	fullPath, info, err := s.lookup(r.Context(), requestPath)
+	if err != nil {
+		if s.fsCallFailed(w, r, fail, "stat", fullPath, err) {
+			return "", "", nil, false
+		}
+		if os.IsNotExist(err) {
+			fail(w, r, areaRequest, http.StatusNotFound, "Not found", "")
+		} else if os.IsPermission(err) {
+			fail(w, r, areaIO, http.StatusForbidden, "Access denied", "permission denied: "+fullPath)
+		} else {
+			fail(w, r, areaIO, http.StatusInternalServerError, "Internal server error", fmt.Sprintf("stat %s: %v", fullPath, err))
+		}
+		return "", "", nil, false
+	}
+	return strings.TrimSuffix(requestPath, "/"), fullPath, info, true
+}
+
+// listingEntry is the API form of a listing row under dir, which ends in a
+// slash; f.Name may be a relative path in recursive listings.
+func listingEntry(dir string, f FileInfo, fields map[string]bool) apiEntry {
+	entry := apiEntry{Path: dir + f.Name, Type: "file", Size: f.Size, ModTime: f.ModTime, fields: fields}
+	entry.Name, entry.RawName = apiName(path.Base(f.Name))
+	if f.IsDir {
+		entry.Type, entry.Size = "dir", 0
+	}
+	if f.counted && f.Items >= 0 {
+		entry.Items, entry.MoreItems = &f.Items, f.MoreItems
+	}
+	entry.Recent, entry.Group, entry.Description = f.IsRecent, f.Group, f.Description
+	return entry
+}
+
+func newAPIEntry(requestPath string, info fs.FileInfo) apiEntry {
+	entry := apiEntry{Path: requestPath, Type: "file", Size: info.Size(), ModTime: info.ModTime()}
+	entry.Name, entry.RawName = apiName(info.Name())
+	if info.IsDir() {
+		entry.Type = "dir"
+		entry.Size = 0
+	}
+	if requestPath == "" || requestPath == "/" {
+		entry.Name, entry.Path = "", "/"
+	}
+	return entry
+}
+
+func (s *Server) handleAPIStat(w http.ResponseWriter, r *http.Request) {
+	requestPath, _, info, ok := s.apiLookup(w, r)
+	if !ok {
+		return
+	}
+	writeAPI(w, r, newAPIEntry(requestPath, info))
+}
+
+func (s *Server) handleAPIList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, err := strconv.Atoi(query.Get("offset"))
+	if query.Get("offset") == "" {
+		offset, err = 0, nil
+	}
+	if err != nil || offset < 0 {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Invalid offset parameter", query.Get("offset"))
+		return
+	}
+	limit, ok := apiIntParam(w, r, "limit", apiDefaultPageSize, apiMaxPageSize)
+	if !ok {
+		return
+	}
+	dirsOnly, filesOnly := apiBoolParam(r, "dirsOnly"), apiBoolParam(r, "filesOnly")
+	if dirsOnly && filesOnly {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "dirsOnly and filesOnly are exclusive", "")
+		return
+	}
+	filter, err := parseListingFilter(query)
+	if err != nil {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Invalid filter: "+err.Error(), "")
+		return
+	}
+	fields, err := parseFields(query)
+	if err != nil {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Invalid fields: "+err.Error(), "")
+		return
+	}
+	order, err := parseListingOrder(query)
+	if err != nil {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Invalid sort: "+err.Error(), "")
+		return
+	}
+
+	requestPath, fullPath, info, ok := s.apiLookup(w, r)
+	if !ok {
+		return
+	}
+	if !info.IsDir() {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Not a directory", "")
+		return
+	}
+
+	dirCtx, cancel := context.WithTimeout(r.Context(), s.config.Timeouts.DirRead)
+	defer cancel()
+	entries, err := s.dirReader.read(dirCtx, fullPath)
+	if err == errTooManyDirReads {
+		w.Header().Set("Retry-After", "30")
+		apiFail(w, r, areaMount, http.StatusServiceUnavailable, "Storage temporarily unavailable",
+			fmt.Sprintf("%d directory reads outstanding", s.dirReader.outstanding()))
+		return
+	}
+	if dirCtx.Err() != nil {
+		apiFail(w, r, areaListing, http.StatusRequestTimeout, "Request timeout", "directory read timeout for "+fullPath)
+		return
+	}
+	if err != nil {
+		detail := fmt.Sprintf("reading directory %s: %v", fullPath, err)
+		if os.IsPermission(err) {
+			apiFail(w, r, areaListing, http.StatusForbidden, "Access denied", detail)
+		} else {
+			apiFail(w, r, areaListing, http.StatusInternalServerError, "Failed to read directory", detail)
+		}
+		return
+	}
+
+	// Without size or modTime in the projection or the order, entries
+	// need no stat
+	namesOnly := !fieldsNeedStat(fields) && !order.needsStat()
+	files, _, _ := s.listFiles(r, fullPath, requestPath+"/", entries, namesOnly)
+	var truncated bool
+	if apiBoolParam(r, "recursive") {
+		files, truncated = s.expandRecursive(dirCtx, r, fullPath, requestPath+"/", files, namesOnly)
+	} else if !namesOnly {
+		s.countSubdirs(r, fullPath, requestPath+"/", files)
+	}
+	files = filter.apply(files)
+	if dirsOnly || filesOnly {
+		kept := files[:0]
+		for _, f := range files {
+			if f.IsDir == dirsOnly {
+				kept = append(kept, f)
+			}
+		}
+		files = kept
+	}
+	if order.by == "exifdate" {
+		s.setCaptureTimes(dirCtx, r, fullPath, files)
+	}
+	order.apply(files)
+
+	// Out-of-range offsets give an empty page, not an error
+	listing := apiListing{Path: newAPIEntry(requestPath, info).Path, Total: len(files), Offset: offset, Truncated: truncated, Entries: []apiEntry{}}
+	if order.group {
+		listing.Groups = groupCounts(files)
+	}
+	page := files[min(offset, len(files)):min(offset+limit, len(files))]
+	for _, f := range page {
+		listing.Entries = append(listing.Entries, listingEntry(requestPath+"/", f, fields))
+	}
+	if offset+limit < len(files) {
+		listing.NextOffset = offset + limit
+	}
+	writeAPI(w, r, listing)
+}
+
+// apiWalk walks the tree under fullPath with the hidden-file and note rules
+// of listings, stopping early when the request context ends.
+func (s *Server) apiWalk(ctx context.Context, fullPath string, fn func(p string, d fs.DirEntry) error) error {
+	fsys, err := s.storage.Sub(fullPath)
+	if err != nil {
+		return err
+	}
+	fsys = s.hideFilter(ctx, fsys, s.requestPathOf(fullPath))
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable subdirectories are skipped, not fatal
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p == "." || s.isDirNote(d.Name()) {
+			return nil
+		}
+		return fn(p, d)
+	})
+}
+
+func (s *Server) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Missing q parameter", "")
+		return
+	}
+	limit, ok := apiIntParam(w, r, "limit", 100, apiMaxSearchResults)
+	if !ok {
+		return
+	}
+	requestPath, fullPath, info, ok := s.apiLookup(w, r)
+	if !ok {
+		return
+	}
+	if !info.IsDir() {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Not a directory", "")
+		return
+	}
+
+	result := apiSearchResult{Query: query, Path: newAPIEntry(requestPath, info).Path, Results: []apiEntry{}}
+	needle := strings.ToLower(query)
+	err := s.apiWalk(r.Context(), fullPath, func(p string, d fs.DirEntry) error {
+		if !strings.Contains(strings.ToLower(d.Name()), needle) {
+			return nil
+		}
+		if len(result.Results) == limit {
+			result.Truncated = true
+			return fs.SkipAll
+		}
+		if entryInfo, err := d.Info(); err == nil {
+			result.Results = append(result.Results, newAPIEntry(requestPath+"/"+p, entryInfo))
+		}
+		return nil
+	})
+	if err != nil {
+		// Out of time: return what was found so far
+		reqLogf(r, levelDebug, areaListing, "Search under %s stopped early: %v", fullPath, err)
+		result.Truncated = true
+	}
+	writeAPI(w, r, result)
+}
+
+func (s *Server) handleAPITree(w http.ResponseWriter, r *http.Request) {
+	depth, ok := apiIntParam(w, r, "depth", 2, apiMaxTreeDepth)
+	if !ok {
+		return
+	}
+	requestPath, fullPath, info, ok := s.apiLookup(w, r)
+	if !ok {
+		return
+	}
+
+	tree := apiTree{Root: &apiTreeNode{apiEntry: newAPIEntry(requestPath, info)}}
+	if !info.IsDir() {
+		writeAPI(w, r, tree)
+		return
+	}
+
+	dirs := map[string]*apiTreeNode{".": tree.Root}
+	nodes := 0
+	err := s.apiWalk(r.Context(), fullPath, func(p string, d fs.DirEntry) error {
+		if nodes == apiMaxTreeNodes {
+			tree.Truncated = true
+			return fs.SkipAll
+		}
+		entryInfo, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		node := &apiTreeNode{apiEntry: newAPIEntry(requestPath+"/"+p, entryInfo)}
+		parent := dirs[path.Dir(p)]
+		parent.Children = append(parent.Children, node)
+		nodes++
+		if d.IsDir() {
+			if strings.Count(p, "/")+1 >= depth {
+				return fs.SkipDir
+			}
+			dirs[p] = node
+		}
+		return nil
+	})
+	if err != nil {
+		reqLogf(r, levelDebug, areaListing, "Tree of %s stopped early: %v", fullPath, err)
+		tree.Truncated = true
+	}
+	writeAPI(w, r, tree)
+}
+
+func (s *Server) handleAPIStatsTop(w http.ResponseWriter, r *http.Request) {
+	n, ok := apiIntParam(w, r, "n", 20, 1000)
+	if !ok {
+		return
+	}
+	writeAPI(w, r, s.stats.top(n))
+}
+
+func apiBoolParam(r *http.Request, name string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return v
+}
+
+// apiIntParam parses an optional positive integer parameter, capped at max.
+func apiIntParam(w http.ResponseWriter, r *http.Request, name string, def, max int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Invalid "+name+" parameter", v)
+		return 0, false
+	}
+	return min(n, max), true
+}
@@ -0,0 +1,349 @@
+package fileserver
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveEstimate summarises a directory tree before archiving. The
+// fingerprint changes whenever any member is added, removed, resized or
+// touched, so stale spool files are never served.
+type archiveEstimate struct {
+	files       int
+	bytes       int64
+	fingerprint string
+}
+
+func estimateArchive(ctx context.Context, fsys fs.FS, dir string) (archiveEstimate, error) {
+	var est archiveEstimate
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", dir)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable entries are skipped in the archive as well
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		est.files++
+		est.bytes += info.Size()
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", p, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	est.fingerprint = hex.EncodeToString(h.Sum(nil))[:32]
+	return est, err
+}
+
+// skippedNotice is added to archives that leave out files over the size
+// limit, listing them.
+const skippedNotice = "SKIPPED-FILES.txt"
+
+// writeZip streams the regular files in fsys into a store-only zip (most
+// large payloads are already compressed, and storing keeps throughput at
+// disk speed). Files for which tooLarge is true are listed in
+// skippedNotice instead.
+func writeZip(ctx context.Context, w io.Writer, fsys fs.FS, tooLarge func(p string, size int64) bool, progress func(written int64)) error {
+	zw := zip.NewWriter(w)
+	var written int64
+	var skipped []string
+
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			ctxLogf(ctx, levelDebug, areaIO, "Skipping unreadable archive entry %s: %v", p, err)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p == "." || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		name := p
+
+		info, err := d.Info()
+		if err != nil {
+			ctxLogf(ctx, levelDebug, areaIO, "Skipping archive entry %s: %v", p, err)
+			return nil
+		}
+
+		header := &zip.FileHeader{Name: name, Method: zip.Store, Modified: info.ModTime()}
+		if d.IsDir() {
+			header.Name += "/"
+			_, err := zw.CreateHeader(header)
+			return err
+		}
+		if tooLarge != nil && tooLarge(p, info.Size()) {
+			skipped = append(skipped, fmt.Sprintf("%s (%d bytes)", p, info.Size()))
+			return nil
+		}
+
+		file, err := fsys.Open(p)
+		if err != nil {
+			ctxLogf(ctx, levelDebug, areaIO, "Skipping archive entry %s: %v", p, err)
+			return nil
+		}
+		defer file.Close()
+
+		header.UncompressedSize64 = uint64(info.Size())
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(entry, file)
+		written += n
+		if progress != nil {
+			progress(written)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(skipped) > 0 {
+		notice, err := zw.CreateHeader(&zip.FileHeader{Name: skippedNotice, Method: zip.Store, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(notice, "These files exceed the server's file size limit and were left out:\n\n%s\n", strings.Join(skipped, "\n"))
+	}
+	return zw.Close()
+}
+
+func archiveName(fullPath string) string {
+	return dirFileName(fullPath) + ".zip"
+}
+
+// dirFileName names downloads made of a whole directory, like archives
+// and CSV exports.
+func dirFileName(fullPath string) string {
+	name := filepath.Base(fullPath)
+	if name == "/" || name == "." {
+		name = "root"
+	}
+	return name
+}
+
+func (s *Server) handleArchive(w http.ResponseWriter, r *http.Request, fullPath string) {
+	if s.shedForFDs(w, r) {
+		return
+	}
+	fsys, err := s.storage.Sub(fullPath)
+	if err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to create archive", fmt.Sprintf("opening %s: %v", fullPath, err))
+		return
+	}
+	fsys = s.hideFilter(r.Context(), fsys, s.requestPathOf(fullPath))
+
+	if s.spool != nil {
+		est, err := estimateArchive(r.Context(), fsys, fullPath)
+		if err != nil {
+			httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to create archive", fmt.Sprintf("estimating %s: %v", fullPath, err))
+			return
+		}
+		if est.bytes >= s.config.ArchiveSpoolMin {
+			s.serveSpooledArchive(w, r, fullPath, fsys, est)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archiveName(fullPath)))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	w, done := s.beginTransfer(w, r, fullPath)
+	defer done()
+
+	// Not bound to the request timeout: a client that goes away surfaces as
+	// a write error instead
+	if err := writeZip(context.WithoutCancel(r.Context()), w, fsys, s.tooLarge(s.requestPathOf(fullPath)), nil); err != nil {
+		// Headers are gone; all we can do is cut the stream short
+		reqLogf(r, levelWarn, areaIO, "Archive streaming for %s aborted: %v", fullPath, err)
+	}
+}
+
+// spoolJob is one archive being (or already) written to the spool
+// directory. Concurrent requests for the same fingerprint share the job.
+type spoolJob struct {
+	done     chan struct{}
+	path     string
+	err      error
+	lastUsed time.Time
+}
+
+type archiveSpool struct {
+	dir string
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*spoolJob
+}
+
+func newArchiveSpool(dir string, ttl time.Duration) (*archiveSpool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create archive spool directory: %v", err)
+	}
+	// Anything left over from a previous run is unusable: we cannot tell
+	// whether it was finished.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive spool directory: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "spool-") {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+	return &archiveSpool{dir: dir, ttl: ttl, jobs: make(map[string]*spoolJob)}, nil
+}
+
+// get returns the job for key, starting it if needed.
+func (sp *archiveSpool) get(key, fullPath string, fsys fs.FS, est archiveEstimate, tooLarge func(string, int64) bool) *spoolJob {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if job, ok := sp.jobs[key]; ok {
+		job.lastUsed = time.Now()
+		return job
+	}
+
+	job := &spoolJob{
+		done:     make(chan struct{}),
+		path:     filepath.Join(sp.dir, "spool-"+key+".zip"),
+		lastUsed: time.Now(),
+	}
+	sp.jobs[key] = job
+	go sp.build(job, fullPath, fsys, est, tooLarge)
+	return job
+}
+
+func (sp *archiveSpool) build(job *spoolJob, fullPath string, fsys fs.FS, est archiveEstimate, tooLarge func(string, int64) bool) {
+	defer close(job.done)
+
+	// Zip overhead is small; leave 1% plus 64 MiB of slack
+	needed := est.bytes + est.bytes/100 + 64<<20
+	if free, _, err := diskSpace(sp.dir); err == nil && free < uint64(needed) {
+		job.err = fmt.Errorf("insufficient spool space: need %s, have %s", FormatSize(needed), FormatSize(int64(free)))
+		return
+	}
+
+	tmpPath := job.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		job.err = err
+		return
+	}
+
+	start := time.Now()
+	lastLog := start
+	infof(areaIO, "Spooling archive of %s (%d files, %s)", fullPath, est.files, FormatSize(est.bytes))
+	err = writeZip(context.Background(), file, fsys, tooLarge, func(written int64) {
+		if time.Since(lastLog) >= 10*time.Second {
+			lastLog = time.Now()
+			infof(areaIO, "Spooling %s: %s of %s", fullPath, FormatSize(written), FormatSize(est.bytes))
+		}
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, job.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		job.err = err
+		return
+	}
+	infof(areaIO, "Spooled archive of %s in %s", fullPath, time.Since(start).Round(time.Second))
+}
+
+// sweep removes finished spools that nobody has requested within the TTL,
+// and failed jobs so they can be retried.
+func (sp *archiveSpool) sweep() {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for key, job := range sp.jobs {
+		select {
+		case <-job.done:
+		default:
+			continue // still building
+		}
+		if job.err != nil || time.Since(job.lastUsed) > sp.ttl {
+			os.Remove(job.path)
+			delete(sp.jobs, key)
+		}
+	}
+}
+
+func (sp *archiveSpool) runSweeper(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sp.sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) serveSpooledArchive(w http.ResponseWriter, r *http.Request, fullPath string, fsys fs.FS, est archiveEstimate) {
+	key := est.fingerprint
+	job := s.spool.get(key, fullPath, fsys, est, s.tooLarge(s.requestPathOf(fullPath)))
+
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		// The spool keeps building; the client can retry later
+		w.Header().Set("Retry-After", "30")
+		httpError(w, r, areaIO, http.StatusServiceUnavailable, "Archive is being prepared, retry shortly", "")
+		return
+	}
+
+	if job.err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to create archive", fmt.Sprintf("spooling %s: %v", fullPath, job.err))
+		return
+	}
+
+	file, err := os.Open(job.path)
+	if err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to create archive", fmt.Sprintf("opening spool %s: %v", job.path, err))
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to create archive", err.Error())
+		return
+	}
+
+	// A strong validator tied to the tree contents lets If-Range resume
+	// across spool rebuilds of an unchanged directory.
+	w.Header().Set("ETag", `"`+key+`"`)
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archiveName(fullPath)))
+	w.Header().Set("Content-Type", "application/zip")
+
+	w, done := s.beginTransfer(w, r, fullPath)
+	defer done()
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
@@ -0,0 +1,319 @@
+package fileserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// archiveStorage serves a read-only snapshot from a single .zip or .tar
+// file given as the root. Zip and tar entries stored without compression
+// are served as sections of the archive file, so Range requests work.
+type archiveStorage struct {
+	root string // absolute archive path, standing in for the root directory
+	file *os.File
+	fsys fs.FS
+	zip  map[string]*zip.File // zip entries by name, for seekable access
+}
+
+func openArchiveStorage(archivePath string) (*archiveStorage, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive root: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat archive root: %v", err)
+	}
+
+	a := &archiveStorage{root: archivePath, file: file}
+
+	var magic [4]byte
+	n, _ := file.ReadAt(magic[:], 0)
+	switch {
+	case bytes.HasPrefix(magic[:n], []byte("PK")):
+		zr, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read zip archive %s: %v", archivePath, err)
+		}
+		a.fsys = zr
+		a.zip = make(map[string]*zip.File, len(zr.File))
+		for _, f := range zr.File {
+			a.zip[strings.TrimSuffix(f.Name, "/")] = f
+		}
+		infof(areaServer, "Serving zip archive %s (%d entries)", archivePath, len(zr.File))
+	case bytes.HasPrefix(magic[:n], []byte{0x1f, 0x8b}), bytes.HasPrefix(magic[:n], []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		file.Close()
+		return nil, fmt.Errorf("compressed tar archives cannot be served with random access; decompress %s to a plain .tar first", archivePath)
+	default:
+		index, err := indexTar(file, info.Size())
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to index tar archive %s: %v", archivePath, err)
+		}
+		a.fsys = index
+	}
+	return a, nil
+}
+
+// isArchiveFile reports whether a file root looks like an archive to serve
+// as a tree, by extension or by the zip or tar magic. Other compressed
+// files are shared as they are.
+func isArchiveFile(path string) bool {
+	name := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tgz", ".tar.gz", ".tar.zst"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	var head [262]byte
+	n, _ := io.ReadFull(file, head[:])
+	return bytes.HasPrefix(head[:n], []byte("PK\x03\x04")) || n == len(head) && string(head[257:262]) == "ustar"
+}
+
+// name maps a full path under the root to a name inside the archive.
+func (a *archiveStorage) name(full string) (string, error) {
+	rel, err := filepath.Rel(a.root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fs.ErrNotExist
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (a *archiveStorage) Stat(full string) (fs.FileInfo, error) {
+	name, err := a.name(full)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: err}
+	}
+	return fs.Stat(a.fsys, name)
+}
+
+func (a *archiveStorage) Open(full string) (fs.File, error) {
+	name, err := a.name(full)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: full, Err: err}
+	}
+	if f, ok := a.zip[name]; ok && f.Method == zip.Store && !f.Mode().IsDir() {
+		offset, err := f.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return &sectionFile{io.NewSectionReader(a.file, offset, int64(f.UncompressedSize64)), f.FileInfo()}, nil
+	}
+	return a.fsys.Open(name)
+}
+
+func (a *archiveStorage) ReadDir(full string) ([]fs.DirEntry, error) {
+	name, err := a.name(full)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: full, Err: err}
+	}
+	return fs.ReadDir(a.fsys, name)
+}
+
+func (a *archiveStorage) Sub(full string) (fs.FS, error) {
+	name, err := a.name(full)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Sub(a.fsys, name)
+}
+
+// sectionFile is an archive member read straight from the archive file.
+type sectionFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+}
+
+func (f *sectionFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *sectionFile) Close() error               { return nil }
+
+// tarIndex is an fs.FS over an uncompressed tar file, built by one pass
+// over the headers at startup. Parent directories missing from the archive
+// are synthesized.
+type tarIndex struct {
+	file    *os.File
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	name     string
+	header   *tar.Header
+	offset   int64
+	children []string // directories only, sorted
+}
+
+func (e *tarEntry) Name() string               { return path.Base(e.name) }
+func (e *tarEntry) Size() int64                { return e.header.Size }
+func (e *tarEntry) Mode() fs.FileMode          { return e.header.FileInfo().Mode() }
+func (e *tarEntry) ModTime() time.Time         { return e.header.ModTime }
+func (e *tarEntry) IsDir() bool                { return e.header.Typeflag == tar.TypeDir }
+func (e *tarEntry) Sys() any                   { return e.header }
+func (e *tarEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *tarEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+func indexTar(file *os.File, size int64) (*tarIndex, error) {
+	t := &tarIndex{file: file, entries: make(map[string]*tarEntry)}
+	t.entries["."] = &tarEntry{name: ".", header: &tar.Header{Typeflag: tar.TypeDir, Mode: 0o755}}
+
+	start := time.Now()
+	lastLog := start
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == "." || !fs.ValidPath(name) {
+			continue
+		}
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			continue // links, devices and sparse files are not served
+		}
+
+		// tar.Reader reads headers exactly, so the file position is the
+		// start of this entry's data
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		if existing, ok := t.entries[name]; ok {
+			// Later entries win, as when extracting
+			existing.header, existing.offset = header, offset
+			continue
+		}
+		t.add(&tarEntry{name: name, header: header, offset: offset})
+
+		if time.Since(lastLog) >= 5*time.Second {
+			lastLog = time.Now()
+			infof(areaServer, "Indexing tar archive: %d entries, %d%% read", len(t.entries), offset*100/max(size, 1))
+		}
+	}
+	for _, e := range t.entries {
+		sort.Strings(e.children)
+	}
+	infof(areaServer, "Indexed tar archive %s: %d entries in %s", file.Name(), len(t.entries), time.Since(start).Round(time.Millisecond))
+	return t, nil
+}
+
+func (t *tarIndex) add(e *tarEntry) {
+	t.entries[e.name] = e
+	for child := e.name; child != "."; {
+		parent := path.Dir(child)
+		p, ok := t.entries[parent]
+		if !ok {
+			p = &tarEntry{name: parent, header: &tar.Header{Typeflag: tar.TypeDir, Mode: 0o755, ModTime: e.header.ModTime}}
+			t.entries[parent] = p
+		}
+		p.children = append(p.children, child)
+		if ok {
+			break
+		}
+		child = parent
+	}
+}
+
+func (t *tarIndex) lookup(op, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return e, nil
+}
+
+func (t *tarIndex) Stat(name string) (fs.FileInfo, error) {
+	return t.lookup("stat", name)
+}
+
+func (t *tarIndex) Open(name string) (fs.File, error) {
+	e, err := t.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir() {
+		return &tarDir{e}, nil
+	}
+	return &sectionFile{io.NewSectionReader(t.file, e.offset, e.header.Size), e}, nil
+}
+
+func (t *tarIndex) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := t.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	list := make([]fs.DirEntry, 0, len(e.children))
+	for _, child := range e.children {
+		list = append(list, t.entries[child])
+	}
+	return list, nil
+}
+
+// tarDir is an opened directory; listings go through ReadDir on the index.
+type tarDir struct{ e *tarEntry }
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.e, nil }
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.e.name, Err: errors.New("is a directory")}
+}
+func (d *tarDir) Close() error { return nil }
+
+// serveStream sends an archive member that cannot seek. Range requests get
+// the whole body, but If-Modified-Since still short-circuits.
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, file fs.File, fullPath string, info fs.FileInfo) {
+	if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !info.ModTime().Truncate(time.Second).After(t) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	tw, done := s.beginTransfer(w, r, fullPath)
+	defer done()
+
+	if tw.Header().Get("Content-Type") == "" {
+		ctype := mime.TypeByExtension(path.Ext(info.Name()))
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		tw.Header().Set("Content-Type", ctype)
+	}
+	tw.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	tw.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	tw.Header().Set("Accept-Ranges", "none")
+	doneCopy := requestTiming(r).track("copy")
+	if _, err := io.Copy(tw, file); err != nil {
+		reqLogf(r, levelWarn, areaIO, "Failed to stream %s: %v", fullPath, err)
+	}
+	doneCopy()
+	s.fileServed(r, tw, fullPath)
+}
@@ -0,0 +1,118 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//go:embed static/*
+var staticFS embed.FS
+
+// DefaultAssetsPrefix is where the embedded stylesheets are served.
+const DefaultAssetsPrefix = "/_assets/"
+
+// staticAsset is an embedded file published under a name carrying a hash
+// of its content, so it can be cached forever and still change on upgrade.
+type staticAsset struct {
+	name string
+	data []byte
+}
+
+type staticAssets struct {
+	byHashed map[string]staticAsset // "directory.3f2a1b9c.css"
+	hashed   map[string]string      // "directory.css" -> "directory.3f2a1b9c.css"
+}
+
+func loadStaticAssets() (*staticAssets, error) {
+	a := &staticAssets{byHashed: map[string]staticAsset{}, hashed: map[string]string{}}
+	entries, err := fs.ReadDir(staticFS, "static")
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		data, err := staticFS.ReadFile("static/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		ext := path.Ext(entry.Name())
+		hashed := strings.TrimSuffix(entry.Name(), ext) + "." + hex.EncodeToString(sum[:4]) + ext
+		a.byHashed[hashed] = staticAsset{name: entry.Name(), data: data}
+		a.hashed[entry.Name()] = hashed
+	}
+	return a, nil
+}
+
+// assetURLs maps each asset's plain name to its URL for PageData.Assets.
+func (s *Server) assetURLs(r *http.Request) map[string]string {
+	urls := make(map[string]string, len(s.assets.hashed))
+	for name, hashed := range s.assets.hashed {
+		urls[name] = basePath(r) + s.config.AssetsPrefix + hashed
+	}
+	return urls
+}
+
+// handleAsset serves embedded assets. Anything else under the prefix falls
+// through to the file tree, so a real directory of the same name stays
+// reachable.
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request) {
+	asset, ok := s.assets.byHashed[strings.TrimPrefix(r.URL.Path, s.config.AssetsPrefix)]
+	if !ok {
+		s.handleRequest(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, asset.name, time.Time{}, bytes.NewReader(asset.data))
+}
+
+// checkReservedCollisions warns when the served tree has entries under the
+// server's own URL prefixes.
+func (s *Server) checkReservedCollisions() {
+	name := strings.Trim(s.config.AssetsPrefix, "/")
+	if _, err := s.storage.Stat(filepath.Join(s.rootDir, name)); err == nil {
+		warnf(areaServer, "%s in the served root overlaps the assets prefix %s; its files stay reachable unless named like an asset (see -assets-prefix)",
+			name, s.config.AssetsPrefix)
+	}
+	if _, err := s.storage.Stat(filepath.Join(s.rootDir, "_api")); err == nil {
+		warnf(areaServer, "_api in the served root is shadowed by the API under /_api/; files in it that collide with API routes cannot be downloaded")
+	}
+	if s.config.Sitemap {
+		if _, err := s.storage.Stat(filepath.Join(s.rootDir, "sitemap.xml")); err == nil {
+			warnf(areaServer, "sitemap.xml in the served root is shadowed by the generated sitemap")
+		}
+	}
+	reserved := []string{"_recent", "_grep"}
+	if s.config.DirPasswords {
+		reserved = append(reserved, "_unlock")
+	}
+	if s.sessions != nil {
+		reserved = append(reserved, "_login", "_logout")
+	}
+	if s.oidc != nil {
+		reserved = append(reserved, "_oidc")
+	}
+	if s.config.Pprof && s.config.PprofAddr == "" {
+		reserved = append(reserved, "debug")
+	}
+	for _, name := range reserved {
+		if _, err := s.storage.Stat(filepath.Join(s.rootDir, name)); err == nil {
+			warnf(areaServer, "%s in the served root is shadowed by the /%s endpoint", name, name)
+		}
+	}
+}
+
+func validateAssetsPrefix(prefix string) error {
+	if !strings.HasPrefix(prefix, "/") || !strings.HasSuffix(prefix, "/") || prefix == "/" {
+		return fmt.Errorf("assets prefix must start and end with / and not be the root: %q", prefix)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultFeedEntries is how many files a ?format=atom feed lists
+	DefaultFeedEntries = 50
+	feedMaxEntries     = 1000
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Links   []atomLink `xml:"link"`
+	Summary string     `xml:"summary"`
+}
+
+// feedEntries is the ?n= count for a feed, or the configured default.
+func (s *Server) feedEntries(r *http.Request) (int, error) {
+	n := s.config.FeedEntries
+	if n <= 0 {
+		n = DefaultFeedEntries
+	}
+	if v := r.URL.Query().Get("n"); v != "" {
+		count, err := strconv.Atoi(v)
+		if err != nil || count < 1 || count > feedMaxEntries {
+			return 0, fmt.Errorf("n must be between 1 and %d", feedMaxEntries)
+		}
+		n = count
+	}
+	return n, nil
+}
+
+// writeAtomFeed answers ?format=atom: the most recently modified files of
+// the listing, newest first, for feed readers to subscribe to. Links are
+// absolute, built from the scheme and host the client used and the base
+// path. The feed is rendered in full so ServeContent can answer
+// conditional requests from its ETag and the newest modification time.
+func (s *Server) writeAtomFeed(w http.ResponseWriter, r *http.Request, requestPath string, files []FileInfo, limit int) {
+	var entries []FileInfo
+	for _, f := range files {
+		if !f.IsDir {
+			entries = append(entries, f)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ModTime.After(entries[j].ModTime) })
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	dirURL := requestBaseURL(r) + urlPath(basePath(r)+requestPath)
+	feed := atomFeed{
+		ID:     dirURL,
+		Title:  "File Server - " + requestPath,
+		Author: atomAuthor{Name: r.Host},
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: requestBaseURL(r) + basePath(r) + r.URL.RequestURI()},
+			{Rel: "alternate", Type: "text/html", Href: dirURL},
+		},
+	}
+	// An empty feed still needs an updated time; the epoch keeps it stable
+	updated := time.Unix(0, 0)
+	if len(entries) > 0 {
+		updated = entries[0].ModTime
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+	for _, f := range entries {
+		fileURL := requestBaseURL(r) + urlPath(basePath(r)+requestPath+f.Name)
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fileURL,
+			Title:   f.Name,
+			Updated: f.ModTime.UTC().Format(time.RFC3339),
+			Links: []atomLink{
+				{Rel: "alternate", Href: fileURL},
+				{Rel: "enclosure", Href: fileURL, Length: f.Size},
+			},
+			Summary: fmt.Sprintf("%s (%d bytes)", s.formatSize(f.Size), f.Size),
+		})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(feed); err != nil {
+		httpError(w, r, areaListing, http.StatusInternalServerError, "Internal server error", "encoding feed: "+err.Error())
+		return
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("ETag", `W/"`+hex.EncodeToString(sum[:8])+`"`)
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	s.emitTiming(w, r)
+	http.ServeContent(w, r, "", updated, bytes.NewReader(buf.Bytes()))
+}
@@ -0,0 +1,267 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writes go to a temp file beside their destination and are renamed over
+// it once complete, so downloads see either the old file or the new one,
+// never a partial upload. Temp files are always denied: they never appear
+// in listings, archives or searches and cannot be fetched directly.
+const (
+	writeTempPrefix  = ".fileserver-tmp-"
+	writeTempPattern = writeTempPrefix + "*"
+
+	// Older temp files are orphans of a crash. The margin leaves writes
+	// of a process being gracefully replaced alone.
+	writeTempMaxAge = time.Hour
+)
+
+var errWriteConflict = errors.New("another write to this path is in progress")
+
+func isWriteTemp(name string) bool {
+	return strings.HasPrefix(name, writeTempPrefix)
+}
+
+// writeLocks holds advisory locks on final paths, so two writes to the
+// same name conflict instead of racing on the rename. It also remembers
+// the directories temp files were seen in for the sweeper.
+type writeLocks struct {
+	mu   sync.Mutex
+	held map[string]bool
+	dirs map[string]bool
+}
+
+func newWriteLocks(root string) *writeLocks {
+	// The root is swept at startup; other directories once they are
+	// written to or listed
+	return &writeLocks{held: make(map[string]bool), dirs: map[string]bool{root: true}}
+}
+
+func (l *writeLocks) tryLock(fullPath string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[fullPath] {
+		return false
+	}
+	l.held[fullPath] = true
+	l.dirs[filepath.Dir(fullPath)] = true
+	return true
+}
+
+func (l *writeLocks) unlock(fullPath string) {
+	l.mu.Lock()
+	delete(l.held, fullPath)
+	l.mu.Unlock()
+}
+
+// noteDir queues dir, where a listing found temp files, for the sweeper.
+func (l *writeLocks) noteDir(dir string) {
+	l.mu.Lock()
+	l.dirs[dir] = true
+	l.mu.Unlock()
+}
+
+// sweep removes orphaned temp files from the queued directories.
+func (l *writeLocks) sweep() {
+	l.mu.Lock()
+	dirs := l.dirs
+	l.dirs = make(map[string]bool)
+	l.mu.Unlock()
+
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !isWriteTemp(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if time.Since(info.ModTime()) < writeTempMaxAge {
+				// Still being written, or not yet old enough to tell
+				l.noteDir(dir)
+				continue
+			}
+			tmpPath := filepath.Join(dir, entry.Name())
+			if err := os.Remove(tmpPath); err != nil {
+				warnf(areaIO, "Failed to remove orphaned upload %s: %v", tmpPath, err)
+				continue
+			}
+			infof(areaIO, "Removed orphaned upload %s", tmpPath)
+		}
+	}
+}
+
+func (l *writeLocks) runSweeper(ctx context.Context) {
+	l.sweep()
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// atomicFile is a write in progress to final, hashed as it is written.
+// Exactly one of commit and abort must be called. It deliberately has no
+// ReadFrom, so io.Copy goes through Write and the hash.
+type atomicFile struct {
+	file    *os.File
+	final   string
+	hash    hash.Hash
+	expect  string // hex SHA-256 the client sent, "" for none
+	locks   *writeLocks
+	perms   *writePerms
+	digests *digestCache
+}
+
+// createAtomic starts a write of fullPath, failing with errWriteConflict
+// while another write to it is in progress. expectSHA256, from
+// parseContentSHA256, is checked on commit when set.
+func (s *Server) createAtomic(fullPath, expectSHA256 string) (*atomicFile, error) {
+	if !s.writeLocks.tryLock(fullPath) {
+		return nil, errWriteConflict
+	}
+	f, err := createTemp(filepath.Dir(fullPath))
+	if err != nil {
+		s.writeLocks.unlock(fullPath)
+		return nil, fmt.Errorf("failed to create temp file: %v", err)
+	}
+	return &atomicFile{file: f, final: fullPath, hash: sha256.New(), expect: expectSHA256,
+		locks: s.writeLocks, perms: s.writePerms, digests: s.digests}, nil
+}
+
+// createTemp is os.CreateTemp creating the file with 0666 rather than
+// 0600, so an upload gets the umask's permissions like any new file.
+func createTemp(dir string) (*os.File, error) {
+	for range 100 {
+		name := filepath.Join(dir, writeTempPrefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o666)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+	return nil, fmt.Errorf("failed to find an unused temp file name in %s", dir)
+}
+
+func (f *atomicFile) Write(p []byte) (int, error) {
+	n, err := f.file.Write(p)
+	f.hash.Write(p[:n])
+	return n, err
+}
+
+// commit flushes the temp file to disk and renames it over the final
+// path, returning the hex SHA-256 of what was written. A digest differing
+// from the expected one fails with a *checksumMismatchError and leaves any
+// existing file untouched. The digest goes into the checksum cache, so
+// SHA256SUMS need not read the file again.
+func (f *atomicFile) commit() (string, error) {
+	defer f.locks.unlock(f.final)
+	tmpPath := f.file.Name()
+	sum := hex.EncodeToString(f.hash.Sum(nil))
+	err := f.file.Sync()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && f.expect != "" && sum != f.expect {
+		os.Remove(tmpPath)
+		return "", &checksumMismatchError{expected: f.expect, actual: sum}
+	}
+	if err == nil {
+		err = f.perms.apply(tmpPath, false)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, f.final)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write %s: %v", f.final, err)
+	}
+	if info, err := os.Stat(f.final); err == nil {
+		f.digests.store(f.final, info, sum)
+	}
+	return sum, nil
+}
+
+// abort discards the write, leaving any existing file untouched.
+func (f *atomicFile) abort() {
+	defer f.locks.unlock(f.final)
+	f.file.Close()
+	os.Remove(f.file.Name())
+}
+
+// checksumMismatchError is a write whose content does not match the
+// digest the client sent.
+type checksumMismatchError struct {
+	expected, actual string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected sha256 %s, got %s", e.expected, e.actual)
+}
+
+// contentSHA256Header carries the digest a client expects an upload to
+// have, and on success the digest of what was stored.
+const contentSHA256Header = "X-Content-SHA256"
+
+// parseContentSHA256 reads the digest a client expects an upload to have
+// from a contentSHA256Header value: 64 hex digits, or "" for none.
+func parseContentSHA256(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return "", nil
+	}
+	if b, err := hex.DecodeString(value); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 %q, want 64 hex digits", value)
+	}
+	return value, nil
+}
+
+// writeFailed answers a failed createAtomic or commit: 409 for a
+// conflicting write, 422 with both digests for a checksum mismatch, 500
+// otherwise.
+func writeFailed(w http.ResponseWriter, r *http.Request, requestPath string, err error) {
+	var mismatch *checksumMismatchError
+	switch {
+	case errors.Is(err, errWriteConflict):
+		httpError(w, r, areaRequest, http.StatusConflict, "Another upload to this file is in progress", "")
+	case errors.As(err, &mismatch):
+		logHTTPError(r, areaIO, http.StatusUnprocessableEntity, "Checksum mismatch", fmt.Sprintf("writing %s: %v", requestPath, err))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(errorReply{
+			Error:          "Checksum mismatch",
+			Status:         http.StatusUnprocessableEntity,
+			Path:           basePath(r) + r.URL.Path,
+			RequestID:      requestID(r),
+			ExpectedSHA256: mismatch.expected,
+			ActualSHA256:   mismatch.actual,
+		})
+	default:
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to write file", fmt.Sprintf("writing %s: %v", requestPath, err))
+	}
+}
@@ -0,0 +1,155 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// AuthFunc authenticates a request and returns the identity recorded in
+// logs. When ok is false the request is refused with 401; fn may set
+// response headers such as WWW-Authenticate first.
+type AuthFunc func(w http.ResponseWriter, r *http.Request) (identity string, ok bool)
+
+// Config.AuthMode values
+const (
+	AuthModeAll       = "all"
+	AuthModeWriteOnly = "write-only"
+)
+
+// DefaultAuthBypass are the paths Config.AuthBypass leaves open by
+// default: health checks and metrics.
+var DefaultAuthBypass = []string{"/healthz", "/readyz", "/metrics"}
+
+// authBypassHandlers maps the AuthBypass paths that name an endpoint of
+// mux to its handler. Other paths would fall through to the file tree and
+// serve files without credentials, so they are left out, with a warning
+// unless they are defaults for an endpoint that is turned off.
+func (s *Server) authBypassHandlers(mux *http.ServeMux) map[string]http.Handler {
+	handlers := map[string]http.Handler{}
+	for _, p := range s.config.AuthBypass {
+		h, pattern := mux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: p}})
+		if pattern != p {
+			if !slices.Contains(DefaultAuthBypass, p) {
+				warnf(areaAuth, "Ignoring -auth-bypass path %s: no endpoint is served there", p)
+			}
+			continue
+		}
+		handlers[p] = h
+	}
+	return handlers
+}
+
+// safeMethod reports methods that only read.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// authenticateOptional identifies a reading request in write-only mode
+// without ever refusing it: a session or credentials that check out name
+// the user, anything else stays anonymous. Auth's challenge headers are
+// discarded.
+func (s *Server) authenticateOptional(r *http.Request) {
+	if claims, ok := s.session(r); ok {
+		info := getRequestInfo(r)
+		info.identity, info.groups = claims.User, claims.Groups
+		return
+	}
+	if s.config.Auth == nil || r.Header.Get("Authorization") == "" {
+		return
+	}
+	if identity, ok := s.config.Auth(discardHeaders{}, r); ok && identity != "" {
+		getRequestInfo(r).identity = identity
+	}
+}
+
+// discardHeaders is a ResponseWriter for calling an AuthFunc whose
+// response is not sent.
+type discardHeaders struct{}
+
+func (discardHeaders) Header() http.Header         { return http.Header{} }
+func (discardHeaders) Write(b []byte) (int, error) { return len(b), nil }
+func (discardHeaders) WriteHeader(int)             {}
+
+// authenticate admits requests with a valid login session or, failing
+// that, those Auth accepts. With Login set and no Auth, or for a browser
+// without credentials, the answer is a redirect to the login page.
+// Endpoints in AuthBypass skip all of this and go straight to their
+// handler, as do requests from a trusted proxy with AuthProxyHeader set,
+// which names the user itself, and reads through a signed playlist link,
+// made for the user it names. Expired OIDC sessions are renewed first
+// where they can be.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AuthProxyHeader != "" {
+			if user, groups, trusted := s.proxyIdentity(r); trusted {
+				info := getRequestInfo(r)
+				info.identity, info.groups = user, groups
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if h, ok := s.authBypass[r.URL.Path]; ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if s.oidc != nil {
+			s.renewOIDCSession(w, r)
+		}
+		if claims, ok := s.signedLink(r); ok && safeMethod(r.Method) {
+			info := getRequestInfo(r)
+			info.identity, info.groups = claims.User, claims.Groups
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.config.AuthMode == AuthModeWriteOnly && safeMethod(r.Method) {
+			s.authenticateOptional(r)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if s.sessions != nil {
+			if r.URL.Path == loginPath || r.URL.Path == logoutPath || r.URL.Path == oidcCallbackPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if claims, ok := s.session(r); ok {
+				info := getRequestInfo(r)
+				info.identity, info.groups = claims.User, claims.Groups
+				next.ServeHTTP(w, r)
+				return
+			}
+			// With a users file, anonymous requests go on and its rules
+			// decide whether they need to log in
+			browser := r.Header.Get("Authorization") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")
+			switch {
+			case browser && s.acl.Load() == nil && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+				s.redirectToLogin(w, r)
+				return
+			case browser && s.config.AuthMode == AuthModeWriteOnly:
+				// A form posted without a session: log in, then go back
+				// to the page the form was on
+				s.redirectToLoginFrom(w, r)
+				return
+			case s.config.Auth == nil:
+				httpError(w, r, areaAuth, http.StatusUnauthorized, "Authentication required", "no valid session from "+clientIP(r))
+				return
+			}
+		}
+		if s.config.Auth == nil {
+			// Only a proxy authenticates, and this request did not come
+			// through it
+			next.ServeHTTP(w, r)
+			return
+		}
+		identity, ok := s.config.Auth(w, r)
+		if !ok {
+			httpError(w, r, areaAuth, http.StatusUnauthorized, "Authentication required", "rejected by auth function from "+clientIP(r))
+			return
+		}
+		if identity != "" {
+			getRequestInfo(r).identity = identity
+		}
+		next.ServeHTTP(w, r)
+	})
+}
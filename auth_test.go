@@ -0,0 +1,41 @@
+package fileserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestAuthBypass checks that only registered endpoints skip
+// authentication: a file at a bypassed path is never served without
+// credentials.
+func TestAuthBypass(t *testing.T) {
+	reject := func(w http.ResponseWriter, r *http.Request) (string, bool) { return "", false }
+	files := map[string]string{"metrics": "secret metrics file", "readyz": "secret readyz file", "public.txt": "secret"}
+
+	tests := []struct {
+		name   string
+		cfg    Config
+		target string
+		want   int
+	}{
+		{"metrics off", Config{}, "/metrics", http.StatusUnauthorized},
+		{"metrics on", Config{Metrics: true}, "/metrics", http.StatusOK},
+		{"health", Config{}, "/readyz", http.StatusOK},
+		{"other file", Config{}, "/public.txt", http.StatusUnauthorized},
+		{"bypass naming a file", Config{AuthBypass: []string{"/public.txt"}}, "/public.txt", http.StatusUnauthorized},
+		{"no bypass", Config{AuthBypass: []string{"none"}}, "/readyz", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		tt.cfg.Auth = reject
+		s, h := newTestServer(t, tt.cfg)
+		writeFiles(t, s.rootDir, files)
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if w.Code != tt.want {
+			t.Errorf("%s: GET %s: status %d, want %d", tt.name, tt.target, w.Code, tt.want)
+		}
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s: GET %s served the file without credentials", tt.name, tt.target)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const bandwidthDayLayout = "2006-01-02"
+
+// clientCounter accumulates bytes for one client between flushes. Transfers
+// hold a reference and add to it atomically, so the hot path never takes a
+// lock.
+type clientCounter struct {
+	bytes atomic.Int64
+	refs  atomic.Int64
+}
+
+// bandwidthAccounting keeps bytes served per client in daily buckets,
+// pruned to a retention period and persisted to a JSON file.
+type bandwidthAccounting struct {
+	file      string
+	retention int // days
+
+	mu   sync.Mutex
+	live map[string]*clientCounter
+	days map[string]map[string]int64 // day -> client -> bytes
+}
+
+type clientUsage struct {
+	Client string `json:"client"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type bandwidthReport struct {
+	From    string        `json:"from"`
+	To      string        `json:"to"`
+	Clients []clientUsage `json:"clients"`
+}
+
+func newBandwidthAccounting(file string, retentionDays int) (*bandwidthAccounting, error) {
+	ba := &bandwidthAccounting{
+		file:      file,
+		retention: retentionDays,
+		live:      make(map[string]*clientCounter),
+		days:      make(map[string]map[string]int64),
+	}
+	if file == "" {
+		return ba, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return ba, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bandwidth file: %v", err)
+	}
+	if err := json.Unmarshal(data, &ba.days); err != nil {
+		return nil, fmt.Errorf("failed to parse bandwidth file %s: %v", file, err)
+	}
+	return ba, nil
+}
+
+// acquire returns the live counter for client; release it when done.
+func (ba *bandwidthAccounting) acquire(client string) *clientCounter {
+	ba.mu.Lock()
+	defer ba.mu.Unlock()
+	c, ok := ba.live[client]
+	if !ok {
+		c = &clientCounter{}
+		ba.live[client] = c
+	}
+	c.refs.Add(1)
+	return c
+}
+
+// flush moves live counters into today's bucket, prunes expired days and
+// persists the result.
+func (ba *bandwidthAccounting) flush() error {
+	today := time.Now().Format(bandwidthDayLayout)
+	cutoff := time.Now().AddDate(0, 0, -ba.retention).Format(bandwidthDayLayout)
+
+	ba.mu.Lock()
+	bucket := ba.days[today]
+	for client, c := range ba.live {
+		if n := c.bytes.Swap(0); n > 0 {
+			if bucket == nil {
+				bucket = make(map[string]int64)
+				ba.days[today] = bucket
+			}
+			bucket[client] += n
+		} else if c.refs.Load() == 0 {
+			delete(ba.live, client)
+		}
+	}
+	for day := range ba.days {
+		if day < cutoff {
+			delete(ba.days, day)
+		}
+	}
+	data, err := json.Marshal(ba.days)
+	ba.mu.Unlock()
+
+	if err != nil || ba.file == "" {
+		return err
+	}
+	return writeFileAtomic(ba.file, data)
+}
+
+func (ba *bandwidthAccounting) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if err := ba.flush(); err != nil {
+			warnf(areaServer, "Failed to save bandwidth accounting: %v", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// usage sums bytes per client over the inclusive day range, including
+// bytes not yet flushed.
+func (ba *bandwidthAccounting) usage(from, to string) []clientUsage {
+	today := time.Now().Format(bandwidthDayLayout)
+	totals := make(map[string]int64)
+
+	ba.mu.Lock()
+	for day, clients := range ba.days {
+		if day < from || day > to {
+			continue
+		}
+		for client, n := range clients {
+			totals[client] += n
+		}
+	}
+	if from <= today && today <= to {
+		for client, c := range ba.live {
+			totals[client] += c.bytes.Load()
+		}
+	}
+	ba.mu.Unlock()
+
+	list := make([]clientUsage, 0, len(totals))
+	for client, n := range totals {
+		if n > 0 {
+			list = append(list, clientUsage{client, n})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Bytes != list[j].Bytes {
+			return list[i].Bytes > list[j].Bytes
+		}
+		return list[i].Client < list[j].Client
+	})
+	return list
+}
+
+// accountingClient is the identity bytes are charged to: the authenticated
+// user when there is one, otherwise the client IP.
+func accountingClient(r *http.Request) string {
+	if identity := requestIdentity(r); identity != "-" {
+		return identity
+	}
+	return clientIP(r)
+}
+
+// handleBandwidth reports usage per client. Query: from/to as YYYY-MM-DD,
+// or days=N for the last N days including today (default 30).
+func (s *Server) handleBandwidth(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	to := time.Now().Format(bandwidthDayLayout)
+	from := time.Now().AddDate(0, 0, -29).Format(bandwidthDayLayout)
+
+	if v := q.Get("days"); v != "" {
+		var days int
+		if _, err := fmt.Sscanf(v, "%d", &days); err != nil || days < 1 {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid days parameter", v)
+			return
+		}
+		from = time.Now().AddDate(0, 0, 1-days).Format(bandwidthDayLayout)
+	}
+	for name, dst := range map[string]*string{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			if _, err := time.Parse(bandwidthDayLayout, v); err != nil {
+				httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid "+name+" date, want YYYY-MM-DD", v)
+				return
+			}
+			*dst = v
+		}
+	}
+
+	report := bandwidthReport{from, to, s.bandwidth.usage(from, to)}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		reqLogf(r, levelWarn, areaRequest, "Failed to write bandwidth report: %v", err)
+	}
+}
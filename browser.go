@@ -0,0 +1,53 @@
+package fileserver
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// openBrowserWhenReady waits until addr accepts connections, then opens
+// url in the platform's browser. Failing to either only warns: the server
+// runs the same without a browser.
+func openBrowserWhenReady(url string, addr net.Addr) {
+	target := addr.String()
+	// Some systems refuse to dial a wildcard address
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			target = net.JoinHostPort("localhost", port)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.DialTimeout(addr.Network(), target, time.Second)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			warnf(areaServer, "Not opening a browser: %s does not accept connections: %v", addr, err)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		warnf(areaServer, "Failed to open a browser at %s: %v", url, err)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			warnf(areaServer, "Failed to open a browser at %s: %s exited: %v", url, cmd.Path, err)
+		}
+	}()
+}
@@ -0,0 +1,63 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// cleanURLPath collapses duplicate slashes and dot segments while keeping a
+// trailing slash, so "/a//b/./c/" becomes "/a/b/c/".
+func cleanURLPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// urlPath escapes a slash-separated request path for use in a link,
+// including the '#', '?' and '%' that html/template would pass through.
+func urlPath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// redirectCanonical issues a 301 to the given unescaped path under the base
+// path, preserving the query string. The location is built from the escaped form so that names
+// containing spaces, '#', '?' or non-ASCII survive the round trip.
+func redirectCanonical(w http.ResponseWriter, r *http.Request, target string) {
+	location := basePath(r) + urlPath(target)
+	if r.URL.RawQuery != "" {
+		location += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
+
+// canonicalPaths answers GET and HEAD for non-canonical paths with a 301
+// before they reach the mux. The mux's own cleanup redirects with a
+// temporary 307, which clients and caches do not remember, and leaves
+// -base-path out of the Location; other methods still get it, since a 307
+// keeps the method and body. Paths that requestPathFrom refuses get 400
+// rather than a redirect, which would otherwise resolve "..%2f" into a
+// real parent.
+func canonicalPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := requestPathFrom(r.URL); err != nil {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Bad request", fmt.Sprintf("%v from %s", err, clientIP(r)))
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if canonical := cleanURLPath(r.URL.Path); canonical != r.URL.Path {
+				redirectCanonical(w, r, canonical)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
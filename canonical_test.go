@@ -0,0 +1,85 @@
+package fileserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCleanURLPath(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", "/"},
+		{"/", "/"},
+		{"//", "/"},
+		{"/a/b", "/a/b"},
+		{"/a/b/", "/a/b/"},
+		{"/a//b", "/a/b"},
+		{"/a//b//", "/a/b/"},
+		{"/a/./b/", "/a/b/"},
+		{"/a/../b", "/b"},
+		{"/a/b/..", "/a"},
+		{"/a/b/../", "/a/"},
+		{"/../../x", "/x"},
+		{"a/b", "/a/b"},
+		{"/./", "/"},
+	}
+	for _, tt := range tests {
+		if got := cleanURLPath(tt.in); got != tt.want {
+			t.Errorf("cleanURLPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectCanonical(t *testing.T) {
+	tests := []struct {
+		target, basePath, want string
+	}{
+		{"/a b/", "", "/a%20b/"},
+		{"/100%/x#1?.txt", "", "/100%25/x%231%3F.txt"},
+		{"/café/", "", "/caf%C3%A9/"},
+		{"/dir/", "/files", "/files/dir/"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/x?sort=size&order=desc", nil)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, &requestInfo{basePath: tt.basePath}))
+		w := httptest.NewRecorder()
+		redirectCanonical(w, r, tt.target)
+		want := tt.want + "?sort=size&order=desc"
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != want {
+			t.Errorf("redirectCanonical(%q) = %d %s, want 301 %s", tt.target, w.Code, w.Header().Get("Location"), want)
+		}
+	}
+}
+
+func TestCanonicalPaths(t *testing.T) {
+	s, h := newTestServer(t, Config{BasePath: "/files", Uploads: true})
+	writeFiles(t, s.rootDir, map[string]string{"a/b.txt": "b"})
+	tests := []struct {
+		method, target string
+		want           int
+		location       string
+	}{
+		{http.MethodGet, "/a/b.txt", http.StatusOK, ""},
+		{http.MethodGet, "/a//b.txt", http.StatusMovedPermanently, "/files/a/b.txt"},
+		{http.MethodHead, "/a/./b.txt", http.StatusMovedPermanently, "/files/a/b.txt"},
+		{http.MethodGet, "/x/../a/?q=1", http.StatusMovedPermanently, "/files/a/?q=1"},
+		{http.MethodGet, "/a", http.StatusMovedPermanently, "/files/a/"},
+		{http.MethodGet, "/a/b.txt/", http.StatusMovedPermanently, "/files/a/b.txt"},
+		{http.MethodGet, "/%2e%2e/a/", http.StatusBadRequest, ""},
+		// Other methods are left to the mux, whose 307 keeps the method
+		{http.MethodPut, "/a//c.txt", http.StatusTemporaryRedirect, "/a/c.txt"},
+	}
+	for _, tt := range tests {
+		var w *httptest.ResponseRecorder
+		if tt.method == http.MethodPut {
+			w = put(h, tt.target, strings.NewReader("c"), nil)
+		} else {
+			w = serve(h, tt.method, tt.target, nil)
+		}
+		if w.Code != tt.want || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s %s = %d %q, want %d %q", tt.method, tt.target, w.Code, w.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+}
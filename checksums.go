@@ -0,0 +1,245 @@
+package fileserver
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// digestKey identifies file content: a digest is only reused while the
+// size and mtime it was computed for are unchanged.
+type digestKey struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"` // UnixNano
+}
+
+type digestEntry struct {
+	digestKey
+	SHA256 string `json:"sha256"`
+}
+
+// digestJob is one hash computation shared by every caller that asks for
+// the same uncached file while it runs.
+type digestJob struct {
+	done chan struct{}
+	sum  string
+	err  error
+}
+
+// digestCache is an LRU of SHA-256 digests, optionally persisted so
+// restarts keep warm data.
+type digestCache struct {
+	max  int
+	file string
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element // by path
+	lru      *list.List               // front is most recently used
+	inflight map[digestKey]*digestJob
+	dirty    bool
+}
+
+func newDigestCache(max int, file string) (*digestCache, error) {
+	c := &digestCache{
+		max:      max,
+		file:     file,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		inflight: make(map[digestKey]*digestJob),
+	}
+	if file == "" {
+		return c, nil
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checksum cache: %v", err)
+	}
+	var saved []digestEntry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse checksum cache %s: %v", file, err)
+	}
+	// Saved most recently used first
+	for i := len(saved) - 1; i >= 0; i-- {
+		c.add(&saved[i])
+	}
+	return c, nil
+}
+
+func keyFor(path string, info fs.FileInfo) digestKey {
+	return digestKey{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+}
+
+// add inserts an entry, replacing any older digest for the same path.
+// Callers hold mu.
+func (c *digestCache) add(entry *digestEntry) {
+	if elem, ok := c.entries[entry.Path]; ok {
+		c.lru.Remove(elem)
+	}
+	c.entries[entry.Path] = c.lru.PushFront(entry)
+	for c.max > 0 && c.lru.Len() > c.max {
+		oldest := c.lru.Remove(c.lru.Back()).(*digestEntry)
+		delete(c.entries, oldest.Path)
+	}
+	c.dirty = true
+}
+
+// sha256 returns the hex SHA-256 of the file at path, described by info.
+// Concurrent callers for the same uncached content share one computation,
+// which carries on in the background if ctx ends first so a retry finds
+// it cached.
+func (c *digestCache) sha256(ctx context.Context, st storage, path string, info fs.FileInfo) (string, error) {
+	key := keyFor(path, info)
+
+	c.mu.Lock()
+	if elem, ok := c.entries[path]; ok {
+		entry := elem.Value.(*digestEntry)
+		if entry.digestKey == key {
+			c.lru.MoveToFront(elem)
+			c.mu.Unlock()
+			return entry.SHA256, nil
+		}
+	}
+	job, ok := c.inflight[key]
+	if !ok {
+		job = &digestJob{done: make(chan struct{})}
+		c.inflight[key] = job
+		go c.compute(st, key, job)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-job.done:
+		return job.sum, job.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// cached returns the digest of path if it is known for its current size
+// and mtime, without computing it.
+func (c *digestCache) cached(path string, info fs.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[path]
+	if !ok || elem.Value.(*digestEntry).digestKey != keyFor(path, info) {
+		return "", false
+	}
+	return elem.Value.(*digestEntry).SHA256, true
+}
+
+// store records a digest computed elsewhere, such as while writing the
+// file.
+func (c *digestCache) store(path string, info fs.FileInfo, sum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(&digestEntry{digestKey: keyFor(path, info), SHA256: sum})
+}
+
+func (c *digestCache) compute(st storage, key digestKey, job *digestJob) {
+	job.sum, job.err = hashFile(st, key.Path)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if job.err == nil {
+		c.add(&digestEntry{digestKey: key, SHA256: job.sum})
+	}
+	c.mu.Unlock()
+	close(job.done)
+}
+
+func hashFile(st storage, path string) (string, error) {
+	file, err := st.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// save writes the cache atomically if anything changed.
+func (c *digestCache) save() error {
+	if c.file == "" {
+		return nil
+	}
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	list := make([]digestEntry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		list = append(list, *elem.Value.(*digestEntry))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.file, data)
+}
+
+// run persists the cache every minute and once more on shutdown.
+func (c *digestCache) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if err := c.save(); err != nil {
+			warnf(areaServer, "Failed to save checksum cache: %v", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// handleChecksum answers ?checksum=sha256 in sha256sum format. Large files
+// may take longer than the request timeout; the client gets 503 with
+// Retry-After while hashing continues.
+func (s *Server) handleChecksum(w http.ResponseWriter, r *http.Request, fullPath string, info fs.FileInfo) {
+	if algo := r.URL.Query().Get("checksum"); algo != "sha256" {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Unsupported checksum algorithm, use sha256", algo)
+		return
+	}
+
+	done := requestTiming(r).track("hash")
+	sum, err := s.digests.sha256(r.Context(), s.storage, fullPath, info)
+	done()
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.Header().Set("Retry-After", "30")
+		httpError(w, r, areaIO, http.StatusServiceUnavailable, "Checksum is being computed, retry shortly", "")
+		return
+	}
+	if err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to compute checksum", fmt.Sprintf("hashing %s: %v", fullPath, err))
+		return
+	}
+
+	s.emitTiming(w, r)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	io.WriteString(w, sumsLine(sum, info.Name()))
+}
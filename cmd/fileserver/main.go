@@ -0,0 +1,671 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"schrojf/fileserver"
+)
+
+const commandHelp = `Usage: fileserver <command> [flags]
+
+Commands:
+  serve        Run the file server (default; "fileserver -root X" still works)
+  version      Print version, commit, build date and Go version (-json for scripts)
+  hash <root>  Pre-compute SHA-256 digests into the checksum cache file used by serve
+  index <root> Pre-compute directory entry counts into the -dir-counts-file used by serve
+  dir-password <dir>
+               Protect a directory with a passphrase read from standard input
+  hash-password
+               Print the hash of a password read from standard input, for -users-file
+  help         Show this help; "help templates" documents custom templates
+
+Run "fileserver <command> -help" for the flags of a command.
+`
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runServe(args)
+		return
+	}
+
+	switch args[0] {
+	case "serve":
+		runServe(args[1:])
+	case "version":
+		runVersion(args[1:])
+	case "hash":
+		runHash(args[1:])
+	case "index":
+		runIndex(args[1:])
+	case "dir-password":
+		runDirPassword(args[1:])
+	case "hash-password":
+		runHashPassword(args[1:])
+	case "help":
+		if len(args) > 1 && args[1] == "templates" {
+			fmt.Print(fileserver.TemplateHelp)
+			return
+		}
+		fmt.Print(commandHelp)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], commandHelp)
+		os.Exit(2)
+	}
+}
+
+func runVersion(args []string) {
+	flags := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Print as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileserver version [-json]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	printVersion(*asJSON)
+}
+
+func printVersion(asJSON bool) {
+	b := fileserver.Build()
+	if !asJSON {
+		fmt.Println(b)
+		return
+	}
+	data, _ := json.Marshal(b)
+	fmt.Println(string(data))
+}
+
+// runHash pre-computes digests with the same root resolution as serve.
+func runHash(args []string) {
+	flags := flag.NewFlagSet("hash", flag.ExitOnError)
+	cacheFile := flags.String("checksum-cache-file", "", "Checksum cache file to fill, as passed to serve")
+	cacheSize := flags.Int("checksum-cache", 10000, "Maximum number of digests kept in the cache")
+	writeSums := flags.Bool("write-sums", false, "Write the digests to a SHA256SUMS file in root instead of standard output")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileserver hash [flags] <root>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Hashes every file under root. With -checksum-cache-file, serve answers")
+		fmt.Fprintln(flags.Output(), "?checksum=sha256 and ?sums=sha256 for them without reading the files again.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if *writeSums {
+		if err := fileserver.WriteSums(flags.Arg(0), *cacheFile, *cacheSize); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := fileserver.HashTree(flags.Arg(0), *cacheFile, *cacheSize, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// listingFlags registers the flags deciding what listings show, shared by
+// serve and index so their directory counts agree.
+func listingFlags(flags *flag.FlagSet, cfg *fileserver.Config) {
+	flags.BoolVar(&cfg.HideDotFiles, "hide-dotfiles", false, "Do not list, serve or archive files whose names start with a dot")
+	flags.StringVar(&cfg.DirNotes, "dir-notes", "text", "Show HEADER.html/FOOTER.html around listings: off, text (markup stripped) or html (trusted)")
+	flags.Func("deny", "Comma-separated patterns (e.g. default,*.bak,/private/**) answered 404 and hidden from listings, replacing the built-in list of secrets; \"default\" includes it, \"none\" disables it", denyFlag(&cfg.Deny))
+}
+
+// runIndex fills the directory counts file with the same listing settings
+// as serve.
+func runIndex(args []string) {
+	var cfg fileserver.Config
+	flags := flag.NewFlagSet("index", flag.ExitOnError)
+	flags.StringVar(&cfg.DirCountsFile, "dir-counts-file", "", "Directory counts file to fill, as passed to serve")
+	listingFlags(flags, &cfg)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileserver index [flags] <root>")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Counts the entries of every directory under root. serve with -dir-counts")
+		fmt.Fprintln(flags.Output(), "and the same -dir-counts-file and listing flags shows them without reading")
+		fmt.Fprintln(flags.Output(), "the directories again.")
+		fmt.Fprintln(flags.Output())
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 || cfg.DirCountsFile == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg.RootDir = flags.Arg(0)
+	n, err := fileserver.IndexTree(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Counted %d directories\n", n)
+}
+
+// runDirPassword writes the access file protecting a directory.
+func runDirPassword(args []string) {
+	flags := flag.NewFlagSet("dir-password", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileserver dir-password <dir> < passphrase")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintf(flags.Output(), "Reads a passphrase from the first line of standard input and writes its hash\nto <dir>/%s. serve then asks for it for the directory and everything\nbelow. Delete the file to remove the protection.\n", fileserver.DirAccessFile)
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	hash, err := fileserver.HashPassword(readPassphrase())
+	if err != nil {
+		log.Fatal(err)
+	}
+	target := filepath.Join(flags.Arg(0), fileserver.DirAccessFile)
+	if err := os.WriteFile(target, []byte(hash+"\n"), 0o644); err != nil {
+		log.Fatalf("Failed to write %s: %v", target, err)
+	}
+	fmt.Println("Protected", flags.Arg(0))
+}
+
+// runHashPassword prints a password hash for the users file.
+func runHashPassword(args []string) {
+	flags := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: fileserver hash-password < password")
+		fmt.Fprintln(flags.Output())
+		fmt.Fprintln(flags.Output(), "Prints the hash of the first line of standard input for a user's")
+		fmt.Fprintln(flags.Output(), "\"password\" in the -users-file.")
+	}
+	flags.Parse(args)
+	hash, err := fileserver.HashPassword(readPassphrase())
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(hash)
+}
+
+// readPassphrase reads the first line of standard input.
+func readPassphrase() string {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		log.Fatalf("No passphrase on standard input: %v", err)
+	}
+	return passphrase
+}
+
+// runServe runs the server; it is also what a bare "fileserver -root X"
+// invokes, so these flags stay compatible.
+func runServe(args []string) {
+	var cfg fileserver.Config
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&cfg.RootDir, "root", ".", "Root directory to serve; a .zip or .tar is served as the tree inside it, any other file alone at /")
+	flags.BoolVar(&cfg.OpenBrowser, "open", false, "Open the server in the system browser once it is listening")
+	flags.BoolVar(&cfg.SingleFile, "single-file", false, "Serve a -root file alone at / even when it is a .zip or .tar")
+	flags.Func("vhost", "Serve another root for a Host header, as HOST=ROOT with optional ;users-file=FILE and ;deny=PATTERNS (repeatable)", vhostFlag(&cfg.VirtualHosts))
+	flags.BoolVar(&cfg.VhostStrict, "vhost-strict", false, "Answer hosts without a -vhost with 421 instead of serving -root")
+	flags.IntVar(&cfg.Port, "port", 8080, "Port to listen on")
+	flags.StringVar(&cfg.Addr, "addr", "", "Listen address as host:port, e.g. 127.0.0.1:8080 (overrides -port)")
+	flags.Func("listen", "Address to serve on, repeatable: HOST:PORT (TLS when -tls-cert is set), http://HOST:PORT, https://HOST:PORT or unix:/path/to.sock (overrides -addr and -port)", func(value string) error {
+		cfg.Listen = append(cfg.Listen, value)
+		return nil
+	})
+	flags.StringVar(&cfg.TLSCertFile, "tls-cert", "", "TLS certificate file (enables HTTPS)")
+	flags.StringVar(&cfg.TLSKeyFile, "tls-key", "", "TLS private key file")
+	flags.StringVar(&cfg.TLSMinVersion, "tls-min-version", "", "Minimum TLS version, 1.2 or 1.3 (default 1.2)")
+	flags.Func("tls-ciphers", "Comma-separated TLS 1.2 cipher suites by Go name, e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 (default: Go's secure set)", listFlag(&cfg.TLSCipherSuites))
+	flags.Func("tls-curves", "Comma-separated key exchange curves in preference order: X25519MLKEM768, X25519, P-256, P-384, P-521 (default: Go's)", listFlag(&cfg.TLSCurves))
+	flags.StringVar(&cfg.ClientCAFile, "client-ca", "", "CA bundle for verifying client certificates (enables mutual TLS)")
+	flags.Func("client-allow", "Comma-separated client certificate CNs/SANs allowed (default: any verified certificate)", listFlag(&cfg.ClientAllow))
+	flags.Func("trusted-proxies", "Comma-separated CIDRs of reverse proxies whose X-Forwarded-For/-Proto headers are trusted", listFlag(&cfg.TrustedProxies))
+	flags.StringVar(&cfg.AuthProxyHeader, "auth-proxy-header", "", "Header naming the user authenticated by a -trusted-proxies proxy, e.g. X-Remote-User; ignored and removed from other peers")
+	flags.StringVar(&cfg.AuthProxyGroupsHeader, "auth-proxy-groups-header", "", "Header with the comma-separated groups of a -auth-proxy-header user, e.g. X-Remote-Groups, for group:NAME rules")
+	flags.StringVar(&cfg.SendfileHeader, "sendfile-header", "", "Offload file bodies to the proxy with this header (X-Accel-Redirect or X-Sendfile)")
+	flags.StringVar(&cfg.SendfilePrefix, "sendfile-prefix", "/protected/", "Internal location prefix used with X-Accel-Redirect")
+	flags.StringVar(&cfg.ArchiveSpoolDir, "archive-spool-dir", "", "Dedicated directory for spooling large zip downloads so they can be resumed")
+	cfg.ArchiveSpoolMin = 1 << 30
+	flags.Var(sizeFlag{&cfg.CacheSize}, "cache-size", "Memory budget for caching small files (0 disables)")
+	cfg.CacheMaxFile = 256 << 10
+	flags.Var(sizeFlag{&cfg.CacheMaxFile}, "cache-max-file", "Largest file kept in the memory cache")
+	flags.IntVar(&cfg.ChecksumCacheSize, "checksum-cache", 10000, "Maximum number of file digests to cache")
+	flags.StringVar(&cfg.ChecksumCacheFile, "checksum-cache-file", "", "Persist cached file digests to this JSON file")
+	flags.BoolVar(&cfg.ReprDigest, "repr-digest", false, "Send Repr-Digest and Digest headers on file responses whose SHA-256 is cached")
+	flags.Var(sizeFlag{&cfg.ReprDigestComputeMax}, "repr-digest-compute-max", "Compute the digest for clients sending Want-Repr-Digest on files up to this size (0: only cached digests)")
+	cfg.SumsBudget = fileserver.DefaultSumsBudget
+	flags.Var(sizeFlag{&cfg.SumsBudget}, "sums-budget", "Most bytes a ?sums=sha256 request may hash beyond already cached digests")
+	flags.Var(sizeFlag{&cfg.ArchiveSpoolMin}, "archive-spool-min", "Spool zip downloads whose estimated size is at least this large")
+	flags.DurationVar(&cfg.ArchiveSpoolTTL, "archive-spool-ttl", 6*time.Hour, "Remove spooled archives not requested for this long")
+	flags.DurationVar(&cfg.DrainTimeout, "drain-timeout", 10*time.Minute, "On shutdown, let progressing downloads finish for up to this long")
+	flags.DurationVar(&cfg.Timeouts.Request, "request-timeout", fileserver.DefaultRequestTimeout, "Deadline for filesystem work on a request (not the body transfer)")
+	flags.DurationVar(&cfg.Timeouts.Read, "read-timeout", fileserver.DefaultReadTimeout, "Maximum time to read a request")
+	flags.DurationVar(&cfg.Timeouts.ReadHeader, "read-header-timeout", fileserver.DefaultReadHeaderTimeout, "Maximum time to read request headers")
+	flags.DurationVar(&cfg.Timeouts.Write, "write-timeout", fileserver.DefaultWriteTimeout, "Maximum time to write a response; for downloads, the maximum time without progress")
+	flags.DurationVar(&cfg.Timeouts.Idle, "idle-timeout", fileserver.DefaultIdleTimeout, "Keep-alive idle timeout")
+	flags.DurationVar(&cfg.Timeouts.DirRead, "dir-read-timeout", fileserver.DefaultDirReadTimeout, "Deadline for reading a directory before a listing fails")
+	flags.DurationVar(&cfg.Timeouts.Stat, "stat-timeout", fileserver.DefaultStatTimeout, "Deadline for a single stat or open before the request gets 503")
+	flags.IntVar(&cfg.MaxDirReads, "max-dir-reads", 64, "Maximum concurrent directory reads; further listings get 503 (protects against hung mounts)")
+	flags.IntVar(&cfg.MaxConns, "max-conns", 0, "Maximum open client connections; further clients wait to be accepted (0: no limit)")
+	flags.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", 0, "Maximum concurrent requests per client address; more get 429 (0: no limit)")
+	flags.Func("per-ip-exempt", "Comma-separated CIDRs exempt from -max-conns-per-ip (e.g. monitoring)", listFlag(&cfg.PerIPLimitExempt))
+	flags.IntVar(&cfg.MinFreeFDs, "min-free-fds", fileserver.DefaultMinFreeFDs, "Refuse new downloads with 503 while fewer file descriptors than this are free (0: off)")
+	flags.IntVar(&cfg.MaxFSCalls, "max-fs-calls", 64, "Maximum concurrent stat and open calls, including ones stuck on a hung mount; further requests get 503")
+	flags.Var(sizeFlag{&cfg.IO.CopyBuffer}, "io-buffer", "Copy file bodies through a buffer of this size (e.g. 1M) instead of sendfile (0: sendfile where possible)")
+	flags.BoolVar(&cfg.IO.Sequential, "io-sequential", false, "Hint the kernel that files are read sequentially, enlarging its readahead (Linux)")
+	flags.BoolVar(&cfg.IO.DropCache, "io-drop-cache", false, "Drop bytes already sent from the page cache, so huge downloads do not evict hot files (Linux)")
+	flags.Var(sizeFlag{&cfg.IO.Readahead}, "io-readahead", "Ask the kernel to prefetch this much of a file ahead of each chunk sent (Linux; 0: off)")
+	flags.DurationVar(&cfg.MountCheckInterval, "mount-check-interval", fileserver.DefaultMountCheckInterval, "How often to probe the storage; while it fails, content routes answer 503")
+	flags.IntVar(&cfg.MaxTailSessions, "max-tail-sessions", 16, "Maximum concurrent ?tail=1 streams of growing files; 0 disables tailing")
+	flags.BoolVar(&cfg.Metrics, "metrics", false, "Expose Prometheus metrics at /metrics")
+	flags.BoolVar(&cfg.Status, "status", false, "Expose server state (connections, transfers, free space) at /_status on the public port; -health-addr always serves it")
+	flags.BoolVar(&cfg.Stats, "stats", false, "Count downloads per path and serve a popular-files report at /_stats/top")
+	flags.StringVar(&cfg.StatsFile, "stats-file", "", "Persist download statistics to this JSON file")
+	flags.DurationVar(&cfg.StatsWindow, "stats-window", time.Hour, "Repeated requests for a path by one client within this window count as one download")
+	flags.BoolVar(&cfg.Bandwidth, "bandwidth", false, "Account bytes served per client; report at /_admin/bandwidth on -health-addr")
+	flags.StringVar(&cfg.BandwidthFile, "bandwidth-file", "", "Persist per-client bandwidth accounting to this JSON file")
+	flags.IntVar(&cfg.BandwidthRetention, "bandwidth-retention", 90, "Days of per-client bandwidth history to keep")
+	flags.StringVar(&cfg.BasePath, "base-path", "", "URL prefix a reverse proxy strips before forwarding, e.g. /files; used in links and redirects")
+	flags.BoolVar(&cfg.NormalizeUnicode, "normalize-unicode", false, "Retry missing paths in the other Unicode normalization (NFC/NFD), for names created on macOS; costs an extra stat per request")
+	flags.StringVar(&cfg.AuthMode, "auth-mode", fileserver.AuthModeAll, "With authentication configured: all (every request) or write-only (anyone may browse and download; changes need credentials)")
+	flags.StringVar(&cfg.UsersFile, "users-file", "", "JSON file of users, passwords, tokens and per-path access rules; reloaded on SIGHUP")
+	flags.StringVar(&cfg.OIDC.Issuer, "oidc-issuer", "", "OpenID Connect provider URL; enables logging in there (code flow with PKCE) for a session cookie")
+	flags.StringVar(&cfg.OIDC.ClientID, "oidc-client-id", "", "OAuth client ID registered with -oidc-issuer")
+	flags.StringVar(&cfg.OIDC.ClientSecret, "oidc-client-secret", "", "OAuth client secret (default: $FILESERVER_OIDC_CLIENT_SECRET; none for a public client)")
+	flags.StringVar(&cfg.OIDC.RedirectURL, "oidc-redirect-url", "", "Callback URL registered with the provider, ending in /_oidc/callback, e.g. https://files.example.com/_oidc/callback")
+	flags.Func("oidc-scopes", "Comma-separated scopes to request (default: openid,email,profile)", listFlag(&cfg.OIDC.Scopes))
+	flags.StringVar(&cfg.OIDC.UserClaim, "oidc-claim", "email", "ID token claim naming the user in logs and -users-file rules")
+	flags.StringVar(&cfg.OIDC.GroupsClaim, "oidc-groups-claim", "groups", "ID token claim listing groups, matched by \"group:NAME\" rules in -users-file")
+	flags.Func("auth-bypass", "Comma-separated endpoints answered without authentication; \"none\" for no exceptions (default: /healthz,/readyz,/metrics, where enabled)", listFlag(&cfg.AuthBypass))
+	listingFlags(flags, &cfg)
+	flags.BoolVar(&cfg.DirPasswords, "dir-passwords", true, "Protect directories holding a "+fileserver.DirAccessFile+" file with its passphrase (create one with: fileserver dir-password)")
+	flags.BoolVar(&cfg.Grep, "grep", false, "Serve /_grep, a full-text search reading up to 512 MiB of files per request")
+	flags.IntVar(&cfg.FeedEntries, "feed-entries", fileserver.DefaultFeedEntries, "Files listed in ?format=atom feeds, newest first")
+	flags.IntVar(&cfg.StatWorkers, "stat-workers", fileserver.DefaultStatWorkers, "Directory entries stat'ed concurrently when building a listing; raise for high-latency network mounts")
+	flags.Func("hotlink-allow", "Comma-separated hosts (or *.domain) whose pages may embed images and videos besides this server's; others get 403. Requests without a Referer or with a signed playlist link pass", listFlag(&cfg.HotlinkAllow))
+	flags.Func("hotlink-types", "Comma-separated content types -hotlink-allow protects: a class like image, or a full type like application/pdf (default: image,video)", listFlag(&cfg.HotlinkTypes))
+	flags.StringVar(&cfg.HotlinkPlaceholder, "hotlink-placeholder", "", "File served with the 403 to refused hotlinks, e.g. a placeholder image")
+	flags.BoolVar(&cfg.Uploads, "uploads", false, "Accept PUT uploads to the tree, written atomically; with -users-file they need write access, without authentication anyone may upload")
+	flags.StringVar(&cfg.OnUploadCmd, "on-upload-cmd", "", "WARNING: runs with the server's privileges. Executable started in the background after each successful -uploads upload, as CMD ABSPATH RELPATH with FILESERVER_UPLOAD_{PATH,REL,SIZE,USER} and FILESERVER_REQUEST_ID set")
+	flags.IntVar(&cfg.OnUploadConcurrency, "on-upload-concurrency", fileserver.DefaultOnUploadConcurrency, "Upload commands running at once; later uploads wait their turn")
+	flags.DurationVar(&cfg.OnUploadTimeout, "on-upload-timeout", fileserver.DefaultOnUploadTimeout, "Kill an upload command running longer than this")
+	flags.StringVar(&cfg.ThumbnailerCmd, "thumbnailer-cmd", "", "SECURITY: runs with the server's privileges. Executable making video thumbnails, called as CMD INPUT OUTPUT SIZE to write a JPEG (e.g. an ffmpeg wrapper)")
+	flags.IntVar(&cfg.ThumbnailerConcurrency, "thumbnailer-concurrency", fileserver.DefaultThumbnailerConcurrency, "Thumbnail commands running at once")
+	flags.DurationVar(&cfg.ThumbnailerTimeout, "thumbnailer-timeout", fileserver.DefaultThumbnailerTimeout, "Kill a thumbnail command running longer than this")
+	flags.StringVar(&cfg.ThumbnailCacheDir, "thumbnail-cache-dir", "", "Directory caching thumbnails (default under the user cache directory)")
+	flags.Func("playlist-extensions", "Comma-separated extensions listed by ?playlist=m3u (default: mp3,flac,ogg,wav,m4a,opus)", listFlag(&cfg.PlaylistExtensions))
+	flags.DurationVar(&cfg.PlaylistLinkLifetime, "playlist-link-lifetime", 0, "Sign ?playlist=m3u entries for authenticated users so players can fetch them without credentials, valid this long (0: plain links)")
+	flags.BoolVar(&cfg.ExifRedactGPS, "exif-redact-gps", false, "Leave GPS coordinates out of ?exif=1 answers")
+	flags.Var(modeFlag{&cfg.UploadFileMode}, "upload-file-mode", "Octal permissions for uploaded files, e.g. 0664 (default: from the umask)")
+	flags.Var(modeFlag{&cfg.UploadDirMode}, "upload-dir-mode", "Octal permissions for directories created by uploads, e.g. 0775 (default: from the umask)")
+	flags.StringVar(&cfg.UploadOwner, "upload-owner", "", "Owner for uploaded files and directories as user:group, names or IDs (needs root)")
+	flags.Var(sizeFlag{&cfg.MinFreeBytes}, "min-free-bytes", "Refuse writes with 507 that would leave less free space than this on the root's filesystem")
+	flags.Float64Var(&cfg.MinFreePercent, "min-free-percent", 0, "Refuse writes with 507 that would leave less than this percentage of the root's filesystem free")
+	flags.DurationVar(&cfg.HighlightRecent, "highlight-recent", 0, "Flag listing entries modified within this long, e.g. 24h (0: off)")
+	flags.BoolVar(&cfg.DirCounts, "dir-counts", false, "Show the number of entries of each subdirectory in listings (one extra directory read per subdirectory, cached by mtime)")
+	flags.StringVar(&cfg.DirCountsFile, "dir-counts-file", "", "Persist -dir-counts counts to this JSON file, which \"fileserver index\" can fill ahead of time")
+	flags.IntVar(&cfg.FastListingOver, "fast-listing-over", 0, "Show only names in HTML listings of directories with more entries than this, with a link to full details (0: always full)")
+	flags.BoolVar(&cfg.Sitemap, "sitemap", false, "Serve /sitemap.xml listing every file, for public instances meant to be indexed by search engines")
+	flags.StringVar(&cfg.PublicURL, "public-url", "", "External URL of the server including any base path (e.g. https://files.example.org), used for sitemap links")
+	flags.BoolVar(&cfg.CSVOwners, "csv-owners", false, "Add mode, owner and group columns to ?format=csv listings")
+	flags.BoolVar(&cfg.SISizes, "si", false, "Show sizes in base-1000 units (kB, MB, GB) instead of base-1024 (KiB, MiB, GiB)")
+	flags.BoolVar(&cfg.ExactSizes, "exact-sizes", false, "Show sizes as exact, thousands-separated byte counts")
+	flags.BoolVar(&cfg.RelativeTimes, "relative-times", false, "Show modification times as \"3 hours ago\", with the date in a tooltip")
+	flags.DurationVar(&cfg.RelativeTimesMax, "relative-times-max", fileserver.DefaultRelativeTimesMax, "Age beyond which -relative-times shows the date instead")
+	flags.Func("index", "Comma-separated file names served instead of a directory listing, tried in order (e.g. index.html,index.htm)", listFlag(&cfg.IndexFiles))
+	flags.BoolVar(&cfg.RenderHTML, "render-html", false, "Serve HTML and SVG files as-is instead of sandboxed with a Content-Security-Policy")
+	flags.Func("trusted-html", "Comma-separated URL prefixes (e.g. /site/) whose HTML and SVG render unsandboxed and are exempt from -force-download-ext", listFlag(&cfg.TrustedHTML))
+	flags.Var(sizeFlag{&cfg.MaxFileSize}, "max-file-size", "Refuse files larger than this with 403 and leave them out of archives (0: no limit)")
+	flags.Func("max-file-size-override", "Comma-separated PREFIX=SIZE limits replacing -max-file-size below a URL prefix, e.g. /isos/=0 for no limit", func(value string) error {
+		if cfg.MaxFileSizeOverrides == nil {
+			cfg.MaxFileSizeOverrides = map[string]int64{}
+		}
+		for _, item := range splitList(value) {
+			prefix, size, ok := strings.Cut(item, "=")
+			n, err := parseSize(size)
+			if !ok || !strings.HasPrefix(prefix, "/") || err != nil {
+				return fmt.Errorf("invalid override %q, want /prefix/=SIZE", item)
+			}
+			cfg.MaxFileSizeOverrides[prefix] = n
+		}
+		return nil
+	})
+	flags.Func("force-download-ext", "Comma-separated extensions (e.g. .html,.svg) always sent as downloads, never shown inline", listFlag(&cfg.ForceDownloadExt))
+	flags.StringVar(&cfg.AssetsPrefix, "assets-prefix", fileserver.DefaultAssetsPrefix, "URL prefix for the embedded stylesheets; change it if the served tree has a directory of that name")
+	flags.StringVar(&cfg.TemplateDir, "templates", "", "Directory of templates replacing the embedded ones of the same name (see: fileserver help templates)")
+	flags.StringVar(&cfg.RewriteRules, "rewrite-rules", "", "File of ordered redirect and rewrite rules for old links, e.g. \"redirect 301 /old/* /new/$1\"; reloaded on SIGHUP")
+	flags.BoolVar(&cfg.Descriptions, "descriptions", false, "Show descriptions from a directory's .descriptions file and NAME.meta files in listings, hiding those files")
+	flags.BoolVar(&cfg.APIExplorer, "api-explorer", false, "Serve an API explorer page at /_api/")
+	flags.BoolVar(&cfg.ServerTiming, "timing", false, "Add Server-Timing headers with listing and file phase durations")
+	flags.StringVar(&cfg.HealthAddr, "health-addr", "", "Separate plain HTTP address for /healthz, e.g. :8081")
+	flags.StringVar(&cfg.OTelEndpoint, "otel-endpoint", "", "OTLP/HTTP collector to send a trace span per request to, e.g. http://localhost:4318; continues incoming traceparent headers (default: tracing off)")
+	flags.Float64Var(&cfg.OTelSampleRatio, "otel-sample-ratio", 1, "Fraction of requests without a traceparent to trace, above 0 and up to 1; requests with one follow its sampled flag")
+	flags.StringVar(&cfg.OTelServiceName, "otel-service-name", "fileserver", "service.name of exported spans")
+	flags.BoolVar(&cfg.Pprof, "pprof", false, "Serve Go profiling endpoints under /debug/pprof/ and expvar counters at /debug/vars. SECURITY: without -pprof-addr they are on the public port, where anyone passing authentication can read the command line (including any secrets in flags), take CPU profiles and traces that load the server, and learn its internals")
+	flags.StringVar(&cfg.PprofAddr, "pprof-addr", "", "Separate plain HTTP address for /debug/pprof/ and /debug/vars without authentication, e.g. 127.0.0.1:6060; keep it private. Implies -pprof")
+	flags.BoolVar(&cfg.AccessLog, "access-log", false, "Log every request")
+	flags.StringVar(&cfg.AccessLogFile, "access-log-file", "", "Write the access log to this file instead of the application log (implies -access-log)")
+	flags.StringVar(&cfg.LogLevel, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	flags.Func("log-debug", "Comma-separated areas to log at debug level ("+strings.Join(fileserver.LogAreas(), ", ")+")", listFlag(&cfg.LogDebugAreas))
+	flags.StringVar(&cfg.LogFile, "log-file", "", "Write application logs to this file instead of stderr")
+	cfg.LogMaxSize = 100 << 20
+	flags.Var(sizeFlag{&cfg.LogMaxSize}, "log-max-size", "Rotate log files when they reach this size (0 disables)")
+	flags.DurationVar(&cfg.LogMaxAge, "log-max-age", 0, "Rotate log files older than this, e.g. 24h (0 disables)")
+	flags.IntVar(&cfg.LogMaxBackups, "log-max-backups", 5, "Number of rotated log files to keep")
+	flags.BoolVar(&cfg.ServerHeader, "server-header", false, "Send a Server header with the version")
+	showVersion := flags.Bool("version", false, "Print version information and exit")
+	versionJSON := flags.Bool("json", false, "With -version, print JSON")
+	pidFile := flags.String("pid-file", "", "Write the process ID to this file while running")
+	help := flags.Bool("help", false, "Show help message")
+	flags.Parse(args)
+
+	if *showVersion {
+		printVersion(*versionJSON)
+		return
+	}
+
+	if *help {
+		fmt.Println("Simple Web File Server")
+		fmt.Println()
+		fmt.Println("Usage: fileserver [serve] [flags]")
+		flags.PrintDefaults()
+		fmt.Println()
+		fmt.Println("Examples:")
+		fmt.Println("  ./fileserver -root /var/www -port 8080")
+		fmt.Println("  ./fileserver -root /home/user/documents")
+		fmt.Println("  ./fileserver -root /mnt/external-drive")
+		fmt.Println("  ./fileserver -root /srv/files -tls-cert cert.pem -tls-key key.pem -client-ca ca.pem -health-addr :8081")
+		fmt.Println()
+		fmt.Println("Other commands: fileserver version, fileserver hash <dir> (see fileserver help)")
+		return
+	}
+
+	if cfg.OIDC.ClientSecret == "" {
+		cfg.OIDC.ClientSecret = os.Getenv("FILESERVER_OIDC_CLIENT_SECRET")
+	}
+
+	if err := fileserver.ConfigureLogging(cfg.LogLevel, cfg.LogDebugAreas); err != nil {
+		log.Fatal(err)
+	}
+
+	var appLog *fileserver.RotatingFile
+	if cfg.LogFile != "" {
+		var err error
+		appLog, err = fileserver.NewRotatingFile(cfg.LogFile, cfg.LogMaxSize, cfg.LogMaxAge, cfg.LogMaxBackups)
+		if err != nil {
+			log.Fatal("Failed to open log file: ", err)
+		}
+		log.SetOutput(appLog)
+		defer appLog.Close()
+	}
+
+	ready, err := inheritListeners(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server, err := fileserver.NewServerFromConfig(cfg)
+	if err != nil {
+		log.Fatal("Failed to create server:", err)
+	}
+
+	if *pidFile != "" {
+		// A restarted process takes the PID file over from its parent
+		write := writePIDFile
+		if ready != nil {
+			write = replacePIDFile
+		}
+		if err := write(*pidFile); err != nil {
+			log.Fatal("Failed to write PID file: ", err)
+		}
+		defer removePIDFile(*pidFile)
+	}
+
+	// Set up graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+
+	statusChan := make(chan os.Signal, 1)
+	signal.Notify(statusChan, syscall.SIGUSR1)
+	go func() {
+		for range statusChan {
+			server.LogStatus()
+		}
+	}()
+
+	// SIGHUP reopens log files after an external logrotate
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			if appLog != nil {
+				if err := appLog.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+				}
+			}
+			server.ReopenLogs()
+			if err := server.ReloadUsers(); err != nil {
+				log.Printf("Failed to reload users file, keeping the previous rules: %v", err)
+			}
+			if err := server.ReloadRewriteRules(); err != nil {
+				log.Printf("Failed to reload rewrite rules, keeping the previous ones: %v", err)
+			}
+		}
+	}()
+
+	// SIGUSR2 hands the listeners to a freshly started binary and drains
+	handedOff := make(chan int, 1)
+	restartChan := make(chan os.Signal, 1)
+	signal.Notify(restartChan, syscall.SIGUSR2)
+	go func() {
+		for range restartChan {
+			log.Printf("Graceful restart requested, starting a new process")
+			pid, err := restart(server)
+			if err != nil {
+				log.Printf("Graceful restart failed, still serving: %v", err)
+				continue
+			}
+			handedOff <- pid
+			return
+		}
+	}()
+
+	// Start server in goroutine
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.Start(); err != nil && err != http.ErrServerClosed {
+			serverErr <- err
+		}
+	}()
+	if ready != nil {
+		// Errors from Start surface at once; after a moment without one the
+		// parent can stop accepting
+		select {
+		case err := <-serverErr:
+			log.Fatal("Server error:", err)
+		case <-time.After(time.Second):
+			signalReady(ready)
+		}
+	}
+
+	// Wait for shutdown signal, handoff or server error
+	select {
+	case sig := <-sigChan:
+		fmt.Printf("\nReceived signal: %v\n", sig)
+		fmt.Println("Shutting down gracefully...")
+
+		// A second signal means the operator does not want to wait
+		go func() {
+			sig := <-sigChan
+			log.Printf("Received second signal %v, exiting immediately", sig)
+			if *pidFile != "" {
+				removePIDFile(*pidFile)
+			}
+			os.Exit(1)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Server shutdown error: %v", err)
+		} else {
+			fmt.Println("Server stopped gracefully")
+		}
+
+	case pid := <-handedOff:
+		log.Printf("Process %d took over the listeners, draining", pid)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Server shutdown error: %v", err)
+		} else {
+			log.Printf("Drained, handing over to process %d", pid)
+		}
+
+	case err := <-serverErr:
+		if *pidFile != "" {
+			removePIDFile(*pidFile)
+		}
+		log.Fatal("Server error:", err)
+	}
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseSize accepts plain byte counts or binary-unit suffixes such as
+// "512K", "100MB" or "2GiB".
+func parseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if i := strings.IndexByte("KMGTPE", value[n-1]); i >= 0 {
+			value = value[:n-1]
+			for ; i >= 0; i-- {
+				multiplier *= 1024
+			}
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// sizeFlag is a flag.Value for human-readable byte sizes.
+type sizeFlag struct{ p *int64 }
+
+func (f sizeFlag) String() string {
+	if f.p == nil {
+		return ""
+	}
+	return fileserver.FormatSize(*f.p)
+}
+
+func (f sizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*f.p = n
+	return nil
+}
+
+// modeFlag is a flag.Value for octal permission bits.
+type modeFlag struct{ p *fs.FileMode }
+
+func (f modeFlag) String() string {
+	if f.p == nil || *f.p == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04o", uint32(*f.p))
+}
+
+func (f modeFlag) Set(s string) error {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > uint64(fs.ModePerm) {
+		return fmt.Errorf("invalid mode %q, want octal permissions like 0644", s)
+	}
+	*f.p = fs.FileMode(n)
+	return nil
+}
+
+// denyFlag builds Config.Deny: the first use replaces the built-in list,
+// "default" stands for it and "none" clears everything.
+func denyFlag(dst *[]string) func(string) error {
+	return func(value string) error {
+		if *dst == nil {
+			*dst = []string{}
+		}
+		for _, item := range splitList(value) {
+			switch item {
+			case "default":
+				*dst = append(*dst, fileserver.DefaultDeny...)
+			case "none":
+				*dst = []string{}
+			default:
+				*dst = append(*dst, item)
+			}
+		}
+		return nil
+	}
+}
+
+// vhostFlag parses -vhost HOST=ROOT[;users-file=FILE][;deny=PATTERNS].
+func vhostFlag(dst *[]fileserver.VirtualHost) func(string) error {
+	return func(value string) error {
+		parts := strings.Split(value, ";")
+		host, root, ok := strings.Cut(parts[0], "=")
+		if !ok || host == "" || root == "" {
+			return fmt.Errorf("want HOST=ROOT, got %q", parts[0])
+		}
+		vh := fileserver.VirtualHost{Host: host, Root: root}
+		for _, part := range parts[1:] {
+			key, val, _ := strings.Cut(part, "=")
+			switch key {
+			case "users-file":
+				vh.UsersFile = val
+			case "deny":
+				if err := denyFlag(&vh.Deny)(val); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unknown virtual host setting %q, want users-file or deny", key)
+			}
+		}
+		*dst = append(*dst, vh)
+		return nil
+	}
+}
+
+// listFlag appends comma-separated values, so list flags may also be repeated.
+func listFlag(dst *[]string) func(string) error {
+	return func(value string) error {
+		*dst = append(*dst, splitList(value)...)
+		return nil
+	}
+}
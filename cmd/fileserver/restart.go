@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"schrojf/fileserver"
+)
+
+// A graceful restart (SIGUSR2) starts the current binary again with the
+// listening sockets as extra files: fd 3 is a pipe the new process writes
+// to once it is ready, and the listeners follow from fd 4 in the order
+// named by inheritEnv. The old process only starts draining after that, so
+// a binary that fails to start leaves it serving.
+const (
+	inheritEnv       = "FILESERVER_INHERIT" // e.g. "listen,listen,health"
+	restartReadyFD   = 3
+	restartTimeout   = 30 * time.Second
+	restartReadyWord = "ready"
+)
+
+// restart execs the replacement and waits until it is serving, returning
+// its PID.
+func restart(server *fileserver.Server) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to find executable: %v", err)
+	}
+	files, health, err := server.ListenerFiles()
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for range files {
+		names = append(names, "listen")
+	}
+	if health != nil {
+		names = append(names, "health")
+		files = append(files, health)
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create pipe: %v", err)
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), inheritEnv+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = append([]*os.File{readyW}, files...)
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start %s: %v", exe, err)
+	}
+
+	// Reap the child if it dies before taking over
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	word := make(chan bool, 1)
+	go func() {
+		buf := make([]byte, len(restartReadyWord))
+		_, err := io.ReadFull(ready, buf)
+		word <- err == nil && string(buf) == restartReadyWord
+	}()
+
+	select {
+	case ok := <-word:
+		if ok {
+			return cmd.Process.Pid, nil
+		}
+		err := <-exited
+		return 0, fmt.Errorf("new process exited before taking over: %v", err)
+	case <-time.After(restartTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("new process not ready after %s, killed it", restartTimeout)
+	}
+}
+
+// inheritListeners fills cfg with the sockets passed by a restarting parent
+// and returns the pipe to report readiness on, or nil when not restarted.
+func inheritListeners(cfg *fileserver.Config) (*os.File, error) {
+	inherit, ok := os.LookupEnv(inheritEnv)
+	if !ok {
+		return nil, nil
+	}
+	os.Unsetenv(inheritEnv)
+
+	for i, name := range strings.Split(inherit, ",") {
+		file := os.NewFile(uintptr(restartReadyFD+1+i), name)
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inherit %s listener: %v", name, err)
+		}
+		switch name {
+		case "listen":
+			cfg.Listeners = append(cfg.Listeners, ln)
+		case "health":
+			cfg.HealthListener = ln
+		default:
+			ln.Close()
+		}
+	}
+	return os.NewFile(restartReadyFD, "ready"), nil
+}
+
+// signalReady tells the parent to start draining.
+func signalReady(ready *os.File) {
+	if ready == nil {
+		return
+	}
+	ready.Write([]byte(restartReadyWord))
+	ready.Close()
+}
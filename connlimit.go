@@ -0,0 +1,106 @@
+package fileserver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connLimiter caps the open connections across the listeners it wraps.
+// Accept waits for a free slot, so a burst of clients queues in the kernel's
+// backlog instead of costing a file descriptor and goroutine each.
+type connLimiter struct {
+	max      int
+	slots    chan struct{}
+	conns    atomic.Int64
+	waits    atomic.Int64 // accepts that found every slot taken
+	lastWarn atomic.Int64 // unix nanoseconds
+}
+
+func newConnLimiter(max int) *connLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &connLimiter{max: max, slots: make(chan struct{}, max)}
+}
+
+// open is the number of accepted connections holding a slot.
+func (l *connLimiter) open() int64 {
+	return l.conns.Load()
+}
+
+// wrap applies the limit to ln. A nil limiter returns ln unchanged.
+func (l *connLimiter) wrap(ln net.Listener) net.Listener {
+	if l == nil {
+		return ln
+	}
+	return &limitListener{Listener: ln, limiter: l, closed: make(chan struct{})}
+}
+
+// acquire takes a slot, waiting until one frees up or closed is closed.
+func (l *connLimiter) acquire(closed <-chan struct{}) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	l.waits.Add(1)
+	// At most one warning a minute; a sustained burst would flood the log
+	now := time.Now().UnixNano()
+	if last := l.lastWarn.Load(); now-last > int64(time.Minute) && l.lastWarn.CompareAndSwap(last, now) {
+		warnf(areaServer, "Connection limit of %d reached; new connections wait for a free slot (see -max-conns)", l.max)
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+func (l *connLimiter) release() {
+	<-l.slots
+}
+
+type limitListener struct {
+	net.Listener
+	limiter   *connLimiter
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	if !ll.limiter.acquire(ll.closed) {
+		return nil, net.ErrClosed
+	}
+	conn, err := ll.Listener.Accept()
+	if err != nil {
+		ll.limiter.release()
+		return nil, err
+	}
+	ll.limiter.conns.Add(1)
+	return &limitConn{Conn: conn, release: func() {
+		ll.limiter.conns.Add(-1)
+		ll.limiter.release()
+	}}, nil
+}
+
+// Close also wakes an Accept waiting for a slot, so shutdown never blocks
+// on the limiter.
+func (ll *limitListener) Close() error {
+	ll.closeOnce.Do(func() { close(ll.closed) })
+	return ll.Listener.Close()
+}
+
+type limitConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
@@ -0,0 +1,102 @@
+package fileserver
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// activeExtensions are served types a browser may run scripts from.
+var activeExtensions = map[string]bool{
+	".html": true, ".htm": true, ".shtml": true, ".xhtml": true, ".svg": true, ".svgz": true, ".xml": true,
+}
+
+// applyContentPolicy sets headers deciding how a browser treats a file
+// before it is served. Outside the TrustedHTML prefixes, ForceDownloadExt
+// files are always attachments and, unless RenderHTML is set, HTML and SVG
+// are sandboxed: they render, but cannot run scripts or act as the server's
+// origin. ?raw=1 forces text/plain.
+func (s *Server) applyContentPolicy(w http.ResponseWriter, r *http.Request, fullPath string) {
+	ext := strings.ToLower(path.Ext(fullPath))
+	trusted := s.trustedPath(s.requestPathOf(fullPath))
+	if !trusted && s.forceDownload(ext) {
+		w.Header().Set("Content-Disposition", contentDisposition("attachment", path.Base(fullPath)))
+	}
+	if r.URL.Query().Get("raw") == "1" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		return
+	}
+	if trusted || s.config.RenderHTML || !activeExtensions[ext] {
+		return
+	}
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// trustedPath reports whether requestPath is under a TrustedHTML prefix.
+func (s *Server) trustedPath(requestPath string) bool {
+	for _, prefix := range s.config.TrustedHTML {
+		if underPrefix(requestPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// underPrefix reports whether requestPath is prefix or below it, with or
+// without a trailing slash on prefix. Users file rules, size overrides and
+// trusted prefixes all match through it, so on case-insensitive platforms
+// it ignores case: /PRIVATE/x is the same file as /private/x there.
+func underPrefix(requestPath, prefix string) bool {
+	return underPrefixFold(requestPath, prefix, caseInsensitivePaths)
+}
+
+func underPrefixFold(requestPath, prefix string, fold bool) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if fold {
+		requestPath, prefix = strings.ToLower(requestPath), strings.ToLower(prefix)
+	}
+	return requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+}
+
+// samePath compares request paths as the filesystem would.
+func samePath(a, b string) bool {
+	if caseInsensitivePaths {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// fileSizeLimit is the largest file served at requestPath, 0 for no limit.
+// The longest matching MaxFileSizeOverrides prefix wins over MaxFileSize.
+func (s *Server) fileSizeLimit(requestPath string) int64 {
+	limit, longest := s.config.MaxFileSize, -1
+	for prefix, override := range s.config.MaxFileSizeOverrides {
+		if underPrefix(requestPath, prefix) && len(prefix) > longest {
+			limit, longest = override, len(prefix)
+		}
+	}
+	return limit
+}
+
+// tooLarge reports archive members over the limit, given paths relative to
+// the archived directory; nil when no limit is configured.
+func (s *Server) tooLarge(base string) func(p string, size int64) bool {
+	if s.config.MaxFileSize <= 0 && len(s.config.MaxFileSizeOverrides) == 0 {
+		return nil
+	}
+	return func(p string, size int64) bool {
+		limit := s.fileSizeLimit(path.Join(base, p))
+		return limit > 0 && size > limit
+	}
+}
+
+func (s *Server) forceDownload(ext string) bool {
+	for _, forced := range s.config.ForceDownloadExt {
+		if strings.EqualFold("."+strings.TrimPrefix(forced, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package fileserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUnderPrefixFold(t *testing.T) {
+	tests := []struct {
+		path, prefix string
+		fold, want   bool
+	}{
+		{"/private", "/private", false, true},
+		{"/private/x", "/private", false, true},
+		{"/private/x", "/private/", false, true},
+		{"/private-old/x", "/private", false, false},
+		{"/PRIVATE/x", "/private", false, false},
+		{"/PRIVATE/x", "/private", true, true},
+		{"/Private", "/private/", true, true},
+		{"/PRIVATE-old/x", "/private", true, false},
+		{"/anything", "/", false, true},
+	}
+	for _, tt := range tests {
+		if got := underPrefixFold(tt.path, tt.prefix, tt.fold); got != tt.want {
+			t.Errorf("underPrefixFold(%q, %q, %v) = %v, want %v", tt.path, tt.prefix, tt.fold, got, tt.want)
+		}
+	}
+}
+
+// TestFileSizeLimitCase checks that an override covers every spelling
+// the filesystem treats as the same path.
+func TestFileSizeLimitCase(t *testing.T) {
+	s := &Server{config: Config{MaxFileSize: 100, MaxFileSizeOverrides: map[string]int64{"/big": 1000}}}
+	if got := s.fileSizeLimit("/big/a.iso"); got != 1000 {
+		t.Errorf("fileSizeLimit(/big/a.iso) = %d, want 1000", got)
+	}
+	want := int64(100)
+	if caseInsensitivePaths {
+		want = 1000
+	}
+	if got := s.fileSizeLimit("/BIG/a.iso"); got != want {
+		t.Errorf("fileSizeLimit(/BIG/a.iso) = %d, want %d", got, want)
+	}
+}
+
+// TestMaxFileSizeMethods checks that the limit holds for every method
+// that reaches a file, HEAD and ranges included, not only for GET.
+func TestMaxFileSizeMethods(t *testing.T) {
+	s, h := newTestServer(t, Config{MaxFileSize: 4, MaxFileSizeOverrides: map[string]int64{"/big": 0}})
+	writeFiles(t, s.rootDir, map[string]string{"small.txt": "tiny", "large.txt": "too large", "big/large.txt": "too large"})
+
+	tests := []struct {
+		method, target, rng string
+		want                int
+	}{
+		{http.MethodGet, "/small.txt", "", http.StatusOK},
+		{http.MethodHead, "/small.txt", "", http.StatusOK},
+		{http.MethodGet, "/large.txt", "", http.StatusForbidden},
+		{http.MethodHead, "/large.txt", "", http.StatusForbidden},
+		{http.MethodGet, "/large.txt", "bytes=0-1", http.StatusForbidden},
+		{http.MethodHead, "/big/large.txt", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.rng != "" {
+			header.Set("Range", tt.rng)
+		}
+		w := serve(h, tt.method, tt.target, header)
+		if w.Code != tt.want {
+			t.Errorf("%s %s %s: status %d, want %d", tt.method, tt.target, tt.rng, w.Code, tt.want)
+		}
+		if w.Code == http.StatusForbidden && strings.Contains(w.Body.String(), "too large") {
+			t.Errorf("%s %s: refused response carries the file", tt.method, tt.target)
+		}
+	}
+}
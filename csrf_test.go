@@ -0,0 +1,89 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// formPost submits form to target as a browser would, with extra headers
+// and cookies.
+func formPost(h http.Handler, target string, form url.Values, header http.Header, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCrossSiteFormPost(t *testing.T) {
+	root := t.TempDir()
+	hash, err := HashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, map[string]string{
+		filepath.Join("private", DirAccessFile): hash + "\n",
+		"private/file.txt":                      "secret",
+	})
+	// The login form comes with authentication, which would ask for it
+	// before the unlock form too, so each gets its own server
+	_, login := newTestServer(t, Config{
+		Login: func(user, password string) bool { return user == "alice" && password == "pw" },
+	})
+	_, unlock := newTestServer(t, Config{RootDir: root, DirPasswords: true})
+
+	token := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	csrf := &http.Cookie{Name: csrfCookie, Value: token}
+	forms := []struct {
+		h      http.Handler
+		target string
+		form   url.Values
+	}{
+		{login, loginPath, url.Values{"user": {"alice"}, "password": {"pw"}, csrfField: {token}}},
+		{unlock, unlockPath, url.Values{"dir": {"/private"}, "password": {"open sesame"}, csrfField: {token}}},
+	}
+
+	for _, f := range forms {
+		h, target, form := f.h, f.target, f.form
+		tests := []struct {
+			name    string
+			header  http.Header
+			cookies []*http.Cookie
+			want    int
+		}{
+			{"cross-site fetch", http.Header{"Sec-Fetch-Site": {"cross-site"}}, []*http.Cookie{csrf}, http.StatusForbidden},
+			{"foreign origin", http.Header{"Origin": {"https://evil.example"}}, []*http.Cookie{csrf}, http.StatusForbidden},
+			{"no CSRF cookie", http.Header{"Sec-Fetch-Site": {"same-origin"}}, nil, http.StatusForbidden},
+			{"same origin", http.Header{"Sec-Fetch-Site": {"same-origin"}}, []*http.Cookie{csrf}, http.StatusSeeOther},
+		}
+		for _, tt := range tests {
+			w := formPost(h, target, form, tt.header, tt.cookies...)
+			if w.Code != tt.want {
+				t.Errorf("POST %s, %s: status %d, want %d", target, tt.name, w.Code, tt.want)
+			}
+			if tt.want == http.StatusForbidden && len(w.Result().Cookies()) != 0 {
+				t.Errorf("POST %s, %s: refused request set cookies %v", target, tt.name, w.Result().Cookies())
+			}
+		}
+
+		// A forged token fails even from the same origin
+		forged := url.Values{}
+		for k, v := range form {
+			forged[k] = v
+		}
+		forged.Set(csrfField, strings.Repeat("0", 64))
+		if w := formPost(h, target, forged, http.Header{"Sec-Fetch-Site": {"same-origin"}}, csrf); w.Code != http.StatusForbidden {
+			t.Errorf("POST %s with a mismatching token: status %d, want 403", target, w.Code)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package fileserver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultDeny is used when Config.Deny is nil: common secrets and version
+// control metadata.
+var DefaultDeny = []string{
+	".env", ".env.*", "id_rsa", "id_dsa", "id_ecdsa", "id_ed25519", "*.key",
+	".git", ".svn", ".hg", ".ssh", ".aws", ".htpasswd", ".netrc",
+}
+
+// denyPattern matches request paths. A pattern without a slash matches any
+// single path segment, so "*.key" blocks key files and ".git" blocks the
+// directory with everything in it. Patterns with slashes match runs of
+// segments, "**" standing for any number of them; a leading slash anchors
+// at the root ("/private/**"). Matching ignores case, so a case-insensitive
+// filesystem cannot be used to slip past a pattern.
+type denyPattern struct {
+	raw      string
+	segments []string
+	anchored bool
+}
+
+type denyList []denyPattern
+
+func compileDeny(patterns []string) (denyList, error) {
+	var list denyList
+	for _, raw := range patterns {
+		p := denyPattern{raw: raw, anchored: strings.HasPrefix(raw, "/")}
+		for _, seg := range strings.Split(strings.Trim(strings.ToLower(raw), "/"), "/") {
+			if _, err := path.Match(seg, ""); err != nil || seg == "" {
+				return nil, fmt.Errorf("invalid deny pattern %q", raw)
+			}
+			p.segments = append(p.segments, seg)
+		}
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// match returns the pattern denying requestPath, or "". A denied directory
+// denies everything below it.
+func (l denyList) match(requestPath string) string {
+	if len(l) == 0 {
+		return ""
+	}
+	var segments []string
+	for _, seg := range strings.Split(strings.ToLower(requestPath), "/") {
+		if seg != "" {
+			segments = append(segments, seg)
+		}
+	}
+	for _, p := range l {
+		for start := 0; start < len(segments); start++ {
+			if matchSegments(p.segments, segments[start:]) {
+				return p.raw
+			}
+			if p.anchored {
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// matchSegments reports whether pattern matches a leading run of segments.
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return true
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segments[0])
+	return ok && matchSegments(pattern[1:], segments[1:])
+}
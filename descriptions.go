@@ -0,0 +1,168 @@
+package fileserver
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Sidecar descriptions, with -descriptions: a directory's .descriptions
+// file has a line per entry, the name (quoted if it has spaces) followed
+// by its description, as in 4DOS's descript.ion; a NAME.meta file holds
+// the description of NAME alone and wins over the directory file.
+const (
+	descriptionsFile    = ".descriptions"
+	descriptionExt      = ".meta"
+	descriptionsMaxSize = 64 << 10
+	descriptionMaxSize  = 4 << 10
+
+	descriptionCacheSize = 4096
+)
+
+// descriptionCache holds parsed sidecar files by path, valid while their
+// modification time and size are unchanged.
+type descriptionCache struct {
+	mu      sync.Mutex
+	entries map[string]descriptionCacheEntry
+}
+
+type descriptionCacheEntry struct {
+	modTime time.Time
+	size    int64
+	byName  map[string]string // a .meta file's is keyed by ""
+}
+
+func (c *descriptionCache) get(path string, info fs.FileInfo) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if !ok || !e.modTime.Equal(info.ModTime()) || e.size != info.Size() {
+		return nil, false
+	}
+	return e.byName, true
+}
+
+func (c *descriptionCache) put(path string, info fs.FileInfo, byName map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= descriptionCacheSize {
+		// Crude, but listings reread what they need
+		c.entries = make(map[string]descriptionCacheEntry)
+	}
+	c.entries[path] = descriptionCacheEntry{modTime: info.ModTime(), size: info.Size(), byName: byName}
+}
+
+// isSidecar reports whether name is a description file hidden from the
+// listing of a directory holding names. NAME.meta is a sidecar only
+// while NAME exists.
+func (s *Server) isSidecar(name string, names map[string]bool) bool {
+	if !s.config.Descriptions {
+		return false
+	}
+	return name == descriptionsFile || strings.HasSuffix(name, descriptionExt) && names[strings.TrimSuffix(name, descriptionExt)]
+}
+
+// describe sets the Description of files from the sidecars among
+// entries of the directory fullPath. Unreadable, oversized or malformed
+// sidecars are logged at debug level and skipped.
+func (s *Server) describe(ctx context.Context, fullPath string, entries []fs.DirEntry, files []FileInfo) {
+	var dirFile fs.DirEntry
+	metas := map[string]fs.DirEntry{}
+	for _, entry := range entries {
+		switch name := entry.Name(); {
+		case name == descriptionsFile:
+			dirFile = entry
+		case strings.HasSuffix(name, descriptionExt):
+			metas[strings.TrimSuffix(name, descriptionExt)] = entry
+		}
+	}
+	if dirFile == nil && len(metas) == 0 {
+		return
+	}
+	var byName map[string]string
+	if dirFile != nil {
+		byName = s.readSidecar(ctx, fullPath, dirFile, descriptionsMaxSize, parseDescriptions)
+	}
+	for i := range files {
+		if meta, ok := metas[files[i].Name]; ok {
+			if text := s.readSidecar(ctx, fullPath, meta, descriptionMaxSize, parseMeta)[""]; text != "" {
+				files[i].Description = text
+				continue
+			}
+		}
+		files[i].Description = byName[files[i].Name]
+	}
+}
+
+func (s *Server) readSidecar(ctx context.Context, dir string, entry fs.DirEntry, maxSize int64, parse func(path, data string) map[string]string) map[string]string {
+	path := filepath.Join(dir, entry.Name())
+	info, err := entry.Info()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	if byName, ok := s.descriptions.get(path, info); ok {
+		return byName
+	}
+	var byName map[string]string
+	if info.Size() > maxSize {
+		debugf(areaListing, "Ignoring %s larger than %s", path, FormatSize(maxSize))
+	} else if data, err := s.readSmallFile(ctx, path, maxSize); err != nil {
+		debugf(areaListing, "Failed to read %s: %v", path, err)
+		return nil // not cached, so a transient failure is retried
+	} else if !utf8.Valid(data) {
+		debugf(areaListing, "Ignoring %s: not UTF-8 text", path)
+	} else {
+		byName = parse(path, string(data))
+	}
+	s.descriptions.put(path, info, byName)
+	return byName
+}
+
+func (s *Server) readSmallFile(ctx context.Context, path string, maxSize int64) ([]byte, error) {
+	file, err := s.open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(io.LimitReader(file, maxSize))
+}
+
+// parseMeta reads a NAME.meta file: its text, with surrounding space and
+// line breaks collapsed.
+func parseMeta(path, data string) map[string]string {
+	return map[string]string{"": strings.Join(strings.Fields(data), " ")}
+}
+
+// parseDescriptions reads a .descriptions file. Blank lines and lines
+// starting with # are skipped, as are malformed ones.
+func parseDescriptions(path, data string) map[string]string {
+	byName := map[string]string{}
+	for n, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var name, text string
+		if rest, ok := strings.CutPrefix(line, `"`); ok {
+			var found bool
+			if name, text, found = strings.Cut(rest, `"`); !found {
+				debugf(areaListing, "Ignoring line %d of %s: unterminated quote", n+1, path)
+				continue
+			}
+		} else if i := strings.IndexAny(line, " \t"); i >= 0 {
+			name, text = line[:i], line[i+1:]
+		}
+		text = strings.TrimSpace(text)
+		if name == "" || text == "" {
+			debugf(areaListing, "Ignoring line %d of %s: want a name and a description", n+1, path)
+			continue
+		}
+		byName[name] = text
+	}
+	return byName
+}
@@ -0,0 +1,255 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DirAccessFile in a directory holds the passphrase hash protecting it
+	// and everything below. It is never listed or served.
+	DirAccessFile = ".fsaccess"
+
+	unlockPath = "/_unlock"
+
+	// How long a directory's lookup result is reused
+	dirAccessTTL      = 30 * time.Second
+	dirAccessCacheMax = 20000
+
+	passwordIterations = 600000
+)
+
+// HashPassword returns the hash of passphrase kept in DirAccessFile and the
+// users file: pbkdf2-sha256$ITERATIONS$SALT$KEY, with unpadded base64 salt
+// and key.
+func HashPassword(passphrase string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// checkPassword reports whether passphrase matches hash.
+func checkPassword(hash, passphrase string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || err1 != nil || err2 != nil || iterations <= 0 || len(want) == 0 {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dirAccess caches, per directory, the hash in its DirAccessFile ("" for
+// none), so checking every ancestor of a deep path costs no stats while
+// the entries are fresh.
+type dirAccess struct {
+	mu      sync.Mutex
+	entries map[string]dirAccessEntry
+}
+
+type dirAccessEntry struct {
+	hash    string
+	checked time.Time
+}
+
+// unreadableAccess stands for a DirAccessFile that exists but cannot be
+// read or parsed: the directory stays locked.
+const unreadableAccess = "!"
+
+// dirHash returns the hash protecting exactly the directory dirPath.
+func (s *Server) dirHash(ctx context.Context, dirPath string) string {
+	s.dirAccess.mu.Lock()
+	e, ok := s.dirAccess.entries[dirPath]
+	s.dirAccess.mu.Unlock()
+	if ok && time.Since(e.checked) < dirAccessTTL {
+		return e.hash
+	}
+
+	fullPath := filepath.Join(s.fsPath(dirPath), DirAccessFile)
+	hash, err := fsCall(s.fsCalls, ctx, s.config.Timeouts.Stat, func() (string, error) {
+		f, err := s.storage.Open(fullPath)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, 1024))
+		return strings.TrimSpace(string(data)), err
+	}, nil)
+	switch {
+	case err == nil && hash != "":
+	case errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR):
+		hash = ""
+	case err != nil && ctx.Err() != nil:
+		// Not cached: the request gave up, not the file
+		return unreadableAccess
+	default:
+		warnf(areaAuth, "Failed to read %s, keeping it locked: %v", fullPath, err)
+		hash = unreadableAccess
+	}
+
+	s.dirAccess.mu.Lock()
+	if s.dirAccess.entries == nil || len(s.dirAccess.entries) >= dirAccessCacheMax {
+		s.dirAccess.entries = make(map[string]dirAccessEntry)
+	}
+	s.dirAccess.entries[dirPath] = dirAccessEntry{hash, time.Now()}
+	s.dirAccess.mu.Unlock()
+	return hash
+}
+
+// protectingDir returns the innermost protected directory containing
+// requestPath (or requestPath itself), with its hash; "" if none.
+func (s *Server) protectingDir(ctx context.Context, requestPath string) (dir, hash string) {
+	if !s.config.DirPasswords {
+		return "", ""
+	}
+	for dir = path.Clean("/" + requestPath); ; dir = path.Dir(dir) {
+		if hash = s.dirHash(ctx, dir); hash != "" {
+			return dir, hash
+		}
+		if dir == "/" {
+			return "", ""
+		}
+	}
+}
+
+// newDirSigner signs unlock cookies with a key derived from the session
+// key, so an unlock cookie can never pass for a login session.
+func newDirSigner(sessionKey []byte, lifetime time.Duration) *sessionSigner {
+	var key []byte
+	if len(sessionKey) > 0 {
+		sum := sha256.Sum256(append([]byte("fileserver dir unlock\x00"), sessionKey...))
+		key = sum[:]
+	}
+	return newSessionSigner(key, lifetime)
+}
+
+// dirCookieName is per directory, so unlocking one protected directory
+// says nothing about another.
+func dirCookieName(dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return "fileserver_dir_" + hex.EncodeToString(sum[:6])
+}
+
+// dirUnlockClaim ties a cookie to the directory and its current hash, so
+// changing the passphrase locks everyone out again.
+func dirUnlockClaim(dir, hash string) string {
+	sum := sha256.Sum256([]byte(hash))
+	return "dir:" + dir + ":" + hex.EncodeToString(sum[:8])
+}
+
+// dirUnlocked reports whether r may read below dir: by the directory's
+// cookie, a signed playlist link or, for scripts, a Basic auth password.
+func (s *Server) dirUnlocked(r *http.Request, dir, hash string) bool {
+	if hash == unreadableAccess {
+		return false
+	}
+	if _, ok := s.signedLink(r); ok {
+		return true // signed for a user who had it unlocked
+	}
+	if cookie, err := r.Cookie(dirCookieName(dir)); err == nil {
+		if claim, ok := s.dirSigner.verify(cookie.Value); ok && claim == dirUnlockClaim(dir, hash) {
+			return true
+		}
+	}
+	if _, password, ok := r.BasicAuth(); ok && s.config.Auth == nil && s.sessions == nil {
+		return checkPassword(hash, password)
+	}
+	return false
+}
+
+// checkDirAccess answers a request for a locked directory, or anything
+// below it, with the passphrase form for browsers and a Basic challenge
+// otherwise. It reports whether the request may go on.
+func (s *Server) checkDirAccess(w http.ResponseWriter, r *http.Request, requestPath string) bool {
+	dir, hash := s.protectingDir(r.Context(), requestPath)
+	if dir == "" || s.dirUnlocked(r, dir, hash) {
+		return true
+	}
+	logHTTPError(r, areaAuth, http.StatusUnauthorized, "Passphrase required", requestPath+" is under protected "+dir)
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		s.renderUnlockPage(w, r, dir, basePath(r)+r.URL.RequestURI(), "", http.StatusUnauthorized)
+		return false
+	}
+	if s.config.Auth == nil && s.sessions == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+strings.ReplaceAll(dir, `"`, "")+`"`)
+	}
+	writeError(w, r, http.StatusUnauthorized, "Passphrase required")
+	return false
+}
+
+func (s *Server) renderUnlockPage(w http.ResponseWriter, r *http.Request, dir, next, message string, status int) {
+	data := loginPageData{
+		Next:      next,
+		Dir:       dir,
+		Error:     message,
+		Action:    basePath(r) + unlockPath,
+		CSRFToken: csrfToken(w, r),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginPage.Execute(w, data); err != nil {
+		reqLogf(r, levelWarn, areaRequest, "Failed to render unlock page: %v", err)
+	}
+}
+
+// handleUnlock checks a passphrase posted from the unlock form and sets
+// the directory's cookie, scoped to its path.
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, unlockMethods)
+		return
+	}
+	dir := path.Clean("/" + r.PostFormValue("dir"))
+	next := r.PostFormValue("next")
+	got, hash := s.protectingDir(r.Context(), dir)
+	if got != dir || hash == unreadableAccess || !checkPassword(hash, r.PostFormValue("password")) {
+		logHTTPError(r, areaAuth, http.StatusUnauthorized, "Unlock failed", "directory "+dir)
+		s.renderUnlockPage(w, r, dir, next, "Wrong passphrase", http.StatusUnauthorized)
+		return
+	}
+	value, expires := s.dirSigner.sign(dirUnlockClaim(dir, hash))
+	http.SetCookie(w, &http.Cookie{
+		Name:     dirCookieName(dir),
+		Value:    value,
+		Path:     basePath(r) + strings.TrimSuffix(dir, "/") + "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, loginRedirectTarget(r, next), http.StatusSeeOther)
+}
+
+// lockedSubdir reports a directory below a walk's start that has its own
+// DirAccessFile; walks across directories never enter one.
+func (s *Server) lockedSubdir(requestPath string, isDir bool) bool {
+	return isDir && s.config.DirPasswords && s.dirHash(context.Background(), requestPath) != ""
+}
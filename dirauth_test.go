@@ -0,0 +1,82 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// TestLockedRoot locks the root itself: every endpoint that lists, reads
+// or searches the tree asks for the passphrase until it is given.
+func TestLockedRoot(t *testing.T) {
+	hash, err := HashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, h := newTestServer(t, Config{DirPasswords: true, Sitemap: true, Grep: true})
+	writeFiles(t, s.rootDir, map[string]string{
+		DirAccessFile:       hash + "\n",
+		"secret-report.txt": "quarterly numbers",
+	})
+
+	targets := []string{
+		"/",
+		"/secret-report.txt",
+		"/?search=secret",
+		"/?download=zip",
+		"/_recent",
+		"/_recent?format=json",
+		"/sitemap.xml",
+		"/_grep?q=quarterly",
+		apiV1Prefix + "list?path=/",
+		apiV1Prefix + "tree?path=/",
+		apiV1Prefix + "search?q=secret",
+	}
+	for _, target := range targets {
+		w := serve(h, http.MethodGet, target, nil)
+		if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "secret-report") || strings.Contains(w.Body.String(), "quarterly") {
+			t.Errorf("GET %s on a locked root: status %d, body %.200s", target, w.Code, w.Body)
+		}
+	}
+
+	token := strings.Repeat("ab", 32)
+	csrf := &http.Cookie{Name: csrfCookie, Value: token}
+	w := formPost(h, unlockPath, url.Values{"dir": {"/"}, "password": {"open sesame"}, csrfField: {token}},
+		http.Header{"Sec-Fetch-Site": {"same-origin"}}, csrf)
+	if w.Code != http.StatusSeeOther || len(w.Result().Cookies()) == 0 {
+		t.Fatalf("unlocking the root: status %d", w.Code)
+	}
+	header := http.Header{}
+	for _, c := range w.Result().Cookies() {
+		header.Add("Cookie", c.Name+"="+c.Value)
+	}
+	for _, target := range []string{"/_recent", "/sitemap.xml"} {
+		if w := serve(h, http.MethodGet, target, header); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "secret-report.txt") {
+			t.Errorf("GET %s once unlocked: status %d, body %.200s", target, w.Code, w.Body)
+		}
+	}
+}
+
+// TestDirCookieIsNotASession checks that an unlock cookie, signed from the
+// same session key, does not log anyone in.
+func TestDirCookieIsNotASession(t *testing.T) {
+	s, h := newTestServer(t, Config{
+		DirPasswords: true,
+		SessionKey:   []byte("0123456789abcdef0123456789abcdef"),
+		Login:        func(user, password string) bool { return false },
+	})
+	writeFiles(t, s.rootDir, map[string]string{"file.txt": "secret"})
+	value, _ := s.dirSigner.sign(dirUnlockClaim("/private", "hash"))
+	if claims, ok := s.sessions.verifyClaims(value); ok {
+		t.Errorf("unlock cookie verifies as the session of %q", claims.User)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/file.txt", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized || strings.Contains(w.Body.String(), "secret") {
+		t.Errorf("GET with an unlock cookie as session: status %d", w.Code)
+	}
+}
@@ -0,0 +1,245 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Counting stops past this many entries, shown as "500+ items"
+	dirCountCap = 500
+	// Cached counts; the cache starts over when it fills up, unless it is
+	// persisted, where it is meant to hold the whole tree
+	dirCountCacheMax = 50000
+)
+
+type dirCount struct {
+	items int
+	more  bool // stopped at dirCountCap
+}
+
+// dirCounts caches entry counts by directory, valid while the directory's
+// mtime, which changes whenever an entry is added or removed, stays the
+// same. With -dir-counts-file it is persisted, so restarts and "fileserver
+// index" runs keep warm counts.
+type dirCounts struct {
+	file     string
+	settings string // what a count depends on besides the directory
+
+	mu      sync.Mutex
+	entries map[string]dirCountEntry
+	dirty   bool
+}
+
+type dirCountEntry struct {
+	modTime time.Time
+	count   dirCount
+}
+
+func (c *dirCounts) get(fullPath string, modTime time.Time) (dirCount, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[fullPath]
+	return e.count, ok && e.modTime.Equal(modTime)
+}
+
+func (c *dirCounts) put(fullPath string, modTime time.Time, count dirCount) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || (c.file == "" && len(c.entries) >= dirCountCacheMax) {
+		c.entries = make(map[string]dirCountEntry)
+	}
+	c.entries[fullPath] = dirCountEntry{modTime, count}
+	c.dirty = true
+}
+
+// savedDirCounts is the -dir-counts-file format. Counts saved under other
+// settings are discarded on load.
+type savedDirCounts struct {
+	Settings string                   `json:"settings"`
+	Dirs     map[string]savedDirCount `json:"dirs"`
+}
+
+type savedDirCount struct {
+	ModTime int64 `json:"modTime"` // UnixNano
+	Items   int   `json:"items"`
+	More    bool  `json:"more,omitempty"`
+}
+
+// dirCountSettings describes the options that decide which entries a count
+// includes.
+func (s *Server) dirCountSettings() string {
+	return fmt.Sprintf("dotfiles=%t notes=%s deny=%s", s.config.HideDotFiles, s.config.DirNotes, strings.Join(s.config.Deny, "\x00"))
+}
+
+// load reads the counts saved in file, which need not exist yet.
+func (c *dirCounts) load(file, settings string) error {
+	c.file, c.settings = file, settings
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read directory counts: %v", err)
+	}
+	var saved savedDirCounts
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to parse directory counts %s: %v", file, err)
+	}
+	if saved.Settings != settings {
+		infof(areaServer, "Ignoring directory counts in %s, saved with other listing settings", file)
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]dirCountEntry, len(saved.Dirs))
+	for dir, e := range saved.Dirs {
+		c.entries[dir] = dirCountEntry{time.Unix(0, e.ModTime), dirCount{e.Items, e.More}}
+	}
+	return nil
+}
+
+// save writes the counts atomically if anything changed.
+func (c *dirCounts) save() error {
+	if c.file == "" {
+		return nil
+	}
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	saved := savedDirCounts{Settings: c.settings, Dirs: make(map[string]savedDirCount, len(c.entries))}
+	for dir, e := range c.entries {
+		saved.Dirs[dir] = savedDirCount{e.modTime.UnixNano(), e.count.items, e.count.more}
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.file, data)
+}
+
+// run persists the counts every minute and once more on shutdown.
+func (c *dirCounts) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if err := c.save(); err != nil {
+			warnf(areaServer, "Failed to save directory counts: %v", err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// countSubdirs fills in the item counts of the directories in a listing
+// of fullPath with -dir-counts, from the cache or with one directory read
+// each, StatWorkers at a time. A directory that cannot be read gets
+// Items -1.
+func (s *Server) countSubdirs(r *http.Request, fullPath, requestPath string, files []FileInfo) {
+	if !s.config.DirCounts {
+		return
+	}
+	s.parallel(r.Context(), len(files), func(i int) {
+		f := &files[i]
+		if !f.IsDir || s.lockedSubdir(requestPath+f.Name, true) {
+			return
+		}
+		sub := filepath.Join(fullPath, filepath.FromSlash(f.Name))
+		count, ok := s.dirCounts.get(sub, f.ModTime)
+		if !ok {
+			var err error
+			count, err = s.countEntries(r.Context(), sub, requestPath+f.Name+"/")
+			if err != nil {
+				reqLogf(r, levelDebug, areaListing, "Failed to count entries of %s: %v", sub, err)
+				f.Items, f.counted = -1, true
+				f.SizeStr = "?"
+				return
+			}
+			s.dirCounts.put(sub, f.ModTime, count)
+		}
+		f.Items, f.MoreItems, f.counted = count.items, count.more, true
+		f.SizeStr = itemsLabel(count)
+	}, func(int, error) {})
+}
+
+func itemsLabel(c dirCount) string {
+	switch {
+	case c.more:
+		return strconv.Itoa(c.items) + "+ items"
+	case c.items == 1:
+		return "1 item"
+	}
+	return strconv.Itoa(c.items) + " items"
+}
+
+// countEntries counts what a listing of fullPath would show, up to
+// dirCountCap. Directories are read in batches so a huge one costs no
+// more than the cap, unless the storage cannot read an open directory
+// incrementally and lists it whole.
+func (s *Server) countEntries(ctx context.Context, fullPath, requestPath string) (dirCount, error) {
+	return fsCall(s.fsCalls, ctx, s.config.Timeouts.Stat, func() (dirCount, error) {
+		var count dirCount
+		visible := func(entries []fs.DirEntry) bool {
+			for _, entry := range entries {
+				if (s.config.HideDotFiles && isDotFile(entry.Name())) || s.isDirNote(entry.Name()) ||
+					s.deny.match(requestPath+entry.Name()) != "" {
+					continue
+				}
+				if count.items == dirCountCap {
+					count.more = true
+					return false
+				}
+				count.items++
+			}
+			return true
+		}
+
+		f, err := s.storage.Open(fullPath)
+		if err != nil {
+			return dirCount{}, err
+		}
+		defer f.Close()
+		dir, ok := f.(fs.ReadDirFile)
+		if !ok {
+			entries, err := s.storage.ReadDir(fullPath)
+			if err != nil {
+				return dirCount{}, err
+			}
+			visible(entries)
+			return count, nil
+		}
+		for {
+			entries, err := dir.ReadDir(256)
+			if !visible(entries) {
+				return count, nil
+			}
+			if errors.Is(err, io.EOF) {
+				return count, nil
+			}
+			if err != nil {
+				return dirCount{}, err
+			}
+		}
+	}, nil)
+}
@@ -0,0 +1,84 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// batchStorage hides that files are *os.File, as other storage would, and
+// counts the entries read from the directory named big.
+type batchStorage struct {
+	storage
+	big         string
+	wholeReads  atomic.Int64
+	entriesRead atomic.Int64
+}
+
+func (s *batchStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := s.storage.ReadDir(name)
+	if name == s.big {
+		s.wholeReads.Add(1)
+		s.entriesRead.Add(int64(len(entries)))
+	}
+	return entries, err
+}
+
+func (s *batchStorage) Open(name string) (fs.File, error) {
+	f, err := s.storage.Open(name)
+	if err != nil || name != s.big {
+		return f, err
+	}
+	return &batchDir{f.(fs.ReadDirFile), s}, nil
+}
+
+type batchDir struct {
+	fs.ReadDirFile
+	s *batchStorage
+}
+
+func (d *batchDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := d.ReadDirFile.ReadDir(n)
+	d.s.entriesRead.Add(int64(len(entries)))
+	return entries, err
+}
+
+// TestDirCountBounded counts a huge subdirectory on storage other than
+// the local filesystem: reading stops soon after dirCountCap entries.
+func TestDirCountBounded(t *testing.T) {
+	const entries = 3000
+	batches := &batchStorage{}
+	s, h := newWrappedTestServer(t, Config{DirCounts: true}, func(st storage) storage {
+		batches.storage = st
+		return batches
+	})
+	files := map[string]string{}
+	for i := range entries {
+		files[fmt.Sprintf("big/f%04d", i)] = ""
+	}
+	writeFiles(t, s.rootDir, files)
+	batches.big = filepath.Join(s.rootDir, "big")
+
+	w := serve(h, http.MethodGet, apiV1Prefix+"list?path=/", nil)
+	var reply struct {
+		Data struct {
+			Entries []apiEntry `json:"entries"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); w.Code != http.StatusOK || err != nil || len(reply.Data.Entries) != 1 {
+		t.Fatalf("list: status %d, body %s", w.Code, w.Body)
+	}
+	if e := reply.Data.Entries[0]; e.Items == nil || *e.Items != dirCountCap || !e.MoreItems {
+		t.Errorf("count of big = %v, more %v, want %d+", e.Items, e.MoreItems, dirCountCap)
+	}
+	if n := batches.wholeReads.Load(); n != 0 {
+		t.Errorf("big was read whole %d times", n)
+	}
+	if n := batches.entriesRead.Load(); n >= entries {
+		t.Errorf("read %d entries of big to count past %d", n, dirCountCap)
+	}
+}
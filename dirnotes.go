@@ -0,0 +1,67 @@
+package fileserver
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Per-directory notes, as with Apache's HeaderName/ReadmeName: HEADER.html
+// goes above the listing and FOOTER.html below it.
+const (
+	dirHeaderFile  = "HEADER.html"
+	dirFooterFile  = "FOOTER.html"
+	dirNoteMaxSize = 64 << 10
+)
+
+var (
+	noteScriptPattern = regexp.MustCompile(`(?is)<(script|style)\b.*?</(script|style)\s*>`)
+	noteTagPattern    = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+func validateDirNotes(mode string) error {
+	switch mode {
+	case "off", "text", "html":
+		return nil
+	}
+	return fmt.Errorf("invalid -dir-notes %q, want off, text or html", mode)
+}
+
+// isDirNote reports whether name is hidden from listings as a note file.
+func (s *Server) isDirNote(name string) bool {
+	return s.config.DirNotes != "off" && (name == dirHeaderFile || name == dirFooterFile)
+}
+
+// readDirNote returns the note for the listing. In "text" mode markup is
+// stripped and the text escaped; "html" trusts the file as-is. Missing,
+// oversized or unreadable files yield nothing.
+func (s *Server) readDirNote(r *http.Request, dir, name string) template.HTML {
+	path := filepath.Join(dir, name)
+	file, err := s.open(r.Context(), path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, dirNoteMaxSize+1))
+	if err != nil {
+		reqLogf(r, levelDebug, areaListing, "Failed to read %s: %v", path, err)
+		return ""
+	}
+	if len(data) > dirNoteMaxSize {
+		reqLogf(r, levelDebug, areaListing, "Ignoring %s larger than %s", path, FormatSize(dirNoteMaxSize))
+		return ""
+	}
+
+	if s.config.DirNotes == "html" {
+		return template.HTML(data)
+	}
+	text := noteScriptPattern.ReplaceAllString(string(data), "")
+	text = html.UnescapeString(noteTagPattern.ReplaceAllString(text, ""))
+	return template.HTML(`<div class="note-text">` + template.HTMLEscapeString(strings.TrimSpace(text)) + `</div>`)
+}
@@ -0,0 +1,137 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var errTooManyDirReads = errors.New("too many outstanding directory reads")
+
+// dirRead is one ReadDir call shared by every request for the same path
+// while it runs. info is the directory read through an open handle, nil
+// for a read by path.
+type dirRead struct {
+	done    chan struct{}
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	err     error
+}
+
+// dirReader runs ReadDir in the background so a request can give up on a
+// hung network mount. The goroutine cannot be cancelled once inside the
+// syscall, so the number outstanding is capped: on a dead mount we reject
+// with 503 instead of parking a new goroutine per request.
+type dirReader struct {
+	max     int
+	readDir func(string) ([]fs.DirEntry, error)
+
+	mu       sync.Mutex
+	inflight map[string]*dirRead // shared reads by path
+	running  int
+
+	rejected atomic.Int64
+	started  atomic.Int64 // ReadDir calls run
+	shared   atomic.Int64 // reads that joined one already running
+}
+
+func newDirReader(max int, readDir func(string) ([]fs.DirEntry, error)) *dirReader {
+	return &dirReader{max: max, readDir: readDir, inflight: make(map[string]*dirRead)}
+}
+
+// read returns the entries of path. The slice may be shared with concurrent
+// callers and must not be modified.
+func (d *dirReader) read(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	d.mu.Lock()
+	op, ok := d.inflight[path]
+	if ok {
+		d.shared.Add(1)
+	} else if op = d.start(path, nil, true, func() ([]fs.DirEntry, error) { return d.readDir(path) }); op == nil {
+		d.mu.Unlock()
+		return nil, errTooManyDirReads
+	}
+	d.mu.Unlock()
+	return op.wait(ctx)
+}
+
+// readOpen returns the entries of dir, open at path with info from its
+// handle, sorted by name like read. It lists that very handle, so a
+// directory swapped in at path after the open is not what gets listed, and
+// joins a running read only of the same directory. A handle read is not
+// retried, as a retry could only go by path. readOpen closes dir; handles
+// that cannot list fall back to read.
+func (d *dirReader) readOpen(ctx context.Context, path string, dir fs.File, info fs.FileInfo) ([]fs.DirEntry, error) {
+	rd, ok := dir.(fs.ReadDirFile)
+	if !ok {
+		dir.Close()
+		return d.read(ctx, path)
+	}
+	d.mu.Lock()
+	op, ok := d.inflight[path]
+	if ok && op.info != nil && os.SameFile(op.info, info) {
+		d.shared.Add(1)
+		dir.Close()
+	} else if op = d.start(path, info, !ok, func() ([]fs.DirEntry, error) {
+		defer dir.Close()
+		entries, err := rd.ReadDir(-1)
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+		return entries, err
+	}); op == nil {
+		d.mu.Unlock()
+		dir.Close()
+		return nil, errTooManyDirReads
+	}
+	d.mu.Unlock()
+	return op.wait(ctx)
+}
+
+// start runs readDir in the background, registered for others to join
+// when shared; nil when too many reads are outstanding. d.mu is held.
+func (d *dirReader) start(path string, info fs.FileInfo, shared bool, readDir func() ([]fs.DirEntry, error)) *dirRead {
+	if d.max > 0 && d.running >= d.max {
+		d.rejected.Add(1)
+		return nil
+	}
+	op := &dirRead{done: make(chan struct{}), info: info}
+	if shared {
+		d.inflight[path] = op
+	}
+	d.running++
+	d.started.Add(1)
+	go d.run(path, op, readDir)
+	return op
+}
+
+func (d *dirReader) run(path string, op *dirRead, readDir func() ([]fs.DirEntry, error)) {
+	op.entries, op.err = readDir()
+
+	d.mu.Lock()
+	if d.inflight[path] == op {
+		delete(d.inflight, path)
+	}
+	d.running--
+	d.mu.Unlock()
+	close(op.done)
+}
+
+func (op *dirRead) wait(ctx context.Context) ([]fs.DirEntry, error) {
+	select {
+	case <-op.done:
+		return op.entries, op.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// outstanding is the number of ReadDir calls currently running, including
+// ones whose requests have already timed out.
+func (d *dirReader) outstanding() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running
+}
@@ -0,0 +1,48 @@
+package fileserver
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"testing"
+)
+
+func TestDirReaderReadOpen(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"c": "", "a": "", "b": ""})
+	release := make(chan struct{})
+	d := newDirReader(1, func(string) ([]fs.DirEntry, error) {
+		<-release
+		return nil, nil
+	})
+
+	open := func() (*os.File, fs.FileInfo) {
+		f, err := os.Open(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, _ := f.Stat()
+		return f, info
+	}
+	f, info := open()
+	entries, err := d.readOpen(context.Background(), root, f, info)
+	if err != nil || len(entries) != 3 || entries[0].Name() != "a" || entries[2].Name() != "c" {
+		t.Fatalf("readOpen = %v, %v, want a, b, c", entries, err)
+	}
+	if _, err := f.Stat(); err == nil {
+		t.Error("readOpen left the handle open")
+	}
+
+	// A hung read by path holds the only slot
+	go d.read(context.Background(), "/hung")
+	for d.outstanding() == 0 {
+	}
+	f, info = open()
+	if _, err := d.readOpen(context.Background(), root, f, info); err != errTooManyDirReads {
+		t.Errorf("readOpen at the limit = %v, want errTooManyDirReads", err)
+	}
+	if _, err := f.Stat(); err == nil {
+		t.Error("rejected readOpen left the handle open")
+	}
+	close(release)
+}
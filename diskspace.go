@@ -0,0 +1,119 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Bytes written between free space re-checks during a long write
+const spaceRecheckBytes = 64 << 20
+
+// spaceGuard refuses writes that would leave the filesystem under the
+// -min-free-bytes / -min-free-percent headroom. Uploads check with check
+// before accepting a body and write it through guardWriter; the status
+// endpoint reports it. Reads never consult it.
+type spaceGuard struct {
+	root       string
+	minBytes   int64
+	minPercent float64
+}
+
+func newSpaceGuard(root string, minBytes int64, minPercent float64) (*spaceGuard, error) {
+	if minBytes < 0 {
+		return nil, fmt.Errorf("-min-free-bytes must not be negative")
+	}
+	if minPercent < 0 || minPercent >= 100 {
+		return nil, fmt.Errorf("-min-free-percent must be between 0 and 100: %v", minPercent)
+	}
+	if minBytes == 0 && minPercent == 0 {
+		return nil, nil
+	}
+	if _, _, err := diskSpace(root); err != nil {
+		return nil, fmt.Errorf("failed to read free space of %s: %v", root, err)
+	}
+	return &spaceGuard{root: root, minBytes: minBytes, minPercent: minPercent}, nil
+}
+
+// lowSpaceError is answered with 507 Insufficient Storage.
+type lowSpaceError struct {
+	available, reserved uint64
+}
+
+func (e *lowSpaceError) Error() string {
+	return fmt.Sprintf("not enough free space: %s available, %s reserved",
+		FormatSize(int64(e.available)), FormatSize(int64(e.reserved)))
+}
+
+// reserved is the headroom in bytes for a filesystem of total bytes.
+func (g *spaceGuard) reserved(total uint64) uint64 {
+	reserve := uint64(g.minBytes)
+	if pct := uint64(float64(total) * g.minPercent / 100); pct > reserve {
+		reserve = pct
+	}
+	return reserve
+}
+
+// check reports a *lowSpaceError if writing incoming more bytes under dir
+// would eat into the headroom.
+func (g *spaceGuard) check(dir string, incoming int64) error {
+	free, total, err := diskSpace(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read free space: %v", err)
+	}
+	reserve := g.reserved(total)
+	if incoming < 0 {
+		incoming = 0
+	}
+	if free < reserve || free-reserve < uint64(incoming) {
+		return &lowSpaceError{available: free, reserved: reserve}
+	}
+	return nil
+}
+
+// guardWriter re-checks free space every spaceRecheckBytes, so a
+// concurrent writer filling the volume stops a long upload too.
+func (g *spaceGuard) guardWriter(dir string, w io.Writer) io.Writer {
+	return &spaceGuardWriter{guard: g, dir: dir, w: w}
+}
+
+type spaceGuardWriter struct {
+	guard   *spaceGuard
+	dir     string
+	w       io.Writer
+	pending int64
+}
+
+func (sw *spaceGuardWriter) Write(p []byte) (int, error) {
+	if sw.pending += int64(len(p)); sw.pending >= spaceRecheckBytes {
+		if err := sw.guard.check(sw.dir, sw.pending); err != nil {
+			return 0, err
+		}
+		sw.pending = 0
+	}
+	return sw.w.Write(p)
+}
+
+// writeSpaceError answers a failed check or guarded write: 507 for low
+// space, 500 otherwise.
+func writeSpaceError(w http.ResponseWriter, r *http.Request, err error) {
+	if low, ok := err.(*lowSpaceError); ok {
+		httpError(w, r, areaRequest, http.StatusInsufficientStorage, "Insufficient storage: "+FormatSize(int64(low.available))+" available", err.Error())
+		return
+	}
+	httpError(w, r, areaRequest, http.StatusInternalServerError, "Internal server error", err.Error())
+}
+
+type spaceStatus struct {
+	FreeBytes     uint64 `json:"freeBytes"`
+	TotalBytes    uint64 `json:"totalBytes"`
+	ReservedBytes uint64 `json:"reservedBytes"`
+}
+
+func (g *spaceGuard) status() *spaceStatus {
+	free, total, err := diskSpace(g.root)
+	if err != nil {
+		return nil
+	}
+	return &spaceStatus{FreeBytes: free, TotalBytes: total, ReservedBytes: g.reserved(total)}
+}
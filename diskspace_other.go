@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package fileserver
+
+import "errors"
+
+func diskSpace(dir string) (free, total uint64, err error) {
+	return 0, 0, errors.New("free space is not available on this platform")
+}
@@ -0,0 +1,42 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPutLowSpace(t *testing.T) {
+	s, h := newTestServer(t, Config{Uploads: true, MinFreeBytes: 1 << 62})
+	writeFiles(t, s.rootDir, map[string]string{"old.txt": "readable"})
+
+	if w := put(h, "/new.txt", strings.NewReader("data"), nil); w.Code != http.StatusInsufficientStorage {
+		t.Errorf("PUT under the headroom: status %d, want 507", w.Code)
+	}
+	if names := tempFiles(t, s.rootDir); names != nil {
+		t.Errorf("temp files left behind: %v", names)
+	}
+	if w := serve(h, http.MethodGet, "/old.txt", nil); w.Code != http.StatusOK {
+		t.Errorf("GET under the headroom: status %d, want 200", w.Code)
+	}
+}
+
+func TestSpaceGuardWriter(t *testing.T) {
+	g := &spaceGuard{root: t.TempDir(), minBytes: 1 << 62}
+	w := g.guardWriter(g.root, io.Discard)
+	chunk := bytes.Repeat([]byte{0}, 1<<20)
+
+	// Free space is only looked at again after spaceRecheckBytes
+	for written := 0; written+len(chunk) < spaceRecheckBytes; written += len(chunk) {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("write after %d bytes: %v", written, err)
+		}
+	}
+	var low *lowSpaceError
+	if _, err := w.Write(chunk); !errors.As(err, &low) {
+		t.Errorf("write past the recheck: %v, want a *lowSpaceError", err)
+	}
+}
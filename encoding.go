@@ -0,0 +1,49 @@
+package fileserver
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+// Old archives carry names in legacy 8-bit encodings. Their bytes go into
+// links percent-encoded as they are, so requests find the original name,
+// and only what is shown is transcoded: valid UTF-8 sequences are kept and
+// every other byte is read as Latin-1, the most likely source.
+
+// latin1Label is name with invalid UTF-8 bytes read as Latin-1.
+func latin1Label(name string) string {
+	if utf8.ValidString(name) {
+		return name
+	}
+	var b strings.Builder
+	for len(name) > 0 {
+		r, size := utf8.DecodeRuneInString(name)
+		if r == utf8.RuneError && size == 1 {
+			r = rune(name[0])
+		}
+		b.WriteRune(r)
+		name = name[size:]
+	}
+	return b.String()
+}
+
+// Label is the name as shown in listings, see latin1Label.
+func (f FileInfo) Label() string {
+	return latin1Label(f.Name)
+}
+
+// NotUTF8 reports whether the name is not valid UTF-8, so Label is a
+// guess.
+func (f FileInfo) NotUTF8() bool {
+	return !utf8.ValidString(f.Name)
+}
+
+// apiName splits a name for JSON, which cannot carry invalid UTF-8: the
+// label to show and, only for such names, the raw bytes in base64.
+func apiName(name string) (label, raw string) {
+	if utf8.ValidString(name) {
+		return name, ""
+	}
+	return latin1Label(name), base64.StdEncoding.EncodeToString([]byte(name))
+}
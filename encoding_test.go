@@ -0,0 +1,79 @@
+package fileserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLatin1Label(t *testing.T) {
+	tests := []struct {
+		name, label string
+		raw         bool
+	}{
+		{"plain.txt", "plain.txt", false},
+		{"café.txt", "café.txt", false},
+		{"caf\xe9.txt", "café.txt", true},
+		{"\xc0 la carte", "À la carte", true},
+		{"ok-é-\xff", "ok-é-ÿ", true},
+		{"\xc3", "Ã", true}, // a truncated UTF-8 sequence
+	}
+	for _, tt := range tests {
+		if got := latin1Label(tt.name); got != tt.label {
+			t.Errorf("latin1Label(%q) = %q, want %q", tt.name, got, tt.label)
+		}
+		label, raw := apiName(tt.name)
+		if label != tt.label || (raw != "") != tt.raw {
+			t.Errorf("apiName(%q) = %q, %q", tt.name, label, raw)
+		}
+		if tt.raw {
+			if decoded, _ := base64.StdEncoding.DecodeString(raw); string(decoded) != tt.name {
+				t.Errorf("apiName(%q) raw bytes decode to %q", tt.name, decoded)
+			}
+		}
+	}
+}
+
+// TestLatin1Names lists and downloads a file whose name is not UTF-8,
+// which only some filesystems can store.
+func TestLatin1Names(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("needs a filesystem taking arbitrary bytes in names")
+	}
+	s, h := newTestServer(t, Config{})
+	name := "caf\xe9.txt"
+	if err := os.WriteFile(filepath.Join(s.rootDir, name), []byte("latin-1"), 0o644); err != nil {
+		t.Skip("filesystem refuses the name:", err)
+	}
+
+	body := serve(h, http.MethodGet, "/", nil).Body.String()
+	if !strings.Contains(body, `href="/caf%E9.txt"`) || !strings.Contains(body, "café.txt") || !strings.Contains(body, "(Latin-1)") {
+		t.Errorf("listing lacks the raw link, the label or the marker:\n%s", body)
+	}
+	if w := serve(h, http.MethodGet, "/caf%E9.txt", nil); w.Code != http.StatusOK || w.Body.String() != "latin-1" {
+		t.Errorf("download through the listed link: status %d, body %q", w.Code, w.Body)
+	}
+	// The UTF-8 spelling is another name
+	if w := serve(h, http.MethodGet, "/caf%C3%A9.txt", nil); w.Code != http.StatusNotFound {
+		t.Errorf("UTF-8 spelling: status %d, want 404", w.Code)
+	}
+
+	var reply struct {
+		Data struct {
+			Entries []apiEntry `json:"entries"`
+		} `json:"data"`
+	}
+	w := serve(h, http.MethodGet, apiV1Prefix+"list?path=/", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || len(reply.Data.Entries) != 1 {
+		t.Fatalf("JSON listing: %v: %s", err, w.Body)
+	}
+	e := reply.Data.Entries[0]
+	if raw, _ := base64.StdEncoding.DecodeString(e.RawName); e.Name != "café.txt" || string(raw) != name {
+		t.Errorf("JSON entry name %q, rawName %q", e.Name, e.RawName)
+	}
+}
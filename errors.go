@@ -0,0 +1,64 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mountRetryAfter is the Retry-After, in seconds, sent while the storage
+// behind the root is unavailable.
+const mountRetryAfter = 10
+
+// errorReply is the body of an error response, as JSON or for error.html.
+type errorReply struct {
+	Error             string `json:"error"`
+	Status            int    `json:"status"`
+	Path              string `json:"path"`
+	RequestID         string `json:"requestId"`
+	RetryAfterSeconds int    `json:"retryAfterSeconds,omitempty"`
+	// Both digests of an upload failing its X-Content-SHA256 check
+	ExpectedSHA256 string `json:"expectedSha256,omitempty"`
+	ActualSHA256   string `json:"actualSha256,omitempty"`
+	Home           string `json:"-"`
+}
+
+// The error page is embedded only; it must render even when custom
+// templates are broken.
+var errorPage = template.Must(template.ParseFS(templateFS, "templates/error.html"))
+
+// writeError replies with message in the format the client asked for: JSON
+// for API clients, an HTML page for browsers, and plain text otherwise. A
+// Retry-After header set by the caller is repeated in the body.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	reply := errorReply{
+		Error:     message,
+		Status:    status,
+		Path:      basePath(r) + r.URL.Path,
+		RequestID: requestID(r),
+		Home:      basePath(r) + "/",
+	}
+	if secs, err := strconv.Atoi(w.Header().Get("Retry-After")); err == nil {
+		reply.RetryAfterSeconds = secs
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	switch {
+	case wantsJSON(r):
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(reply)
+	case strings.Contains(r.Header.Get("Accept"), "text/html"):
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		if err := errorPage.Execute(w, reply); err != nil {
+			reqLogf(r, levelWarn, areaRequest, "Failed to render error page: %v", err)
+		}
+	default:
+		http.Error(w, message, status)
+	}
+}
@@ -0,0 +1,374 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Bytes read from the start of an image for ?exif=1: enough for a
+	// JPEG's APP1 segment and frame header, without reading whole TIFFs
+	exifMaxRead = 256 << 10
+
+	exifCacheSize = 4096
+)
+
+// EXIF tags read, from IFD0, the Exif IFD and the GPS IFD
+const (
+	tagImageWidth       = 0x0100
+	tagImageLength      = 0x0101
+	tagMake             = 0x010f
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagOffsetOriginal   = 0x9011
+	tagPixelXDimension  = 0xa002
+	tagPixelYDimension  = 0xa003
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSAltitudeRef  = 5
+	tagGPSAltitude     = 6
+)
+
+// exifData is the answer to ?exif=1. Every field is optional; an image
+// without EXIF, or one that cannot be parsed, gives an empty object.
+type exifData struct {
+	// Capture time as RFC 3339, without a zone unless the camera
+	// recorded its offset
+	Captured    string   `json:"captured,omitempty"`
+	Make        string   `json:"make,omitempty"`
+	Model       string   `json:"model,omitempty"`
+	Width       int      `json:"width,omitempty"` // as stored, before Orientation
+	Height      int      `json:"height,omitempty"`
+	Orientation int      `json:"orientation,omitempty"` // 1 to 8, as in EXIF
+	GPS         *exifGPS `json:"gps,omitempty"`
+
+	captured time.Time // for ?sort=exifdate, zero when unknown
+}
+
+type exifGPS struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // meters above sea level
+}
+
+func isImage(name string) bool {
+	return fileCategory(name, false) == "image" || strings.HasPrefix(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))), "image/")
+}
+
+// exifCache holds parsed metadata by path, valid while the file's
+// modification time and size are unchanged.
+type exifCache struct {
+	mu      sync.Mutex
+	entries map[string]exifCacheEntry
+}
+
+type exifCacheEntry struct {
+	modTime time.Time
+	size    int64
+	data    exifData
+}
+
+func (c *exifCache) get(path string, info fs.FileInfo) (exifData, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[path]
+	if !ok || !e.modTime.Equal(info.ModTime()) || e.size != info.Size() {
+		return exifData{}, false
+	}
+	return e.data, true
+}
+
+func (c *exifCache) put(path string, info fs.FileInfo, data exifData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= exifCacheSize {
+		c.entries = make(map[string]exifCacheEntry)
+	}
+	c.entries[path] = exifCacheEntry{modTime: info.ModTime(), size: info.Size(), data: data}
+}
+
+// readExif returns the metadata of the image at fullPath, from the cache
+// when it has not changed. Failures are logged at debug level and give
+// empty metadata.
+func (s *Server) readExif(ctx context.Context, r *http.Request, fullPath string, info fs.FileInfo) exifData {
+	if data, ok := s.exif.get(fullPath, info); ok {
+		return data
+	}
+	head, err := s.readSmallFile(ctx, fullPath, exifMaxRead)
+	if err != nil {
+		reqLogf(r, levelDebug, areaIO, "Failed to read %s for EXIF: %v", fullPath, err)
+		return exifData{} // not cached, so a transient failure is retried
+	}
+	data := parseExif(head)
+	s.exif.put(fullPath, info, data)
+	return data
+}
+
+// handleExif answers ?exif=1 on an image with its metadata as JSON.
+func (s *Server) handleExif(w http.ResponseWriter, r *http.Request, fullPath string, info fs.FileInfo) {
+	if !isImage(info.Name()) {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "EXIF metadata is only read from images", "")
+		return
+	}
+	data := s.readExif(r.Context(), r, fullPath, info)
+	if s.config.ExifRedactGPS {
+		data.GPS = nil
+	}
+	s.emitTiming(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	json.NewEncoder(w).Encode(data)
+}
+
+// setCaptureTimes fills in Captured for the images among files, entries
+// of fullPath (or below it, in a recursive listing), for ?sort=exifdate.
+// It stops early when ctx is done; the rest sort by modification time.
+func (s *Server) setCaptureTimes(ctx context.Context, r *http.Request, fullPath string, files []FileInfo) {
+	for i := range files {
+		f := &files[i]
+		if f.IsDir || f.info == nil || !isImage(f.Name) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		f.Captured = s.readExif(ctx, r, filepath.Join(fullPath, filepath.FromSlash(f.Name)), f.info).captured
+	}
+}
+
+// parseExif reads what it can from the start of an image. Dimensions come
+// from the image header for JPEG, PNG and GIF, the rest from an EXIF block
+// in a JPEG APP1 segment, a PNG eXIf chunk, or a TIFF file's first IFD.
+func parseExif(head []byte) exifData {
+	var data exifData
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+		data.Width, data.Height = cfg.Width, cfg.Height
+	}
+	tiff := findExif(head)
+	if tiff == nil {
+		return data
+	}
+	x, ok := newTIFFReader(tiff)
+	if !ok {
+		return data
+	}
+	ifd0 := x.ifd(x.order.Uint32(tiff[4:]))
+	exif := x.ifd(x.uint(ifd0, tagExifIFD))
+
+	data.Make = x.ascii(ifd0, tagMake)
+	data.Model = x.ascii(ifd0, tagModel)
+	if o := x.uint(ifd0, tagOrientation); o >= 1 && o <= 8 {
+		data.Orientation = int(o)
+	}
+	if data.Width == 0 {
+		w, h := x.uint(exif, tagPixelXDimension), x.uint(exif, tagPixelYDimension)
+		if w == 0 || h == 0 {
+			w, h = x.uint(ifd0, tagImageWidth), x.uint(ifd0, tagImageLength)
+		}
+		data.Width, data.Height = int(w), int(h)
+	}
+
+	taken := x.ascii(exif, tagDateTimeOriginal)
+	if taken == "" {
+		taken = x.ascii(ifd0, tagDateTime)
+	}
+	if t, err := time.Parse("2006:01:02 15:04:05", taken); err == nil {
+		data.captured = t
+		data.Captured = t.Format("2006-01-02T15:04:05")
+		if zone, err := time.Parse("-07:00", x.ascii(exif, tagOffsetOriginal)); err == nil {
+			_, offset := zone.Zone()
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.FixedZone("", offset))
+			data.captured = t
+			data.Captured = t.Format(time.RFC3339)
+		}
+	}
+
+	if gps := x.ifd(x.uint(ifd0, tagGPSIFD)); gps != nil {
+		lat, lon := x.degrees(gps, tagGPSLatitude), x.degrees(gps, tagGPSLongitude)
+		if lat != nil && lon != nil {
+			g := &exifGPS{Latitude: *lat, Longitude: *lon}
+			if x.ascii(gps, tagGPSLatitudeRef) == "S" {
+				g.Latitude = -g.Latitude
+			}
+			if x.ascii(gps, tagGPSLongitudeRef) == "W" {
+				g.Longitude = -g.Longitude
+			}
+			if alt := x.rationals(gps, tagGPSAltitude); len(alt) == 1 {
+				if ref := x.raw(gps, tagGPSAltitudeRef); len(ref) > 0 && ref[0] == 1 {
+					alt[0] = -alt[0]
+				}
+				g.Altitude = &alt[0]
+			}
+			data.GPS = g
+		}
+	}
+	return data
+}
+
+// findExif returns the TIFF structure holding a file's EXIF, or nil.
+func findExif(head []byte) []byte {
+	switch {
+	case bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")):
+		return head
+	case bytes.HasPrefix(head, []byte("\xff\xd8")):
+		for p := 2; p+4 <= len(head) && head[p] == 0xff; {
+			marker := head[p+1]
+			if marker == 0xda || marker == 0xd9 {
+				break // image data follows
+			}
+			n := int(binary.BigEndian.Uint16(head[p+2:]))
+			end := p + 2 + n
+			if n < 2 || end > len(head) {
+				break
+			}
+			if segment := head[p+4 : end]; marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				return segment[6:]
+			}
+			p = end
+		}
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		for p := 8; p+8 <= len(head); {
+			n := binary.BigEndian.Uint32(head[p:])
+			if uint64(n) > uint64(len(head)-p-8) {
+				break // also keeps p+8+n from overflowing an int
+			}
+			end := p + 8 + int(n)
+			if string(head[p+4:p+8]) == "eXIf" {
+				return head[p+8 : end]
+			}
+			p = end + 4 // CRC
+		}
+	}
+	return nil
+}
+
+// tiffReader reads IFD entries from a TIFF structure, ignoring anything
+// pointing outside it.
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// Sizes of the TIFF field types, by type number
+var tiffTypeSize = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8}
+
+func newTIFFReader(data []byte) (*tiffReader, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	switch string(data[:2]) {
+	case "II":
+		return &tiffReader{data, binary.LittleEndian}, true
+	case "MM":
+		return &tiffReader{data, binary.BigEndian}, true
+	}
+	return nil, false
+}
+
+// ifd reads the directory at offset, nil when it is out of bounds.
+func (x *tiffReader) ifd(offset uint32) map[uint16]tiffEntry {
+	if offset == 0 || uint64(offset)+2 > uint64(len(x.data)) {
+		return nil
+	}
+	// A corrupt count cannot claim more entries than there are bytes for
+	n := min(int(x.order.Uint16(x.data[offset:])), (len(x.data)-int(offset)-2)/12)
+	entries := make(map[uint16]tiffEntry, n)
+	for i := range n {
+		p := int(offset) + 2 + 12*i
+		e := tiffEntry{typ: x.order.Uint16(x.data[p+2:]), count: x.order.Uint32(x.data[p+4:])}
+		size := uint64(tiffTypeSize[e.typ]) * uint64(e.count)
+		if size == 0 {
+			continue
+		}
+		if size <= 4 {
+			e.value = x.data[p+8 : p+8+int(size)]
+		} else if at := uint64(x.order.Uint32(x.data[p+8:])); at+size <= uint64(len(x.data)) {
+			e.value = x.data[at : at+size]
+		} else {
+			continue
+		}
+		entries[x.order.Uint16(x.data[p:])] = e
+	}
+	return entries
+}
+
+func (x *tiffReader) raw(ifd map[uint16]tiffEntry, tag uint16) []byte {
+	return ifd[tag].value
+}
+
+func (x *tiffReader) ascii(ifd map[uint16]tiffEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	s, _, _ := strings.Cut(string(e.value), "\x00")
+	return strings.TrimSpace(strings.ToValidUTF8(s, ""))
+}
+
+// uint returns the first value of a SHORT or LONG field, 0 if missing.
+func (x *tiffReader) uint(ifd map[uint16]tiffEntry, tag uint16) uint32 {
+	e, ok := ifd[tag]
+	switch {
+	case !ok:
+		return 0
+	case e.typ == 3:
+		return uint32(x.order.Uint16(e.value))
+	case e.typ == 4:
+		return x.order.Uint32(e.value)
+	}
+	return 0
+}
+
+// rationals returns the values of a RATIONAL field, nil if missing or
+// with a zero denominator.
+func (x *tiffReader) rationals(ifd map[uint16]tiffEntry, tag uint16) []float64 {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 5 {
+		return nil
+	}
+	values := make([]float64, e.count)
+	for i := range values {
+		num, den := x.order.Uint32(e.value[8*i:]), x.order.Uint32(e.value[8*i+4:])
+		if den == 0 {
+			return nil
+		}
+		values[i] = float64(num) / float64(den)
+	}
+	return values
+}
+
+// degrees reads a GPS coordinate stored as degrees, minutes and seconds.
+func (x *tiffReader) degrees(ifd map[uint16]tiffEntry, tag uint16) *float64 {
+	dms := x.rationals(ifd, tag)
+	if len(dms) != 3 {
+		return nil
+	}
+	d := dms[0] + dms[1]/60 + dms[2]/3600
+	return &d
+}
@@ -0,0 +1,37 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestFindExifPNG(t *testing.T) {
+	chunk := func(typ string, data []byte) []byte {
+		c := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		c = append(append(c, typ...), data...)
+		return append(c, 0, 0, 0, 0) // CRC, not checked
+	}
+	png := func(chunks ...[]byte) []byte {
+		return append([]byte("\x89PNG\r\n\x1a\n"), bytes.Join(chunks, nil)...)
+	}
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	oversized := []byte("\xff\xff\xff\xffeXIf\x00\x00\x00\x00")
+
+	tests := []struct {
+		name string
+		head []byte
+		want []byte
+	}{
+		{"eXIf chunk", png(chunk("IHDR", make([]byte, 13)), chunk("eXIf", tiff)), tiff},
+		{"no eXIf chunk", png(chunk("IHDR", make([]byte, 13)), chunk("IDAT", []byte("data"))), nil},
+		{"chunk past the head", png(chunk("IHDR", make([]byte, 13)), chunk("eXIf", tiff)[:12]), nil},
+		{"oversized chunk length", png(oversized), nil},
+		{"oversized length after a chunk", png(chunk("IHDR", make([]byte, 13)), oversized), nil},
+	}
+	for _, tt := range tests {
+		if got := findExif(tt.head); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: findExif = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux && (amd64 || arm64 || riscv64 || ppc64le || loong64)
+
+package fileserver
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	fadviseSupported = true
+
+	fadvSequential = 2
+	fadvWillNeed   = 3
+	fadvDontNeed   = 4
+)
+
+// fadvise is posix_fadvise. Hints are best effort, so errors are ignored.
+func fadvise(file *os.File, offset, length int64, advice int) {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return
+	}
+	conn.Control(func(fd uintptr) {
+		syscall.Syscall6(syscall.SYS_FADVISE64, fd, uintptr(offset), uintptr(length), uintptr(advice), 0, 0)
+	})
+}
@@ -0,0 +1,15 @@
+//go:build !(linux && (amd64 || arm64 || riscv64 || ppc64le || loong64))
+
+package fileserver
+
+import "os"
+
+const (
+	fadviseSupported = false
+
+	fadvSequential = 0
+	fadvWillNeed   = 0
+	fadvDontNeed   = 0
+)
+
+func fadvise(file *os.File, offset, length int64, advice int) {}
@@ -0,0 +1,112 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultMinFreeFDs = 128
+	fdCheckInterval   = time.Second
+	fdRetryAfter      = 5
+)
+
+// fdMonitor samples open file descriptors against RLIMIT_NOFILE. While
+// fewer than min are free, new downloads are refused with 503 so the
+// transfers and listings already running keep the descriptors they need.
+type fdMonitor struct {
+	min   int64
+	limit atomic.Int64 // 0 when unknown
+	open  atomic.Int64
+	low   atomic.Bool
+	shed  atomic.Int64
+}
+
+// countOpenFDs counts this process's descriptors (Linux /proc, BSD and
+// macOS /dev/fd).
+func countOpenFDs() (int64, error) {
+	for _, dir := range []string{"/proc/self/fd", "/dev/fd"} {
+		f, err := os.Open(dir)
+		if err != nil {
+			continue
+		}
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return 0, err
+		}
+		// Less the descriptor used to read the directory
+		return int64(len(names)) - 1, nil
+	}
+	return 0, errors.New("no /proc/self/fd or /dev/fd")
+}
+
+func (m *fdMonitor) sample() {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlimit); err != nil {
+		return
+	}
+	open, err := countOpenFDs()
+	if err != nil {
+		return
+	}
+	limit := int64(rlimit.Cur)
+	m.limit.Store(limit)
+	m.open.Store(open)
+
+	// Recovering only well above the threshold keeps it from flapping
+	free, recoverAt := limit-open, min(2*m.min, (limit+m.min)/2)
+	switch {
+	case free < m.min && !m.low.Load():
+		m.low.Store(true)
+		warnf(areaIO, "Running out of file descriptors: %d of %d (RLIMIT_NOFILE) in use; new downloads get 503 until %d are free. Raise the limit (ulimit -n, LimitNOFILE=) or lower -max-conns",
+			open, limit, recoverAt)
+	case free >= recoverAt && m.low.Load():
+		m.low.Store(false)
+		infof(areaIO, "File descriptors available again: %d of %d in use", open, limit)
+	}
+}
+
+func (m *fdMonitor) run(ctx context.Context) {
+	ticker := time.NewTicker(fdCheckInterval)
+	defer ticker.Stop()
+	for {
+		m.sample()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// headroom is the number of free descriptors, or -1 when unknown.
+func (m *fdMonitor) headroom() int64 {
+	limit := m.limit.Load()
+	if limit == 0 {
+		return -1
+	}
+	return limit - m.open.Load()
+}
+
+// shedForFDs answers 503 when descriptors are low, reporting whether it did.
+func (s *Server) shedForFDs(w http.ResponseWriter, r *http.Request) bool {
+	if !s.fds.low.Load() {
+		return false
+	}
+	s.fds.shed.Add(1)
+	w.Header().Set("Retry-After", strconv.Itoa(fdRetryAfter))
+	httpError(w, r, areaIO, http.StatusServiceUnavailable, "Server busy, try again shortly", "")
+	return true
+}
+
+// isFDExhausted reports whether err is EMFILE or ENFILE.
+func isFDExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
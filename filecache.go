@@ -0,0 +1,91 @@
+package fileserver
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fileCache keeps the bytes of small, frequently requested files in memory
+// within a total budget, evicting the least recently used. Entries are keyed
+// by path and only match while size and mtime are unchanged.
+type fileCache struct {
+	budget  int64
+	maxFile int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // front is most recently used
+	size    int64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cachedFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+	data    []byte
+}
+
+func newFileCache(budget, maxFile int64) *fileCache {
+	return &fileCache{
+		budget:  budget,
+		maxFile: min(maxFile, budget),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheable reports whether a file of this size should go through the cache.
+func (c *fileCache) cacheable(size int64) bool {
+	return c != nil && size <= c.maxFile
+}
+
+// get returns the cached bytes if they still match size and modTime. A stale
+// entry is dropped.
+func (c *fileCache) get(path string, size int64, modTime time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[path]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := elem.Value.(*cachedFile)
+	if entry.size != size || !entry.modTime.Equal(modTime) {
+		c.remove(elem)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits.Add(1)
+	return entry.data, true
+}
+
+func (c *fileCache) put(path string, modTime time.Time, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[path]; ok {
+		c.remove(elem)
+	}
+	c.entries[path] = c.lru.PushFront(&cachedFile{path: path, size: int64(len(data)), modTime: modTime, data: data})
+	c.size += int64(len(data))
+	for c.size > c.budget {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *fileCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedFile)
+	delete(c.entries, entry.path)
+	c.size -= entry.size
+}
+
+func (c *fileCache) usage() (entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
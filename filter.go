@@ -0,0 +1,171 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// filterCategories are the ?type= values, in chip order.
+var filterCategories = []string{"image", "video", "audio", "archive", "document", "code", "text"}
+
+// CategoryChip is a ?type= filter toggle above a listing.
+type CategoryChip struct {
+	Name   string
+	Count  int    // files of this category in the unfiltered listing
+	Active bool   // part of the current filter
+	URL    string // query string toggling this category
+}
+
+// listingFilter narrows a listing with ?type= categories and a ?glob=
+// name pattern (case-insensitive with ci=1).
+type listingFilter struct {
+	types map[string]bool // nil for all
+	glob  string
+	ci    bool
+}
+
+func parseListingFilter(query url.Values) (listingFilter, error) {
+	types, err := parseTypeFilter(query.Get("type"))
+	if err != nil {
+		return listingFilter{}, err
+	}
+	f := listingFilter{types: types, glob: query.Get("glob"), ci: query.Get("ci") == "1"}
+	if f.ci {
+		f.glob = strings.ToLower(f.glob)
+	}
+	if _, err := path.Match(f.glob, ""); err != nil {
+		return listingFilter{}, fmt.Errorf("invalid glob %q: use *, ? and [a-z] classes, escaping literal ones with \\", query.Get("glob"))
+	}
+	return f, nil
+}
+
+// matchName reports whether the base of name passes the glob.
+func (f listingFilter) matchName(name string) bool {
+	if f.glob == "" {
+		return true
+	}
+	name = path.Base(name)
+	if f.ci {
+		name = strings.ToLower(name)
+	}
+	ok, _ := path.Match(f.glob, name)
+	return ok
+}
+
+// matchFile reports whether a file passes both the glob and the categories.
+func (f listingFilter) matchFile(name string) bool {
+	return f.matchName(name) && (f.types == nil || f.types[fileCategory(name, false)])
+}
+
+// apply drops entries not matching the glob, then files outside the
+// categories.
+func (f listingFilter) apply(files []FileInfo) []FileInfo {
+	return filterByCategory(f.applyGlob(files), f.types)
+}
+
+func (f listingFilter) applyGlob(files []FileInfo) []FileInfo {
+	if f.glob == "" {
+		return files
+	}
+	kept := files[:0:0]
+	for _, file := range files {
+		if f.matchName(file.Name) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// query is the query string keeping the filter and order on links, or "".
+func (f listingFilter) query(r *http.Request) string {
+	values := url.Values{}
+	for _, name := range []string{"type", "glob", "ci", "recursive", "sort", "order", "group"} {
+		if v := r.URL.Query().Get(name); v != "" {
+			values.Set(name, v)
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// parseTypeFilter parses a comma-separated ?type= value; nil means no
+// filter.
+func parseTypeFilter(v string) (map[string]bool, error) {
+	if v == "" {
+		return nil, nil
+	}
+	types := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		if categoryIcons[name] == "" || name == "folder" || name == "file" {
+			return nil, fmt.Errorf("unknown type %q", name)
+		}
+		types[name] = true
+	}
+	return types, nil
+}
+
+// filterByCategory keeps files in one of types. Directories always stay so
+// the tree remains navigable.
+func filterByCategory(files []FileInfo, types map[string]bool) []FileInfo {
+	if types == nil {
+		return files
+	}
+	kept := files[:0:0]
+	for _, f := range files {
+		if f.IsDir || types[fileCategory(f.Name, false)] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// categoryChips counts the categories in files and links each to the
+// current query with that category toggled. Categories without files are
+// left out unless active.
+func categoryChips(r *http.Request, files []FileInfo, types map[string]bool) []CategoryChip {
+	counts := map[string]int{}
+	for _, f := range files {
+		if !f.IsDir {
+			counts[fileCategory(f.Name, false)]++
+		}
+	}
+	var chips []CategoryChip
+	for _, name := range filterCategories {
+		if counts[name] == 0 && !types[name] {
+			continue
+		}
+		var toggled []string
+		for _, other := range filterCategories {
+			if (other == name) != types[other] {
+				toggled = append(toggled, other)
+			}
+		}
+		query := r.URL.Query()
+		query.Del("type")
+		if len(toggled) > 0 {
+			query.Set("type", strings.Join(toggled, ","))
+		}
+		chips = append(chips, CategoryChip{Name: name, Count: counts[name], Active: types[name], URL: "?" + query.Encode()})
+	}
+	return chips
+}
+
+// globClearURL is the current query without the glob.
+// detailsURL is the current query string asking for a full listing.
+func detailsURL(r *http.Request) string {
+	query := r.URL.Query()
+	query.Set("details", "1")
+	return "?" + query.Encode()
+}
+
+func globClearURL(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("glob")
+	query.Del("ci")
+	return "?" + query.Encode()
+}
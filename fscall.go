@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errTooManyFSCalls = errors.New("too many outstanding filesystem calls")
+	errFSCallTimeout  = errors.New("filesystem call timed out")
+)
+
+// fsCalls runs Stat and Open off the request goroutine, as dirReader does
+// for ReadDir. A call stuck on a dead network mount cannot be interrupted,
+// so the request gives up at its deadline and the calls still running,
+// abandoned or not, are capped.
+type fsCalls struct {
+	slots    chan struct{} // nil when uncapped
+	running  atomic.Int64
+	rejected atomic.Int64
+	timedOut atomic.Int64
+}
+
+func newFSCalls(max int) *fsCalls {
+	c := &fsCalls{}
+	if max > 0 {
+		c.slots = make(chan struct{}, max)
+	}
+	return c
+}
+
+// fsCall runs call until ctx or timeout ends. A result arriving after the
+// caller gave up is passed to release, e.g. to close a file nobody reads.
+func fsCall[T any](c *fsCalls, ctx context.Context, timeout time.Duration, call func() (T, error), release func(T)) (T, error) {
+	var zero T
+	if c.slots != nil {
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			c.rejected.Add(1)
+			return zero, errTooManyFSCalls
+		}
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+	var mu sync.Mutex
+	abandoned := false
+	done := make(chan result, 1)
+	c.running.Add(1)
+	go func() {
+		value, err := call()
+		c.running.Add(-1)
+		if c.slots != nil {
+			<-c.slots
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			if err == nil && release != nil {
+				release(value)
+			}
+			return
+		}
+		done <- result{value, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	select {
+	case res := <-done:
+		return res.value, res.err
+	default:
+		abandoned = true
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.timedOut.Add(1)
+		return zero, errFSCallTimeout
+	}
+	return zero, ctx.Err()
+}
+
+// stat is storage.Stat bounded by ctx and Timeouts.Stat.
+func (s *Server) stat(ctx context.Context, name string) (fs.FileInfo, error) {
+	return fsCall(s.fsCalls, ctx, s.config.Timeouts.Stat,
+		func() (fs.FileInfo, error) {
+			return retryIO(ctx, &s.ioRetries, func() (fs.FileInfo, error) { return s.storage.Stat(name) })
+		}, nil)
+}
+
+// open is storage.Open bounded by ctx and Timeouts.Stat.
+func (s *Server) open(ctx context.Context, name string) (fs.File, error) {
+	return fsCall(s.fsCalls, ctx, s.config.Timeouts.Stat,
+		func() (fs.File, error) {
+			return retryIO(ctx, &s.ioRetries, func() (fs.File, error) { return s.storage.Open(name) })
+		},
+		func(f fs.File) { f.Close() })
+}
+
+// replyFunc is httpError or apiFail.
+type replyFunc func(w http.ResponseWriter, r *http.Request, area string, status int, message, detail string)
+
+// fsCallFailed replies 503 when err means the storage did not answer in
+// time, reporting whether it replied.
+func (s *Server) fsCallFailed(w http.ResponseWriter, r *http.Request, fail replyFunc, op, fullPath string, err error) bool {
+	if !errors.Is(err, errFSCallTimeout) && !errors.Is(err, errTooManyFSCalls) {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(mountRetryAfter))
+	fail(w, r, areaMount, http.StatusServiceUnavailable, "Storage temporarily unavailable",
+		fmt.Sprintf("%s %s: %v (%d calls outstanding)", op, fullPath, err, s.fsCalls.running.Load()))
+	return true
+}
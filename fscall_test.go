@@ -0,0 +1,113 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFSCallAbandonedReleasesSlot(t *testing.T) {
+	c := newFSCalls(1)
+	unblock := make(chan struct{})
+	released := make(chan string, 1)
+	_, err := fsCall(c, context.Background(), 10*time.Millisecond, func() (string, error) {
+		<-unblock
+		return "late", nil
+	}, func(v string) { released <- v })
+	if !errors.Is(err, errFSCallTimeout) {
+		t.Fatalf("stuck call returned %v, want errFSCallTimeout", err)
+	}
+
+	// The abandoned call still holds the only slot
+	if _, err := fsCall(c, context.Background(), 0, func() (int, error) { return 1, nil }, nil); !errors.Is(err, errTooManyFSCalls) {
+		t.Errorf("call while the slot is held returned %v, want errTooManyFSCalls", err)
+	}
+
+	close(unblock)
+	select {
+	case v := <-released:
+		if v != "late" {
+			t.Errorf("released %q", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("late result was never released")
+	}
+	waitFor(t, "the slot to be freed", func() bool { return c.running.Load() == 0 && len(c.slots) == 0 })
+	if v, err := fsCall(c, context.Background(), 0, func() (int, error) { return 1, nil }, nil); v != 1 || err != nil {
+		t.Errorf("call after the slot was freed = %v, %v", v, err)
+	}
+	if c.timedOut.Load() != 1 || c.rejected.Load() != 1 {
+		t.Errorf("timedOut %d, rejected %d, want 1 each", c.timedOut.Load(), c.rejected.Load())
+	}
+}
+
+func TestFSCallCanceled(t *testing.T) {
+	c := newFSCalls(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	unblock := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err := fsCall(c, ctx, 0, func() (int, error) {
+		<-unblock
+		return 0, nil
+	}, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled call returned %v", err)
+	}
+	close(unblock)
+	waitFor(t, "the slot to be freed", func() bool { return len(c.slots) == 0 })
+	if c.timedOut.Load() != 0 {
+		t.Error("a canceled call counted as timed out")
+	}
+}
+
+// hangingStatFile is a file whose Stat blocks until unblock closes.
+type hangingStatFile struct {
+	fs.File
+	unblock <-chan struct{}
+	closed  *atomic.Bool
+}
+
+func (f hangingStatFile) Stat() (fs.FileInfo, error) {
+	<-f.unblock
+	return f.File.Stat()
+}
+
+func (f hangingStatFile) Close() error {
+	f.closed.Store(true)
+	return f.File.Close()
+}
+
+type hangingStatStorage struct {
+	storage
+	unblock <-chan struct{}
+	closed  *atomic.Bool
+}
+
+func (s hangingStatStorage) Open(name string) (fs.File, error) {
+	f, err := s.storage.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return hangingStatFile{f, s.unblock, s.closed}, nil
+}
+
+func TestOpenStatBounded(t *testing.T) {
+	unblock := make(chan struct{})
+	closed := &atomic.Bool{}
+	s, _ := newWrappedTestServer(t, Config{Timeouts: Timeouts{Stat: 20 * time.Millisecond}}, func(st storage) storage {
+		return hangingStatStorage{st, unblock, closed}
+	})
+	writeFiles(t, s.rootDir, map[string]string{"f.txt": "x"})
+
+	if _, _, err := s.openStat(context.Background(), s.fsPath("/f.txt")); !errors.Is(err, errFSCallTimeout) {
+		t.Fatalf("openStat with a hanging fstat returned %v, want errFSCallTimeout", err)
+	}
+	close(unblock)
+	waitFor(t, "the abandoned file to be closed", closed.Load)
+}
@@ -0,0 +1,199 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Budgets for /_grep, so one search cannot read the whole tree
+const (
+	grepDefaultResults = 100
+	grepMaxResults     = 1000
+	grepMaxFileBytes   = 16 << 20
+	grepMaxTotalBytes  = 512 << 20
+	grepMaxLine        = 1 << 20
+	grepMaxPattern     = 1000
+	grepContext        = 80 // characters kept on each side of a match
+)
+
+type grepMatch struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Text string `json:"text"`
+	URL  string `json:"-"`
+}
+
+type grepResult struct {
+	Query        string      `json:"query"`
+	Path         string      `json:"path"`
+	Regex        bool        `json:"regex"`
+	Matches      []grepMatch `json:"matches"`
+	FilesScanned int         `json:"filesScanned"`
+	Truncated    bool        `json:"truncated"` // result cap, byte budget or deadline reached
+}
+
+func (s *Server) handleGrep(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" || len(q) > grepMaxPattern {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "The q parameter must be 1 to "+strconv.Itoa(grepMaxPattern)+" bytes", "")
+		return
+	}
+	isRegex := query.Get("regex") == "1"
+	var re *regexp.Regexp
+	if isRegex {
+		var err error
+		if re, err = regexp.Compile(q); err != nil {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid regular expression: "+err.Error(), "")
+			return
+		}
+	}
+	limit := grepDefaultResults
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid limit parameter", v)
+			return
+		}
+		limit = min(n, grepMaxResults)
+	}
+	filter, err := parseListingFilter(query)
+	if err != nil {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid filter: "+err.Error(), "")
+		return
+	}
+	requestPath, fullPath, info, ok := s.lookupParam(w, r, httpError)
+	if !ok {
+		return
+	}
+	if !info.IsDir() {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Not a directory", "")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.Timeouts.DirRead)
+	defer cancel()
+	result := grepResult{Query: q, Path: newAPIEntry(requestPath, info).Path, Regex: isRegex, Matches: []grepMatch{}}
+	match := func(line []byte) int {
+		if re != nil {
+			if loc := re.FindIndex(line); loc != nil {
+				return loc[0]
+			}
+			return -1
+		}
+		return bytes.Index(line, []byte(q))
+	}
+
+	budget := int64(grepMaxTotalBytes)
+	err = s.apiWalk(ctx, fullPath, func(p string, d fs.DirEntry) error {
+		if d.IsDir() || !d.Type().IsRegular() || !filter.matchFile(d.Name()) {
+			return nil
+		}
+		if budget <= 0 || len(result.Matches) >= limit {
+			result.Truncated = true
+			return fs.SkipAll
+		}
+		matches, read, err := s.grepFile(ctx, filepath.Join(fullPath, filepath.FromSlash(p)), match, limit-len(result.Matches))
+		budget -= read
+		if err != nil {
+			reqLogf(r, levelDebug, areaIO, "Failed to search %s: %v", p, err)
+			return nil
+		}
+		if read > 0 {
+			result.FilesScanned++
+		}
+		for _, m := range matches {
+			m.Path = requestPath + "/" + p
+			m.URL = basePath(r) + urlPath(m.Path)
+			result.Matches = append(result.Matches, m)
+		}
+		return nil
+	})
+	if err != nil {
+		reqLogf(r, levelDebug, areaListing, "Search under %s stopped early: %v", fullPath, err)
+		result.Truncated = true
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			reqLogf(r, levelWarn, areaRequest, "Failed to write search results: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.template.ExecuteTemplate(w, "grep.html", result); err != nil {
+		reqLogf(r, levelError, areaRequest, "Template execution error: %v", err)
+	}
+}
+
+// grepFile searches one file line by line, reading at most
+// grepMaxFileBytes. Files that sniff as binary are skipped unread.
+func (s *Server) grepFile(ctx context.Context, fullPath string, match func([]byte) int, limit int) (matches []grepMatch, read int64, err error) {
+	file, err := s.open(ctx, fullPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(io.LimitReader(file, grepMaxFileBytes), 64<<10)
+	head, _ := reader.Peek(512)
+	if !looksLikeText(head) {
+		return nil, 0, nil
+	}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64<<10), grepMaxLine)
+	for line := 1; scanner.Scan(); line++ {
+		read += int64(len(scanner.Bytes())) + 1
+		if at := match(scanner.Bytes()); at >= 0 {
+			matches = append(matches, grepMatch{Line: line, Text: trimAround(scanner.Bytes(), at)})
+			if len(matches) == limit {
+				break
+			}
+		}
+		if line%1000 == 0 && ctx.Err() != nil {
+			break
+		}
+	}
+	// A line over grepMaxLine ends the file; what was found still counts
+	return matches, read, nil
+}
+
+// looksLikeText is true for content without NUL bytes that sniffs as text.
+func looksLikeText(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	return len(head) == 0 || strings.HasPrefix(http.DetectContentType(head), "text/") || utf8.Valid(head)
+}
+
+// trimAround shortens a long line to grepContext characters around at.
+func trimAround(line []byte, at int) string {
+	start, end := max(0, at-grepContext), min(len(line), at+2*grepContext)
+	// Do not cut through a multi-byte character
+	for start > 0 && !utf8.RuneStart(line[start]) {
+		start--
+	}
+	for end < len(line) && !utf8.RuneStart(line[end]) {
+		end++
+	}
+	text := strings.ToValidUTF8(string(bytes.TrimRight(line[start:end], "\r")), "�")
+	if start > 0 {
+		text = "…" + text
+	}
+	if end < len(line) {
+		text += "…"
+	}
+	return text
+}
@@ -0,0 +1,26 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestGrepOptIn(t *testing.T) {
+	s, h := newTestServer(t, Config{})
+	writeFiles(t, s.rootDir, map[string]string{"logs/app.log": "ok\ndisk full\n"})
+	if w := serve(h, http.MethodGet, "/_grep?q=disk", nil); w.Code != http.StatusNotFound {
+		t.Errorf("/_grep without -grep: status %d, want 404", w.Code)
+	}
+
+	s, h = newTestServer(t, Config{Grep: true})
+	writeFiles(t, s.rootDir, map[string]string{"logs/app.log": "ok\ndisk full\n"})
+	w := serve(h, http.MethodGet, "/_grep?q=disk&format=json", nil)
+	var result grepResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("/_grep with -grep: status %d, body %s", w.Code, w.Body)
+	}
+	if len(result.Matches) != 1 || result.Matches[0].Path != "/logs/app.log" || result.Matches[0].Line != 2 {
+		t.Errorf("matches = %+v", result.Matches)
+	}
+}
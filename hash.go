@@ -0,0 +1,113 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// HashTree computes the SHA-256 of every file under root, resolved exactly
+// as the server resolves -root, and writes sha256sum-style lines to w. With
+// cacheFile the digests are saved where the server's checksum cache (the
+// same file) picks them up.
+func HashTree(root, cacheFile string, cacheSize int, w io.Writer) error {
+	return hashTree(root, cacheFile, cacheSize, "", w)
+}
+
+// WriteSums hashes every file under the local directory root like
+// HashTree and writes the lines to a SHA256SUMS file in it, replacing any
+// previous one atomically. The manifest itself is not listed.
+func WriteSums(root, cacheFile string, cacheSize int) error {
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return fmt.Errorf("%s is not a local directory to write %s into", root, SumsFile)
+	}
+	var manifest bytes.Buffer
+	hashErr := hashTree(root, cacheFile, cacheSize, SumsFile, &manifest)
+	if hashErr != nil && manifest.Len() == 0 {
+		return hashErr
+	}
+
+	tmp, err := os.CreateTemp(root, "."+SumsFile+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", SumsFile, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(manifest.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(root, SumsFile))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", SumsFile, err)
+	}
+	return hashErr
+}
+
+// hashTree is HashTree leaving out skip at the top of the tree.
+func hashTree(root, cacheFile string, cacheSize int, skip string, w io.Writer) error {
+	store, absRoot, err := openRoot(root, false)
+	if err != nil {
+		return err
+	}
+	digests, err := newDigestCache(cacheSize, cacheFile)
+	if err != nil {
+		return err
+	}
+	tree, err := store.Sub(absRoot)
+	if err != nil {
+		return err
+	}
+
+	files, failed := 0, 0
+	err = fs.WalkDir(tree, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			warnf(areaIO, "Skipping %s: %v", p, err)
+			failed++
+			return nil
+		}
+		if !d.Type().IsRegular() || p == skip {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			warnf(areaIO, "Skipping %s: %v", p, err)
+			failed++
+			return nil
+		}
+		fullPath := filepath.Join(absRoot, filepath.FromSlash(p))
+		sum, err := digests.sha256(context.Background(), store, fullPath, info)
+		if err != nil {
+			warnf(areaIO, "Failed to hash %s: %v", p, err)
+			failed++
+			return nil
+		}
+		io.WriteString(w, sumsLine(sum, p))
+		files++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if cacheFile != "" {
+		if files > cacheSize {
+			warnf(areaServer, "Hashed %d files but the checksum cache keeps only %d", files, cacheSize)
+		}
+		if err := digests.save(); err != nil {
+			return fmt.Errorf("failed to save checksum cache: %v", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be hashed", failed)
+	}
+	return nil
+}
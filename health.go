@@ -0,0 +1,75 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleHealth reports the storage state from the mount monitor.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+
+	if err := s.mount.unavailable(); err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(mountRetryAfter))
+		httpError(w, r, areaMount, http.StatusServiceUnavailable, "unhealthy", err.Error())
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReady is /healthz that also fails while draining for shutdown, so
+// load balancers stop sending new requests.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		httpError(w, r, areaServer, http.StatusServiceUnavailable, "draining", "")
+		return
+	}
+	s.handleHealth(w, r)
+}
+
+// startHealthServer exposes /healthz (and admin reports) on a separate plain HTTP listener so
+// load balancers can probe it without a client certificate.
+func (s *Server) startHealthServer() error {
+	ln := s.config.HealthListener
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", s.config.HealthAddr); err != nil {
+			return fmt.Errorf("failed to listen on health address %s: %v", s.config.HealthAddr, err)
+		}
+	}
+	s.listenMu.Lock()
+	s.healthListener = ln
+	s.listenMu.Unlock()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	mux.HandleFunc("/_status", s.handleStatus)
+	if s.config.Metrics {
+		mux.Handle("/metrics", s.metrics)
+	}
+	if s.bandwidth != nil {
+		// Per-client usage is private; it never goes on the public listener
+		mux.HandleFunc("/_admin/bandwidth", s.handleBandwidth)
+	}
+
+	s.healthServer = &http.Server{
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+
+	infof(areaServer, "Health endpoint on: http://%s/healthz", ln.Addr())
+
+	go func() {
+		if err := s.healthServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			errorf(areaServer, "Health server error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,59 @@
+package fileserver
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+func isDotFile(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// hasDotComponent reports whether any element of a request path is a dot
+// file, so hidden names cannot be reached by typing them.
+func hasDotComponent(requestPath string) bool {
+	for _, part := range strings.Split(requestPath, "/") {
+		if isDotFile(part) {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenPath reports whether requestPath is hidden by -hide-dotfiles or a
+// deny pattern.
+func (s *Server) hiddenPath(requestPath string) bool {
+	return (s.config.HideDotFiles && hasDotComponent(requestPath)) || s.deny.match(requestPath) != ""
+}
+
+// hideFilter wraps fsys, the tree at requestPath, so directory reads skip
+// hidden entries, keeping them out of archives and searches.
+func (s *Server) hideFilter(ctx context.Context, fsys fs.FS, requestPath string) fs.FS {
+	visible := s.aclVisibleFunc(ctx)
+	if !s.config.HideDotFiles && len(s.deny) == 0 && !s.config.DirPasswords && visible == nil {
+		return fsys
+	}
+	return hideFilterFS{fsys, requestPath, func(requestPath string, isDir bool) bool {
+		return s.hiddenPath(requestPath) || s.lockedSubdir(requestPath, isDir) ||
+			(visible != nil && !visible(requestPath, isDir))
+	}}
+}
+
+type hideFilterFS struct {
+	fs.FS
+	base   string
+	hidden func(requestPath string, isDir bool) bool
+}
+
+func (f hideFilterFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.FS, name)
+	kept := entries[:0:0]
+	for _, entry := range entries {
+		if !f.hidden(path.Join(f.base, name, entry.Name()), entry.IsDir()) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept, err
+}
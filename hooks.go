@@ -0,0 +1,146 @@
+package fileserver
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ListingHook filters or augments a directory listing before it is
+// rendered. dir is the request path of the directory, e.g. "/docs/".
+type ListingHook func(r *http.Request, dir string, files []FileInfo) []FileInfo
+
+// FileServedHook runs after a file body has been sent, with the bytes
+// actually written and the time the transfer took.
+type FileServedHook func(r *http.Request, path string, bytes int64, duration time.Duration)
+
+// WriteHook runs after an operation that changes the tree, with its
+// outcome. op is "upload" for a PUT with -uploads.
+type WriteHook func(r *http.Request, op, path string, err error)
+
+// hooks run in registration order. A panicking hook is logged and skipped;
+// it never fails the request.
+type hooks struct {
+	mu         sync.RWMutex
+	middleware []func(http.Handler) http.Handler
+	listing    []ListingHook
+	fileServed []FileServedHook
+	write      []WriteHook
+}
+
+// Use adds middleware around the request handlers, inside authentication
+// and the access log. The first added runs first. Middleware must be added
+// before Start or Handler.
+func (s *Server) Use(mw ...func(http.Handler) http.Handler) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.middleware = append(s.hooks.middleware, mw...)
+}
+
+// OnListing registers a hook that may filter or augment listings.
+func (s *Server) OnListing(fn ListingHook) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.listing = append(s.hooks.listing, fn)
+}
+
+// OnFileServed registers a hook called after each file download.
+func (s *Server) OnFileServed(fn FileServedHook) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.fileServed = append(s.hooks.fileServed, fn)
+}
+
+// OnWrite registers a hook called after each mutating operation.
+func (s *Server) OnWrite(fn WriteHook) {
+	s.hooks.mu.Lock()
+	defer s.hooks.mu.Unlock()
+	s.hooks.write = append(s.hooks.write, fn)
+}
+
+func (s *Server) wrapMiddleware(handler http.Handler) http.Handler {
+	s.hooks.mu.RLock()
+	defer s.hooks.mu.RUnlock()
+	for i := len(s.hooks.middleware) - 1; i >= 0; i-- {
+		handler = s.hooks.middleware[i](handler)
+	}
+	return handler
+}
+
+// runHook calls fn, turning a panic into a logged error.
+func runHook(r *http.Request, kind string, fn func()) (ok bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			reqLogf(r, levelError, areaServer, "%s hook panicked: %v", kind, v)
+			ok = false
+		}
+	}()
+	fn()
+	return true
+}
+
+func (s *Server) runListingHooks(r *http.Request, dir string, files []FileInfo) []FileInfo {
+	s.hooks.mu.RLock()
+	defer s.hooks.mu.RUnlock()
+	for _, hook := range s.hooks.listing {
+		var result []FileInfo
+		if runHook(r, "Listing", func() { result = hook(r, dir, files) }) {
+			files = result
+		}
+	}
+	return files
+}
+
+// fileServed records a finished file response in the stats and reports it
+// to the OnFileServed hooks.
+func (s *Server) fileServed(r *http.Request, tw *transferWriter, fullPath string) {
+	s.recordDownload(r, tw, fullPath)
+
+	s.hooks.mu.RLock()
+	defer s.hooks.mu.RUnlock()
+	if len(s.hooks.fileServed) == 0 {
+		return
+	}
+	_, bytes := tw.written()
+	duration := time.Since(tw.t.started)
+	rel := s.requestPathOf(fullPath)
+	for _, hook := range s.hooks.fileServed {
+		runHook(r, "FileServed", func() { hook(r, rel, bytes, duration) })
+	}
+}
+
+// writeDone reports a mutating operation to the OnWrite hooks.
+func (s *Server) writeDone(r *http.Request, op, fullPath string, err error) {
+	s.hooks.mu.RLock()
+	defer s.hooks.mu.RUnlock()
+	rel := s.requestPathOf(fullPath)
+	for _, hook := range s.hooks.write {
+		runHook(r, "Write", func() { hook(r, op, rel, err) })
+	}
+}
+
+// requestPathOf maps a full path under the root back to its URL path.
+func (s *Server) requestPathOf(fullPath string) string {
+	rel, err := filepath.Rel(s.rootDir, fullPath)
+	if err != nil {
+		return fullPath
+	}
+	return path.Clean("/" + filepath.ToSlash(rel))
+}
+
+// HideFiles is a ListingHook dropping entries for which hide returns true,
+// given the entry's request path. It only affects listings; pair it with
+// middleware to refuse the files themselves.
+func HideFiles(hide func(path string, file FileInfo) bool) ListingHook {
+	return func(r *http.Request, dir string, files []FileInfo) []FileInfo {
+		kept := files[:0:0]
+		for _, f := range files {
+			if !hide(path.Join(dir, f.Name), f) {
+				kept = append(kept, f)
+			}
+		}
+		return kept
+	}
+}
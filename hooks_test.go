@@ -0,0 +1,40 @@
+package fileserver
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOnWrite(t *testing.T) {
+	s, h := newTestServer(t, Config{Uploads: true})
+	type write struct {
+		op, path string
+		failed   bool
+	}
+	var got []write
+	s.OnWrite(func(r *http.Request, op, path string, err error) {
+		got = append(got, write{op, path, err != nil})
+	})
+	// A panicking hook is skipped without failing the upload
+	s.OnWrite(func(r *http.Request, op, path string, err error) { panic("broken hook") })
+
+	if w := put(h, "/dir/ok.txt", strings.NewReader("data"), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d", w.Code)
+	}
+	if w := put(h, "/bad.txt", strings.NewReader("data"), digestHeader(strings.Repeat("0", 64))); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("PUT with a wrong digest: status %d", w.Code)
+	}
+	// Refused before writing anything: no hook
+	put(h, "/dir/", strings.NewReader("data"), nil)
+
+	want := []write{{"upload", "/dir/ok.txt", false}, {"upload", "/bad.txt", true}}
+	if len(got) != len(want) {
+		t.Fatalf("hook saw %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("write %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
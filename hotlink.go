@@ -0,0 +1,120 @@
+package fileserver
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultHotlinkTypes are the content types -hotlink-allow protects.
+var DefaultHotlinkTypes = []string{"image", "video"}
+
+// hotlinkPolicy refuses files of the protected types to requests whose
+// Referer names a site other than this one and the allowed hosts. Requests
+// without a Referer pass: browsers and proxies drop it too often.
+type hotlinkPolicy struct {
+	allow           []string // host, or *.domain for its subdomains
+	types           []string // "image" for image/*, or a full type
+	placeholder     []byte   // served with the 403 instead of an error page
+	placeholderType string
+}
+
+func newHotlinkPolicy(allow, types []string, placeholder string) (*hotlinkPolicy, error) {
+	if len(allow) == 0 {
+		if placeholder != "" {
+			return nil, fmt.Errorf("-hotlink-placeholder needs -hotlink-allow")
+		}
+		return nil, nil
+	}
+	if len(types) == 0 {
+		types = DefaultHotlinkTypes
+	}
+	p := &hotlinkPolicy{types: types}
+	for _, host := range allow {
+		p.allow = append(p.allow, strings.ToLower(host))
+	}
+	if placeholder != "" {
+		data, err := os.ReadFile(placeholder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hotlink placeholder: %v", err)
+		}
+		p.placeholder = data
+		p.placeholderType = mime.TypeByExtension(path.Ext(placeholder))
+		if p.placeholderType == "" {
+			p.placeholderType = http.DetectContentType(data)
+		}
+	}
+	return p, nil
+}
+
+// protects reports whether files named name are subject to the check.
+func (p *hotlinkPolicy) protects(name string) bool {
+	ctype, _, _ := strings.Cut(mime.TypeByExtension(path.Ext(name)), ";")
+	if ctype == "" {
+		return false
+	}
+	major, _, _ := strings.Cut(ctype, "/")
+	for _, t := range p.types {
+		if strings.EqualFold(t, ctype) || strings.EqualFold(t, major) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether the Referer of r may embed protected files.
+func (p *hotlinkPolicy) allowed(r *http.Request) bool {
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		return true
+	}
+	u, err := url.Parse(referer)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if own, _, err := net.SplitHostPort(r.Host); err == nil && strings.EqualFold(own, host) || strings.EqualFold(r.Host, host) {
+		return true
+	}
+	for _, allowed := range p.allow {
+		if host == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHotlink refuses a protected file embedded from a foreign site,
+// before any body or range is served. Signed links, handed out on purpose,
+// pass from anywhere. It reports whether the request may go on.
+func (s *Server) checkHotlink(w http.ResponseWriter, r *http.Request, fullPath string) bool {
+	p := s.hotlink
+	if p == nil || !p.protects(fullPath) {
+		return true
+	}
+	if _, ok := s.signedLink(r); ok {
+		return true
+	}
+	// The answer depends on the Referer, so shared caches must not mix them
+	w.Header().Add("Vary", "Referer")
+	if p.allowed(r) {
+		return true
+	}
+	if p.placeholder == nil {
+		httpError(w, r, areaRequest, http.StatusForbidden, "Embedding this file from other sites is not allowed", "hotlink from "+r.Header.Get("Referer"))
+		return false
+	}
+	logHTTPError(r, areaRequest, http.StatusForbidden, "Hotlink refused", "placeholder served to "+r.Header.Get("Referer"))
+	w.Header().Set("Content-Type", p.placeholderType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.placeholder)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(p.placeholder)
+	return false
+}
@@ -0,0 +1,46 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHotlink(t *testing.T) {
+	s, h := newTestServer(t, Config{HotlinkAllow: []string{"*.friend.example"}, PlaylistLinkLifetime: time.Hour})
+	writeFiles(t, s.rootDir, map[string]string{"pic.jpg": "jpeg", "other.jpg": "jpeg", "notes.txt": "text"})
+	sign := func(path string, expires time.Time) string {
+		return "?" + linkParam + "=" + url.QueryEscape(s.linkSigner.seal(linkClaims{
+			Host: s.vhost, Path: path, User: "alice", Expires: expires.Unix(),
+		}))
+	}
+
+	tests := []struct {
+		name, target, referer, rng string
+		want                       int
+	}{
+		{"no referer", "/pic.jpg", "", "", http.StatusOK},
+		{"same site", "/pic.jpg", "http://example.com/gallery/", "", http.StatusOK},
+		{"allowed site", "/pic.jpg", "https://www.friend.example/post", "", http.StatusOK},
+		{"foreign site", "/pic.jpg", "https://evil.example/", "", http.StatusForbidden},
+		{"foreign range", "/pic.jpg", "https://evil.example/", "bytes=0-1", http.StatusForbidden},
+		{"unprotected type", "/notes.txt", "https://evil.example/", "", http.StatusOK},
+		{"signed link", "/pic.jpg" + sign("/pic.jpg", time.Now().Add(time.Hour)), "https://evil.example/", "", http.StatusOK},
+		{"signed range", "/pic.jpg" + sign("/pic.jpg", time.Now().Add(time.Hour)), "https://evil.example/", "bytes=0-1", http.StatusPartialContent},
+		{"link signed for another file", "/pic.jpg" + sign("/other.jpg", time.Now().Add(time.Hour)), "https://evil.example/", "", http.StatusForbidden},
+		{"expired link", "/pic.jpg" + sign("/pic.jpg", time.Now().Add(-time.Minute)), "https://evil.example/", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.referer != "" {
+			header.Set("Referer", tt.referer)
+		}
+		if tt.rng != "" {
+			header.Set("Range", tt.rng)
+		}
+		if w := serve(h, http.MethodGet, tt.target, header); w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
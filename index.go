@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// IndexTree counts the entries of every directory under cfg.RootDir, as
+// listings with -dir-counts show them, into cfg.DirCountsFile, so a server
+// sharing that file starts with every count cached. It returns the number
+// of directories counted.
+func IndexTree(cfg Config) (int, error) {
+	if cfg.DirCountsFile == "" {
+		return 0, errors.New("no directory counts file given")
+	}
+	s, err := NewServerFromConfig(cfg)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := s.storage.(osStorage); !ok {
+		return 0, errors.New("indexing needs a local directory root")
+	}
+	indexed := 0
+	filepath.WalkDir(s.rootDir, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			warnf(areaServer, "Failed to index %s: %v", fullPath, err)
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(s.rootDir, fullPath)
+		requestPath := "/"
+		if rel != "." {
+			requestPath += filepath.ToSlash(rel) + "/"
+			if (s.config.HideDotFiles && isDotFile(d.Name())) || s.deny.match(strings.TrimSuffix(requestPath, "/")) != "" {
+				return fs.SkipDir
+			}
+		}
+		info, err := d.Info()
+		if err != nil {
+			warnf(areaServer, "Failed to index %s: %v", fullPath, err)
+			return nil
+		}
+		count, err := s.countEntries(context.Background(), fullPath, requestPath)
+		if err != nil {
+			warnf(areaServer, "Failed to index %s: %v", fullPath, err)
+			return nil
+		}
+		s.dirCounts.put(fullPath, info.ModTime(), count)
+		indexed++
+		return nil
+	})
+	if err := s.dirCounts.save(); err != nil {
+		return indexed, fmt.Errorf("failed to save directory counts: %v", err)
+	}
+	return indexed, nil
+}
@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexTree(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"a/1.txt":      "",
+		"a/2.txt":      "",
+		"a/.hidden":    "",
+		"a/b/3.txt":    "",
+		".private/x":   "",
+		"secrets/.env": "",
+	})
+	file := filepath.Join(t.TempDir(), "counts.json")
+	cfg := Config{RootDir: root, DirCountsFile: file, HideDotFiles: true}
+
+	n, err := IndexTree(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("indexed %d directories, want 4 (root, a, a/b, secrets)", n)
+	}
+
+	cfg.DirCounts = true
+	s, _ := newTestServer(t, cfg)
+	tests := []struct {
+		dir  string
+		want int
+	}{
+		{"", 2},
+		{"a", 3},
+		{"a/b", 1},
+		{"secrets", 0},
+	}
+	for _, tt := range tests {
+		fullPath := filepath.Join(s.rootDir, filepath.FromSlash(tt.dir))
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, ok := s.dirCounts.get(fullPath, info.ModTime())
+		if !ok || count.items != tt.want {
+			t.Errorf("loaded count of /%s = %d, %v, want %d", tt.dir, count.items, ok, tt.want)
+		}
+	}
+	if _, ok := s.dirCounts.entries[filepath.Join(s.rootDir, ".private")]; ok {
+		t.Error("hidden directory was indexed")
+	}
+
+	// Counts made with other listing settings would be wrong
+	cfg.HideDotFiles = false
+	s, _ = newTestServer(t, cfg)
+	if len(s.dirCounts.entries) != 0 {
+		t.Errorf("loaded %d counts saved with other settings", len(s.dirCounts.entries))
+	}
+}
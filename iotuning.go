@@ -0,0 +1,77 @@
+package fileserver
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// IOTuning adjusts how file bodies are read. The zero value keeps the
+// defaults: the kernel's sendfile where the connection allows it and no
+// hints about the access pattern.
+type IOTuning struct {
+	// Copy bodies through a buffer of this many bytes instead of sendfile
+	CopyBuffer int64
+	// Tell the kernel files are read sequentially (larger readahead)
+	Sequential bool
+	// Drop bytes already sent from the page cache, so streaming huge files
+	// does not evict everything else
+	DropCache bool
+	// Ask the kernel to prefetch this many bytes ahead of each chunk
+	Readahead int64
+}
+
+func (t IOTuning) hinted() bool {
+	return t.Sequential || t.DropCache || t.Readahead > 0
+}
+
+// ioTuner holds the buffers for IOTuning.CopyBuffer.
+type ioTuner struct {
+	IOTuning
+	buffers sync.Pool
+}
+
+func newIOTuner(t IOTuning) *ioTuner {
+	tuner := &ioTuner{IOTuning: t}
+	tuner.buffers.New = func() any {
+		buf := make([]byte, t.CopyBuffer)
+		return &buf
+	}
+	if t.hinted() && !fadviseSupported {
+		warnf(areaIO, "Kernel I/O hints are not supported on this platform and are ignored")
+	}
+	return tuner
+}
+
+// copyChunk writes up to n bytes of file to w, through the copy buffer if
+// one is configured and otherwise with rf (sendfile).
+func (t *ioTuner) copyChunk(w io.Writer, rf io.ReaderFrom, file *os.File, n int64) (int64, error) {
+	src := &io.LimitedReader{R: file, N: n}
+	if t.CopyBuffer <= 0 && rf != nil {
+		return rf.ReadFrom(src)
+	}
+	buf := t.buffers.Get().(*[]byte)
+	defer t.buffers.Put(buf)
+	// Hiding ReadFrom keeps io.CopyBuffer from going around the buffer
+	return io.CopyBuffer(struct{ io.Writer }{w}, src, *buf)
+}
+
+// start hints the kernel before a body is read from offset.
+func (t *ioTuner) start(file *os.File, offset int64) {
+	if t.Sequential {
+		fadvise(file, 0, 0, fadvSequential)
+	}
+	if t.Readahead > 0 {
+		fadvise(file, offset, t.Readahead, fadvWillNeed)
+	}
+}
+
+// sent hints the kernel after n bytes from offset went out.
+func (t *ioTuner) sent(file *os.File, offset, n int64) {
+	if t.DropCache {
+		fadvise(file, offset, n, fadvDontNeed)
+	}
+	if t.Readahead > 0 {
+		fadvise(file, offset+n, t.Readahead, fadvWillNeed)
+	}
+}
@@ -0,0 +1,175 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// listenAddr is one entry of Config.Listen: "HOST:PORT", "http://HOST:PORT",
+// "https://HOST:PORT" or "unix:/path/to.sock". Bare addresses use TLS when
+// a certificate is configured; unix sockets are plain HTTP.
+type listenAddr struct {
+	network string // "tcp" or "unix"
+	address string
+	tls     bool
+}
+
+func (a listenAddr) String() string {
+	if a.network == "unix" {
+		return "unix:" + a.address
+	}
+	if a.tls {
+		return "https://" + a.address
+	}
+	return "http://" + a.address
+}
+
+func parseListenAddr(spec string, tlsConfigured bool) (listenAddr, error) {
+	if path, ok := strings.CutPrefix(spec, "unix:"); ok {
+		if path == "" {
+			return listenAddr{}, fmt.Errorf("invalid listen address %q: missing socket path", spec)
+		}
+		return listenAddr{network: "unix", address: path}, nil
+	}
+	addr := listenAddr{network: "tcp", address: spec, tls: tlsConfigured}
+	if rest, ok := strings.CutPrefix(spec, "http://"); ok {
+		addr.address, addr.tls = rest, false
+	} else if rest, ok := strings.CutPrefix(spec, "https://"); ok {
+		if !tlsConfigured {
+			return listenAddr{}, fmt.Errorf("listen address %q needs -tls-cert and -tls-key", spec)
+		}
+		addr.address, addr.tls = rest, true
+	}
+	if err := validateAddr(addr.address); err != nil {
+		return listenAddr{}, err
+	}
+	return addr, nil
+}
+
+// listenAddrs resolves Config.Listen, falling back to Addr or Port.
+func listenAddrs(cfg Config, defaultAddr string, tlsConfigured bool) ([]listenAddr, error) {
+	if len(cfg.Listen) == 0 {
+		return []listenAddr{{network: "tcp", address: defaultAddr, tls: tlsConfigured}}, nil
+	}
+	var addrs []listenAddr
+	for _, spec := range cfg.Listen {
+		addr, err := parseListenAddr(spec, tlsConfigured)
+		if err != nil {
+			return nil, err
+		}
+		// A client certificate is the only credential there may be, so no
+		// listener may go without one
+		if cfg.ClientCAFile != "" && !addr.tls {
+			return nil, fmt.Errorf("listen address %q is plain HTTP, which -client-ca does not allow", spec)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// listen binds every address, or none: on the first failure the sockets
+// already bound are closed and the error names the address.
+func (s *Server) listen() ([]net.Listener, error) {
+	if inherited := s.config.Listeners; len(inherited) > 0 {
+		if len(inherited) != len(s.listenAddrs) {
+			return nil, fmt.Errorf("inherited %d listeners for %d listen addresses", len(inherited), len(s.listenAddrs))
+		}
+		return inherited, nil
+	}
+	var listeners []net.Listener
+	for _, addr := range s.listenAddrs {
+		ln, err := listenOn(addr)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+func listenOn(addr listenAddr) (net.Listener, error) {
+	ln, err := net.Listen(addr.network, addr.address)
+	if addr.network != "unix" || !errors.Is(err, syscall.EADDRINUSE) {
+		return ln, err
+	}
+	// A socket left behind by a process that died; one that still answers
+	// belongs to a running server and is left alone
+	if conn, dialErr := net.Dial("unix", addr.address); dialErr == nil {
+		conn.Close()
+		return nil, err
+	}
+	if rmErr := os.Remove(addr.address); rmErr != nil {
+		return nil, err
+	}
+	return net.Listen("unix", addr.address)
+}
+
+// serve runs the HTTP server on every listener and returns the first error,
+// or http.ErrServerClosed once all have been closed.
+func (s *Server) serve(listeners []net.Listener) error {
+	errs := make(chan error, len(listeners))
+	for i, ln := range listeners {
+		ln = s.connLimit.wrap(ln)
+		go func() {
+			if s.listenAddrs[i].tls {
+				errs <- s.httpServer.ServeTLS(ln, s.config.TLSCertFile, s.config.TLSKeyFile)
+			} else {
+				errs <- s.httpServer.Serve(ln)
+			}
+		}()
+	}
+	for range listeners {
+		if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+	return http.ErrServerClosed
+}
+
+// bannerURLs are the URLs a bound listener is shown under at startup:
+// its own host, or for a wildcard bind localhost and then every
+// non-loopback interface address of the family bound. Ports are taken
+// from the socket so ":0" shows the port picked.
+func bannerURLs(addr listenAddr, ln net.Listener) []string {
+	if addr.network == "unix" {
+		return []string{addr.String()}
+	}
+	host, _, _ := net.SplitHostPort(addr.address)
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	scheme := "http"
+	if addr.tls {
+		scheme = "https"
+	}
+	hosts := []string{host}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		hosts = []string{"localhost"}
+		ifaceAddrs, err := net.InterfaceAddrs()
+		if err != nil {
+			warnf(areaServer, "Failed to list interface addresses: %v", err)
+		}
+		for _, a := range ifaceAddrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			// "0.0.0.0" binds IPv4 only; "" and "::" both families
+			if host == "0.0.0.0" && ipNet.IP.To4() == nil {
+				continue
+			}
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	urls := make([]string, len(hosts))
+	for i, h := range hosts {
+		urls[i] = scheme + "://" + net.JoinHostPort(h, port)
+	}
+	return urls
+}
@@ -0,0 +1,116 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// groupOrder is the order of ?group=type sections: folders, then the
+// filter categories, then everything else.
+var groupOrder = func() map[string]int {
+	order := map[string]int{"folder": 0}
+	for i, name := range filterCategories {
+		order[name] = i + 1
+	}
+	order["file"] = len(filterCategories) + 1
+	return order
+}()
+
+// listingOrder sorts a listing by ?sort= (name, ext, size, mtime or
+// exifdate, reversed with order=desc) and with ?group=type sections it by category
+// first. Directories stay ahead of files; ties keep the name order
+// listFiles gives.
+type listingOrder struct {
+	by    string
+	desc  bool
+	group bool
+}
+
+func parseListingOrder(query url.Values) (listingOrder, error) {
+	o := listingOrder{by: query.Get("sort")}
+	switch o.by {
+	case "", "name", "ext", "size", "mtime", "exifdate":
+	default:
+		return listingOrder{}, fmt.Errorf("unknown sort %q, want name, ext, size, mtime or exifdate", o.by)
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		o.desc = true
+	default:
+		return listingOrder{}, fmt.Errorf("unknown order %q, want asc or desc", query.Get("order"))
+	}
+	switch query.Get("group") {
+	case "":
+	case "type":
+		o.group = true
+	default:
+		return listingOrder{}, fmt.Errorf("unknown group %q, want type", query.Get("group"))
+	}
+	return o, nil
+}
+
+// needsStat reports whether the order uses more than names.
+func (o listingOrder) needsStat() bool {
+	return o.by == "size" || o.by == "mtime" || o.by == "exifdate"
+}
+
+// apply reorders files, already sorted by name, in place and sets Group
+// when grouping.
+func (o listingOrder) apply(files []FileInfo) {
+	if o.group {
+		for i := range files {
+			files[i].Group = fileCategory(files[i].Name, files[i].IsDir)
+		}
+	}
+	if !o.group && !o.desc && (o.by == "" || o.by == "name") {
+		return
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if o.group && a.Group != b.Group {
+			return groupOrder[a.Group] < groupOrder[b.Group]
+		}
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if o.desc {
+			a, b = b, a
+		}
+		switch o.by {
+		case "ext":
+			return strings.ToLower(path.Ext(a.Name)) < strings.ToLower(path.Ext(b.Name))
+		case "size":
+			return a.Size < b.Size
+		case "mtime":
+			return a.ModTime.Before(b.ModTime)
+		case "exifdate":
+			// Files without a capture time go by modification time
+			return captureTime(a).Before(captureTime(b))
+		}
+		return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+	})
+}
+
+func captureTime(f FileInfo) time.Time {
+	if !f.Captured.IsZero() {
+		return f.Captured
+	}
+	return f.ModTime
+}
+
+// groupCounts counts the entries of each group in a grouped listing, so a
+// page can say how large a section spanning several pages is.
+func groupCounts(files []FileInfo) map[string]int {
+	counts := map[string]int{}
+	for _, f := range files {
+		if f.Group != "" {
+			counts[f.Group]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,201 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = [...]string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+var slogLevels = [...]slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+func (l logLevel) slogLevel() slog.Level {
+	return slogLevels[l]
+}
+
+// Log areas tag each log site so one area can be made verbose on its own.
+const (
+	areaServer  = "server"
+	areaRequest = "request"
+	areaListing = "listing"
+	areaMount   = "mount"
+	areaAuth    = "auth"
+	areaIO      = "io"
+)
+
+var logAreas = []string{areaServer, areaRequest, areaListing, areaMount, areaAuth, areaIO}
+
+var (
+	logger      = slog.New(stdLogHandler{}) // replaced by Config.Logger
+	minLogLevel = levelInfo
+	debugAreas  = map[string]bool{}
+)
+
+func parseLogLevel(s string) (logLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) || (strings.EqualFold(s, "warning") && logLevel(i) == levelWarn) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", s)
+}
+
+// LogAreas lists the areas accepted by ConfigureLogging.
+func LogAreas() []string {
+	return append([]string(nil), logAreas...)
+}
+
+// ConfigureLogging sets the global minimum level and the areas that log at
+// debug regardless of it.
+func ConfigureLogging(level string, areas []string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	enabled := make(map[string]bool, len(areas))
+	for _, area := range areas {
+		area = strings.ToLower(area)
+		known := false
+		for _, a := range logAreas {
+			known = known || a == area
+		}
+		if !known {
+			return fmt.Errorf("unknown log area %q (want one of %s)", area, strings.Join(logAreas, ", "))
+		}
+		enabled[area] = true
+	}
+	minLogLevel = lvl
+	debugAreas = enabled
+	return nil
+}
+
+func logEnabled(level logLevel, area string) bool {
+	return level >= minLogLevel || debugAreas[area]
+}
+
+func logf(level logLevel, area string, format string, args ...any) {
+	if !logEnabled(level, area) {
+		return
+	}
+	logger.Log(context.Background(), level.slogLevel(), fmt.Sprintf(format, args...), "area", area)
+}
+
+func debugf(area string, format string, args ...any) { logf(levelDebug, area, format, args...) }
+func infof(area string, format string, args ...any)  { logf(levelInfo, area, format, args...) }
+func warnf(area string, format string, args ...any)  { logf(levelWarn, area, format, args...) }
+func errorf(area string, format string, args ...any) { logf(levelError, area, format, args...) }
+
+// reqLogf logs through the request's logger, which carries the request ID,
+// client and root.
+func reqLogf(r *http.Request, level logLevel, area string, format string, args ...any) {
+	ctxLogf(r.Context(), level, area, format, args...)
+}
+
+// ctxLogf is reqLogf for code that only has the request's context.
+func ctxLogf(ctx context.Context, level logLevel, area string, format string, args ...any) {
+	if !logEnabled(level, area) {
+		return
+	}
+	LoggerFromContext(ctx).Log(ctx, level.slogLevel(), fmt.Sprintf(format, args...), "area", area)
+}
+
+// LoggerFromContext returns the logger of the request being handled, for
+// use by middleware and hooks, or the server logger outside a request.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if info, ok := ctx.Value(requestInfoKey).(*requestInfo); ok && info.logger != nil {
+		return info.logger
+	}
+	return logger
+}
+
+// stdLogHandler renders records in the classic "LEVEL [area] message" form
+// through the standard log package, so log.SetOutput (-log-file) and the
+// timestamp flags still apply. Attributes follow in parentheses.
+type stdLogHandler struct {
+	attrs  []string
+	prefix string // from WithGroup
+}
+
+func (h stdLogHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h stdLogHandler) Handle(_ context.Context, rec slog.Record) error {
+	area := ""
+	attrs := append([]string(nil), h.attrs...)
+	rec.Attrs(func(a slog.Attr) bool {
+		if a.Key == "area" && h.prefix == "" {
+			area = a.Value.String()
+		} else {
+			attrs = append(attrs, formatAttr(h.prefix, a))
+		}
+		return true
+	})
+
+	var b strings.Builder
+	b.WriteString(rec.Level.String())
+	if area != "" {
+		b.WriteString(" [" + area + "]")
+	}
+	b.WriteString(" " + rec.Message)
+	if len(attrs) > 0 {
+		b.WriteString(" (" + strings.Join(attrs, " ") + ")")
+	}
+	return log.Output(2, b.String())
+}
+
+func (h stdLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	formatted := append([]string(nil), h.attrs...)
+	for _, a := range attrs {
+		formatted = append(formatted, formatAttr(h.prefix, a))
+	}
+	return stdLogHandler{attrs: formatted, prefix: h.prefix}
+}
+
+func (h stdLogHandler) WithGroup(name string) slog.Handler {
+	return stdLogHandler{attrs: h.attrs, prefix: h.prefix + name + "."}
+}
+
+func formatAttr(prefix string, a slog.Attr) string {
+	v := a.Value.Resolve().String()
+	if v == "" || strings.ContainsAny(v, " \"=") {
+		v = strconv.Quote(v)
+	}
+	return prefix + a.Key + "=" + v
+}
+
+// httpError replies to the client through writeError and logs the failure
+// at warn (4xx) or error (5xx) with the request ID. detail is for the log
+// only.
+func httpError(w http.ResponseWriter, r *http.Request, area string, status int, message, detail string) {
+	logHTTPError(r, area, status, message, detail)
+	writeError(w, r, status, message)
+}
+
+func logHTTPError(r *http.Request, area string, status int, message, detail string) {
+	level := levelWarn
+	if status >= 500 {
+		level = levelError
+	}
+	if detail != "" {
+		reqLogf(r, level, area, "%d %s for %s: %s", status, message, r.URL.RequestURI(), detail)
+	} else {
+		reqLogf(r, level, area, "%d %s for %s", status, message, r.URL.RequestURI())
+	}
+}
@@ -0,0 +1,89 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Allow values. The file tree takes PUT with -uploads; OPTIONS and 405s
+// pick them up from here.
+const (
+	readMethods   = "GET, HEAD, OPTIONS"
+	uploadMethods = "GET, HEAD, OPTIONS, PUT"
+	loginMethods  = "GET, POST, OPTIONS"
+	unlockMethods = "POST, OPTIONS"
+)
+
+// treeMethods is the Allow value for paths in the file tree.
+func (s *Server) treeMethods() string {
+	if s.config.Uploads {
+		return uploadMethods
+	}
+	return readMethods
+}
+
+// allowedMethods is the Allow value for an endpoint of the mux, by the
+// pattern it matched, given the tree's.
+func allowedMethods(pattern, tree string) string {
+	switch pattern {
+	case loginPath:
+		return loginMethods
+	case unlockPath:
+		return unlockMethods
+	case "/":
+		return tree
+	}
+	return readMethods
+}
+
+// answerOptions answers OPTIONS with 204 and the Allow value of the
+// endpoint the mux would route to, and "OPTIONS *" with what the server
+// supports anywhere (net/http's own reply has no Allow).
+func answerOptions(mux *http.ServeMux, tree string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var allow string
+		if r.RequestURI == "*" {
+			allow = serverMethods(mux, tree)
+		} else if _, err := requestPathFrom(r.URL); err != nil {
+			next.ServeHTTP(w, r) // refused with 400
+			return
+		} else {
+			_, pattern := mux.Handler(r)
+			allow = allowedMethods(pattern, tree)
+		}
+		w.Header().Set("Allow", allow)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// serverMethods merges the Allow values of every endpoint registered.
+func serverMethods(mux *http.ServeMux, tree string) string {
+	patterns := []string{"/"}
+	for _, p := range []string{loginPath, unlockPath} {
+		if _, pattern := mux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: p}}); pattern == p {
+			patterns = append(patterns, p)
+		}
+	}
+	var methods []string
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		for _, m := range strings.Split(allowedMethods(p, tree), ", ") {
+			if !seen[m] {
+				seen[m] = true
+				methods = append(methods, m)
+			}
+		}
+	}
+	return strings.Join(methods, ", ")
+}
+
+// methodNotAllowed answers a method the endpoint does not support.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allow string) {
+	w.Header().Set("Allow", allow)
+	httpError(w, r, areaRequest, http.StatusMethodNotAllowed, "Method not allowed", r.Method)
+}
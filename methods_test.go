@@ -0,0 +1,43 @@
+package fileserver
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAllow(t *testing.T) {
+	login := func(user, password string) bool { return false }
+	tests := []struct {
+		name   string
+		cfg    Config
+		target string
+		want   string
+	}{
+		{"read-only tree", Config{}, "/file.txt", readMethods},
+		{"read-only dir", Config{}, "/", readMethods},
+		{"read-only API", Config{}, apiV1Prefix + "list", readMethods},
+		{"read-only server", Config{}, "*", readMethods},
+		{"uploads tree", Config{Uploads: true}, "/file.txt", uploadMethods},
+		{"uploads API", Config{Uploads: true}, apiV1Prefix + "list", readMethods},
+		{"uploads server", Config{Uploads: true}, "*", uploadMethods},
+		{"unlock form", Config{DirPasswords: true}, unlockPath, unlockMethods},
+		{"unlock server", Config{DirPasswords: true}, "*", "GET, HEAD, OPTIONS, POST"},
+		{"login form", Config{Login: login}, loginPath, loginMethods},
+	}
+	for _, tt := range tests {
+		_, h := newTestServer(t, tt.cfg)
+		w := serve(h, http.MethodOptions, tt.target, nil)
+		if w.Code != http.StatusNoContent || w.Header().Get("Allow") != tt.want {
+			t.Errorf("%s: OPTIONS %s: status %d, Allow %q, want %q", tt.name, tt.target, w.Code, w.Header().Get("Allow"), tt.want)
+		}
+	}
+
+	// 405s name the same methods
+	for _, uploads := range []bool{false, true} {
+		s, h := newTestServer(t, Config{Uploads: uploads})
+		w := serve(h, http.MethodDelete, "/file.txt", nil)
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != s.treeMethods() {
+			t.Errorf("DELETE with uploads %v: status %d, Allow %q, want %q", uploads, w.Code, w.Header().Get("Allow"), s.treeMethods())
+		}
+	}
+}
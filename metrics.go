@@ -0,0 +1,159 @@
+package fileserver
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// metric is exported in the Prometheus text format. Values are read at
+// scrape time from the same counters the rest of the server uses.
+type metric struct {
+	name  string
+	help  string
+	kind  string // "counter" or "gauge"
+	value func() float64
+}
+
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func (m *metricsRegistry) register(kind, name, help string, value func() float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = append(m.metrics, metric{name: name, help: help, kind: kind, value: value})
+}
+
+func (m *metricsRegistry) counter(name, help string, value func() float64) {
+	m.register("counter", name, help, value)
+}
+
+func (m *metricsRegistry) gauge(name, help string, value func() float64) {
+	m.register("gauge", name, help, value)
+}
+
+// serveVars is /debug/vars: the standard expvar variables (memstats,
+// cmdline and any a library user published) plus "fileserver", every
+// registered metric by name with its current value.
+func (m *metricsRegistry) serveVars(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	metrics := append([]metric(nil), m.metrics...)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	fmt.Fprintf(w, "%q: {", "fileserver")
+	for i, mt := range metrics {
+		if i > 0 {
+			fmt.Fprintf(w, ",")
+		}
+		fmt.Fprintf(w, "\n%q: %s", mt.name, strconv.FormatFloat(mt.value(), 'g', -1, 64))
+	}
+	fmt.Fprintf(w, "\n}\n}\n")
+}
+
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	metrics := append([]metric(nil), m.metrics...)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	for _, mt := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", mt.name, mt.help, mt.name, mt.kind, mt.name, mt.value())
+	}
+}
+
+func (s *Server) registerMetrics() {
+	m := s.metrics
+	m.counter("fileserver_requests_total", "Requests received on the public listeners.",
+		func() float64 { return float64(s.requests.Load()) })
+	m.counter("fileserver_bytes_served_total", "Bytes written in file and archive bodies.",
+		func() float64 { return float64(s.transfers.bytesServed.Load()) })
+	m.gauge("fileserver_active_transfers", "File and archive bodies currently being written.",
+		func() float64 { return float64(s.transfers.count()) })
+	m.gauge("fileserver_active_connections", "Open client connections.",
+		func() float64 { return float64(s.activeConns.Load()) })
+	m.gauge("fileserver_dir_reads_outstanding", "Background directory reads still running, including abandoned ones.",
+		func() float64 { return float64(s.dirReader.outstanding()) })
+	m.gauge("fileserver_storage_available", "1 while the mount monitor finds the storage available, 0 while requests get 503.",
+		func() float64 {
+			if s.mount.unavailable() != nil {
+				return 0
+			}
+			return 1
+		})
+	m.counter("fileserver_storage_state_changes_total", "Times the storage went unavailable or recovered.",
+		func() float64 { _, _, n := s.mount.state(); return float64(n) })
+	m.gauge("fileserver_fs_calls_outstanding", "Stat and open calls still running, including abandoned ones.",
+		func() float64 { return float64(s.fsCalls.running.Load()) })
+	m.counter("fileserver_fs_calls_timed_out_total", "Stat and open calls a request gave up on at the deadline.",
+		func() float64 { return float64(s.fsCalls.timedOut.Load()) })
+	m.counter("fileserver_fs_calls_rejected_total", "Requests refused because too many stat and open calls were outstanding.",
+		func() float64 { return float64(s.fsCalls.rejected.Load()) })
+	m.counter("fileserver_io_retries_total", "Stat, open and directory read attempts repeated after a transient error (EIO, ESTALE, EAGAIN, EINTR).",
+		func() float64 { return float64(s.ioRetries.retries.Load()) })
+	m.counter("fileserver_io_retries_recovered_total", "Operations that succeeded on a retry.",
+		func() float64 { return float64(s.ioRetries.recovered.Load()) })
+	m.counter("fileserver_io_retries_exhausted_total", "Operations still failing with a transient error after the last retry.",
+		func() float64 { return float64(s.ioRetries.exhausted.Load()) })
+	if s.connLimit != nil {
+		m.gauge("fileserver_connections_max", "Connection limit set by -max-conns.",
+			func() float64 { return float64(s.connLimit.max) })
+		m.gauge("fileserver_connections_limited", "Connections holding a slot under -max-conns.",
+			func() float64 { return float64(s.connLimit.open()) })
+		m.counter("fileserver_connection_limit_waits_total", "Accepts that had to wait because the connection limit was reached.",
+			func() float64 { return float64(s.connLimit.waits.Load()) })
+	}
+	if s.perIP != nil {
+		m.gauge("fileserver_per_ip_clients", "Client addresses with requests in flight under -max-conns-per-ip.",
+			func() float64 { return float64(s.perIP.clients()) })
+		m.counter("fileserver_per_ip_rejected_total", "Requests answered 429 because their client was at -max-conns-per-ip.",
+			func() float64 { return float64(s.perIP.rejected.Load()) })
+	}
+	if s.fds.min > 0 {
+		m.gauge("fileserver_fds_open", "Open file descriptors, sampled every second.",
+			func() float64 { return float64(s.fds.open.Load()) })
+		m.gauge("fileserver_fds_limit", "RLIMIT_NOFILE soft limit.",
+			func() float64 { return float64(s.fds.limit.Load()) })
+		m.gauge("fileserver_fds_headroom", "Free file descriptors; below -min-free-fds new downloads get 503.",
+			func() float64 { return float64(s.fds.headroom()) })
+		m.counter("fileserver_fd_shed_total", "Downloads refused because file descriptors were low.",
+			func() float64 { return float64(s.fds.shed.Load()) })
+	}
+	m.counter("fileserver_dir_reads_rejected_total", "Listings refused because too many directory reads were outstanding.",
+		func() float64 { return float64(s.dirReader.rejected.Load()) })
+	m.counter("fileserver_dir_reads_total", "Directory reads run for listings and walks.",
+		func() float64 { return float64(s.dirReader.started.Load()) })
+	m.counter("fileserver_dir_reads_shared_total", "Listings answered by joining a read of the same directory already running.",
+		func() float64 { return float64(s.dirReader.shared.Load()) })
+	m.gauge("fileserver_goroutines", "Goroutines in the process.",
+		func() float64 { return float64(runtime.NumGoroutine()) })
+
+	if c := s.uploadCmd; c != nil {
+		m.counter("fileserver_upload_cmd_runs_total", "Runs of -on-upload-cmd.",
+			func() float64 { return float64(c.runs.Load()) })
+		m.counter("fileserver_upload_cmd_failures_total", "Runs of -on-upload-cmd that failed or timed out.",
+			func() float64 { return float64(c.failures.Load()) })
+	}
+
+	if c := s.cache; c != nil {
+		m.counter("fileserver_cache_hits_total", "File requests served from the memory cache.",
+			func() float64 { return float64(c.hits.Load()) })
+		m.counter("fileserver_cache_misses_total", "Cacheable file requests read from storage.",
+			func() float64 { return float64(c.misses.Load()) })
+		m.gauge("fileserver_cache_bytes", "Bytes held in the memory cache.",
+			func() float64 { _, n := c.usage(); return float64(n) })
+		m.gauge("fileserver_cache_entries", "Files held in the memory cache.",
+			func() float64 { n, _ := c.usage(); return float64(n) })
+	}
+}
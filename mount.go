@@ -0,0 +1,84 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMountCheckInterval = 5 * time.Second
+	// A probe taking longer than this counts as a failure, so a hung mount
+	// is noticed without waiting on it
+	mountProbeTimeout = 5 * time.Second
+)
+
+// mountMonitor holds the storage state found by the background probe.
+// Requests read it instead of touching storage themselves.
+type mountMonitor struct {
+	mu          sync.RWMutex
+	err         error // nil while available
+	since       time.Time
+	transitions int64
+}
+
+// unavailable returns the probe error while storage is unavailable.
+func (m *mountMonitor) unavailable() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err
+}
+
+func (m *mountMonitor) state() (err error, since time.Time, transitions int64) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.err, m.since, m.transitions
+}
+
+// update records a probe result, logging only changes of state.
+func (m *mountMonitor) update(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if (err == nil) == (m.err == nil) {
+		if err != nil {
+			m.err = err
+		}
+		return
+	}
+	if err != nil {
+		warnf(areaMount, "Storage unavailable, answering 503 until it recovers: %v", err)
+	} else {
+		infof(areaMount, "Storage available again after %s", time.Since(m.since).Round(time.Second))
+	}
+	m.err, m.since = err, time.Now()
+	m.transitions++
+}
+
+// runMountMonitor probes storage every interval until ctx ends.
+func (s *Server) runMountMonitor(ctx context.Context) {
+	interval := s.config.MountCheckInterval
+	if interval <= 0 {
+		interval = DefaultMountCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		probeCtx, cancel := context.WithTimeout(ctx, mountProbeTimeout)
+		err := s.checkMountHealth(probeCtx)
+		if errors.Is(probeCtx.Err(), context.DeadlineExceeded) {
+			err = errors.New("mount point unhealthy: probe timed out after " + mountProbeTimeout.String())
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		s.mount.update(err)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
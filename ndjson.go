@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ndjsonFlushEvery is how many lines go out between flushes.
+const ndjsonFlushEvery = 500
+
+// ndjsonSummary is the last line of an NDJSON listing; a listing without
+// one ended early.
+type ndjsonSummary struct {
+	Summary struct {
+		Entries   int  `json:"entries"`
+		Truncated bool `json:"truncated"` // recursive walk cut short
+	} `json:"summary"`
+}
+
+// writeNDJSONListing answers ?format=ndjson: one API entry per line, in
+// listing order or, with recursive=1, as the walk reaches them, then a
+// summary line. Like CSV exports it streams instead of building the whole
+// response, and it stops writing once the client goes away.
+func (s *Server) writeNDJSONListing(ctx context.Context, w http.ResponseWriter, r *http.Request, fullPath, requestPath string, top []FileInfo, recursive, namesOnly bool, filter listingFilter, fields map[string]bool) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	s.emitTiming(w, r)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	var summary ndjsonSummary
+	var writeErr error
+	write := func(f FileInfo) {
+		if writeErr != nil || ctx.Err() != nil {
+			return
+		}
+		if !filter.matchName(f.Name) || (!f.IsDir && filter.types != nil && !filter.types[fileCategory(f.Name, false)]) {
+			return
+		}
+		if writeErr = enc.Encode(listingEntry(requestPath, f, fields)); writeErr != nil {
+			return
+		}
+		if summary.Summary.Entries++; summary.Summary.Entries%ndjsonFlushEvery == 0 {
+			rc.Flush()
+		}
+	}
+
+	if recursive {
+		summary.Summary.Truncated = s.walkRecursive(ctx, r, fullPath, requestPath, top, namesOnly, write)
+	} else {
+		for _, f := range top {
+			write(f)
+		}
+	}
+	if writeErr != nil || ctx.Err() != nil {
+		reqLogf(r, levelDebug, areaListing, "Stopped NDJSON listing of %s after %d entries: %v", requestPath, summary.Summary.Entries, context.Cause(ctx))
+		return
+	}
+	enc.Encode(summary)
+}
@@ -0,0 +1,649 @@
+package fileserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcCallbackPath = "/_oidc/callback"
+	oidcStateCookie  = "fileserver_oidc"
+	// How long a browser may take to log in at the provider
+	oidcStateLifetime = 10 * time.Minute
+	// Unknown key IDs refetch the provider's keys at most this often
+	oidcKeysRefresh = time.Minute
+	// Requests still carrying a session renewed this recently get the
+	// same renewed session
+	oidcRenewalReuse = time.Minute
+)
+
+// OIDCConfig configures login through an OpenID Connect provider with the
+// authorization code flow (with PKCE). The login sets the same session
+// cookie as Config.Login. When the provider issues a refresh token, the
+// session lasts as long as the ID token and is then renewed with the
+// refresh token, as long as it is used within the session lifetime;
+// otherwise expired sessions send browsers back to the provider, which
+// usually signs them in again without asking.
+type OIDCConfig struct {
+	// The provider, e.g. https://accounts.example.com; the rest is read
+	// from its /.well-known/openid-configuration on first use
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client
+	// The callback URL registered with the provider; its path must end
+	// in /_oidc/callback
+	RedirectURL string
+	Scopes      []string // default openid, email, profile
+	// ID token claim naming the user in logs and users file rules,
+	// default "email" (refused when email_verified is false)
+	UserClaim string
+	// Claim listing the user's groups, matched by "group:NAME" rules;
+	// default "groups"
+	GroupsClaim string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// oidcProvider reads the provider's configuration and keys lazily, so the
+// server starts while the provider is down.
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery // nil until fetched
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+
+	renewMu  sync.Mutex
+	renewals map[string]*oidcRenewal // by refresh token
+}
+
+func newOIDCProvider(cfg OIDCConfig) (*oidcProvider, error) {
+	issuer, err := url.Parse(cfg.Issuer)
+	if err != nil || issuer.Host == "" || issuer.Scheme != "https" && issuer.Scheme != "http" {
+		return nil, fmt.Errorf("invalid OIDC issuer %q, want an http(s) URL", cfg.Issuer)
+	}
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("OIDC needs a client ID")
+	}
+	redirect, err := url.Parse(cfg.RedirectURL)
+	if err != nil || redirect.Host == "" || !strings.HasSuffix(redirect.Path, oidcCallbackPath) {
+		return nil, fmt.Errorf("invalid OIDC redirect URL %q, want an absolute URL ending in %s", cfg.RedirectURL, oidcCallbackPath)
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	} else if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "email"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	return &oidcProvider{config: cfg, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", u, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// discover returns the provider's configuration, fetching it until that
+// succeeds once.
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("failed to read OIDC discovery document: %v", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, not %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document lacks an authorization, token or JWKS endpoint")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var jwkCurves = map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+		e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+		if err1 != nil || err2 != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("bad RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		curve, ok := jwkCurves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+		y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if err1 != nil || err2 != nil || len(x) != size || len(y) != size {
+			return nil, fmt.Errorf("bad EC key")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// key returns the signing key kid, refetching the provider's keys when it
+// is unknown, as after a key rotation.
+func (p *oidcProvider) key(ctx context.Context, jwksURI, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < oidcKeysRefresh {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to read OIDC signing keys: %v", err)
+	}
+	p.keys = map[string]crypto.PublicKey{}
+	p.keysFetched = time.Now()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			warnf(areaAuth, "Skipping OIDC signing key %q: %v", k.Kid, err)
+			continue
+		}
+		p.keys[k.Kid] = key
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+var jwsHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"PS256": crypto.SHA256, "PS384": crypto.SHA384, "PS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+var jwsCurves = map[string]string{"ES256": "P-256", "ES384": "P-384", "ES512": "P-521"}
+
+// verifyJWS checks sig over input with key for algorithm alg. Only
+// asymmetric algorithms are accepted; "none" and HMAC never are.
+func verifyJWS(alg string, key crypto.PublicKey, input string, sig []byte) error {
+	hash, ok := jwsHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(input))
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		case "PS":
+			return rsa.VerifyPSS(k, hash, digest, sig, nil)
+		}
+	case *ecdsa.PublicKey:
+		if jwsCurves[alg] != k.Curve.Params().Name {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("bad signature length")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("algorithm %s does not match the signing key", alg)
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce, and returns its claims. Tokens from a refresh carry no nonce
+// to check, and nonce is empty for them.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, raw, nonce string) (map[string]any, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return nil, errors.New("malformed ID token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed ID token signature")
+	}
+	key, err := p.key(ctx, d.JWKSURI, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWS(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, fmt.Errorf("ID token signature: %v", err)
+	}
+	var claims map[string]any
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return nil, errors.New("malformed ID token claims")
+	}
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("ID token issuer %q is not %q", iss, d.Issuer)
+	}
+	var audience []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = []string{aud}
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audience = append(audience, s)
+			}
+		}
+	}
+	if !slices.Contains(audience, p.config.ClientID) {
+		return nil, fmt.Errorf("ID token is for %v, not this client", audience)
+	}
+	// Allow a minute of clock skew
+	if exp, _ := claims["exp"].(float64); time.Now().After(time.Unix(int64(exp), 0).Add(time.Minute)) {
+		return nil, errors.New("ID token expired")
+	}
+	if got, _ := claims["nonce"].(string); nonce != "" && subtle.ConstantTimeCompare([]byte(got), []byte(nonce)) != 1 {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// oidcTokens is a token endpoint response.
+type oidcTokens struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// exchange trades an authorization code for the ID token and, if the
+// provider issues one, a refresh token.
+func (p *oidcProvider) exchange(ctx context.Context, d *oidcDiscovery, code, verifier string) (oidcTokens, error) {
+	tokens, err := p.token(ctx, d, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+	})
+	if err == nil && tokens.IDToken == "" {
+		err = errors.New("token endpoint returned no ID token")
+	}
+	return tokens, err
+}
+
+// refresh trades a refresh token for new tokens. The ID token is optional
+// here, and the refresh token is only replaced by providers that rotate
+// them.
+func (p *oidcProvider) refresh(ctx context.Context, d *oidcDiscovery, refreshToken string) (oidcTokens, error) {
+	return p.token(ctx, d, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+func (p *oidcProvider) token(ctx context.Context, d *oidcDiscovery, form url.Values) (oidcTokens, error) {
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcTokens{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return oidcTokens{}, fmt.Errorf("failed to reach token endpoint: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		oidcTokens
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		return oidcTokens{}, fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	return body.oidcTokens, nil
+}
+
+// idTokenExpiry is when verified ID token claims expire.
+func idTokenExpiry(claims map[string]any) time.Time {
+	exp, _ := claims["exp"].(float64)
+	return time.Unix(int64(exp), 0)
+}
+
+// session maps ID token claims onto a session.
+func (p *oidcProvider) session(claims map[string]any) (sessionClaims, error) {
+	user, _ := claims[p.config.UserClaim].(string)
+	if user == "" {
+		return sessionClaims{}, fmt.Errorf("ID token has no %s claim", p.config.UserClaim)
+	}
+	if verified, ok := claims["email_verified"].(bool); p.config.UserClaim == "email" && ok && !verified {
+		return sessionClaims{}, fmt.Errorf("email %s is not verified", user)
+	}
+	session := sessionClaims{User: user, External: true}
+	switch groups := claims[p.config.GroupsClaim].(type) {
+	case string:
+		session.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				session.Groups = append(session.Groups, s)
+			}
+		}
+	}
+	return session, nil
+}
+
+// oidcState is what the callback needs to finish a login, kept in a
+// signed cookie for oidcStateLifetime.
+type oidcState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Next     string `json:"next"`
+	Expires  int64  `json:"exp"`
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (s *Server) setOIDCStateCookie(w http.ResponseWriter, r *http.Request, value string) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     basePath(r) + oidcCallbackPath,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		// Lax is sent on the provider's top-level redirect back
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcStateLifetime / time.Second),
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// startOIDC sends a browser to the provider to log in, to come back to
+// next.
+func (s *Server) startOIDC(w http.ResponseWriter, r *http.Request, next string) {
+	d, err := s.oidc.discover(r.Context())
+	if err != nil {
+		httpError(w, r, areaAuth, http.StatusBadGateway, "Login provider unavailable", err.Error())
+		return
+	}
+	st := oidcState{
+		State:    randomString(),
+		Nonce:    randomString(),
+		Verifier: randomString() + randomString(),
+		Next:     next,
+		Expires:  time.Now().Add(oidcStateLifetime).Unix(),
+	}
+	s.setOIDCStateCookie(w, r, s.sessions.seal(st))
+	challenge := crypto.SHA256.New()
+	challenge.Write([]byte(st.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.oidc.config.ClientID},
+		"redirect_uri":          {s.oidc.config.RedirectURL},
+		"scope":                 {strings.Join(s.oidc.config.Scopes, " ")},
+		"state":                 {st.State},
+		"nonce":                 {st.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge.Sum(nil))},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, d.AuthorizationEndpoint+sep+q.Encode(), http.StatusSeeOther)
+}
+
+// handleOIDCCallback finishes a login the provider redirected back: the
+// state must match the cookie startOIDC set, the code is exchanged for an
+// ID token, and its claims become the session.
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var st oidcState
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || !s.sessions.open(cookie.Value, &st) || time.Now().Unix() >= st.Expires {
+		httpError(w, r, areaAuth, http.StatusBadRequest, "Login expired, please try again", "missing or expired OIDC state cookie")
+		return
+	}
+	s.setOIDCStateCookie(w, r, "")
+	if subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(st.State)) != 1 {
+		httpError(w, r, areaAuth, http.StatusBadRequest, "Login failed", "OIDC state does not match")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		httpError(w, r, areaAuth, http.StatusUnauthorized, "Login failed", "OIDC provider: "+e+" "+q.Get("error_description"))
+		return
+	}
+	d, err := s.oidc.discover(r.Context())
+	if err != nil {
+		httpError(w, r, areaAuth, http.StatusBadGateway, "Login provider unavailable", err.Error())
+		return
+	}
+	tokens, err := s.oidc.exchange(r.Context(), d, q.Get("code"), st.Verifier)
+	if err != nil {
+		httpError(w, r, areaAuth, http.StatusBadGateway, "Login provider error", err.Error())
+		return
+	}
+	claims, err := s.oidc.verifyIDToken(r.Context(), d, tokens.IDToken, st.Nonce)
+	if err != nil {
+		httpError(w, r, areaAuth, http.StatusUnauthorized, "Login failed", err.Error())
+		return
+	}
+	session, err := s.oidc.session(claims)
+	if err != nil {
+		httpError(w, r, areaAuth, http.StatusUnauthorized, "Login failed", err.Error())
+		return
+	}
+	value, expires := s.sessions.signClaims(session)
+	if tokens.RefreshToken != "" {
+		value, expires = s.sessions.signRenewable(session, idTokenExpiry(claims), tokens.RefreshToken)
+	}
+	s.setSessionCookie(w, r, value, expires)
+	getRequestInfo(r).identity = session.User
+	reqLogf(r, levelInfo, areaAuth, "OIDC login of %s (groups %v)", session.User, session.Groups)
+	http.Redirect(w, r, loginRedirectTarget(r, st.Next), http.StatusSeeOther)
+}
+
+// renewOIDCSession renews an expired OIDC session with its refresh token,
+// setting the new cookie on the response and on r for the handlers after
+// it. A refresh the provider refuses leaves the session expired, so the
+// browser logs in again.
+func (s *Server) renewOIDCSession(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return
+	}
+	claims, refreshToken, ok := s.sessions.renewable(cookie.Value)
+	if !ok {
+		return
+	}
+	renewal := s.oidc.renewal(refreshToken, func() (string, time.Time, error) {
+		return s.renewOIDC(context.WithoutCancel(r.Context()), claims, refreshToken)
+	})
+	if renewal.err != nil {
+		reqLogf(r, levelInfo, areaAuth, "Failed to refresh OIDC session of %s: %v", claims.User, renewal.err)
+		return
+	}
+	s.setSessionCookie(w, r, renewal.value, renewal.expires)
+	replaceCookie(r, sessionCookie, renewal.value)
+}
+
+func (s *Server) renewOIDC(ctx context.Context, claims sessionClaims, refreshToken string) (string, time.Time, error) {
+	d, err := s.oidc.discover(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	tokens, err := s.oidc.refresh(ctx, d, refreshToken)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	session := sessionClaims{User: claims.User, Groups: claims.Groups, External: true}
+	// Without a new ID token the access token's lifetime bounds the session
+	expires := time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	if tokens.ExpiresIn <= 0 {
+		expires = time.Now().Add(s.sessions.lifetime)
+	}
+	if tokens.IDToken != "" {
+		idClaims, err := s.oidc.verifyIDToken(ctx, d, tokens.IDToken, "")
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if session, err = s.oidc.session(idClaims); err != nil {
+			return "", time.Time{}, err
+		}
+		if session.User != claims.User {
+			return "", time.Time{}, fmt.Errorf("refreshed ID token is for %s", session.User)
+		}
+		expires = idTokenExpiry(idClaims)
+	}
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = refreshToken
+	}
+	value, cookieExpires := s.sessions.signRenewable(session, expires, tokens.RefreshToken)
+	return value, cookieExpires, nil
+}
+
+// oidcRenewal is a session renewal, shared by the requests arriving with
+// the same expired session meanwhile, as a provider rotating refresh
+// tokens accepts each only once.
+type oidcRenewal struct {
+	done    chan struct{}
+	value   string
+	expires time.Time
+	err     error
+}
+
+// renewal runs renew for refreshToken, or waits for the renewal already
+// running, or reuses one that succeeded within oidcRenewalReuse.
+func (p *oidcProvider) renewal(refreshToken string, renew func() (string, time.Time, error)) *oidcRenewal {
+	p.renewMu.Lock()
+	if rn, ok := p.renewals[refreshToken]; ok {
+		p.renewMu.Unlock()
+		<-rn.done
+		return rn
+	}
+	rn := &oidcRenewal{done: make(chan struct{})}
+	if p.renewals == nil {
+		p.renewals = map[string]*oidcRenewal{}
+	}
+	p.renewals[refreshToken] = rn
+	p.renewMu.Unlock()
+
+	rn.value, rn.expires, rn.err = renew()
+	close(rn.done)
+	forget := func() {
+		p.renewMu.Lock()
+		delete(p.renewals, refreshToken)
+		p.renewMu.Unlock()
+	}
+	// A failure may be the provider being down: the next request retries
+	if rn.err != nil {
+		forget()
+	} else {
+		time.AfterFunc(oidcRenewalReuse, forget)
+	}
+	return rn
+}
+
+// oidcLogoutURL is the provider's end-session endpoint, when it has one,
+// so logging out does not bounce straight back in through the provider.
+func (s *Server) oidcLogoutURL(ctx context.Context) string {
+	d, err := s.oidc.discover(ctx)
+	if err != nil || d.EndSessionEndpoint == "" {
+		return ""
+	}
+	sep := "?"
+	if strings.Contains(d.EndSessionEndpoint, "?") {
+		sep = "&"
+	}
+	return d.EndSessionEndpoint + sep + url.Values{"client_id": {s.oidc.config.ClientID}}.Encode()
+}
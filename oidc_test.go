@@ -0,0 +1,197 @@
+package fileserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubProvider is an OpenID Connect provider answering refresh token
+// grants: each refresh token is accepted once, for a new one and an ID
+// token naming user.
+type stubProvider struct {
+	*httptest.Server
+	key       *ecdsa.PrivateKey
+	user      atomic.Value // string
+	refreshes atomic.Int64
+
+	mu     sync.Mutex
+	issued map[string]bool // refresh tokens not used yet
+}
+
+func newStubProvider(t *testing.T) *stubProvider {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubProvider{key: key, issued: map[string]bool{"rt-1": true}}
+	p.user.Store("alice@example.com")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub, _ := key.PublicKey.Bytes()
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{{
+			Kty: "EC", Kid: "k1", Crv: "P-256",
+			X: base64.RawURLEncoding.EncodeToString(pub[1:33]),
+			Y: base64.RawURLEncoding.EncodeToString(pub[33:]),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		n := p.refreshes.Add(1)
+		token := r.PostFormValue("refresh_token")
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if r.PostFormValue("grant_type") != "refresh_token" || !p.issued[token] {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		delete(p.issued, token)
+		next := fmt.Sprintf("rt-%d", n+1)
+		p.issued[next] = true
+		json.NewEncoder(w).Encode(oidcTokens{IDToken: p.idToken(t), RefreshToken: next, ExpiresIn: 300})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *stubProvider) idToken(t *testing.T) string {
+	enc := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	input := enc(map[string]string{"alg": "ES256", "kid": "k1"}) + "." + enc(map[string]any{
+		"iss": p.URL, "aud": "files", "email": p.user.Load(), "groups": []string{"staff"},
+		"exp": time.Now().Add(5 * time.Minute).Unix(),
+	})
+	digest := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		t.Error(err)
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// expiredSession is a session cookie whose ID token expired but whose
+// refresh token rt-1 may still renew it.
+func expiredSession(s *Server) *http.Cookie {
+	claims := sessionClaims{User: "alice@example.com", External: true}
+	value, _ := s.sessions.signRenewable(claims, time.Now().Add(-time.Minute), "rt-1")
+	return &http.Cookie{Name: sessionCookie, Value: value}
+}
+
+func newOIDCTestServer(t *testing.T, p *stubProvider) (*Server, http.Handler) {
+	s, h := newTestServer(t, Config{OIDC: OIDCConfig{
+		Issuer: p.URL, ClientID: "files", RedirectURL: "https://files.example/_oidc/callback",
+	}})
+	writeFiles(t, s.rootDir, map[string]string{"file.txt": "data"})
+	return s, h
+}
+
+func getWithCookie(h http.Handler, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestOIDCSessionRenewal(t *testing.T) {
+	p := newStubProvider(t)
+	s, h := newOIDCTestServer(t, p)
+	old := expiredSession(s)
+	payload, _, _ := strings.Cut(old.Value, ".")
+	if data, _ := base64.RawURLEncoding.DecodeString(payload); strings.Contains(string(data), "rt-1") {
+		t.Errorf("session cookie shows the refresh token: %s", data)
+	}
+
+	w := getWithCookie(h, "/file.txt", old)
+	if w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Fatalf("GET with an expired, renewable session: status %d, body %s", w.Code, w.Body)
+	}
+	var renewed *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			renewed = c
+		}
+	}
+	if renewed == nil {
+		t.Fatal("no renewed session cookie")
+	}
+	claims, ok := s.sessions.verifyClaims(renewed.Value)
+	if !ok || claims.User != "alice@example.com" || len(claims.Groups) != 1 || !claims.External {
+		t.Errorf("renewed session = %+v, %v", claims, ok)
+	}
+	if refresh, ok := s.sessions.decrypt(claims.Refresh); !ok || refresh != "rt-2" {
+		t.Errorf("renewed session holds refresh token %q, want the rotated rt-2", refresh)
+	}
+	if renewed.Expires.Before(time.Now().Add(DefaultSessionLifetime - time.Minute)) {
+		t.Errorf("renewed cookie expires %v, want a session lifetime from now", renewed.Expires)
+	}
+
+	// Requests still carrying the old cookie share the renewal rather than
+	// reusing a refresh token the provider already rotated
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Go(func() {
+			if w := getWithCookie(h, "/file.txt", old); w.Code != http.StatusOK {
+				t.Errorf("GET with the old session after renewal: status %d", w.Code)
+			}
+		})
+	}
+	wg.Wait()
+	if n := p.refreshes.Load(); n != 1 {
+		t.Errorf("%d refreshes, want 1", n)
+	}
+	if w := getWithCookie(h, "/file.txt", renewed); w.Code != http.StatusOK || len(w.Result().Cookies()) != 0 {
+		t.Errorf("GET with the renewed session: status %d, cookies %v", w.Code, w.Result().Cookies())
+	}
+}
+
+func TestOIDCSessionRenewalRefused(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(*stubProvider)
+	}{
+		{"revoked refresh token", func(p *stubProvider) { p.issued = map[string]bool{} }},
+		{"different user", func(p *stubProvider) { p.user.Store("mallory@example.com") }},
+	}
+	for _, tt := range tests {
+		p := newStubProvider(t)
+		tt.setup(p)
+		s, h := newOIDCTestServer(t, p)
+		w := getWithCookie(h, "/file.txt", expiredSession(s))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", tt.name, w.Code)
+		}
+	}
+
+	// Past the session lifetime the refresh token is no longer used
+	p := newStubProvider(t)
+	s, h := newOIDCTestServer(t, p)
+	claims := sessionClaims{User: "alice@example.com", External: true, Expires: time.Now().Add(-time.Hour).Unix(),
+		Refresh: s.sessions.encrypt("rt-1"), RenewUntil: time.Now().Add(-time.Minute).Unix()}
+	if w := getWithCookie(h, "/file.txt", &http.Cookie{Name: sessionCookie, Value: s.sessions.seal(claims)}); w.Code != http.StatusUnauthorized || p.refreshes.Load() != 0 {
+		t.Errorf("session past its lifetime: status %d after %d refreshes", w.Code, p.refreshes.Load())
+	}
+}
@@ -0,0 +1,242 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// apiParam is a query parameter of a documented endpoint.
+type apiParam struct {
+	name        string
+	description string
+	kind        string // JSON schema type
+}
+
+// apiOperation documents one GET endpoint. Response schemas are derived
+// from the Go type the handler encodes, so the spec follows the code.
+type apiOperation struct {
+	path        string
+	summary     string
+	params      []apiParam
+	response    any    // zero value of the JSON response type, or nil
+	contentType string // for non-JSON responses
+	enabled     func(s *Server) bool
+}
+
+var apiOperations = []apiOperation{
+	{
+		path:        "/healthz",
+		summary:     "Storage health from the background probe; 503 with Retry-After while the root is unavailable",
+		contentType: "text/plain",
+	},
+	{
+		path:        "/readyz",
+		summary:     "Readiness: like /healthz, and also 503 while draining for shutdown",
+		contentType: "text/plain",
+	},
+	{
+		path:     "/_status",
+		summary:  "Server state, active connections and transfers",
+		response: statusReport{},
+		enabled:  func(s *Server) bool { return s.config.Status },
+	},
+	{
+		path:     "/_stats/top",
+		summary:  "Most downloaded files (HTML unless JSON is requested; JSON is deprecated in favor of /_api/v1/stats/top)",
+		params:   []apiParam{{"n", "Number of entries (default 20, max 1000)", "integer"}, {"format", "Set to json for JSON output", "string"}},
+		response: []downloadStat{},
+		enabled:  func(s *Server) bool { return s.stats != nil },
+	},
+	{
+		path:        "/{path}",
+		summary:     "SHA-256 of a file in sha256sum format; 503 with Retry-After while a large file is hashed",
+		params:      []apiParam{{"checksum", "Digest algorithm, sha256", "string"}},
+		contentType: "text/plain",
+	},
+	{
+		path:    apiV1Prefix + "list",
+		summary: "Directory entries, directories first then by name, one page at a time",
+		params: []apiParam{
+			{"path", "Directory path (default /)", "string"},
+			{"offset", "Entries to skip (default 0); past the end gives an empty page", "integer"},
+			{"limit", "Page size (default 1000, max 10000)", "integer"},
+			{"fields", "Comma-separated entry fields to return: name, path, type, size, modTime, items, recent", "string"},
+			{"dirsOnly", "Only directories (true/false)", "boolean"},
+			{"filesOnly", "Only files (true/false)", "boolean"},
+			{"type", "Comma-separated file categories to keep: image, video, audio, archive, document, code, text; directories always remain", "string"},
+			{"glob", "Name pattern with path.Match syntax, e.g. *.log", "string"},
+			{"ci", "1 to match glob case-insensitively", "string"},
+			{"recursive", "List every file below path, with depth and entry limits (true/false)", "boolean"},
+		},
+		response: apiResponse[apiListing]{},
+	},
+	{
+		path:     apiV1Prefix + "stat",
+		summary:  "Type, size and modification time of a path",
+		params:   []apiParam{{"path", "File or directory path (default /)", "string"}},
+		response: apiResponse[apiEntry]{},
+	},
+	{
+		path:     apiV1Prefix + "search",
+		summary:  "Case-insensitive name search below a directory; partial when the request times out",
+		params:   []apiParam{{"q", "Substring to match in names", "string"}, {"path", "Directory to search (default /)", "string"}, {"limit", "Maximum results (default 100, max 1000)", "integer"}},
+		response: apiResponse[apiSearchResult]{},
+	},
+	{
+		path:     apiV1Prefix + "tree",
+		summary:  "Nested entries below a path",
+		params:   []apiParam{{"path", "Root of the tree (default /)", "string"}, {"depth", "Levels to descend (default 2, max 10)", "integer"}},
+		response: apiResponse[apiTree]{},
+	},
+	{
+		path:    apiV1Prefix + "preview",
+		summary: "Beginning of a file, cut at a line boundary for text; 415 for binary content unless allowBinary is set",
+		params: []apiParam{
+			{"path", "File path", "string"},
+			{"bytes", "Maximum bytes (default 4096, max 1048576)", "integer"},
+			{"lines", "Maximum lines, within the byte bound (max 10000)", "integer"},
+			{"allowBinary", "Return binary content base64-encoded instead of 415 (true/false)", "boolean"},
+		},
+		response: apiResponse[apiPreview]{},
+	},
+	{
+		path:     apiV1Prefix + "stats/top",
+		summary:  "Most downloaded files",
+		params:   []apiParam{{"n", "Number of entries (default 20, max 1000)", "integer"}},
+		response: apiResponse[[]downloadStat]{},
+		enabled:  func(s *Server) bool { return s.stats != nil },
+	},
+	{
+		path:     "/_admin/bandwidth",
+		summary:  "Bytes served per client (health listener only)",
+		params:   []apiParam{{"days", "Last N days including today (default 30)", "integer"}, {"from", "First day, YYYY-MM-DD", "string"}, {"to", "Last day, YYYY-MM-DD", "string"}},
+		response: bandwidthReport{},
+		enabled:  func(s *Server) bool { return s.bandwidth != nil },
+	},
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// jsonSchema describes t as encoding/json would encode it.
+func jsonSchema(t reflect.Type) map[string]any {
+	return jsonSchemaOf(t, map[reflect.Type]bool{})
+}
+
+// jsonSchemaOf stops at types already being described, so recursive types
+// such as tree nodes end in a plain object.
+func jsonSchemaOf(t reflect.Type, visiting map[reflect.Type]bool) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchemaOf(t.Elem(), visiting)
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": jsonSchemaOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		if visiting[t] {
+			return map[string]any{"type": "object"}
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		properties := map[string]any{}
+		addStructFields(t, properties, visiting)
+		return map[string]any{"type": "object", "properties": properties}
+	}
+	return map[string]any{}
+}
+
+func addStructFields(t reflect.Type, properties map[string]any, visiting map[reflect.Type]bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			addStructFields(field.Type, properties, visiting)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = jsonSchemaOf(field.Type, visiting)
+	}
+}
+
+func (s *Server) openAPIDocument(base string) map[string]any {
+	paths := map[string]any{}
+	for _, op := range apiOperations {
+		if op.enabled != nil && !op.enabled(s) {
+			continue
+		}
+		content := map[string]any{}
+		if op.response != nil {
+			content["application/json"] = map[string]any{"schema": jsonSchema(reflect.TypeOf(op.response))}
+		} else {
+			content[op.contentType] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		var params []any
+		for _, p := range op.params {
+			params = append(params, map[string]any{
+				"name": p.name, "in": "query", "description": p.description,
+				"schema": map[string]any{"type": p.kind},
+			})
+		}
+		get := map[string]any{
+			"summary":   op.summary,
+			"responses": map[string]any{"200": map[string]any{"description": "OK", "content": content}},
+		}
+		if strings.Contains(op.path, "{path}") {
+			params = append(params, map[string]any{"name": "path", "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		if params != nil {
+			get["parameters"] = params
+		}
+		paths[op.path] = map[string]any{"get": get}
+	}
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "File Server", "version": "1"},
+		"paths":   paths,
+	}
+	if base != "" {
+		doc["servers"] = []any{map[string]any{"url": base}}
+	}
+	return doc
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.openAPIDocument(basePath(r))); err != nil {
+		reqLogf(r, levelWarn, areaRequest, "Failed to write OpenAPI document: %v", err)
+	}
+}
+
+func (s *Server) handleAPIExplorer(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.template.ExecuteTemplate(w, "apidocs.html", nil); err != nil {
+		reqLogf(r, levelError, areaRequest, "Template execution error: %v", err)
+	}
+}
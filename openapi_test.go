@@ -0,0 +1,157 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkSchema reports where value, decoded from JSON, does not match the
+// subset of JSON Schema openAPIDocument generates. Properties missing from
+// the schema count as mismatches, so undocumented fields are caught too.
+func checkSchema(at string, schema map[string]any, value any) []string {
+	if value == nil {
+		// encoding/json writes nil slices, maps and pointers as null
+		if schema["type"] == "array" || schema["type"] == "object" {
+			return nil
+		}
+		return []string{fmt.Sprintf("%s: null for type %v", at, schema["type"])}
+	}
+	switch schema["type"] {
+	case nil:
+		return nil
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: %v is not a boolean", at, value)}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return []string{fmt.Sprintf("%s: %v is not an integer", at, value)}
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s: %v is not a number", at, value)}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not a string", at, value)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, str); err != nil {
+				return []string{fmt.Sprintf("%s: %q is not a date-time", at, str)}
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not an array", at, value)}
+		}
+		var problems []string
+		for i, item := range items {
+			problems = append(problems, checkSchema(fmt.Sprintf("%s[%d]", at, i), schema["items"].(map[string]any), item)...)
+		}
+		return problems
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: %v is not an object", at, value)}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		additional, _ := schema["additionalProperties"].(map[string]any)
+		var problems []string
+		for name, v := range obj {
+			prop, ok := properties[name].(map[string]any)
+			switch {
+			case ok:
+			case additional != nil:
+				prop = additional
+			case properties == nil:
+				// Recursive types end in a plain object
+				continue
+			default:
+				problems = append(problems, fmt.Sprintf("%s.%s: not in the schema", at, name))
+				continue
+			}
+			problems = append(problems, checkSchema(at+"."+name, prop, v)...)
+		}
+		return problems
+	default:
+		return []string{fmt.Sprintf("%s: unknown schema type %v", at, schema["type"])}
+	}
+	return nil
+}
+
+// TestOpenAPIResponses fetches the document and checks real responses of
+// each JSON endpoint against the schema it gives for them.
+func TestOpenAPIResponses(t *testing.T) {
+	s, h := newTestServer(t, Config{Status: true, DirCounts: true})
+	writeFiles(t, s.rootDir, map[string]string{
+		"docs/readme.txt":   "hello\nworld\n",
+		"docs/sub/note.md":  "# note",
+		"photos/a.jpg":      "not really",
+		"empty/.keep":       "",
+		"top-level-file.go": "package main",
+	})
+
+	w := serve(h, http.MethodGet, "/_api/openapi.json", nil)
+	var doc struct {
+		Paths map[string]struct {
+			Get struct {
+				Responses map[string]struct {
+					Content map[string]struct {
+						Schema map[string]any `json:"schema"`
+					} `json:"content"`
+				} `json:"responses"`
+			} `json:"get"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("openapi.json: status %d, %v", w.Code, err)
+	}
+
+	examples := []string{
+		"/_status",
+		apiV1Prefix + "list?path=/",
+		apiV1Prefix + "list?path=/docs&fields=name,size",
+		apiV1Prefix + "list?path=/&recursive=true",
+		apiV1Prefix + "stat?path=/docs/readme.txt",
+		apiV1Prefix + "stat?path=/docs",
+		apiV1Prefix + "search?q=note",
+		apiV1Prefix + "tree?path=/&depth=3",
+		apiV1Prefix + "preview?path=/docs/readme.txt",
+	}
+	var documented []string
+	for path, item := range doc.Paths {
+		if _, ok := item.Get.Responses["200"].Content["application/json"]; ok {
+			documented = append(documented, path)
+		}
+	}
+	for _, path := range documented {
+		if !slices.ContainsFunc(examples, func(e string) bool { return strings.HasPrefix(e, path) }) {
+			t.Errorf("no example response for %s", path)
+		}
+	}
+
+	for _, target := range examples {
+		path, _, _ := strings.Cut(target, "?")
+		media, ok := doc.Paths[path].Get.Responses["200"].Content["application/json"]
+		if !ok {
+			t.Errorf("%s: not documented as JSON", path)
+			continue
+		}
+		w := serve(h, http.MethodGet, target, nil)
+		var value any
+		if err := json.Unmarshal(w.Body.Bytes(), &value); w.Code != http.StatusOK || err != nil {
+			t.Errorf("GET %s: status %d, body %s", target, w.Code, w.Body)
+			continue
+		}
+		for _, problem := range checkSchema(target, media.Schema, value) {
+			t.Error(problem)
+		}
+	}
+}
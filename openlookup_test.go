@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// swapStorage runs swap after opening name, standing in for another
+// process replacing it between the server's checks and its use.
+type swapStorage struct {
+	storage
+	name string
+	swap func()
+}
+
+func (s *swapStorage) Open(name string) (fs.File, error) {
+	f, err := s.storage.Open(name)
+	if err == nil && name == s.name && s.swap != nil {
+		s.swap()
+		s.swap = nil
+	}
+	return f, err
+}
+
+// swapForSymlink replaces path with a symlink to target.
+func swapForSymlink(t *testing.T, path, target string) func() {
+	return func() {
+		if err := os.Rename(path, path+".old"); err != nil {
+			t.Error(err)
+		}
+		if err := os.Symlink(target, path); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSymlinkSwap(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs symlinks")
+	}
+	swapper := &swapStorage{}
+	s, h := newWrappedTestServer(t, Config{}, func(st storage) storage {
+		swapper.storage = st
+		return swapper
+	})
+	writeFiles(t, s.rootDir, map[string]string{
+		"dir/original.txt":  "",
+		"file.txt":          "original",
+		"other/swapped.txt": "",
+		"other/secret.txt":  "swapped",
+	})
+
+	tests := []struct {
+		target, path, swapTo, want, notWant string
+	}{
+		// Names only: the entries' details are still looked up by name
+		{"/dir/?format=txt", "dir", "other", "original.txt", "swapped.txt"},
+		{"/file.txt", "file.txt", "other/secret.txt", "original", "swapped"},
+	}
+	for _, tt := range tests {
+		full := filepath.Join(s.rootDir, tt.path)
+		swapper.name, swapper.swap = full, swapForSymlink(t, full, filepath.Join(s.rootDir, tt.swapTo))
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if body := w.Body.String(); w.Code != http.StatusOK || !strings.Contains(body, tt.want) || strings.Contains(body, tt.notWant) {
+			t.Errorf("GET %s swapped after open: status %d, body %q, want the original", tt.target, w.Code, body)
+		}
+	}
+}
+
+// countingStorage counts the metadata calls made through it.
+type countingStorage struct {
+	storage
+	calls atomic.Int64
+}
+
+func (s *countingStorage) Stat(name string) (fs.FileInfo, error) {
+	s.calls.Add(1)
+	return s.storage.Stat(name)
+}
+
+func (s *countingStorage) Open(name string) (fs.File, error) {
+	s.calls.Add(1)
+	return s.storage.Open(name)
+}
+
+func (s *countingStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	s.calls.Add(1)
+	return s.storage.ReadDir(name)
+}
+
+// BenchmarkServe reports the storage calls per request besides the time:
+// one open for a file and one for a listing, which reads that handle
+// rather than reading the directory again by path.
+func BenchmarkServe(b *testing.B) {
+	root := b.TempDir()
+	files := map[string]string{"file.txt": strings.Repeat("x", 4096)}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		files["dir/"+name] = "x"
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, []byte(content), 0o644)
+	}
+	s, err := NewServerFromConfig(Config{RootDir: root})
+	if err != nil {
+		b.Fatal(err)
+	}
+	counter := &countingStorage{storage: s.storage}
+	s.storage = counter
+	h := s.Handler()
+	b.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	for _, target := range []string{"/file.txt", "/dir/"} {
+		b.Run(strings.Trim(target, "/"), func(b *testing.B) {
+			counter.calls.Store(0)
+			for b.Loop() {
+				if w := serve(h, http.MethodGet, target, nil); w.Code != http.StatusOK {
+					b.Fatalf("GET %s: status %d", target, w.Code)
+				}
+			}
+			b.ReportMetric(float64(counter.calls.Load())/float64(b.N), "fscalls/op")
+		})
+	}
+}
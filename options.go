@@ -0,0 +1,163 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Option configures a Server built by New. Options check their arguments
+// when applied, so a bad value fails New rather than a later request.
+type Option func(*Config) error
+
+// New returns a server for rootDir configured by opts over the same
+// defaults the command line uses.
+func New(rootDir string, opts ...Option) (*Server, error) {
+	cfg := Config{
+		RootDir:           rootDir,
+		Port:              8080,
+		ChecksumCacheSize: 10000,
+		MaxDirReads:       64,
+		MaxFSCalls:        64,
+		MaxTailSessions:   16,
+		MinFreeFDs:        DefaultMinFreeFDs,
+		DirPasswords:      true,
+	}
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	return NewServerFromConfig(cfg)
+}
+
+// WithPort listens on all interfaces at port.
+func WithPort(port int) Option {
+	return func(cfg *Config) error {
+		if port < 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+		cfg.Port = port
+		cfg.Addr = ""
+		return nil
+	}
+}
+
+// WithAddr listens on a host:port address such as "127.0.0.1:8080".
+func WithAddr(addr string) Option {
+	return func(cfg *Config) error {
+		if err := validateAddr(addr); err != nil {
+			return err
+		}
+		cfg.Addr = addr
+		return nil
+	}
+}
+
+// WithBasePath prefixes links and redirects for a reverse proxy that
+// strips path before forwarding. Mounting under http.StripPrefix needs no
+// option; the prefix is detected per request.
+func WithBasePath(path string) Option {
+	return func(cfg *Config) error {
+		if path != "" && !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("base path must start with /: %s", path)
+		}
+		cfg.BasePath = path
+		return nil
+	}
+}
+
+// WithTemplates replaces embedded templates with same-named *.html files
+// from fsys.
+func WithTemplates(fsys fs.FS) Option {
+	return func(cfg *Config) error {
+		if fsys == nil {
+			return fmt.Errorf("nil template FS")
+		}
+		cfg.Templates = fsys
+		return nil
+	}
+}
+
+// WithLogger sends the server's logs to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(cfg *Config) error {
+		cfg.Logger = logger
+		return nil
+	}
+}
+
+// WithTimeouts sets the server deadlines; zero fields keep their defaults.
+func WithTimeouts(t Timeouts) Option {
+	return func(cfg *Config) error {
+		t.setDefaults()
+		if err := t.validate(); err != nil {
+			return err
+		}
+		cfg.Timeouts = t
+		return nil
+	}
+}
+
+// WithAuth requires every request to pass fn.
+func WithAuth(fn AuthFunc) Option {
+	return func(cfg *Config) error {
+		if fn == nil {
+			return fmt.Errorf("nil auth function")
+		}
+		cfg.Auth = fn
+		return nil
+	}
+}
+
+// WithLogin adds a login page checking credentials with fn, and cookie
+// sessions lasting lifetime (0 for DefaultSessionLifetime). Requests with
+// a session skip any WithAuth function; browsers without one are sent to
+// the login page.
+func WithLogin(fn LoginFunc, lifetime time.Duration) Option {
+	return func(cfg *Config) error {
+		if fn == nil {
+			return fmt.Errorf("nil login function")
+		}
+		if lifetime < 0 {
+			return fmt.Errorf("negative session lifetime")
+		}
+		cfg.Login, cfg.SessionLifetime = fn, lifetime
+		return nil
+	}
+}
+
+// WithOIDC adds login through an OpenID Connect provider. It sets the
+// same sessions as WithLogin and may be combined with it and WithAuth.
+func WithOIDC(oidc OIDCConfig) Option {
+	return func(cfg *Config) error {
+		if _, err := newOIDCProvider(oidc); err != nil {
+			return err
+		}
+		cfg.OIDC = oidc
+		return nil
+	}
+}
+
+// WithHiddenFiles controls whether dot files are listed and served.
+func WithHiddenFiles(show bool) Option {
+	return func(cfg *Config) error {
+		cfg.HideDotFiles = !show
+		return nil
+	}
+}
+
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %v", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port in listen address %q", addr)
+	}
+	return nil
+}
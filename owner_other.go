@@ -0,0 +1,9 @@
+//go:build !unix
+
+package fileserver
+
+import "io/fs"
+
+func fileOwnerIDs(info fs.FileInfo) (uid, gid string, ok bool) {
+	return "", "", false
+}
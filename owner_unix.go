@@ -0,0 +1,19 @@
+//go:build unix
+
+package fileserver
+
+import (
+	"io/fs"
+	"strconv"
+	"syscall"
+)
+
+// fileOwnerIDs returns the numeric owner and group of info, if the
+// platform records them.
+func fileOwnerIDs(info fs.FileInfo) (uid, gid string, ok bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", "", false
+	}
+	return strconv.FormatUint(uint64(st.Uid), 10), strconv.FormatUint(uint64(st.Gid), 10), true
+}
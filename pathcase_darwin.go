@@ -0,0 +1,4 @@
+package fileserver
+
+// APFS and HFS+ are case-insensitive by default.
+const caseInsensitivePaths = true
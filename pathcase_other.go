@@ -0,0 +1,5 @@
+//go:build !darwin && !windows
+
+package fileserver
+
+const caseInsensitivePaths = false
@@ -0,0 +1,72 @@
+package fileserver
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithinRootCase(t *testing.T) {
+	root := filepath.FromSlash("/Users/Me/Public")
+	tests := []struct {
+		path string
+		// whether the path is inside root when case is ignored, and when
+		// it is not
+		insensitive, sensitive bool
+	}{
+		{"/Users/Me/Public/a.txt", true, true},
+		{"/users/me/public/a.txt", true, false},
+		{"/USERS/ME/PUBLIC", true, false},
+		{"/users/me/publicity/a.txt", false, false},
+		{"/users/me/public/../private", false, false},
+		{"/Users/Me/Private", false, false},
+		{"/users/me", false, false},
+	}
+	for _, tt := range tests {
+		want := tt.sensitive
+		if caseInsensitivePaths {
+			want = tt.insensitive
+		}
+		if got := withinRoot(root, filepath.Clean(filepath.FromSlash(tt.path))); got != want {
+			t.Errorf("withinRoot(%q, %q) = %v, want %v", root, tt.path, got, want)
+		}
+	}
+}
+
+// TestMixedCaseRoot serves a root with capitals in its name and asks for
+// files in other cases: a case-insensitive filesystem finds them, a
+// case-sensitive one does not, and nothing outside the root is reachable
+// by changing case.
+func TestMixedCaseRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "Public")
+	writeFiles(t, parent, map[string]string{
+		"Public/Docs/Readme.txt": "inside",
+		"PublicOther/secret.txt": "outside",
+	})
+	_, h := newTestServer(t, Config{RootDir: root})
+
+	foldedStatus := http.StatusNotFound
+	if caseInsensitivePaths {
+		foldedStatus = http.StatusOK
+	}
+	tests := []struct {
+		target string
+		want   int
+	}{
+		{"/Docs/Readme.txt", http.StatusOK},
+		{"/docs/readme.txt", foldedStatus},
+		{"/DOCS/README.TXT", foldedStatus},
+		{"/../PublicOther/secret.txt", http.StatusMovedPermanently},
+		{"/../publicother/secret.txt", http.StatusMovedPermanently},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, tt.target, nil)
+		if w.Code != tt.want {
+			t.Errorf("GET %s: status %d, want %d", tt.target, w.Code, tt.want)
+		}
+		if w.Body.String() == "outside" {
+			t.Errorf("GET %s served a file outside the root", tt.target)
+		}
+	}
+}
@@ -0,0 +1,4 @@
+package fileserver
+
+// NTFS lookups ignore case.
+const caseInsensitivePaths = true
@@ -0,0 +1,75 @@
+package fileserver
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// requestPathFrom decodes the escaped URL path exactly once into the path
+// looked up under the root. net/http has decoded URL.Path already, but that
+// loses which characters were escaped, so this starts over from
+// EscapedPath and refuses what the decoded form hides: NUL bytes, escaped
+// separators (%2F, and %5C on Windows) that would turn one name into
+// several directories, and escaped dot segments (%2e%2e) that a proxy in
+// front may have passed on as names. Everything else is a name: ";" is
+// part of it as in net/http, and "%252e" is the name "%2e". The result
+// still goes through isPathSafe.
+func requestPathFrom(u *url.URL) (string, error) {
+	segments := strings.Split(u.EscapedPath(), "/")
+	for i, escaped := range segments {
+		name, err := url.PathUnescape(escaped)
+		switch {
+		case err != nil:
+			return "", err
+		case strings.ContainsRune(name, 0):
+			return "", errors.New("NUL byte in path")
+		case strings.Contains(name, "/") || (filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator)):
+			return "", errors.New("escaped separator in path")
+		case (name == "." || name == "..") && name != escaped:
+			return "", errors.New("escaped dot segment in path")
+		}
+		segments[i] = name
+	}
+	return "/" + strings.TrimPrefix(strings.Join(segments, "/"), "/"), nil
+}
+
+// isPathSafe reports whether requestPath stays inside the root. The path is
+// cleaned as rooted, so ".." cannot climb above "/", and the joined result
+// is then checked with filepath.Rel rather than a string prefix, so
+// "/srv/files-old" does not pass for root "/srv/files". On case-insensitive
+// platforms (macOS, Windows) both sides are case-folded first, and the root
+// itself was resolved with EvalSymlinks at startup.
+//
+// Residual risks: symlinks inside the tree are followed wherever they
+// point; strings.ToLower is not the filesystem's own folding table, so
+// exotic case pairs may compare unequal (which only rejects, never admits);
+// and Windows aliases such as 8.3 short names, trailing dots or spaces and
+// "::$DATA" streams are not recognized here.
+func (s *Server) isPathSafe(requestPath string) bool {
+	fullPath := s.fsPath(path.Clean("/" + requestPath))
+	absPath, err := filepath.Abs(fullPath)
+	if err != nil {
+		return false
+	}
+	return withinRoot(s.rootDir, absPath)
+}
+
+// fsPath is where request paths, which always use forward slashes, become
+// filesystem paths under the root.
+func (s *Server) fsPath(requestPath string) string {
+	return filepath.Join(s.rootDir, filepath.FromSlash(requestPath))
+}
+
+func withinRoot(root, path string) bool {
+	if caseInsensitivePaths {
+		root, path = strings.ToLower(root), strings.ToLower(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
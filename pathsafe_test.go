@@ -0,0 +1,125 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestPathFrom(t *testing.T) {
+	backslashIsSeparator := filepath.Separator == '\\'
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"/a/b.txt", "/a/b.txt", false},
+		{"/a%20b.txt", "/a b.txt", false},
+		{"/a;b", "/a;b", false},
+		{"/%252e%252e/x", "/%2e%2e/x", false},
+		{"/../x", "/../x", false}, // plain dot segments are left to isPathSafe
+		{"/a%2Fb", "", true},
+		{"/a%2f..%2f..%2fetc", "", true},
+		{"/%2e%2e/etc/passwd", "", true},
+		{"/%2E%2e/etc/passwd", "", true},
+		{"/a/%2e", "", true},
+		{"/a%00.txt", "", true},
+		{"/a/%00", "", true},
+		{"/%5C..%5Cetc", "/\\..\\etc", backslashIsSeparator},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := requestPathFrom(u)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("requestPathFrom(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("requestPathFrom(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestWithinRoot(t *testing.T) {
+	root := filepath.FromSlash("/srv/files")
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/srv/files", true},
+		{"/srv/files/a/b", true},
+		{"/srv/files/..hidden", true},
+		{"/srv/files-old", false},
+		{"/srv/files-old/a", false},
+		{"/srv", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if got := withinRoot(root, filepath.FromSlash(tt.path)); got != tt.want {
+			t.Errorf("withinRoot(%q, %q) = %v, want %v", root, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestIsPathSafe(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	// Request paths are rooted, so ".." and absolute-looking paths end up
+	// inside the root rather than above it
+	for _, p := range []string{"/", "/a/../b", "/../../etc/passwd", "//etc/passwd", "../x", "/a/./../../.."} {
+		if !s.isPathSafe(p) {
+			t.Errorf("isPathSafe(%q) = false", p)
+		}
+		if fullPath := s.fsPath(filepath.ToSlash(filepath.Clean("/" + p))); !withinRoot(s.rootDir, fullPath) {
+			t.Errorf("%q maps outside the root to %s", p, fullPath)
+		}
+	}
+}
+
+// TestTraversal requests a file next to the root in every encoding a
+// traversal might use; none may reach it.
+func TestTraversal(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	writeFiles(t, parent, map[string]string{"secret.txt": "secret", "root/public.txt": "public"})
+	_, h := newTestServer(t, Config{RootDir: root})
+
+	targets := []string{
+		"/../secret.txt",
+		"/..%2fsecret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2e%2e%2fsecret.txt",
+		"/public.txt/../../secret.txt",
+		"/..%5csecret.txt",
+		"/%2e%2e%5csecret.txt",
+		"/%252e%252e/secret.txt",
+		"/secret.txt%00.txt",
+		"/public.txt%00",
+		"//" + filepath.ToSlash(filepath.Join(parent, "secret.txt")),
+	}
+	for _, target := range targets {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL, _ = url.Parse(target)
+		r.RequestURI = target
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if strings.Contains(w.Body.String(), "secret") && !strings.Contains(w.Body.String(), "public") {
+			t.Errorf("GET %s: status %d served the file outside the root", target, w.Code)
+		}
+		if loc := w.Header().Get("Location"); strings.Contains(loc, "..") {
+			t.Errorf("GET %s: redirected to %s", target, loc)
+		}
+		if w.Code == http.StatusOK {
+			t.Errorf("GET %s: status 200, body %q", target, w.Body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "secret.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
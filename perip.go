@@ -0,0 +1,92 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// perIPRetryAfter is the Retry-After, in seconds, sent with 429.
+const perIPRetryAfter = 5
+
+// perIPLimiter caps concurrent requests per client address. It counts
+// requests rather than connections so that clients behind a trusted proxy,
+// which all share the proxy's connections, are told apart; a TLS handshake
+// that fails never reaches it and so is never counted.
+type perIPLimiter struct {
+	max    int
+	exempt []netip.Prefix
+
+	mu       sync.Mutex
+	active   map[string]int
+	rejected atomic.Int64
+}
+
+func newPerIPLimiter(max int, exempt []netip.Prefix) *perIPLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &perIPLimiter{max: max, exempt: exempt, active: make(map[string]int)}
+}
+
+func (l *perIPLimiter) exempted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range l.exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *perIPLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] >= l.max {
+		return false
+	}
+	l.active[ip]++
+	return true
+}
+
+func (l *perIPLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip]--; l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
+
+// clients is the number of addresses with requests in flight.
+func (l *perIPLimiter) clients() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.active)
+}
+
+// limitPerIP answers 429 to a client already at its limit.
+func (s *Server) limitPerIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if s.perIP.exempted(ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !s.perIP.acquire(ip) {
+			s.perIP.rejected.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(perIPRetryAfter))
+			httpError(w, r, areaRequest, http.StatusTooManyRequests, "Too many concurrent requests from your address",
+				fmt.Sprintf("%s is at the limit of %d", ip, s.perIP.max))
+			return
+		}
+		defer s.perIP.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,140 @@
+package fileserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// linkParam carries a signed link's token in its query string.
+const linkParam = "sig"
+
+// playlistExtensions is the set of extensions ?playlist=m3u lists, from
+// Config.PlaylistExtensions or, by default, the audio category.
+func playlistExtensions(exts []string) map[string]bool {
+	set := map[string]bool{}
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		set[ext] = true
+	}
+	if len(set) == 0 {
+		for ext, category := range categoryExtensions {
+			if category == "audio" {
+				set[ext] = true
+			}
+		}
+	}
+	return set
+}
+
+// newLinkSigner signs playlist links with a key derived from the session
+// key, so a link's token can never pass for a session cookie.
+func newLinkSigner(sessionKey []byte, lifetime time.Duration) *sessionSigner {
+	var key []byte
+	if len(sessionKey) > 0 {
+		sum := sha256.Sum256(append([]byte("fileserver links\x00"), sessionKey...))
+		key = sum[:]
+	}
+	return newSessionSigner(key, lifetime)
+}
+
+// linkClaims are the signed contents of a link's token: who asked for
+// it, for which path on which virtual host, until when.
+type linkClaims struct {
+	Host    string   `json:"h,omitempty"`
+	Path    string   `json:"p"`
+	User    string   `json:"u"`
+	Groups  []string `json:"g,omitempty"`
+	Expires int64    `json:"exp"` // Unix seconds
+}
+
+// signLink returns requestPath with a token that lets whoever holds it
+// fetch that path as the requesting user until the link lifetime is up,
+// without credentials of their own.
+func (s *Server) signLink(r *http.Request, requestPath string) string {
+	info := getRequestInfo(r)
+	token := s.linkSigner.seal(linkClaims{
+		Host:    s.vhost,
+		Path:    requestPath,
+		User:    info.identity,
+		Groups:  info.groups,
+		Expires: time.Now().Add(s.linkSigner.lifetime).Unix(),
+	})
+	return linkParam + "=" + url.QueryEscape(token)
+}
+
+// signedLink returns the claims of a valid, unexpired token for the
+// request's path.
+func (s *Server) signedLink(r *http.Request) (linkClaims, bool) {
+	var claims linkClaims
+	token := r.URL.Query().Get(linkParam)
+	if s.linkSigner == nil || token == "" || !s.linkSigner.open(token, &claims) {
+		return linkClaims{}, false
+	}
+	if time.Now().Unix() >= claims.Expires || claims.User == "" || claims.Path != r.URL.Path || claims.Host != s.vhost {
+		return linkClaims{}, false
+	}
+	return claims, true
+}
+
+// writePlaylist answers ?playlist=m3u: an extended M3U of the audio files
+// in the listing, with absolute URLs a player can open directly. When
+// links are signed and the request is authenticated, each URL carries a
+// token, so players without the user's credentials can fetch it.
+func (s *Server) writePlaylist(w http.ResponseWriter, r *http.Request, fullPath, requestPath string, files []FileInfo) {
+	baseURL := s.sitemapBaseURL(r)
+	sign := s.linkSigner != nil && getRequestInfo(r).identity != ""
+
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&buf, "#PLAYLIST:%s\n", m3uText(dirFileName(fullPath)))
+	entries := 0
+	for _, f := range files {
+		if f.IsDir || !s.playlistExts[strings.ToLower(path.Ext(f.Name))] || strings.ContainsAny(f.Name, "\r\n") {
+			continue
+		}
+		filePath := requestPath + f.Name
+		link := baseURL + urlPath(filePath)
+		if sign {
+			link += "?" + s.signLink(r, filePath)
+		}
+		title := strings.TrimSuffix(path.Base(f.Name), path.Ext(f.Name))
+		fmt.Fprintf(&buf, "#EXTINF:-1,%s\n%s\n", m3uText(title), link)
+		entries++
+	}
+	reqLogf(r, levelDebug, areaListing, "Playlist of %s has %d entries", requestPath, entries)
+
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", dirFileName(fullPath)+".m3u"))
+	// Signed links expire, so a cached copy would stop working
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	s.emitTiming(w, r)
+	w.Write(buf.Bytes())
+}
+
+func m3uText(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// sortByPath orders a playlist by path, folders' files grouped in the
+// order of a recursive listing.
+func sortByPath(files []FileInfo) {
+	slices.SortStableFunc(files, func(a, b FileInfo) int {
+		if c := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+}
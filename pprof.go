@@ -0,0 +1,43 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// registerDebug adds net/http/pprof's handlers under /debug/pprof/ and
+// the expvar variables at /debug/vars.
+func (s *Server) registerDebug(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/vars", s.metrics.serveVars)
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
+
+// startPprofServer serves the profiling endpoints alone on PprofAddr, with
+// no authentication or access log: the address is meant to be private.
+func (s *Server) startPprofServer() error {
+	ln, err := net.Listen("tcp", s.config.PprofAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on pprof address %s: %v", s.config.PprofAddr, err)
+	}
+	mux := http.NewServeMux()
+	s.registerDebug(mux)
+	s.pprofServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		// No write timeout: CPU profiles and traces run for ?seconds=
+	}
+	infof(areaServer, "Profiling endpoints on: http://%s/debug/pprof/ and /debug/vars", ln.Addr())
+	go func() {
+		if err := s.pprofServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			errorf(areaServer, "Pprof server error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,39 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDebugVars(t *testing.T) {
+	_, h := newTestServer(t, Config{})
+	if w := serve(h, http.MethodGet, "/debug/vars", nil); w.Code != http.StatusNotFound {
+		t.Errorf("/debug/vars without -pprof: status %d, want 404", w.Code)
+	}
+
+	_, h = newTestServer(t, Config{Pprof: true})
+	serve(h, http.MethodGet, "/", nil)
+	w := serve(h, http.MethodGet, "/debug/vars", nil)
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &vars); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("/debug/vars: status %d, %v, body %s", w.Code, err, w.Body)
+	}
+	for _, key := range []string{"cmdline", "memstats", "fileserver"} {
+		if _, ok := vars[key]; !ok {
+			t.Errorf("/debug/vars has no %q", key)
+		}
+	}
+	var metrics map[string]float64
+	if err := json.Unmarshal(vars["fileserver"], &metrics); err != nil {
+		t.Fatalf("fileserver vars: %v", err)
+	}
+	for _, name := range []string{"fileserver_requests_total", "fileserver_bytes_served_total", "fileserver_active_connections", "fileserver_goroutines"} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("fileserver vars have no %s", name)
+		}
+	}
+	if n := metrics["fileserver_requests_total"]; n < 1 {
+		t.Errorf("fileserver_requests_total = %v after a request", n)
+	}
+}
@@ -0,0 +1,129 @@
+package fileserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+const (
+	previewDefaultBytes = 4096
+	previewMaxBytes     = 1 << 20
+	previewMaxLines     = 10000
+)
+
+type apiPreview struct {
+	Path        string `json:"path"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	Encoding    string `json:"encoding"` // "text" or "base64"
+	Content     string `json:"content"`
+	Bytes       int    `json:"bytes"` // length of the preview before encoding
+	EOF         bool   `json:"eof"`   // the preview is the whole file
+}
+
+// handleAPIPreview returns the beginning of a file: bytes (default 4096)
+// or, with lines, up to that many lines within the byte bound. Text is cut
+// at a line boundary; binary content needs allowBinary=true and comes back
+// base64-encoded.
+func (s *Server) handleAPIPreview(w http.ResponseWriter, r *http.Request) {
+	limit, ok := apiIntParam(w, r, "bytes", previewDefaultBytes, previewMaxBytes)
+	if !ok {
+		return
+	}
+	lines, ok := apiIntParam(w, r, "lines", 0, previewMaxLines)
+	if !ok {
+		return
+	}
+	if r.URL.Query().Has("lines") && !r.URL.Query().Has("bytes") {
+		limit = previewMaxBytes
+	}
+	requestPath, fullPath, info, ok := s.apiLookup(w, r)
+	if !ok {
+		return
+	}
+	if info.IsDir() {
+		apiFail(w, r, areaRequest, http.StatusBadRequest, "Not a file", "")
+		return
+	}
+
+	file, err := s.open(r.Context(), fullPath)
+	if err != nil {
+		if s.fsCallFailed(w, r, apiFail, "open", fullPath, err) {
+			return
+		}
+		apiFail(w, r, areaIO, http.StatusInternalServerError, "Failed to open file", fmt.Sprintf("open %s: %v", fullPath, err))
+		return
+	}
+	defer file.Close()
+	head, err := io.ReadAll(io.LimitReader(file, int64(limit)+1))
+	if err != nil {
+		apiFail(w, r, areaIO, http.StatusInternalServerError, "Failed to read file", fmt.Sprintf("read %s: %v", fullPath, err))
+		return
+	}
+	eof := len(head) <= limit
+	head = head[:min(len(head), limit)]
+
+	preview := apiPreview{Path: newAPIEntry(requestPath, info).Path, Size: info.Size(), ContentType: mime.TypeByExtension(filepath.Ext(fullPath))}
+	if preview.ContentType == "" {
+		preview.ContentType = http.DetectContentType(head)
+	}
+	if !looksLikeText(head[:min(len(head), 512)]) {
+		if !apiBoolParam(r, "allowBinary") {
+			apiFail(w, r, areaRequest, http.StatusUnsupportedMediaType, "Binary content; pass allowBinary=true for base64", "")
+			return
+		}
+		preview.Encoding, preview.Content = "base64", base64.StdEncoding.EncodeToString(head)
+		preview.Bytes, preview.EOF = len(head), eof
+		writeAPI(w, r, preview)
+		return
+	}
+
+	if lines > 0 {
+		if cut := nthIndex(head, '\n', lines); cut >= 0 && cut+1 < len(head) {
+			head, eof = head[:cut+1], false
+		}
+	}
+	if !eof {
+		// Whole lines only, unless a single line is longer than the preview
+		if cut := bytes.LastIndexByte(head, '\n'); cut >= 0 {
+			head = head[:cut+1]
+		} else {
+			head = trimPartialRune(head)
+		}
+	}
+	preview.Encoding, preview.Content = "text", string(head)
+	preview.Bytes, preview.EOF = len(head), eof
+	writeAPI(w, r, preview)
+}
+
+// trimPartialRune drops a UTF-8 sequence cut off at the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// nthIndex returns the index of the nth occurrence of c in b, or -1.
+func nthIndex(b []byte, c byte, n int) int {
+	at := -1
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(b[at+1:], c)
+		if i < 0 {
+			return -1
+		}
+		at += i + 1
+	}
+	return at
+}
@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parsePrefixes parses CIDRs and bare addresses; what names the list in
+// errors.
+func parsePrefixes(what string, cidrs []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", what, cidr, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %v", what, cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyIdentity returns the user and groups an authenticating proxy put in
+// AuthProxyHeader and AuthProxyGroupsHeader. From any other peer the
+// headers are deleted, so nothing later mistakes them for the proxy's.
+// trusted reports a trusted peer, whose requests without the header are
+// anonymous.
+func (s *Server) proxyIdentity(r *http.Request) (user string, groups []string, trusted bool) {
+	if peer, ok := remoteAddrIP(r.RemoteAddr); !ok || !s.isTrustedProxy(peer) {
+		r.Header.Del(s.config.AuthProxyHeader)
+		if s.config.AuthProxyGroupsHeader != "" {
+			r.Header.Del(s.config.AuthProxyGroupsHeader)
+		}
+		return "", nil, false
+	}
+	user = strings.TrimSpace(r.Header.Get(s.config.AuthProxyHeader))
+	if user != "" && s.config.AuthProxyGroupsHeader != "" {
+		for _, header := range r.Header.Values(s.config.AuthProxyGroupsHeader) {
+			for _, g := range strings.Split(header, ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					groups = append(groups, g)
+				}
+			}
+		}
+	}
+	return user, groups, true
+}
+
+func remoteAddrIP(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientAddress derives the client IP and scheme for a request. Forwarding
+// headers are only consulted when the direct peer is a trusted proxy; the
+// client is then the rightmost X-Forwarded-For hop that is not itself trusted.
+func (s *Server) clientAddress(r *http.Request) (ip string, scheme string) {
+	scheme = "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	peer, ok := remoteAddrIP(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr, scheme
+	}
+	if len(s.trustedProxies) == 0 || !s.isTrustedProxy(peer) {
+		return peer.String(), scheme
+	}
+
+	client := peer
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Stop at garbage rather than trusting anything to its left
+			break
+		}
+		client = hop.Unmap()
+		if !s.isTrustedProxy(client) {
+			break
+		}
+	}
+
+	proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+	if proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return client.String(), scheme
+}
+
+// requestBaseURL returns the externally visible scheme://host for building
+// absolute URLs.
+func requestBaseURL(r *http.Request) string {
+	info := getRequestInfo(r)
+	scheme := info.scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
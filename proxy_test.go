@@ -0,0 +1,103 @@
+package fileserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientAddressSpoofing(t *testing.T) {
+	s, _ := newTestServer(t, Config{TrustedProxies: []string{"10.0.0.0/8"}})
+	tests := []struct {
+		name       string
+		remote     string
+		xff, proto string
+		wantIP     string
+		wantScheme string
+	}{
+		{"untrusted peer forging both", "203.0.113.5:4000", "198.51.100.1", "https", "203.0.113.5", "http"},
+		{"untrusted peer claiming a proxy hop", "203.0.113.5:4000", "10.0.0.1", "", "203.0.113.5", "http"},
+		{"trusted proxy", "10.0.0.1:4000", "198.51.100.1", "https", "198.51.100.1", "https"},
+		{"forged hop left of the real client", "10.0.0.1:4000", "1.2.3.4, 198.51.100.1", "", "198.51.100.1", "http"},
+		{"chain of trusted proxies", "10.0.0.1:4000", "198.51.100.1, 10.0.0.2", "", "198.51.100.1", "http"},
+		{"garbage hop stops the walk", "10.0.0.1:4000", "198.51.100.1, bogus", "", "10.0.0.1", "http"},
+		{"unknown proto ignored", "10.0.0.1:4000", "", "gopher", "10.0.0.1", "http"},
+		{"IPv4-mapped untrusted peer", "[::ffff:203.0.113.5]:4000", "198.51.100.1", "https", "203.0.113.5", "http"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		ip, scheme := s.clientAddress(r)
+		if ip != tt.wantIP || scheme != tt.wantScheme {
+			t.Errorf("%s: got %s %s, want %s %s", tt.name, ip, scheme, tt.wantIP, tt.wantScheme)
+		}
+	}
+}
+
+func TestForwardingIgnoredWithoutTrustedProxies(t *testing.T) {
+	s, _ := newTestServer(t, Config{})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "127.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if ip, scheme := s.clientAddress(r); ip != "127.0.0.1" || scheme != "http" {
+		t.Errorf("got %s %s, want the peer's address over http", ip, scheme)
+	}
+}
+
+func TestRequestIDSpoofing(t *testing.T) {
+	_, h := newTestServer(t, Config{TrustedProxies: []string{"10.0.0.0/8"}})
+	tests := []struct {
+		remote, id string
+		kept       bool
+	}{
+		{"203.0.113.5:4000", "forged-id", false},
+		{"10.0.0.1:4000", "upstream-id", true},
+		{"10.0.0.1:4000", "has space", false},
+		{"10.0.0.1:4000", "bad\x01char", false},
+		{"10.0.0.1:4000", string(make([]byte, 65)), false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		r.Header.Set("X-Request-Id", tt.id)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		got := w.Header().Get("X-Request-Id")
+		if kept := got == tt.id; kept != tt.kept || got == "" {
+			t.Errorf("X-Request-Id %q from %s answered with %q, kept %v, want %v", tt.id, tt.remote, got, kept, tt.kept)
+		}
+	}
+}
+
+func TestAuthProxyHeaderSpoofing(t *testing.T) {
+	s, _ := newTestServer(t, Config{
+		TrustedProxies:        []string{"10.0.0.0/8"},
+		AuthProxyHeader:       "X-Remote-User",
+		AuthProxyGroupsHeader: "X-Remote-Groups",
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.5:4000"
+	r.Header.Set("X-Remote-User", "admin")
+	r.Header.Set("X-Remote-Groups", "admins")
+	if user, groups, trusted := s.proxyIdentity(r); user != "" || groups != nil || trusted {
+		t.Errorf("untrusted peer got user %q, groups %v, trusted %v", user, groups, trusted)
+	}
+	if r.Header.Get("X-Remote-User") != "" || r.Header.Get("X-Remote-Groups") != "" {
+		t.Error("forged identity headers left on the request")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Remote-User", "alice")
+	r.Header.Set("X-Remote-Groups", "staff, ops")
+	if user, groups, trusted := s.proxyIdentity(r); user != "alice" || len(groups) != 2 || !trusted {
+		t.Errorf("trusted proxy got user %q, groups %v, trusted %v", user, groups, trusted)
+	}
+}
@@ -0,0 +1,169 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	recentDefaultWindow = 7 * 24 * time.Hour
+	recentDefaultLimit  = 200
+	recentMaxLimit      = 1000
+	recentMaxScanned    = 200000
+	// A walk is reused for this long, so reloading the page or paging
+	// through windows does not rescan the tree
+	recentCacheTTL = time.Minute
+)
+
+type recentResult struct {
+	Window    string     `json:"window"`
+	Files     []apiEntry `json:"files"`
+	Truncated bool       `json:"truncated"` // walk ran out of time or entries
+}
+
+// recentCache holds the files seen by the last walk, newest first, within
+// the largest window asked for so far.
+type recentCache struct {
+	mu        sync.Mutex
+	at        time.Time
+	window    time.Duration
+	files     []apiEntry
+	truncated bool
+}
+
+// parseWindow accepts time.ParseDuration values plus whole days ("7d").
+func parseWindow(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid window %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid window %q", v)
+	}
+	return d, nil
+}
+
+// recentFiles returns files under the root modified within window, newest
+// first. The walk is bounded by ctx and recentMaxScanned.
+func (s *Server) recentFiles(ctx context.Context, window time.Duration) ([]apiEntry, bool) {
+	c := &s.recent
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.at) < recentCacheTTL && c.window >= window {
+		return filterRecent(c.files, window), c.truncated
+	}
+
+	cutoff := time.Now().Add(-window)
+	files := []apiEntry{}
+	scanned, truncated := 0, false
+	// Cached for everyone, so filtered by the users file per request
+	err := s.apiWalk(context.WithValue(ctx, aclUnfilteredKey, true), s.rootDir, func(p string, d fs.DirEntry) error {
+		if scanned++; scanned > recentMaxScanned {
+			truncated = true
+			return fs.SkipAll
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().After(cutoff) {
+			files = append(files, newAPIEntry("/"+p, info))
+		}
+		return nil
+	})
+	if err != nil {
+		truncated = true
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.After(files[j].ModTime) })
+
+	// A client that went away says nothing about the tree
+	if ctx.Err() != context.Canceled {
+		c.at, c.window, c.files, c.truncated = time.Now(), window, files, truncated
+	}
+	return files, truncated
+}
+
+func filterRecent(files []apiEntry, window time.Duration) []apiEntry {
+	cutoff := time.Now().Add(-window)
+	// Sorted newest first, so the window is a prefix
+	n := sort.Search(len(files), func(i int) bool { return !files[i].ModTime.After(cutoff) })
+	return files[:n]
+}
+
+func (s *Server) handleRecent(w http.ResponseWriter, r *http.Request) {
+	// The walk skips locked directories below the root, not the root
+	if !s.checkDirAccess(w, r, "/") {
+		return
+	}
+	query := r.URL.Query()
+	window := recentDefaultWindow
+	if v := query.Get("window"); v != "" {
+		var err error
+		if window, err = parseWindow(v); err != nil {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid window parameter", v)
+			return
+		}
+	}
+	limit := recentDefaultLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid limit parameter", v)
+			return
+		}
+		limit = min(n, recentMaxLimit)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.Timeouts.DirRead)
+	defer cancel()
+	files, truncated := s.recentFiles(ctx, window)
+	files = s.readable(r, files)
+	if len(files) > limit {
+		files, truncated = files[:limit], true
+	}
+	result := recentResult{Window: query.Get("window"), Files: files, Truncated: truncated}
+	if result.Window == "" {
+		result.Window = "7d"
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			reqLogf(r, levelWarn, areaRequest, "Failed to write recent files: %v", err)
+		}
+		return
+	}
+
+	type row struct {
+		URL     string
+		Path    string
+		SizeStr string
+		ModStr  string
+		ModTime time.Time
+	}
+	rows := make([]row, len(files))
+	for i, f := range files {
+		rows[i] = row{basePath(r) + urlPath(f.Path), f.Path, s.formatSize(f.Size), s.formatModTime(f.ModTime), f.ModTime}
+	}
+	data := struct {
+		Window    string
+		Rows      []row
+		Truncated bool
+	}{result.Window, rows, truncated}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.template.ExecuteTemplate(w, "recent.html", data); err != nil {
+		reqLogf(r, levelError, areaRequest, "Template execution error: %v", err)
+	}
+}
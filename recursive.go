@@ -0,0 +1,80 @@
+package fileserver
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Caps for ?recursive=1 listings
+const (
+	recursiveMaxDepth   = 16
+	recursiveMaxEntries = 20000
+)
+
+// expandRecursive turns the listing of fullPath into a flat list of every
+// file below it, named by path relative to it, sorted by that path.
+// truncated reports that a cap or the context cut the walk short.
+func (s *Server) expandRecursive(ctx context.Context, r *http.Request, fullPath, requestPath string, top []FileInfo, namesOnly bool) (files []FileInfo, truncated bool) {
+	truncated = s.walkRecursive(ctx, r, fullPath, requestPath, top, namesOnly, func(f FileInfo) {
+		if !f.IsDir {
+			files = append(files, f)
+		}
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if a, b := strings.ToLower(files[i].Name), strings.ToLower(files[j].Name); a != b {
+			return a < b
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files, truncated
+}
+
+// walkRecursive calls visit for every entry below fullPath, directories
+// included, named by path relative to it. The walk is breadth-first and
+// holds only the directories still to descend into, so callers streaming
+// the entries out never have the whole tree in memory. Each directory goes
+// through listFiles, so hidden files, notes and listing hooks apply exactly
+// as in normal listings, namesOnly included.
+func (s *Server) walkRecursive(ctx context.Context, r *http.Request, fullPath, requestPath string, top []FileInfo, namesOnly bool, visit func(FileInfo)) (truncated bool) {
+	type dir struct {
+		rel   string
+		depth int
+		files []FileInfo
+	}
+	queue := []dir{{"", 1, top}}
+	seen := 0
+	for len(queue) > 0 && !truncated {
+		d := queue[0]
+		queue = queue[1:]
+		for _, f := range d.files {
+			if seen++; seen > recursiveMaxEntries {
+				truncated = true
+				break
+			}
+			name := path.Join(d.rel, f.Name)
+			f.Name = name
+			visit(f)
+			if !f.IsDir || s.lockedSubdir(requestPath+name, true) {
+				continue
+			}
+			if d.depth == recursiveMaxDepth || ctx.Err() != nil {
+				truncated = true
+				continue
+			}
+			sub := filepath.Join(fullPath, filepath.FromSlash(name))
+			entries, err := s.dirReader.read(ctx, sub)
+			if err != nil {
+				reqLogf(r, levelDebug, areaListing, "Failed to read %s in recursive listing: %v", sub, err)
+				truncated = truncated || ctx.Err() != nil || err == errTooManyDirReads
+				continue
+			}
+			subFiles, _, _ := s.listFiles(r, sub, requestPath+name+"/", entries, namesOnly)
+			queue = append(queue, dir{name, d.depth + 1, subFiles})
+		}
+	}
+	return truncated
+}
@@ -0,0 +1,72 @@
+package fileserver
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeAgo(t *testing.T) {
+	now := time.Date(2024, 11, 3, 9, 12, 44, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{119 * time.Second, "1 minute ago"},
+		{2 * time.Minute, "2 minutes ago"},
+		{59*time.Minute + 59*time.Second, "59 minutes ago"},
+		{time.Hour, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{day, "1 day ago"},
+		{6 * day, "6 days ago"},
+		{7 * day, "1 week ago"},
+		{29 * day, "4 weeks ago"},
+		{30 * day, "1 month ago"},
+		{364 * day, "12 months ago"},
+		{365 * day, "1 year ago"},
+		{3 * 365 * day, "3 years ago"},
+		{-30 * time.Second, "just now"},
+		{-time.Minute, "in 1 minute"},
+		{-2 * day, "in 2 days"},
+		{-14 * day, "in 2 weeks"},
+	}
+	for _, tt := range tests {
+		if got := timeAgoAt(now.Add(-tt.age), now); got != tt.want {
+			t.Errorf("timeAgoAt(now - %v) = %q, want %q", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestFormatModTimeCutover(t *testing.T) {
+	s, _ := newTestServer(t, Config{RelativeTimes: true, RelativeTimesMax: 48 * time.Hour})
+	recent := time.Now().Add(-3 * time.Hour)
+	old := time.Now().Add(-72 * time.Hour)
+	future := time.Now().Add(72 * time.Hour)
+	if got := s.formatModTime(recent); got != "3 hours ago" {
+		t.Errorf("recent time = %q", got)
+	}
+	for _, tm := range []time.Time{old, future} {
+		if got, want := s.formatModTime(tm), tm.Format("2006-01-02 15:04:05"); got != want {
+			t.Errorf("time beyond -relative-times-max = %q, want %q", got, want)
+		}
+	}
+
+	// JSON keeps RFC 3339 whatever the HTML shows
+	s, h := newTestServer(t, Config{RelativeTimes: true})
+	writeFiles(t, s.rootDir, map[string]string{"f.txt": ""})
+	w := serve(h, http.MethodGet, apiV1Prefix+"list?path=/", nil)
+	if strings.Contains(w.Body.String(), "ago") || !regexp.MustCompile(`"modTime":"\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d`).MatchString(w.Body.String()) {
+		t.Errorf("JSON listing with -relative-times: %s", w.Body)
+	}
+
+	s, _ = newTestServer(t, Config{})
+	if got := s.formatModTime(recent); strings.Contains(got, "ago") {
+		t.Errorf("relative time %q without -relative-times", got)
+	}
+}
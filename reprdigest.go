@@ -0,0 +1,68 @@
+package fileserver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// setReprDigest adds RFC 9530 Repr-Digest and the legacy RFC 3230 Digest
+// to a file response when the SHA-256 of the file is in the checksum cache
+// for its current size and mtime. Both describe the whole file, so they
+// stay correct on 206 responses; Content-Digest, which would cover only
+// the range sent, is never set. A client sending Want-Repr-Digest or
+// Want-Digest for sha-256 gets the digest computed on the spot for files
+// up to ReprDigestComputeMax, and cached like ?checksum=sha256 so it is
+// not hashed again.
+func (s *Server) setReprDigest(w http.ResponseWriter, r *http.Request, fullPath string, info fs.FileInfo) {
+	if !s.config.ReprDigest {
+		return
+	}
+	sum, ok := s.digests.cached(fullPath, info)
+	if !ok && info.Size() <= s.config.ReprDigestComputeMax && wantsSHA256(r) {
+		done := requestTiming(r).track("hash")
+		var err error
+		sum, err = s.digests.sha256(r.Context(), s.storage, fullPath, info)
+		done()
+		if err != nil {
+			reqLogf(r, levelDebug, areaIO, "Failed to hash %s for Repr-Digest: %v", fullPath, err)
+			return
+		}
+		ok = true
+	}
+	if !ok {
+		return
+	}
+	raw, err := hex.DecodeString(sum)
+	if err != nil {
+		return
+	}
+	b64 := base64.StdEncoding.EncodeToString(raw)
+	w.Header().Set("Repr-Digest", "sha-256=:"+b64+":")
+	w.Header().Set("Digest", "SHA-256="+b64)
+}
+
+// wantsSHA256 reports whether Want-Repr-Digest ("sha-256=5, sha-512=3")
+// or the legacy Want-Digest ("SHA-256;q=0.3") asks for sha-256 with a
+// non-zero preference.
+func wantsSHA256(r *http.Request) bool {
+	for _, member := range strings.Split(r.Header.Get("Want-Repr-Digest"), ",") {
+		key, pref, _ := strings.Cut(strings.TrimSpace(member), "=")
+		if strings.EqualFold(key, "sha-256") && strings.TrimSpace(pref) != "0" {
+			return true
+		}
+	}
+	for _, member := range strings.Split(r.Header.Get("Want-Digest"), ",") {
+		algo, params, _ := strings.Cut(strings.TrimSpace(member), ";")
+		if !strings.EqualFold(strings.TrimSpace(algo), "sha-256") {
+			continue
+		}
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok || strings.Trim(q, "0.") != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	// Set on walks whose results are filtered per request afterwards
+	aclUnfilteredKey
+	// Overrides the requester a walk is filtered for
+	aclUserKey
+)
+
+// requestInfo carries per-request state that inner handlers fill in and
+// outer middleware (such as the access log) reads after the fact.
+type requestInfo struct {
+	id       string
+	clientIP string
+	scheme   string
+	identity string
+	groups   []string // from OIDC or an auth proxy, for users file rules
+	basePath string
+	logger   *slog.Logger
+	timing   *serverTiming
+	span     *span // nil unless traced
+	cacheHit bool  // body served from the memory cache
+}
+
+func (s *Server) withRequestInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// http.StripPrefix("/files/", ...) leaves paths without a leading slash
+		if !strings.HasPrefix(r.URL.Path, "/") {
+			u := *r.URL
+			u.Path = "/" + u.Path
+			if u.RawPath != "" {
+				u.RawPath = "/" + u.RawPath
+			}
+			r2 := *r
+			r2.URL = &u
+			r = &r2
+		}
+
+		s.requests.Add(1)
+		info := &requestInfo{basePath: s.config.BasePath + mountPrefix(r)}
+		info.clientIP, info.scheme = s.clientAddress(r)
+		info.id = s.requestIDFor(r)
+		info.logger = s.logger().With("req", info.id, "client", info.clientIP, "root", s.rootDir)
+		if s.vhost != "" {
+			info.logger = info.logger.With("vhost", s.vhost)
+		}
+		w.Header().Set("X-Request-Id", info.id)
+		ctx := context.WithValue(r.Context(), requestInfoKey, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func getRequestInfo(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// mountPrefix returns the part of the path that an enclosing
+// http.StripPrefix removed, by comparing the URL with the request line.
+func mountPrefix(r *http.Request) string {
+	orig, _, _ := strings.Cut(r.RequestURI, "?")
+	if !strings.HasPrefix(orig, "/") {
+		// Absolute form, as sent to proxies
+		u, err := url.Parse(orig)
+		if err != nil {
+			return ""
+		}
+		orig = u.EscapedPath()
+	}
+	escaped := r.URL.EscapedPath()
+	if !strings.HasSuffix(orig, escaped) {
+		return ""
+	}
+	return orig[:len(orig)-len(escaped)]
+}
+
+// basePath is the prefix of every URL the client sees: -base-path for a
+// prefix-stripping proxy plus any prefix stripped by an enclosing mux.
+func basePath(r *http.Request) string {
+	return getRequestInfo(r).basePath
+}
+
+// requestIDFor reuses an upstream X-Request-Id from a trusted proxy so logs
+// can be correlated, and otherwise generates a fresh one.
+func (s *Server) requestIDFor(r *http.Request) string {
+	if id := r.Header.Get("X-Request-Id"); id != "" && len(id) <= 64 {
+		if peer, ok := remoteAddrIP(r.RemoteAddr); ok && len(s.trustedProxies) > 0 && s.isTrustedProxy(peer) {
+			if strings.IndexFunc(id, func(c rune) bool { return c <= ' ' || c > '~' }) < 0 {
+				return id
+			}
+		}
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func requestID(r *http.Request) string {
+	if id := getRequestInfo(r).id; id != "" {
+		return id
+	}
+	return "-"
+}
+
+// clientIP returns the derived client address, honoring trusted proxies.
+func clientIP(r *http.Request) string {
+	if ip := getRequestInfo(r).clientIP; ip != "" {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// requestIdentity returns the authenticated identity, or "-" if none.
+func requestIdentity(r *http.Request) string {
+	if identity := getRequestInfo(r).identity; identity != "" {
+		return identity
+	}
+	return "-"
+}
@@ -0,0 +1,53 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Network mounts (NFS, CIFS) sometimes fail a single operation with an error
+// that a retry a moment later does not see. Only these are retried; missing
+// files and permission errors are answers, not glitches.
+var transientErrors = []error{syscall.ESTALE, syscall.EIO, syscall.EAGAIN, syscall.EINTR}
+
+// ioRetryDelays are the pauses before each retry.
+var ioRetryDelays = []time.Duration{20 * time.Millisecond, 60 * time.Millisecond}
+
+func isTransient(err error) bool {
+	for _, t := range transientErrors {
+		if errors.Is(err, t) {
+			return true
+		}
+	}
+	return false
+}
+
+type ioRetries struct {
+	retries   atomic.Int64 // attempts after the first
+	recovered atomic.Int64 // operations that succeeded on a retry
+	exhausted atomic.Int64 // operations still failing transiently after the last retry
+}
+
+// retryIO runs op, retrying transient errors with backoff until ctx ends.
+func retryIO[T any](ctx context.Context, counts *ioRetries, op func() (T, error)) (T, error) {
+	value, err := op()
+	for i := 0; err != nil && isTransient(err); i++ {
+		if i == len(ioRetryDelays) {
+			counts.exhausted.Add(1)
+			break
+		}
+		select {
+		case <-time.After(ioRetryDelays[i]):
+		case <-ctx.Done():
+			return value, err
+		}
+		counts.retries.Add(1)
+		if value, err = op(); err == nil {
+			counts.recovered.Add(1)
+		}
+	}
+	return value, err
+}
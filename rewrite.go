@@ -0,0 +1,176 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The rewrite rules file (-rewrite-rules) has one rule per line, tried
+// in order until one matches the request path:
+//
+//	# moved in 2023
+//	redirect 301 /old-releases/* /releases/$1
+//	rewrite /latest /releases/v2.3/
+//
+// A pattern matches the whole path; each * matches any run of characters,
+// slashes included, and is captured as $1 to $9 for the target. A redirect
+// target may also be an absolute URL. Redirects keep the query string,
+// rewrites serve the target path in place of the requested one.
+type rewriteRule struct {
+	line     int
+	redirect int // status, 0 for a rewrite
+	pattern  *regexp.Regexp
+	target   string
+}
+
+type rewriteRules struct {
+	file  string
+	rules []rewriteRule
+}
+
+var rewriteCapture = regexp.MustCompile(`\$([0-9])`)
+
+func loadRewriteRules(file string) (*rewriteRules, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rewrite rules: %v", err)
+	}
+	rules := &rewriteRules{file: file}
+	for n, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule, err := parseRewriteRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, n+1, err)
+		}
+		rule.line = n + 1
+		rules.rules = append(rules.rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRewriteRule(fields []string) (rewriteRule, error) {
+	var rule rewriteRule
+	switch {
+	case fields[0] == "redirect" && len(fields) == 4:
+		status, err := strconv.Atoi(fields[1])
+		if err != nil || status != 301 && status != 302 && status != 303 && status != 307 && status != 308 {
+			return rule, fmt.Errorf("invalid redirect status %q, want 301, 302, 303, 307 or 308", fields[1])
+		}
+		rule.redirect = status
+		fields = fields[2:]
+	case fields[0] == "rewrite" && len(fields) == 3:
+		fields = fields[1:]
+	case fields[0] == "redirect" || fields[0] == "rewrite":
+		return rule, errors.New("want \"redirect STATUS FROM TO\" or \"rewrite FROM TO\"")
+	default:
+		return rule, fmt.Errorf("unknown rule %q, want redirect or rewrite", fields[0])
+	}
+	from, to := fields[0], fields[1]
+	if !strings.HasPrefix(from, "/") {
+		return rule, fmt.Errorf("pattern %q must start with /", from)
+	}
+	parts := strings.Split(from, "*")
+	if len(parts) > 10 {
+		return rule, fmt.Errorf("pattern %q has more than 9 wildcards", from)
+	}
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	rule.pattern = regexp.MustCompile("^" + strings.Join(parts, "(.*)") + "$")
+	absolute := strings.HasPrefix(to, "http://") || strings.HasPrefix(to, "https://")
+	if !strings.HasPrefix(to, "/") && !(absolute && rule.redirect != 0) {
+		return rule, fmt.Errorf("target %q must start with / (or be an absolute URL for a redirect)", to)
+	}
+	for _, m := range rewriteCapture.FindAllStringSubmatch(to, -1) {
+		if n, _ := strconv.Atoi(m[1]); n == 0 || n >= len(parts) {
+			return rule, fmt.Errorf("target %q uses %s, but the pattern has %d wildcards", to, m[0], len(parts)-1)
+		}
+	}
+	rule.target = to
+	return rule, nil
+}
+
+// match returns the first rule matching requestPath and its target with
+// the captures filled in.
+func (rs *rewriteRules) match(requestPath string) (rewriteRule, string, bool) {
+	for _, rule := range rs.rules {
+		m := rule.pattern.FindStringSubmatch(requestPath)
+		if m == nil {
+			continue
+		}
+		target := rewriteCapture.ReplaceAllStringFunc(rule.target, func(ref string) string {
+			n, _ := strconv.Atoi(ref[1:])
+			return m[n]
+		})
+		return rule, target, true
+	}
+	return rewriteRule{}, "", false
+}
+
+// applyRewriteRules answers a request matching a redirect rule and
+// returns true, or returns r with its path replaced for a rewrite rule.
+// Rewritten paths are cleaned, and go through the same checks as
+// requested ones afterwards.
+func (s *Server) applyRewriteRules(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	rules := s.rewrites.Load()
+	if rules == nil {
+		return r, false
+	}
+	rule, target, ok := rules.match(r.URL.Path)
+	if !ok {
+		return r, false
+	}
+	if rule.redirect == 0 {
+		u := *r.URL
+		u.Path, u.RawPath = cleanURLPath(target), ""
+		reqLogf(r, levelDebug, areaRequest, "Rewrote %s to %s by %s:%d", r.URL.Path, u.Path, rules.file, rule.line)
+		r2 := *r
+		r2.URL = &u
+		return &r2, false
+	}
+
+	location, query, _ := strings.Cut(target, "?")
+	if strings.HasPrefix(location, "/") {
+		location = basePath(r) + urlPath(location)
+	}
+	if r.URL.RawQuery != "" {
+		query = strings.TrimPrefix(query+"&"+r.URL.RawQuery, "&")
+	}
+	if query != "" {
+		location += "?" + query
+	}
+	reqLogf(r, levelDebug, areaRequest, "Redirecting %s to %s by %s:%d", r.URL.Path, location, rules.file, rule.line)
+	w.Header().Set("Location", location)
+	w.WriteHeader(rule.redirect)
+	return r, true
+}
+
+// ReloadRewriteRules re-reads the rewrite rules of the server and its
+// virtual hosts (on SIGHUP). A file that fails to load leaves the
+// previous rules in place.
+func (s *Server) ReloadRewriteRules() error {
+	var errs []error
+	for _, host := range s.virtualHostNames() {
+		if err := s.vhosts[host].ReloadRewriteRules(); err != nil {
+			errs = append(errs, fmt.Errorf("virtual host %s: %v", host, err))
+		}
+	}
+	if old := s.rewrites.Load(); old != nil {
+		rules, err := loadRewriteRules(old.file)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			s.rewrites.Store(rules)
+			infof(areaServer, "Reloaded rewrite rules %s: %d rules", rules.file, len(rules.rules))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,422 @@
+package fileserver
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	s3MaxAttempts    = 4
+)
+
+var errNoCredentials = errors.New("no AWS credentials found: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, configure ~/.aws/credentials, or run with an instance role")
+
+type awsCredentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	expires      time.Time // zero for static credentials
+}
+
+// credentialChain resolves credentials the way the AWS SDKs do: environment,
+// shared credentials file, then the EC2 instance metadata service. Instance
+// credentials are refreshed shortly before they expire.
+type credentialChain struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	creds *awsCredentials
+}
+
+func (c *credentialChain) get(ctx context.Context) (*awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds != nil && (c.creds.expires.IsZero() || time.Until(c.creds.expires) > 5*time.Minute) {
+		return c.creds, nil
+	}
+
+	if key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); key != "" && secret != "" {
+		c.creds = &awsCredentials{accessKey: key, secretKey: secret, sessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+		return c.creds, nil
+	}
+	if creds, err := sharedCredentials(); err != nil {
+		return nil, err
+	} else if creds != nil {
+		c.creds = creds
+		return c.creds, nil
+	}
+	creds, err := c.instanceCredentials(ctx)
+	if err != nil {
+		debugf(areaIO, "Instance metadata credentials unavailable: %v", err)
+		return nil, errNoCredentials
+	}
+	c.creds = creds
+	return c.creds, nil
+}
+
+// sharedCredentials reads the AWS_PROFILE (or default) section of the shared
+// credentials file. A missing file is not an error.
+func sharedCredentials() (*awsCredentials, error) {
+	file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials file: %v", err)
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	var creds awsCredentials
+	section := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != profile {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.accessKey = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.secretKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.sessionToken = strings.TrimSpace(value)
+		}
+	}
+	if creds.accessKey == "" || creds.secretKey == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+func (c *credentialChain) instanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	fetch := func(method, path, token string) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, method, imds+path, nil)
+		if err != nil {
+			return "", err
+		}
+		if token == "" {
+			req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+		} else {
+			req.Header.Set("X-aws-ec2-metadata-token", token)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+		return strings.TrimSpace(string(body)), err
+	}
+
+	token, err := fetch(http.MethodPut, "/api/token", "")
+	if err != nil {
+		return nil, err
+	}
+	role, err := fetch(http.MethodGet, "/meta-data/iam/security-credentials/", token)
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	doc, err := fetch(http.MethodGet, "/meta-data/iam/security-credentials/"+role, token)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(doc), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse instance credentials: %v", err)
+	}
+	return &awsCredentials{result.AccessKeyId, result.SecretAccessKey, result.Token, result.Expiration}, nil
+}
+
+// s3Client is a minimal S3 REST client: signed GET/HEAD requests with
+// retries on throttling. Custom endpoints (MinIO and friends) use
+// path-style addressing.
+type s3Client struct {
+	bucket    string
+	region    string
+	endpoint  *url.URL
+	pathStyle bool
+	creds     *credentialChain
+	client    *http.Client
+}
+
+func newS3Client(bucket string) (*s3Client, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	c := &s3Client{bucket: bucket, region: region, client: &http.Client{}}
+	c.creds = &credentialChain{client: c.client}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+		}
+		c.endpoint, c.pathStyle = u, true
+	} else {
+		c.endpoint = &url.URL{Scheme: "https", Host: bucket + ".s3." + region + ".amazonaws.com"}
+	}
+	return c, nil
+}
+
+// do sends a signed request for key (empty for the bucket itself). Throttling
+// and server errors are retried with jittered exponential backoff; the caller
+// owns the response body.
+func (c *s3Client) do(ctx context.Context, method, key string, query url.Values, header http.Header) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	if c.pathStyle {
+		u.Path += c.bucket + "/"
+	}
+	u.Path += key
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	for attempt := 1; ; attempt++ {
+		creds, err := c.creds.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		c.sign(req, creds, time.Now().UTC())
+
+		resp, err := c.client.Do(req)
+		retry := err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500)
+		if (err == nil && !retry) || attempt == s3MaxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			debugf(areaIO, "Retrying S3 %s %s after %s (attempt %d)", method, key, resp.Status, attempt)
+		} else {
+			debugf(areaIO, "Retrying S3 %s %s after error: %v (attempt %d)", method, key, err, attempt)
+		}
+
+		backoff := time.Duration(100<<attempt) * time.Millisecond
+		backoff += rand.N(backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sign adds AWS Signature Version 4 headers to req.
+func (c *s3Client) sign(req *http.Request, creds *awsCredentials, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + c.region + "/s3/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the SigV4 unreserved characters.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' || strings.IndexByte("-_.~", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Error turns a failed response into an error, including S3's error code.
+func s3Error(resp *http.Response) error {
+	defer resp.Body.Close()
+	var body struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+	if body.Code != "" {
+		return fmt.Errorf("S3 %s: %s: %s", resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 %s", resp.Status)
+}
+
+type s3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+type s3ListResult struct {
+	Contents              []s3Object
+	CommonPrefixes        []struct{ Prefix string }
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns every object and common prefix directly under prefix,
+// following continuation tokens past the 1000-key page limit.
+func (c *s3Client) list(ctx context.Context, prefix string, limit int) ([]s3Object, []string, error) {
+	var objects []s3Object
+	var prefixes []string
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "delimiter": {"/"}, "prefix": {prefix}}
+		if limit > 0 {
+			query.Set("max-keys", fmt.Sprint(limit))
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, s3Error(resp)
+		}
+		var page s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse S3 listing: %v", err)
+		}
+		objects = append(objects, page.Contents...)
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" || limit > 0 {
+			return objects, prefixes, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
@@ -0,0 +1,301 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3Storage serves a bucket prefix given as -root s3://bucket/prefix.
+// Directories are the common prefixes of a "/"-delimited listing; file
+// bodies are ranged GetObject streams, so seeking maps straight onto Range.
+type s3Storage struct {
+	root   string // synthetic absolute root, "/bucket/prefix"
+	prefix string // key prefix, empty or ending in "/"
+	client *s3Client
+
+	// HeadBucket result, cached so per-request health checks stay cheap
+	healthMu  sync.Mutex
+	healthErr error
+	healthAt  time.Time
+}
+
+const s3HealthTTL = 5 * time.Second
+
+func isS3Root(root string) bool {
+	return strings.HasPrefix(root, "s3://")
+}
+
+func openS3Storage(root string) (*s3Storage, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(root, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("invalid S3 root %q, want s3://bucket/prefix", root)
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	client, err := newS3Client(bucket)
+	if err != nil {
+		return nil, err
+	}
+	st := &s3Storage{root: "/" + path.Join(bucket, prefix), prefix: prefix, client: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := client.creds.get(ctx); err != nil {
+		return nil, err
+	}
+	if err := st.headBucket(ctx); err != nil {
+		return nil, fmt.Errorf("cannot access S3 bucket %s: %v", bucket, err)
+	}
+	return st, nil
+}
+
+// key maps a full path under the root to an object key.
+func (st *s3Storage) key(full string) (string, error) {
+	rel, err := filepath.Rel(st.root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fs.ErrNotExist
+	}
+	if rel == "." {
+		return st.prefix, nil
+	}
+	return st.prefix + filepath.ToSlash(rel), nil
+}
+
+func (st *s3Storage) headBucket(ctx context.Context) error {
+	resp, err := st.client.do(ctx, http.MethodHead, "", nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fmt.Errorf("HeadBucket: %s", resp.Status)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// checkHealth replaces the mount-point probe with a cached HeadBucket.
+func (st *s3Storage) checkHealth(ctx context.Context) error {
+	st.healthMu.Lock()
+	defer st.healthMu.Unlock()
+	if time.Since(st.healthAt) < s3HealthTTL {
+		return st.healthErr
+	}
+	st.healthErr = st.headBucket(ctx)
+	if ctx.Err() == nil {
+		st.healthAt = time.Now()
+	}
+	return st.healthErr
+}
+
+func (st *s3Storage) Stat(full string) (fs.FileInfo, error) {
+	return st.stat(context.Background(), full)
+}
+
+func (st *s3Storage) stat(ctx context.Context, full string) (*s3FileInfo, error) {
+	key, err := st.key(full)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: err}
+	}
+	name := path.Base(filepath.ToSlash(full))
+	if key == st.prefix {
+		return &s3FileInfo{name: name, dir: true}, nil
+	}
+
+	resp, err := st.client.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: err}
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return &s3FileInfo{name: name, size: size, modTime: modTime}, nil
+	case http.StatusNotFound:
+		// Not an object; a directory if anything lives under it
+	case http.StatusForbidden:
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: fs.ErrPermission}
+	default:
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: fmt.Errorf("HeadObject: %s", resp.Status)}
+	}
+
+	objects, prefixes, err := st.client.list(ctx, key+"/", 1)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: err}
+	}
+	if len(objects) == 0 && len(prefixes) == 0 {
+		return nil, &fs.PathError{Op: "stat", Path: full, Err: fs.ErrNotExist}
+	}
+	return &s3FileInfo{name: name, dir: true}, nil
+}
+
+func (st *s3Storage) ReadDir(full string) ([]fs.DirEntry, error) {
+	key, err := st.key(full)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: full, Err: err}
+	}
+	if key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	objects, prefixes, err := st.client.list(context.Background(), key, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: full, Err: err}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(objects)+len(prefixes))
+	for _, p := range prefixes {
+		entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{name: path.Base(p), dir: true}))
+	}
+	for _, obj := range objects {
+		if obj.Key == key {
+			continue // "directory marker" objects created by consoles
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(&s3FileInfo{name: path.Base(obj.Key), size: obj.Size, modTime: obj.LastModified}))
+	}
+	return entries, nil
+}
+
+func (st *s3Storage) Open(full string) (fs.File, error) {
+	info, err := st.stat(context.Background(), full)
+	if err != nil {
+		return nil, err
+	}
+	key, _ := st.key(full)
+	return &s3File{st: st, key: key, info: info}, nil
+}
+
+func (st *s3Storage) Sub(full string) (fs.FS, error) {
+	return storageFS{st, full}, nil
+}
+
+type s3FileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *s3FileInfo) Name() string       { return fi.name }
+func (fi *s3FileInfo) Size() int64        { return fi.size }
+func (fi *s3FileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *s3FileInfo) IsDir() bool        { return fi.dir }
+func (fi *s3FileInfo) Sys() any           { return nil }
+func (fi *s3FileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// s3File reads an object through ranged GETs. The body is opened lazily at
+// the current offset, so a Seek before the first Read costs nothing and a
+// Range request becomes a single ranged GetObject.
+type s3File struct {
+	st     *s3Storage
+	key    string
+	info   *s3FileInfo
+	offset int64
+	body   io.ReadCloser
+}
+
+func (f *s3File) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.info.dir {
+		return 0, &fs.PathError{Op: "read", Path: f.key, Err: errors.New("is a directory")}
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		header := http.Header{"Range": {fmt.Sprintf("bytes=%d-", f.offset)}}
+		resp, err := f.st.client.do(context.Background(), http.MethodGet, f.key, nil, header)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
+			return 0, s3Error(resp)
+		}
+		f.body = resp.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek offset")
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// storageFS adapts a subtree of a storage to fs.FS for code that walks
+// trees, such as archive downloads.
+type storageFS struct {
+	st   storage
+	root string
+}
+
+func (s storageFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+func (s storageFS) Open(name string) (fs.File, error) {
+	full, err := s.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.st.Open(full)
+}
+
+func (s storageFS) Stat(name string) (fs.FileInfo, error) {
+	full, err := s.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.st.Stat(full)
+}
+
+func (s storageFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	full, err := s.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.st.ReadDir(full)
+}
@@ -0,0 +1,64 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// contentDisposition builds a Content-Disposition value, falling back to the
+// RFC 2231 filename* form for names that are not plain ASCII.
+func contentDisposition(disposition, name string) string {
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return disposition
+}
+
+// detectContentType mirrors what ServeContent would pick: the extension first,
+// then a sniff of the first 512 bytes.
+func detectContentType(r *http.Request, file *os.File, name string) string {
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		return ctype
+	}
+	var buf [512]byte
+	n, _ := io.ReadFull(file, buf[:])
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		reqLogf(r, levelWarn, areaIO, "Failed to rewind %s after sniffing: %v", file.Name(), err)
+	}
+	return http.DetectContentType(buf[:n])
+}
+
+// serveSendfile hands the transfer to the fronting proxy via an internal
+// redirect header. All access checks have already run; the proxy owns the
+// body, Content-Length, and Range handling.
+func (s *Server) serveSendfile(w http.ResponseWriter, r *http.Request, file *os.File, fullPath string, info os.FileInfo) {
+	relPath, err := filepath.Rel(s.rootDir, fullPath)
+	if err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Internal server error", fmt.Sprintf("relative path for %s: %v", fullPath, err))
+		return
+	}
+
+	var target string
+	if strings.EqualFold(s.config.SendfileHeader, "X-Sendfile") {
+		// Apache mod_xsendfile and lighttpd expect a filesystem path
+		target = fullPath
+	} else {
+		target = strings.TrimSuffix(s.config.SendfilePrefix, "/") + (&url.URL{Path: "/" + filepath.ToSlash(relPath)}).EscapedPath()
+	}
+
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", detectContentType(r, file, info.Name()))
+	}
+	if w.Header().Get("Content-Disposition") == "" {
+		w.Header().Set("Content-Disposition", contentDisposition("inline", info.Name()))
+	}
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set(s.config.SendfileHeader, target)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,1837 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+	SizeStr string
+	ModStr  string
+
+	// Entries in a directory with -dir-counts, up to dirCountCap with
+	// MoreItems set beyond it; -1 when it could not be read
+	Items     int
+	MoreItems bool
+
+	// Modified within -highlight-recent
+	IsRecent bool
+
+	// Category section with ?group=type, see listingOrder
+	Group string
+
+	// From a sidecar file with -descriptions, see describe
+	Description string
+
+	// EXIF capture time of an image with ?sort=exifdate, see
+	// setCaptureTimes
+	Captured time.Time
+
+	info    fs.FileInfo // as read from the directory, for ?format=csv
+	counted bool        // Items is set
+}
+
+type PageData struct {
+	Title       string
+	BasePath    string // prefix for links, see basePath
+	CurrentPath string
+	ParentPath  string
+	Files       []FileInfo
+	Error       string
+	Header      template.HTML // HEADER.html, see readDirNote
+	Footer      template.HTML
+	Assets      map[string]string // static/ file name -> hashed URL
+	Categories  []CategoryChip    // ?type= filter toggles
+	GroupCounts map[string]int    // entries per Group with ?group=type
+	FilterQuery string            // "?type=...&glob=..." to keep the filter on links, or ""
+	Glob        string            // active ?glob= pattern
+	GlobClear   string            // query string without the glob
+	Recursive   bool              // ?recursive=1: every file below, Name is the relative path
+	Truncated   bool              // the recursive walk hit its limits
+	NamesOnly   bool              // rows carry names only, no size or time
+	DetailsURL  string            // set when NamesOnly is the large-directory fallback: the query string for full details
+	User        string            // authenticated identity, "" for anonymous
+	LoginURL    string            // login page, when anonymous and one exists
+	LogoutURL   string            // set for logged-in session users
+	Thumbnails  bool              // videos have ?thumb= thumbnails
+}
+
+type Config struct {
+	// A directory, or a file: a .zip or .tar is served as the tree inside
+	// it, anything else (or any file with SingleFile) alone at "/"
+	RootDir    string
+	SingleFile bool
+	// Roots served by Host header instead of RootDir; VhostStrict answers
+	// other hosts with 421 rather than serving RootDir
+	VirtualHosts []VirtualHost
+	VhostStrict  bool
+	// Open the system browser at the server once it accepts connections
+	OpenBrowser bool
+	Port        int
+	Addr        string // host:port, overrides Port
+	// Addresses to serve on, overriding Addr and Port: "HOST:PORT" (TLS
+	// when configured), "http://HOST:PORT", "https://HOST:PORT" or
+	// "unix:/path/to.sock"
+	Listen []string
+
+	// URL prefix a reverse proxy strips before forwarding, e.g. "/files"
+	BasePath string
+
+	// Authenticates every request after any client certificate check
+	Auth AuthFunc
+	// AuthModeAll (default) authenticates every request; AuthModeWriteOnly
+	// lets GET, HEAD and OPTIONS through anonymously and challenges only
+	// requests that change something
+	AuthMode string
+
+	// JSON file of users and per-path access rules, see usersFile; it
+	// provides Auth and Login and cannot be combined with them
+	UsersFile string
+
+	// Enables the /_login page and cookie sessions, checked before Auth.
+	// Sessions are HMAC-signed with SessionKey (random per start when
+	// empty, so a restart logs everyone out) and last SessionLifetime
+	Login           LoginFunc
+	SessionKey      []byte
+	SessionLifetime time.Duration
+	// Login through an OpenID Connect provider, enabled by OIDC.Issuer;
+	// it sets the same sessions and may be combined with the above
+	OIDC OIDCConfig
+
+	// Endpoints answered without authentication, so load balancers and
+	// monitoring need no credentials: nil for DefaultAuthBypass, "none"
+	// for no exceptions. Paths where no endpoint is registered, such as
+	// /metrics without Metrics, are ignored
+	AuthBypass []string
+
+	// Refuse to list or serve names starting with a dot
+	HideDotFiles bool
+	// Add mode, owner and group columns to ?format=csv listings
+	CSVOwners bool
+	// Send Repr-Digest and Digest headers when the file's SHA-256 is
+	// cached; clients asking with Want-Repr-Digest get it computed for
+	// files up to ReprDigestComputeMax bytes
+	ReprDigest           bool
+	ReprDigestComputeMax int64
+
+	// Bytes a ?sums=sha256 request may hash beyond cached digests
+	// (default DefaultSumsBudget)
+	SumsBudget int64
+	// Hosts (or *.domain) besides this server whose pages may embed files
+	// of HotlinkTypes ("image" for image/*, or full types; default image
+	// and video); other referers get 403, with HotlinkPlaceholder's
+	// content if set, unless the link is signed (PlaylistLinkLifetime).
+	// Empty disables the check
+	HotlinkAllow       []string
+	HotlinkTypes       []string
+	HotlinkPlaceholder string
+
+	// Accept PUT to store a file at the request path, for requests
+	// allowed to write (any request without a users file). Needs a local
+	// directory root
+	Uploads bool
+
+	// Executable run in the background after each successful PUT, with
+	// the server's privileges; at most OnUploadConcurrency at once, each
+	// killed after OnUploadTimeout (0: the defaults)
+	OnUploadCmd         string
+	OnUploadConcurrency int
+	OnUploadTimeout     time.Duration
+
+	// Executable making video thumbnails for ?thumb=SIZE, run with the
+	// server's privileges as "cmd INPUT OUTPUT SIZE"; at most
+	// ThumbnailerConcurrency at once, each killed after ThumbnailerTimeout
+	// (0: the defaults). Results are cached in ThumbnailCacheDir (default
+	// under the user cache directory)
+	ThumbnailerCmd         string
+	ThumbnailerConcurrency int
+	ThumbnailerTimeout     time.Duration
+	ThumbnailCacheDir      string
+
+	// Extensions listed by ?playlist=m3u (default: the audio category).
+	// With PlaylistLinkLifetime set and a user authenticated, playlist
+	// entries are signed links that work without credentials until then
+	PlaylistExtensions   []string
+	PlaylistLinkLifetime time.Duration
+
+	// Leave GPS coordinates out of ?exif=1 answers
+	ExifRedactGPS bool
+
+	// Permissions and owner ("user:group") for files and directories the
+	// server creates; zero values leave them to the umask and process
+	UploadFileMode fs.FileMode
+	UploadDirMode  fs.FileMode
+	UploadOwner    string
+
+	// Free space kept on the root's filesystem: writes that would go
+	// below the larger of the two are refused with 507
+	MinFreeBytes   int64
+	MinFreePercent float64
+
+	// Flag entries modified within this long in listings (0: off)
+	HighlightRecent time.Duration
+
+	// Show the number of entries of each subdirectory in listings, at the
+	// cost of a directory read per subdirectory. Counts are cached by
+	// mtime, and persisted in DirCountsFile when set, which "fileserver
+	// index" fills ahead of time
+	DirCounts     bool
+	DirCountsFile string
+
+	// HTML listings of directories with more entries than this show names
+	// only, with a link to full details (0: always full)
+	FastListingOver int
+
+	// Files in a ?format=atom feed (default DefaultFeedEntries)
+	FeedEntries int
+
+	// Serve /sitemap.xml listing every file, for public instances meant
+	// to be indexed
+	Sitemap bool
+	// Serve /_grep, which reads file contents across the tree, up to its
+	// budgets, on every search
+	Grep bool
+	// External URL including any base path, e.g. https://files.example.org,
+	// for absolute links that must not depend on the Host header
+	PublicURL string
+
+	// Show sizes in base-1000 kB/MB/GB instead of base-1024 KiB/MiB/GiB
+	SISizes bool
+	// Show sizes as exact byte counts; overrides SISizes
+	ExactSizes bool
+
+	// Show modification times as "3 hours ago" up to RelativeTimesMax
+	// (default DefaultRelativeTimesMax), absolute dates beyond
+	RelativeTimes    bool
+	RelativeTimesMax time.Duration
+
+	// Retry failed lookups in the other Unicode normalization form
+	NormalizeUnicode bool
+
+	// TLS and mutual TLS client authentication
+	TLSCertFile  string
+	TLSKeyFile   string
+	ClientCAFile string
+	ClientAllow  []string
+
+	// Handshake policy: minimum version ("1.2" or "1.3", default 1.2),
+	// TLS 1.2 cipher suites by Go name and curves ("X25519", "P-256", ...)
+	// in preference order. Empty means Go's defaults
+	TLSMinVersion   string
+	TLSCipherSuites []string
+	TLSCurves       []string
+
+	// Peers allowed to set X-Forwarded-For / X-Forwarded-Proto
+	TrustedProxies []string
+	// Header in which a trusted proxy that authenticates users names
+	// them, e.g. X-Remote-User, and the comma-separated groups header for
+	// users file rules. Requests from the proxy without it are anonymous;
+	// from other peers both headers are removed and ignored
+	AuthProxyHeader       string
+	AuthProxyGroupsHeader string
+
+	// Offload file bodies to a fronting proxy, e.g. X-Accel-Redirect
+	SendfileHeader string
+	SendfilePrefix string
+
+	// Large directory archives are spooled to disk so downloads can resume
+	ArchiveSpoolDir string
+	ArchiveSpoolMin int64
+	ArchiveSpoolTTL time.Duration
+
+	// How long progressing transfers may continue after shutdown begins
+	DrainTimeout time.Duration
+
+	Timeouts Timeouts
+
+	// In-memory cache for small files; 0 disables
+	CacheSize    int64
+	CacheMaxFile int64
+
+	// Entry cap and optional persistence for cached file digests
+	ChecksumCacheSize int
+	ChecksumCacheFile string
+
+	// Cap on background ReadDir calls that may be stuck on a hung mount
+	MaxDirReads int
+	// Cap on background stat and open calls, likewise
+	MaxFSCalls int
+	// Directory entries stat'ed at once for a listing (default
+	// DefaultStatWorkers)
+	StatWorkers int
+
+	// How file bodies are read; the zero value suits most disks
+	IO IOTuning
+
+	// Cap on open client connections; further ones wait to be accepted
+	// (0: no limit). The health listener is not counted
+	MaxConns int
+	// Cap on concurrent requests per client address, answered with 429
+	// beyond it (0: no limit), and CIDRs exempt from it
+	MaxConnsPerIP    int
+	PerIPLimitExempt []string
+
+	// New downloads get 503 while fewer file descriptors than this are
+	// free (0: off)
+	MinFreeFDs int
+
+	// How often the storage is probed (default DefaultMountCheckInterval)
+	MountCheckInterval time.Duration
+
+	// Concurrent ?tail=1 streams; 0 disables tailing
+	MaxTailSessions int
+
+	// Expose Prometheus metrics at /metrics
+	Metrics bool
+	// Expose server state at /_status on the public listener; the
+	// -health-addr listener always has it
+	Status bool
+
+	// Per-path download statistics served at /_stats/top
+	Stats       bool
+	StatsFile   string
+	StatsWindow time.Duration
+
+	// Per-client daily byte counts, reported on the health/admin listener
+	Bandwidth          bool
+	BandwidthFile      string
+	BandwidthRetention int
+
+	// Files served instead of a listing, tried in order; empty disables
+	IndexFiles []string
+
+	// Paths answered 404 and left out of listings, see denyPattern; nil
+	// uses DefaultDeny, an empty slice denies nothing. DirAccessFile and
+	// upload temp files are always denied
+	Deny []string
+
+	// Require the passphrase in a directory's DirAccessFile for it and
+	// everything below
+	DirPasswords bool
+
+	// Serve HTML and SVG as-is; otherwise they are sandboxed with a
+	// Content-Security-Policy except under the TrustedHTML URL prefixes
+	RenderHTML  bool
+	TrustedHTML []string
+	// Extensions always sent as attachments outside TrustedHTML, e.g. ".html"
+	ForceDownloadExt []string
+
+	// Larger files are refused with 403 and left out of archives; 0 means
+	// no limit. Overrides map URL prefixes to their own limit (0 for none).
+	MaxFileSize          int64
+	MaxFileSizeOverrides map[string]int64
+
+	// URL prefix for the embedded stylesheets (default /_assets/)
+	AssetsPrefix string
+
+	// Templates overriding the embedded ones; TemplateDir is read from disk
+	Templates   fs.FS
+	TemplateDir string
+
+	// Redirect and rewrite rules, see rewriteRule; reloaded on SIGHUP
+	RewriteRules string
+
+	// HEADER.html/FOOTER.html handling: off, text or html (trusted)
+	DirNotes string
+
+	// Show descriptions from .descriptions and NAME.meta sidecar files in
+	// listings, hiding the sidecars
+	Descriptions bool
+
+	// Serve an interactive page for the API at /_api/
+	APIExplorer bool
+
+	// Send "Server: fileserver/<version>"
+	ServerHeader bool
+
+	// Emit Server-Timing headers with per-phase durations
+	ServerTiming bool
+
+	// Plain HTTP listener serving only the health endpoint
+	HealthAddr string
+
+	// Serve net/http/pprof under /debug/pprof/ and expvar at /debug/vars:
+	// on its own plain HTTP listener without authentication at PprofAddr
+	// (which implies Pprof), otherwise on the public listeners behind
+	// their authentication
+	Pprof     bool
+	PprofAddr string
+
+	// OTLP/HTTP collector to send a trace span per request to, e.g.
+	// http://localhost:4318, with child spans for directory reads, stats,
+	// template rendering and file copies. Incoming traceparent headers
+	// are continued; other requests are sampled at OTelSampleRatio (0
+	// means 1)
+	OTelEndpoint    string
+	OTelSampleRatio float64
+	OTelServiceName string
+
+	// Already-open sockets to serve on instead of listening on Listen (in
+	// its order) and HealthAddr, e.g. inherited during a graceful restart
+	Listeners      []net.Listener
+	HealthListener net.Listener
+
+	AccessLog     bool
+	AccessLogFile string
+
+	// Application log file and rotation, shared with the access log file
+	LogLevel      string
+	LogDebugAreas []string
+	LogFile       string
+	LogMaxSize    int64
+	LogMaxAge     time.Duration
+	LogMaxBackups int
+
+	// Destination for server logs (default: text on stderr in the classic
+	// format). Request logs carry req, client and root attributes. Logs
+	// outside a request and the level settings are process-wide, so there
+	// the last server created wins.
+	Logger *slog.Logger
+}
+
+type Server struct {
+	rootDir        string
+	config         Config
+	template       *template.Template
+	assets         *staticAssets
+	tlsConfig      *tls.Config
+	trustedProxies []netip.Prefix
+	storage        storage
+	spool          *archiveSpool
+	dirReader      *dirReader
+	fsCalls        *fsCalls
+	connLimit      *connLimiter  // nil without -max-conns
+	perIP          *perIPLimiter // nil without -max-conns-per-ip
+	fds            *fdMonitor
+	ioRetries      ioRetries
+	cache          *fileCache
+	digests        *digestCache
+	stats          *downloadStats
+	bandwidth      *bandwidthAccounting
+	metrics        *metricsRegistry
+	accessLogger   *log.Logger
+	accessLogFile  *RotatingFile
+	httpServer     *http.Server
+	healthServer   *http.Server
+	pprofServer    *http.Server       // nil without Config.PprofAddr
+	tracer         *tracer            // nil without Config.OTelEndpoint
+	vhosts         map[string]*Server // by normalized host, nil without Config.VirtualHosts
+	vhost          string             // the host this serves, "" for the default root
+	listenAddrs    []listenAddr
+	listenMu       sync.Mutex
+	listeners      []net.Listener // before the connection limit
+	healthListener net.Listener
+	transfers      *transferTracker
+	startTime      time.Time
+	draining       atomic.Bool
+	activeConns    atomic.Int64
+	requests       atomic.Int64
+	stopBackground context.CancelFunc
+	backgroundDone sync.WaitGroup
+	cancelRequests context.CancelFunc
+	deny           denyList
+	mount          mountMonitor
+	hooks          hooks
+	recent         recentCache
+	dirCounts      dirCounts
+	space          *spaceGuard // nil without -min-free-bytes/-min-free-percent
+	writePerms     *writePerms // nil without -upload-file-mode/-upload-dir-mode/-upload-owner
+	writeLocks     *writeLocks
+	descriptions   descriptionCache
+	exif           exifCache
+	uploadCmd      *uploadCmd              // nil without -on-upload-cmd
+	thumbnails     *thumbnailer            // nil without -thumbnailer-cmd
+	hotlink        *hotlinkPolicy          // nil without -hotlink-allow
+	sessions       *sessionSigner          // nil without Config.Login or Config.OIDC
+	oidc           *oidcProvider           // nil without Config.OIDC
+	authBypass     map[string]http.Handler // AuthBypass endpoints, set by buildHandler
+	dirSigner      *sessionSigner          // signs DirAccessFile unlock cookies
+	linkSigner     *sessionSigner          // nil without Config.PlaylistLinkLifetime
+	playlistExts   map[string]bool
+	dirAccess      dirAccess
+	acl            atomic.Pointer[accessList]   // nil without Config.UsersFile
+	rewrites       atomic.Pointer[rewriteRules] // nil without Config.RewriteRules
+	sitemap        sitemapCache
+	tailSessions   atomic.Int64
+	handlerOnce    sync.Once
+	handler        http.Handler
+}
+
+// FormatSize formats size in binary units: 1536 -> "1.5 KiB".
+func FormatSize(size int64) string {
+	return formatSizeUnits(size, 1024, "KMGTPE", "iB")
+}
+
+// FormatSizeSI formats size in decimal units as disk vendors and macOS do:
+// 1500 -> "1.5 kB".
+func FormatSizeSI(size int64) string {
+	return formatSizeUnits(size, 1000, "kMGTPE", "B")
+}
+
+func formatSizeUnits(size, unit int64, prefixes, suffix string) string {
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/float64(unit), 0
+	// Compare after rounding so 1048575 reads "1.0 MiB", not "1024.0 KiB"
+	for math.Round(value*10)/10 >= float64(unit) && exp < len(prefixes)-1 {
+		value /= float64(unit)
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%s", value, prefixes[exp], suffix)
+}
+
+// formatBytes formats a byte count with thousands separators: "1,234,567".
+func formatBytes(size int64) string {
+	if size < 0 {
+		return "-" + formatBytes(-size)
+	}
+	digits := strconv.FormatInt(size, 10)
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// sizeFormatter returns the formatter for sizes shown to users.
+func sizeFormatter(cfg Config) func(int64) string {
+	switch {
+	case cfg.ExactSizes:
+		return func(size int64) string { return formatBytes(size) + " B" }
+	case cfg.SISizes:
+		return FormatSizeSI
+	}
+	return FormatSize
+}
+
+func (s *Server) formatSize(size int64) string {
+	return sizeFormatter(s.config)(size)
+}
+
+// DefaultRelativeTimesMax is the age beyond which relative times give way
+// to dates.
+const DefaultRelativeTimesMax = 30 * 24 * time.Hour
+
+// formatModTime formats modification times shown to users.
+func (s *Server) formatModTime(t time.Time) string {
+	if s.config.RelativeTimes {
+		limit := s.config.RelativeTimesMax
+		if limit <= 0 {
+			limit = DefaultRelativeTimesMax
+		}
+		if age := time.Since(t); age < limit && age > -limit {
+			return timeAgo(t)
+		}
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// logger is the base for request loggers.
+func (s *Server) logger() *slog.Logger {
+	if s.config.Logger != nil {
+		return s.config.Logger
+	}
+	return logger
+}
+
+func NewServer(rootDir string, port int) (*Server, error) {
+	return NewServerFromConfig(Config{RootDir: rootDir, Port: port})
+}
+
+func NewServerFromConfig(cfg Config) (*Server, error) {
+	// Virtual hosts start from the configuration as given, before defaults
+	// and derived values are filled in
+	base := cfg
+	if cfg.VhostStrict && len(cfg.VirtualHosts) == 0 {
+		return nil, fmt.Errorf("-vhost-strict needs at least one -vhost")
+	}
+	tmpl, err := template.New("").Funcs(templateFuncs).Funcs(template.FuncMap{"formatSize": sizeFormatter(cfg)}).
+		ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse templates: %v", err)
+	}
+	if cfg.Templates == nil && cfg.TemplateDir != "" {
+		cfg.Templates = os.DirFS(cfg.TemplateDir)
+	}
+	if cfg.Templates != nil {
+		// Same-named files replace the embedded templates
+		if tmpl, err = tmpl.ParseFS(cfg.Templates, "*.html"); err != nil {
+			return nil, fmt.Errorf("failed to parse custom templates: %v", err)
+		}
+	}
+
+	if cfg.BasePath != "" {
+		if !strings.HasPrefix(cfg.BasePath, "/") {
+			return nil, fmt.Errorf("base path must start with /: %s", cfg.BasePath)
+		}
+		cfg.BasePath = strings.TrimSuffix(cleanURLPath(cfg.BasePath), "/")
+	}
+
+	if cfg.AssetsPrefix == "" {
+		cfg.AssetsPrefix = DefaultAssetsPrefix
+	}
+	if cfg.StatWorkers == 0 {
+		cfg.StatWorkers = DefaultStatWorkers
+	}
+	if cfg.HighlightRecent < 0 {
+		return nil, fmt.Errorf("-highlight-recent must not be negative: %v", cfg.HighlightRecent)
+	}
+	if err := validateAssetsPrefix(cfg.AssetsPrefix); err != nil {
+		return nil, err
+	}
+	if err := validatePublicURL(cfg.PublicURL); err != nil {
+		return nil, err
+	}
+	assets, err := loadStaticAssets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load static assets: %v", err)
+	}
+
+	addr := cfg.Addr
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", cfg.Port)
+	}
+	if err := validateAddr(addr); err != nil {
+		return nil, err
+	}
+
+	if cfg.DirNotes == "" {
+		cfg.DirNotes = "text"
+	}
+	if err := validateDirNotes(cfg.DirNotes); err != nil {
+		return nil, err
+	}
+
+	cfg.Timeouts.setDefaults()
+	if err := cfg.Timeouts.validate(); err != nil {
+		return nil, err
+	}
+
+	store, absRoot, err := openRoot(cfg.RootDir, cfg.SingleFile)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := store.(osStorage); !ok && cfg.SendfileHeader != "" {
+		return nil, fmt.Errorf("-sendfile-header needs a local directory root")
+	}
+	if _, ok := store.(osStorage); !ok && cfg.Uploads {
+		return nil, fmt.Errorf("-uploads needs a local directory root")
+	}
+	space, err := newSpaceGuard(absRoot, cfg.MinFreeBytes, cfg.MinFreePercent)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := newWritePerms(cfg.UploadFileMode, cfg.UploadDirMode, cfg.UploadOwner)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.UploadOwner != "" && os.Geteuid() != 0 {
+		warnf(areaServer, "-upload-owner needs root; uploads will keep the server's owner")
+	}
+	hotlink, err := newHotlinkPolicy(cfg.HotlinkAllow, cfg.HotlinkTypes, cfg.HotlinkPlaceholder)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.AuthMode {
+	case "":
+		cfg.AuthMode = AuthModeAll
+	case AuthModeAll, AuthModeWriteOnly:
+	default:
+		return nil, fmt.Errorf("invalid auth mode %q, want %s or %s", cfg.AuthMode, AuthModeAll, AuthModeWriteOnly)
+	}
+	var acl *accessList
+	if cfg.UsersFile != "" {
+		if cfg.Auth != nil || cfg.Login != nil {
+			return nil, fmt.Errorf("a users file cannot be combined with an auth or login function")
+		}
+		if acl, err = loadAccessList(cfg.UsersFile, cfg.OIDC.Issuer != "" || cfg.AuthProxyHeader != ""); err != nil {
+			return nil, err
+		}
+		cfg.Login = acl.checkPassword
+	}
+	var oidc *oidcProvider
+	if cfg.OIDC.Issuer != "" {
+		if oidc, err = newOIDCProvider(cfg.OIDC); err != nil {
+			return nil, err
+		}
+	}
+	var sessions *sessionSigner
+	if cfg.Login != nil || oidc != nil {
+		sessions = newSessionSigner(cfg.SessionKey, cfg.SessionLifetime)
+	}
+	switch {
+	case cfg.AuthBypass == nil:
+		cfg.AuthBypass = DefaultAuthBypass
+	case slices.Equal(cfg.AuthBypass, []string{"none"}):
+		cfg.AuthBypass = nil
+	}
+	var linkSigner *sessionSigner
+	if cfg.PlaylistLinkLifetime > 0 {
+		linkSigner = newLinkSigner(cfg.SessionKey, cfg.PlaylistLinkLifetime)
+	}
+	var thumbnails *thumbnailer
+	if cfg.ThumbnailerCmd != "" {
+		if thumbnails, err = newThumbnailer(cfg.ThumbnailerCmd, cfg.ThumbnailCacheDir, cfg.ThumbnailerConcurrency, cfg.ThumbnailerTimeout); err != nil {
+			return nil, err
+		}
+	}
+	var onUpload *uploadCmd
+	if cfg.OnUploadCmd != "" {
+		if onUpload, err = newUploadCmd(cfg.OnUploadCmd, cfg.OnUploadConcurrency, cfg.OnUploadTimeout); err != nil {
+			return nil, err
+		}
+		if !cfg.Uploads {
+			warnf(areaServer, "-on-upload-cmd has no effect without -uploads")
+		}
+	}
+
+	tlsConfig, err := buildTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := listenAddrs(cfg, addr, tlsConfig != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parsePrefixes("trusted proxy", cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.AuthProxyHeader != "" && len(trustedProxies) == 0 {
+		return nil, fmt.Errorf("an auth proxy header needs trusted proxies to accept it from")
+	}
+	perIPExempt, err := parsePrefixes("per-IP limit exemption", cfg.PerIPLimitExempt)
+	if err != nil {
+		return nil, err
+	}
+
+	var spool *archiveSpool
+	if cfg.ArchiveSpoolDir != "" {
+		if spool, err = newArchiveSpool(cfg.ArchiveSpoolDir, cfg.ArchiveSpoolTTL); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Logger != nil {
+		logger = cfg.Logger
+	}
+
+	accessLogger := log.Default()
+	if cfg.Logger != nil {
+		accessLogger = slog.NewLogLogger(cfg.Logger.Handler(), slog.LevelInfo)
+	}
+	var accessLogFile *RotatingFile
+	if cfg.AccessLogFile != "" {
+		accessLogFile, err = NewRotatingFile(cfg.AccessLogFile, cfg.LogMaxSize, cfg.LogMaxAge, cfg.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		accessLogger = log.New(accessLogFile, "", log.LstdFlags)
+		cfg.AccessLog = true
+	}
+
+	var stats *downloadStats
+	if cfg.Stats {
+		if stats, err = newDownloadStats(cfg.StatsFile, cfg.StatsWindow); err != nil {
+			return nil, err
+		}
+	}
+
+	var bandwidth *bandwidthAccounting
+	if cfg.Bandwidth {
+		if bandwidth, err = newBandwidthAccounting(cfg.BandwidthFile, cfg.BandwidthRetention); err != nil {
+			return nil, err
+		}
+		if cfg.HealthAddr == "" {
+			warnf(areaServer, "-bandwidth report is only served on -health-addr, which is not set")
+		}
+	}
+
+	var tracer *tracer
+	if cfg.OTelEndpoint != "" {
+		if tracer, err = newTracer(cfg.OTelEndpoint, cfg.OTelServiceName, cfg.OTelSampleRatio); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Pprof && cfg.PprofAddr == "" {
+		warnf(areaServer, "-pprof without -pprof-addr serves profiles and the command line on the public listener")
+	}
+	if cfg.Uploads && cfg.Auth == nil && cfg.Login == nil && cfg.OIDC.Issuer == "" && cfg.AuthProxyHeader == "" {
+		warnf(areaServer, "-uploads without authentication lets anyone write to the root")
+	}
+
+	var cache *fileCache
+	if cfg.CacheSize > 0 {
+		cache = newFileCache(cfg.CacheSize, cfg.CacheMaxFile)
+	}
+
+	digests, err := newDigestCache(cfg.ChecksumCacheSize, cfg.ChecksumCacheFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Deny == nil {
+		cfg.Deny = DefaultDeny
+	}
+	deny, err := compileDeny(append(cfg.Deny[:len(cfg.Deny):len(cfg.Deny)], DirAccessFile, writeTempPattern))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		rootDir:        absRoot,
+		space:          space,
+		writePerms:     perms,
+		writeLocks:     newWriteLocks(absRoot),
+		uploadCmd:      onUpload,
+		thumbnails:     thumbnails,
+		hotlink:        hotlink,
+		sessions:       sessions,
+		oidc:           oidc,
+		dirSigner:      newDirSigner(cfg.SessionKey, cfg.SessionLifetime),
+		linkSigner:     linkSigner,
+		playlistExts:   playlistExtensions(cfg.PlaylistExtensions),
+		listenAddrs:    addrs,
+		config:         cfg,
+		template:       tmpl,
+		assets:         assets,
+		tlsConfig:      tlsConfig,
+		trustedProxies: trustedProxies,
+		storage:        store,
+		deny:           deny,
+		spool:          spool,
+		accessLogger:   accessLogger,
+		accessLogFile:  accessLogFile,
+		fsCalls:        newFSCalls(cfg.MaxFSCalls),
+		connLimit:      newConnLimiter(cfg.MaxConns),
+		perIP:          newPerIPLimiter(cfg.MaxConnsPerIP, perIPExempt),
+		fds:            &fdMonitor{min: int64(cfg.MinFreeFDs)},
+		cache:          cache,
+		digests:        digests,
+		stats:          stats,
+		bandwidth:      bandwidth,
+		metrics:        &metricsRegistry{},
+		tracer:         tracer,
+		transfers:      newTransferTracker(cfg.Timeouts.Write, cfg.IO),
+		startTime:      time.Now(),
+	}
+	// A directory read is shared by every request waiting on it, so its
+	// retries are not tied to any one request; each still stops waiting at
+	// its own deadline
+	s.dirReader = newDirReader(cfg.MaxDirReads, func(name string) ([]fs.DirEntry, error) {
+		return retryIO(context.Background(), &s.ioRetries, func() ([]fs.DirEntry, error) { return store.ReadDir(name) })
+	})
+	if onUpload != nil {
+		s.OnWrite(onUpload.hook(s))
+	}
+	if cfg.DirCountsFile != "" {
+		if err := s.dirCounts.load(cfg.DirCountsFile, s.dirCountSettings()); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RewriteRules != "" {
+		rules, err := loadRewriteRules(cfg.RewriteRules)
+		if err != nil {
+			return nil, err
+		}
+		s.rewrites.Store(rules)
+	}
+	if acl != nil {
+		s.acl.Store(acl)
+		s.config.Auth = s.aclAuth
+		s.config.Login = func(user, password string) bool { return s.acl.Load().checkPassword(user, password) }
+	}
+	s.registerMetrics()
+	s.checkReservedCollisions()
+	if len(cfg.VirtualHosts) > 0 {
+		if s.vhosts, err = newVirtualHosts(base, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func validateRootDirectory(rootDir string) error {
+	info, err := os.Stat(rootDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("root directory does not exist: %s", rootDir)
+		}
+		if os.IsPermission(err) {
+			return fmt.Errorf("permission denied accessing root directory: %s", rootDir)
+		}
+		return fmt.Errorf("cannot access root directory %s: %v", rootDir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("root path is not a directory: %s", rootDir)
+	}
+
+	// Test if we can actually read the directory
+	_, err = os.ReadDir(rootDir)
+	if err != nil {
+		return fmt.Errorf("cannot read root directory %s: %v", rootDir, err)
+	}
+
+	return nil
+}
+
+func isMountPoint(path string) bool {
+	// Check if path is a mount point by comparing device IDs
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	parent := filepath.Dir(path)
+	parentInfo, err := os.Stat(parent)
+	if err != nil {
+		return false
+	}
+
+	// If device IDs differ, it's likely a mount point
+	stat := info.Sys().(*syscall.Stat_t)
+	parentStat := parentInfo.Sys().(*syscall.Stat_t)
+
+	return stat.Dev != parentStat.Dev
+}
+
+// checkMountHealth probes the storage by reading the root, bounded by ctx,
+// through the shared directory reader so a hung mount cannot pile up
+// goroutines. Only the mount monitor calls it; requests use its result.
+func (s *Server) checkMountHealth(ctx context.Context) error {
+	if st, ok := s.storage.(*s3Storage); ok {
+		if err := st.checkHealth(ctx); err != nil {
+			return fmt.Errorf("bucket unhealthy: %v", err)
+		}
+		return nil
+	}
+	if s.singleFile() {
+		_, err := fsCall(s.fsCalls, ctx, s.config.Timeouts.Stat, func() (fs.FileInfo, error) { return s.storage.Stat(s.rootDir) }, nil)
+		if err != nil {
+			return fmt.Errorf("served file unhealthy: %v", err)
+		}
+		return nil
+	}
+	if _, err := s.dirReader.read(ctx, s.rootDir); err != nil {
+		return fmt.Errorf("mount point unhealthy: %v", err)
+	}
+	return nil
+}
+
+// singleFile reports a root that is one file served at "/", without
+// listings or anything else that needs a directory.
+func (s *Server) singleFile() bool {
+	_, ok := s.storage.(singleFileStorage)
+	return ok
+}
+
+// lookup stats the file for a request path, already canonical.
+func (s *Server) lookup(ctx context.Context, requestPath string) (string, fs.FileInfo, error) {
+	fullPath := s.fsPath(requestPath)
+	info, err := s.stat(ctx, fullPath)
+	return fullPath, info, err
+}
+
+// openLookup opens requestPath and fstats the handle, so everything
+// decided about the request is decided on the object then served: a name
+// replaced between a stat and an open cannot slip through, and slow mounts
+// see one metadata round trip instead of two. requestPath is already
+// canonical (see canonicalPath), so the file opened is the one checked.
+func (s *Server) openLookup(ctx context.Context, requestPath string) (string, fs.File, fs.FileInfo, error) {
+	fullPath := s.fsPath(requestPath)
+	file, info, err := s.openStat(ctx, fullPath)
+	return fullPath, file, info, err
+}
+
+// openStat opens fullPath and fstats the handle in one call bounded like
+// open, so a mount that hangs on the fstat cannot hold the request either.
+func (s *Server) openStat(ctx context.Context, fullPath string) (fs.File, fs.FileInfo, error) {
+	type opened struct {
+		file fs.File
+		info fs.FileInfo
+	}
+	res, err := fsCall(s.fsCalls, ctx, s.config.Timeouts.Stat,
+		func() (opened, error) {
+			file, err := retryIO(ctx, &s.ioRetries, func() (fs.File, error) { return s.storage.Open(fullPath) })
+			if err != nil {
+				return opened{}, err
+			}
+			info, err := file.Stat()
+			if err != nil {
+				file.Close()
+				return opened{}, err
+			}
+			return opened{file, info}, nil
+		},
+		func(o opened) { o.file.Close() })
+	return res.file, res.info, err
+}
+
+// openFailed answers a failed openLookup.
+func (s *Server) openFailed(w http.ResponseWriter, r *http.Request, fullPath string, err error) {
+	if s.fsCallFailed(w, r, httpError, "open", fullPath, err) {
+		return
+	}
+	detail := fmt.Sprintf("opening %s: %v", fullPath, err)
+	switch {
+	case os.IsNotExist(err):
+		httpError(w, r, areaRequest, http.StatusNotFound, "Not found", "")
+	case isFDExhausted(err):
+		w.Header().Set("Retry-After", strconv.Itoa(fdRetryAfter))
+		httpError(w, r, areaIO, http.StatusServiceUnavailable, "Server busy, try again shortly", detail)
+	case os.IsPermission(err):
+		httpError(w, r, areaIO, http.StatusForbidden, "Access denied", detail)
+	default:
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Internal server error", detail)
+	}
+}
+
+func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
+	// Add request timeout for external storage operations; streams that
+	// outlive it use base
+	base := r.Context()
+	ctx, cancel := context.WithTimeout(base, s.config.Timeouts.Request)
+	defer cancel()
+
+	r = r.WithContext(ctx)
+
+	upload := r.Method == http.MethodPut && s.config.Uploads
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && !upload {
+		methodNotAllowed(w, r, s.treeMethods())
+		return
+	}
+
+	r, handled := s.applyRewriteRules(w, r)
+	if handled {
+		return
+	}
+	requestPath, err := requestPathFrom(r.URL)
+	if err != nil {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Bad request", fmt.Sprintf("%v from %s", err, clientIP(r)))
+		return
+	}
+	if !s.isPathSafe(requestPath) {
+		httpError(w, r, areaRequest, http.StatusForbidden, "Access denied", "unsafe path access attempt from "+clientIP(r))
+		return
+	}
+	if s.singleFile() && requestPath != "/" {
+		httpError(w, r, areaRequest, http.StatusNotFound, "Not found", "")
+		return
+	}
+	requestPath = s.canonicalPath(ctx, requestPath)
+
+	if s.config.HideDotFiles && hasDotComponent(requestPath) {
+		httpError(w, r, areaRequest, http.StatusNotFound, "Not found", "")
+		return
+	}
+	if pattern := s.deny.match(requestPath); pattern != "" {
+		httpError(w, r, areaRequest, http.StatusNotFound, "Not found",
+			fmt.Sprintf("blocked %s from %s by deny pattern %q", requestPath, clientIP(r), pattern))
+		return
+	}
+
+	if s.mount.unavailable() != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(mountRetryAfter))
+		// The cause was logged once when the monitor saw the storage fail
+		httpError(w, r, areaMount, http.StatusServiceUnavailable, "Storage temporarily unavailable", "")
+		return
+	}
+	want := aclRead
+	if upload {
+		want = aclWrite
+	}
+	if !s.checkDirAccess(w, r, requestPath) || !s.authorize(w, r, httpError, requestPath, want) {
+		return
+	}
+	if upload {
+		// The body may take longer than the request timeout to arrive
+		s.handlePut(w, r.WithContext(base), requestPath)
+		return
+	}
+
+	doneOpen := requestTiming(r).track("open")
+	fullPath, file, info, err := s.openLookup(ctx, requestPath)
+	doneOpen()
+	if err != nil {
+		s.openFailed(w, r, fullPath, err)
+		return
+	}
+
+	// Directories always end in a slash and files never do
+	hasSlash := strings.HasSuffix(requestPath, "/")
+	if info.IsDir() && !hasSlash {
+		file.Close()
+		redirectCanonical(w, r, requestPath+"/")
+		return
+	}
+	if !info.IsDir() && hasSlash && !s.singleFile() {
+		file.Close()
+		redirectCanonical(w, r, strings.TrimSuffix(requestPath, "/"))
+		return
+	}
+
+	if info.IsDir() {
+		if r.URL.Query().Get("archive") == "zip" {
+			file.Close()
+			s.handleArchive(w, r, fullPath)
+			return
+		}
+		// The listing reads this very handle and closes it
+		s.handleDirectory(w, r, fullPath, requestPath, file, info)
+		return
+	}
+	defer file.Close()
+	if s.thumbnails != nil && r.URL.Query().Has("thumb") {
+		file.Close()
+		s.serveThumbnail(w, r, fullPath, info)
+	} else if r.URL.Query().Get("exif") == "1" {
+		s.handleExif(w, r, fullPath, info)
+	} else if r.URL.Query().Has("checksum") {
+		s.handleChecksum(w, r, fullPath, info)
+	} else if r.URL.Query().Get("tail") == "1" {
+		s.handleTail(w, r.WithContext(base), fullPath)
+	} else {
+		if s.singleFile() {
+			// "/" has no name to save the file under
+			w.Header().Set("Content-Disposition", contentDisposition("inline", info.Name()))
+		}
+		s.handleFile(w, r, fullPath, file, info)
+	}
+}
+
+// listFiles turns directory entries into the sorted listing shown to
+// clients: hidden and note files dropped, listing hooks applied. header and
+// footer report whether the directory has note files. With namesOnly the
+// rows come from the directory entries alone, skipping a stat per entry:
+// Size and ModTime stay zero, SizeStr and ModStr empty, and a symlink to a
+// directory is listed as a file.
+func (s *Server) listFiles(r *http.Request, fullPath, requestPath string, entries []fs.DirEntry, namesOnly bool) (files []FileInfo, header, footer bool) {
+	var listed []fs.DirEntry
+	visible := s.aclVisibleFunc(r.Context())
+	var names map[string]bool
+	if s.config.Descriptions {
+		names = make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			names[entry.Name()] = true
+		}
+	}
+	for _, entry := range entries {
+		if s.isSidecar(entry.Name(), names) {
+			continue
+		}
+		if isWriteTemp(entry.Name()) {
+			s.writeLocks.noteDir(fullPath)
+			continue
+		}
+		if s.config.HideDotFiles && isDotFile(entry.Name()) {
+			continue
+		}
+		if s.deny.match(requestPath+entry.Name()) != "" {
+			continue
+		}
+		if visible != nil && !visible(requestPath+entry.Name(), entry.IsDir()) {
+			continue
+		}
+
+		if s.isDirNote(entry.Name()) {
+			header = header || entry.Name() == dirHeaderFile
+			footer = footer || entry.Name() == dirFooterFile
+			continue
+		}
+		if namesOnly {
+			files = append(files, FileInfo{Name: entry.Name(), IsDir: entry.IsDir()})
+			continue
+		}
+		listed = append(listed, entry)
+	}
+
+	infos, errs := s.entryInfos(r.Context(), listed)
+	now := time.Now()
+	skipped := 0
+	var firstErr error
+	for i, info := range infos {
+		if info == nil {
+			if skipped++; firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+
+		fileInfo := FileInfo{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+			SizeStr: s.formatSize(info.Size()),
+			ModStr:  s.formatModTime(info.ModTime()),
+			info:    info,
+		}
+		if window := s.config.HighlightRecent; window > 0 {
+			fileInfo.IsRecent = now.Sub(info.ModTime()) < window
+		}
+
+		if info.IsDir() {
+			fileInfo.SizeStr = "-"
+		}
+
+		files = append(files, fileInfo)
+	}
+
+	if skipped > 0 {
+		reqLogf(r, levelWarn, areaListing, "Skipped %d unreadable entries in %s, first: %v", skipped, fullPath, firstErr)
+	}
+
+	if s.config.Descriptions {
+		s.describe(r.Context(), fullPath, entries, files)
+	}
+	files = s.runListingHooks(r, requestPath, files)
+
+	// Sort: directories first, then by name; exact names break ties so
+	// API pages are deterministic
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].IsDir != files[j].IsDir {
+			return files[i].IsDir
+		}
+		if a, b := strings.ToLower(files[i].Name), strings.ToLower(files[j].Name); a != b {
+			return a < b
+		}
+		return files[i].Name < files[j].Name
+	})
+	return files, header, footer
+}
+
+// handleDirectory answers a listing of dir, open at fullPath with info
+// from its handle, and closes it.
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request, fullPath, requestPath string, dir fs.File, info fs.FileInfo) {
+	// Ours until handed to the dirReader
+	defer func() {
+		if dir != nil {
+			dir.Close()
+		}
+	}()
+	filter, err := parseListingFilter(r.URL.Query())
+	if err != nil {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid filter: "+err.Error(), "")
+		return
+	}
+	order, err := parseListingOrder(r.URL.Query())
+	if err != nil {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid sort: "+err.Error(), "")
+		return
+	}
+	recursive := r.URL.Query().Get("recursive") == "1"
+	format := r.URL.Query().Get("format")
+	playlist := r.URL.Query().Get("playlist")
+	if playlist != "" && playlist != "m3u" {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid playlist format, want m3u", "")
+		return
+	}
+	asText := format == "txt"
+	feedLimit, err := s.feedEntries(r)
+	if err != nil && format == "atom" {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid feed: "+err.Error(), "")
+		return
+	}
+
+	// Use context timeout for directory operations
+	ctx := r.Context()
+
+	timing := requestTiming(r)
+	doneDir := timing.track("dir")
+	dirCtx, cancel := context.WithTimeout(ctx, s.config.Timeouts.DirRead)
+	defer cancel()
+	entries, err := s.dirReader.readOpen(dirCtx, fullPath, dir, info)
+	dir = nil
+	doneDir()
+
+	if err == errTooManyDirReads {
+		w.Header().Set("Retry-After", "30")
+		httpError(w, r, areaMount, http.StatusServiceUnavailable, "Storage temporarily unavailable",
+			fmt.Sprintf("%d directory reads outstanding", s.dirReader.outstanding()))
+		return
+	}
+	if dirCtx.Err() != nil {
+		httpError(w, r, areaListing, http.StatusRequestTimeout, "Request timeout", "directory read timeout for "+fullPath)
+		return
+	}
+	if err != nil {
+		detail := fmt.Sprintf("reading directory %s: %v", fullPath, err)
+		if os.IsPermission(err) {
+			httpError(w, r, areaListing, http.StatusForbidden, "Access denied", detail)
+		} else {
+			httpError(w, r, areaListing, http.StatusInternalServerError, "Failed to read directory", detail)
+		}
+		return
+	}
+
+	// An index file stands in for the HTML listing only, not for the
+	// exports or a recursive listing
+	if index := s.findIndex(entries); index != "" && !recursive && format == "" && playlist == "" && !r.URL.Query().Has("sums") {
+		s.serveIndex(w, r, filepath.Join(fullPath, index))
+		return
+	}
+
+	doneStat := timing.track("stat")
+	// Names are enough for text listings and ?fields=name, and huge
+	// directories fall back to them unless details=1 asks otherwise
+	query := r.URL.Query()
+	namesOnly := asText || playlist != "" || query.Get("fields") == "name"
+	fastFallback := false
+	if over := s.config.FastListingOver; format == "" && !namesOnly && over > 0 && len(entries) > over && query.Get("details") != "1" && !order.needsStat() {
+		namesOnly, fastFallback = true, true
+	}
+	if format == "csv" || format == "atom" || query.Has("sums") {
+		namesOnly = false
+	}
+	var fields map[string]bool
+	if format == "ndjson" {
+		if fields, err = parseFields(query); err != nil {
+			doneStat()
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid fields: "+err.Error(), "")
+			return
+		}
+		namesOnly = !fieldsNeedStat(fields)
+	}
+	files, header, footer := s.listFiles(r, fullPath, requestPath, entries, namesOnly)
+	if format == "csv" {
+		doneStat()
+		s.writeCSVListing(dirCtx, w, r, fullPath, requestPath, files, recursive, filter)
+		return
+	}
+	if format == "ndjson" {
+		if !recursive && !namesOnly {
+			s.countSubdirs(r, fullPath, requestPath, files)
+		}
+		doneStat()
+		s.writeNDJSONListing(dirCtx, w, r, fullPath, requestPath, files, recursive, namesOnly, filter, fields)
+		return
+	}
+	var truncated bool
+	if recursive {
+		files, truncated = s.expandRecursive(dirCtx, r, fullPath, requestPath, files, namesOnly)
+	} else if !namesOnly {
+		s.countSubdirs(r, fullPath, requestPath, files)
+	}
+	doneStat()
+	// Category counts are over the glob matches, before the type filter
+	files = filter.applyGlob(files)
+	chips := categoryChips(r, files, filter.types)
+	files = filterByCategory(files, filter.types)
+	if order.by == "exifdate" {
+		s.setCaptureTimes(dirCtx, r, fullPath, files)
+	}
+	if playlist != "" {
+		sortByPath(files)
+		s.writePlaylist(w, r, fullPath, requestPath, files)
+		return
+	}
+	order.apply(files)
+	if asText {
+		s.writeTextListing(w, r, requestPath, files, truncated)
+		return
+	}
+	if r.URL.Query().Has("sums") {
+		s.writeSums(w, r, fullPath, requestPath, files)
+		return
+	}
+	if format == "atom" {
+		s.writeAtomFeed(w, r, requestPath, files, feedLimit)
+		return
+	}
+
+	var parentPath string
+	if requestPath != "/" {
+		parentPath = path.Dir(strings.TrimSuffix(requestPath, "/"))
+		if parentPath != "/" {
+			parentPath += "/"
+		}
+	}
+
+	data := PageData{
+		Title:       "File Server - " + requestPath,
+		BasePath:    basePath(r),
+		Assets:      s.assetURLs(r),
+		CurrentPath: requestPath,
+		ParentPath:  parentPath,
+		Files:       files,
+		Categories:  chips,
+		FilterQuery: filter.query(r),
+		Recursive:   recursive,
+		Truncated:   truncated,
+		NamesOnly:   namesOnly,
+	}
+	if fastFallback {
+		data.DetailsURL = detailsURL(r)
+	}
+	if order.group {
+		data.GroupCounts = groupCounts(files)
+	}
+	data.Thumbnails = s.thumbnails != nil
+	data.User = getRequestInfo(r).identity
+	if s.sessions != nil {
+		if data.User == "" {
+			data.LoginURL = loginURL(r)
+		} else if _, ok := s.sessionUser(r); ok {
+			data.LogoutURL = basePath(r) + logoutPath
+		}
+	}
+	if filter.glob != "" {
+		data.Glob, data.GlobClear = r.URL.Query().Get("glob"), globClearURL(r)
+	}
+	if header {
+		data.Header = s.readDirNote(r, fullPath, dirHeaderFile)
+	}
+	if footer {
+		data.Footer = s.readDirNote(r, fullPath, dirFooterFile)
+	}
+
+	// Render to a buffer so a template error can still become a clean 500
+	// and the render time can go into Server-Timing
+	doneTmpl := timing.track("tmpl")
+	var buf bytes.Buffer
+	if err := s.template.ExecuteTemplate(&buf, "directory.html", data); err != nil {
+		httpError(w, r, areaListing, http.StatusInternalServerError, "Internal server error", "template execution: "+err.Error())
+		return
+	}
+	doneTmpl()
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	s.emitTiming(w, r)
+	w.Write(buf.Bytes())
+}
+
+// findIndex returns the first configured index file present in entries,
+// from the listing already read rather than a stat per candidate.
+func (s *Server) findIndex(entries []fs.DirEntry) string {
+	if len(s.config.IndexFiles) == 0 {
+		return ""
+	}
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			present[entry.Name()] = true
+		}
+	}
+	for _, name := range s.config.IndexFiles {
+		if present[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// serveIndex serves an index file found in a directory listing.
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request, fullPath string) {
+	doneOpen := requestTiming(r).track("open")
+	file, info, err := s.openStat(r.Context(), fullPath)
+	doneOpen()
+	if err != nil {
+		s.openFailed(w, r, fullPath, err)
+		return
+	}
+	defer file.Close()
+	s.handleFile(w, r, fullPath, file, info)
+}
+
+// handleFile serves the open file, described by info from its own fstat.
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, fullPath string, file fs.File, info fs.FileInfo) {
+	if s.shedForFDs(w, r) {
+		return
+	}
+	timing := requestTiming(r)
+
+	// Prevent directory listing if somehow a directory gets here
+	if info.IsDir() {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Cannot serve directory as file", "")
+		return
+	}
+
+	if limit := s.fileSizeLimit(s.requestPathOf(fullPath)); limit > 0 && info.Size() > limit {
+		httpError(w, r, areaRequest, http.StatusForbidden, "File exceeds the server's size limit of "+s.formatSize(limit),
+			fmt.Sprintf("%s is %d bytes", fullPath, info.Size()))
+		return
+	}
+	if !s.checkHotlink(w, r, fullPath) {
+		return
+	}
+	s.applyContentPolicy(w, r, fullPath)
+	s.setReprDigest(w, r, fullPath, info)
+
+	if s.config.SendfileHeader != "" {
+		s.emitTiming(w, r)
+		s.serveSendfile(w, r, file.(*os.File), fullPath, info)
+		return
+	}
+
+	var content io.ReadSeeker
+	if s.cache.cacheable(info.Size()) {
+		data, ok := s.cache.get(fullPath, info.Size(), info.ModTime())
+		getRequestInfo(r).cacheHit = ok
+		if !ok {
+			doneRead := timing.track("read")
+			var err error
+			data, err = io.ReadAll(io.LimitReader(file, info.Size()+1))
+			doneRead()
+			if err != nil {
+				httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to read file", fmt.Sprintf("reading %s: %v", fullPath, err))
+				return
+			}
+			// A file changing under us is served as read but not cached
+			if int64(len(data)) == info.Size() {
+				s.cache.put(fullPath, info.ModTime(), data)
+			}
+		}
+		content = bytes.NewReader(data)
+	} else if seeker, ok := file.(io.ReadSeeker); ok {
+		content = seeker
+	} else {
+		// Compressed archive members cannot seek, so they are streamed whole
+		s.emitTiming(w, r)
+		s.serveStream(w, r, file, fullPath, info)
+		return
+	}
+	s.emitTiming(w, r)
+
+	tw, done := s.beginTransfer(w, r, fullPath)
+	defer done()
+
+	// Set appropriate headers for file serving
+	tw.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	tw.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	tw.Header().Set("Accept-Ranges", "bytes")
+
+	doneCopy := timing.track("copy")
+	http.ServeContent(tw, r, info.Name(), info.ModTime(), content)
+	doneCopy()
+	s.fileServed(r, tw, fullPath)
+}
+
+// Handler returns the complete handler chain (path checks, listings, files
+// and the reserved endpoints) without binding a listener, for mounting in
+// another mux, e.g. under http.StripPrefix("/files", srv.Handler()). Links
+// and redirects include the stripped prefix. The first call starts the
+// background tasks that Shutdown stops.
+func (s *Server) Handler() http.Handler {
+	s.handlerOnce.Do(func() {
+		s.handler = s.buildHandler()
+		s.startBackground()
+	})
+	return s.handler
+}
+
+func (s *Server) buildHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/readyz", s.handleReady)
+	if s.config.Status {
+		mux.HandleFunc("/_status", s.handleStatus)
+	}
+	if s.config.Metrics {
+		mux.Handle("/metrics", s.metrics)
+	}
+	if s.stats != nil {
+		mux.HandleFunc("/_stats/top", s.handleStatsTop)
+	}
+	if s.config.Pprof && s.config.PprofAddr == "" {
+		s.registerDebug(mux)
+	}
+	if !s.singleFile() {
+		s.registerTreeEndpoints(mux)
+	}
+	if s.sessions != nil {
+		mux.HandleFunc(loginPath, s.handleLogin)
+		mux.HandleFunc(logoutPath, s.handleLogout)
+	}
+	if s.oidc != nil {
+		mux.HandleFunc(oidcCallbackPath, s.handleOIDCCallback)
+	}
+	mux.HandleFunc(s.config.AssetsPrefix, s.handleAsset)
+	// Without this the mux would redirect a file named like the prefix
+	mux.HandleFunc(strings.TrimSuffix(s.config.AssetsPrefix, "/"), s.handleRequest)
+	mux.HandleFunc("/", s.handleRequest)
+
+	s.authBypass = s.authBypassHandlers(mux)
+	var handler http.Handler = answerOptions(mux, s.treeMethods(), canonicalPaths(mux))
+	handler = s.wrapMiddleware(handler)
+	handler = s.protectWrites(handler)
+	if s.config.Auth != nil || s.sessions != nil || s.config.AuthProxyHeader != "" {
+		handler = s.authenticate(handler)
+	}
+	if s.tlsConfig != nil && s.tlsConfig.ClientCAs != nil {
+		handler = s.requireClientCert(handler)
+	}
+	if s.perIP != nil {
+		handler = s.limitPerIP(handler)
+	}
+	if s.config.VhostStrict {
+		handler = s.rejectUnknownHosts(handler)
+	}
+	if s.config.AccessLog {
+		handler = s.accessLog(handler)
+	}
+	if s.tracer != nil {
+		handler = s.traceRequests(handler)
+	}
+	handler = s.withRequestInfo(handler)
+	if len(s.vhosts) > 0 {
+		handler = s.routeVirtualHosts(handler)
+	}
+	if s.config.ServerHeader {
+		handler = serverHeader(handler)
+	}
+	return handler
+}
+
+// registerTreeEndpoints adds the endpoints that browse or search a
+// directory tree, which a single-file root has none of.
+func (s *Server) registerTreeEndpoints(mux *http.ServeMux) {
+	mux.HandleFunc("/_recent", s.handleRecent)
+	if s.config.Sitemap {
+		mux.HandleFunc("/sitemap.xml", s.handleSitemap)
+	}
+	if s.config.Grep {
+		mux.HandleFunc("/_grep", s.handleGrep)
+	}
+	if s.config.DirPasswords {
+		mux.HandleFunc(unlockPath, s.handleUnlock)
+	}
+	mux.HandleFunc("/_api/openapi.json", s.handleOpenAPI)
+	s.registerAPIv1(mux)
+	if s.config.APIExplorer {
+		mux.HandleFunc("/_api/{$}", s.handleAPIExplorer)
+	}
+}
+
+func (s *Server) startBackground() {
+	var background context.Context
+	background, s.stopBackground = context.WithCancel(context.Background())
+	if s.spool != nil {
+		go s.spool.runSweeper(background)
+	}
+	if !s.singleFile() {
+		go s.writeLocks.runSweeper(background)
+	}
+	if s.bandwidth != nil {
+		s.backgroundDone.Add(1)
+		go func() {
+			defer s.backgroundDone.Done()
+			s.bandwidth.run(background)
+		}()
+	}
+	s.backgroundDone.Add(1)
+	go func() {
+		defer s.backgroundDone.Done()
+		s.digests.run(background)
+	}()
+	if s.dirCounts.file != "" {
+		s.backgroundDone.Add(1)
+		go func() {
+			defer s.backgroundDone.Done()
+			s.dirCounts.run(background)
+		}()
+	}
+	s.backgroundDone.Add(1)
+	go func() {
+		defer s.backgroundDone.Done()
+		s.runMountMonitor(background)
+	}()
+	if s.fds.min > 0 {
+		s.backgroundDone.Add(1)
+		go func() {
+			defer s.backgroundDone.Done()
+			s.fds.run(background)
+		}()
+	}
+	if s.stats != nil {
+		s.backgroundDone.Add(1)
+		go func() {
+			defer s.backgroundDone.Done()
+			s.stats.run(background)
+		}()
+	}
+}
+
+func (s *Server) Start() error {
+	// Cancelled when the short shutdown timeout expires, cutting listings
+	// while transfers keep draining
+	var requestCtx context.Context
+	requestCtx, s.cancelRequests = context.WithCancel(context.Background())
+
+	s.httpServer = &http.Server{
+		Handler:           s.Handler(),
+		BaseContext:       func(net.Listener) context.Context { return requestCtx },
+		ConnState:         s.trackConnState,
+		TLSConfig:         s.tlsConfig,
+		ErrorLog:          log.New(tlsErrorLogWriter{}, "", 0),
+		ReadTimeout:       s.config.Timeouts.Read,
+		ReadHeaderTimeout: s.config.Timeouts.ReadHeader,
+		WriteTimeout:      s.config.Timeouts.Write, // extended per write for file bodies
+		IdleTimeout:       s.config.Timeouts.Idle,
+		// "OPTIONS *" is answered by answerOptions
+		DisableGeneralOptionsHandler: true,
+	}
+
+	infof(areaServer, "Starting %s...", Build())
+	switch s.storage.(type) {
+	case *archiveStorage:
+		infof(areaServer, "Serving archive (read-only): %s", s.rootDir)
+	case *s3Storage:
+		infof(areaServer, "Serving S3 bucket (read-only): %s", s.config.RootDir)
+	case singleFileStorage:
+		infof(areaServer, "Serving single file: %s", s.rootDir)
+	default:
+		infof(areaServer, "Serving directory: %s", s.rootDir)
+	}
+	for _, host := range s.virtualHostNames() {
+		infof(areaServer, "Serving %s for host %s", s.vhosts[host].rootDir, host)
+	}
+	if s.config.VhostStrict {
+		infof(areaServer, "Answering other hosts with 421")
+	}
+	if isMountPoint(s.rootDir) {
+		infof(areaServer, "✓ Detected mount point at: %s", s.rootDir)
+	}
+	if s.tlsConfig != nil {
+		infof(areaServer, "TLS policy: %s", tlsPolicySummary(s.tlsConfig))
+	}
+	if s.tlsConfig != nil && s.tlsConfig.ClientCAs != nil {
+		infof(areaServer, "✓ Requiring client certificates signed by: %s", s.config.ClientCAFile)
+	}
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.listenMu.Lock()
+	s.listeners = listeners
+	s.listenMu.Unlock()
+	var browse string
+	var browseLn net.Listener
+	for i, ln := range listeners {
+		urls := bannerURLs(s.listenAddrs[i], ln)
+		if len(s.config.Listeners) > 0 {
+			infof(areaServer, "Listening on: %s (inherited socket)", urls[0])
+		} else {
+			infof(areaServer, "Listening on: %s", urls[0])
+		}
+		for _, u := range urls[1:] {
+			infof(areaServer, "Also reachable at: %s", u)
+		}
+		if s.listenAddrs[i].network == "unix" {
+			continue
+		}
+		if s.singleFile() {
+			infof(areaServer, "Direct link: %s/", urls[0])
+		}
+		if browse == "" {
+			browse, browseLn = urls[0]+"/", ln
+		}
+	}
+	if s.config.BasePath != "" {
+		infof(areaServer, "Links are prefixed with %s for the reverse proxy; the addresses above serve without it", s.config.BasePath)
+	}
+	if s.config.OpenBrowser && browse != "" {
+		go openBrowserWhenReady(browse, browseLn.Addr())
+	}
+
+	if s.config.HealthAddr != "" || s.config.HealthListener != nil {
+		if err := s.startHealthServer(); err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return err
+		}
+	}
+	if s.config.PprofAddr != "" {
+		if err := s.startPprofServer(); err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return err
+		}
+	}
+	return s.serve(listeners)
+}
+
+// ListenerFiles duplicates the listening sockets for a process taking over
+// from this one: the listeners in Listen order, TLS or not, and the health
+// listener, which is nil without -health-addr. The caller closes the files.
+func (s *Server) ListenerFiles() (listeners []*os.File, health *os.File, err error) {
+	s.listenMu.Lock()
+	defer s.listenMu.Unlock()
+	if s.listeners == nil {
+		return nil, nil, fmt.Errorf("server is not listening yet")
+	}
+	closeAll := func() {
+		for _, f := range listeners {
+			f.Close()
+		}
+	}
+	for _, ln := range s.listeners {
+		f, err := listenerFile(ln)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		listeners = append(listeners, f)
+	}
+	if s.healthListener != nil {
+		if health, err = listenerFile(s.healthListener); err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+	}
+	return listeners, health, nil
+}
+
+func listenerFile(ln net.Listener) (*os.File, error) {
+	// The socket path must outlive our Close for the process taking over
+	if unix, ok := ln.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("listener on %s cannot be handed over", ln.Addr())
+	}
+	file, err := filer.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate listener on %s: %v", ln.Addr(), err)
+	}
+	return file, nil
+}
@@ -0,0 +1,79 @@
+package fileserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Tests provoke errors on purpose; keep their logs out of the output
+	ConfigureLogging("error", nil)
+	os.Exit(m.Run())
+}
+
+// newTestServer serves cfg, rooted in a fresh temporary directory unless
+// cfg names one, and stops its background work when the test ends.
+func newTestServer(t *testing.T, cfg Config) (*Server, http.Handler) {
+	t.Helper()
+	return newWrappedTestServer(t, cfg, nil)
+}
+
+// newWrappedTestServer is newTestServer with its storage replaced by what
+// wrap makes of it, for tests intercepting filesystem calls.
+func newWrappedTestServer(t *testing.T, cfg Config, wrap func(storage) storage) (*Server, http.Handler) {
+	t.Helper()
+	if cfg.RootDir == "" {
+		cfg.RootDir = t.TempDir()
+	}
+	s, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewServerFromConfig: %v", err)
+	}
+	if wrap != nil {
+		s.storage = wrap(s.storage)
+	}
+	h := s.Handler()
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s, h
+}
+
+// writeFiles creates files under root, by slash-separated path, along
+// with their directories.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serve answers one request for target, a raw request URI, on h.
+func serve(h http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// waitFor polls cond for up to five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package fileserver
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultSessionLifetime = 12 * time.Hour
+
+	sessionCookie = "fileserver_session"
+	loginPath     = "/_login"
+	logoutPath    = "/_logout"
+)
+
+// LoginFunc checks the user name and password submitted on the login page.
+type LoginFunc func(user, password string) bool
+
+// The login page is embedded only, like the error page.
+var loginPage = template.Must(template.ParseFS(templateFS, "templates/login.html"))
+
+// sessionClaims are the signed contents of a session cookie. Sessions are
+// stateless: the HMAC proves the server issued them, and Expires is
+// checked on every request whatever the cookie's own lifetime says.
+type sessionClaims struct {
+	User   string   `json:"u"`
+	Groups []string `json:"g,omitempty"`
+	// Set for OIDC logins, whose users need not be in the users file
+	External bool  `json:"x,omitempty"`
+	Expires  int64 `json:"exp"` // Unix seconds
+	// An OIDC refresh token, encrypted, and until when it may renew the
+	// session once Expires has passed
+	Refresh    string `json:"rt,omitempty"`
+	RenewUntil int64  `json:"rx,omitempty"`
+}
+
+type sessionSigner struct {
+	key      []byte
+	lifetime time.Duration
+}
+
+// newSessionSigner uses key, or a random one that lasts until restart.
+func newSessionSigner(key []byte, lifetime time.Duration) *sessionSigner {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultSessionLifetime
+	}
+	return &sessionSigner{key: key, lifetime: lifetime}
+}
+
+func (ss *sessionSigner) mac(payload string) []byte {
+	m := hmac.New(sha256.New, ss.key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// seal signs v's JSON; open checks the signature and decodes it.
+func (ss *sessionSigner) seal(v any) string {
+	data, _ := json.Marshal(v)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(ss.mac(payload))
+}
+
+func (ss *sessionSigner) open(value string, v any) bool {
+	payload, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return false
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, ss.mac(payload)) {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
+
+// encrypt seals plaintext with AES-GCM under a key derived from the
+// signing key, for secrets a cookie must carry but not show.
+func (ss *sessionSigner) encrypt(plaintext string) string {
+	aead := ss.aead()
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plaintext), nil))
+}
+
+func (ss *sessionSigner) decrypt(value string) (string, bool) {
+	aead := ss.aead()
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", false
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	return string(plaintext), err == nil
+}
+
+func (ss *sessionSigner) aead() cipher.AEAD {
+	key := sha256.Sum256(append([]byte("fileserver session encryption\x00"), ss.key...))
+	block, _ := aes.NewCipher(key[:])
+	aead, _ := cipher.NewGCM(block)
+	return aead
+}
+
+func (ss *sessionSigner) sign(user string) (string, time.Time) {
+	return ss.signClaims(sessionClaims{User: user})
+}
+
+func (ss *sessionSigner) signClaims(claims sessionClaims) (string, time.Time) {
+	expires := time.Now().Add(ss.lifetime)
+	claims.Expires = expires.Unix()
+	return ss.seal(claims), expires
+}
+
+// signRenewable signs a session valid until expires, at most the session
+// lifetime, that refresh can renew while it keeps being used: the cookie
+// lasts the session lifetime from now. It returns the cookie's expiry.
+func (ss *sessionSigner) signRenewable(claims sessionClaims, expires time.Time, refresh string) (string, time.Time) {
+	now := time.Now()
+	renewUntil := now.Add(ss.lifetime)
+	claims.Expires = min(expires.Unix(), renewUntil.Unix())
+	claims.Refresh, claims.RenewUntil = ss.encrypt(refresh), renewUntil.Unix()
+	return ss.seal(claims), renewUntil
+}
+
+// renewable returns the claims and refresh token of an expired session
+// cookie value that may still be renewed.
+func (ss *sessionSigner) renewable(value string) (sessionClaims, string, bool) {
+	var claims sessionClaims
+	now := time.Now().Unix()
+	if !ss.open(value, &claims) || claims.Refresh == "" || now < claims.Expires || now >= claims.RenewUntil {
+		return sessionClaims{}, "", false
+	}
+	refresh, ok := ss.decrypt(claims.Refresh)
+	return claims, refresh, ok
+}
+
+// verify returns the user of a valid, unexpired session cookie value.
+func (ss *sessionSigner) verify(value string) (string, bool) {
+	claims, ok := ss.verifyClaims(value)
+	return claims.User, ok
+}
+
+func (ss *sessionSigner) verifyClaims(value string) (sessionClaims, bool) {
+	var claims sessionClaims
+	if !ss.open(value, &claims) || time.Now().Unix() >= claims.Expires || claims.User == "" {
+		return sessionClaims{}, false
+	}
+	return claims, true
+}
+
+// sessionUser returns the user of the request's session, if it has one.
+func (s *Server) sessionUser(r *http.Request) (string, bool) {
+	claims, ok := s.session(r)
+	return claims.User, ok
+}
+
+func (s *Server) session(r *http.Request) (sessionClaims, bool) {
+	if s.sessions == nil {
+		return sessionClaims{}, false
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return sessionClaims{}, false
+	}
+	claims, ok := s.sessions.verifyClaims(cookie.Value)
+	if l := s.acl.Load(); ok && l != nil && !claims.External {
+		// Users removed from the users file lose their sessions
+		_, ok = l.users[claims.User]
+	}
+	return claims, ok
+}
+
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, value string, expires time.Time) {
+	cookiePath := basePath(r)
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     cookiePath,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	} else {
+		cookie.Expires = expires
+	}
+	http.SetCookie(w, cookie)
+}
+
+// replaceCookie changes the value of r's cookie name, so handlers later in
+// the request see a cookie renewed in the response.
+func replaceCookie(r *http.Request, name, value string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name == name {
+			c.Value = value
+		}
+		r.AddCookie(c)
+	}
+}
+
+// redirectToLogin sends a browser to the login page, to come back to the
+// page it asked for.
+func (s *Server) redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, loginURL(r), http.StatusSeeOther)
+}
+
+// loginURL leads to the login page and back to the requested page.
+func loginURL(r *http.Request) string {
+	return basePath(r) + loginPath + "?next=" + url.QueryEscape(basePath(r)+r.URL.RequestURI())
+}
+
+// redirectToLoginFrom sends a browser whose write was refused to the login
+// page, to come back to the page it was on (its same-origin Referer) or
+// else the request path.
+func (s *Server) redirectToLoginFrom(w http.ResponseWriter, r *http.Request) {
+	next := basePath(r) + r.URL.Path
+	if ref, err := url.Parse(r.Header.Get("Referer")); err == nil && ref.Host == r.Host && ref.Path != "" {
+		next = ref.RequestURI()
+	}
+	http.Redirect(w, r, basePath(r)+loginPath+"?next="+url.QueryEscape(next), http.StatusSeeOther)
+}
+
+// loginRedirectTarget is where to go after logging in: next if it is a
+// path on this server, the top directory otherwise.
+func loginRedirectTarget(r *http.Request, next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return basePath(r) + "/"
+	}
+	return next
+}
+
+type loginPageData struct {
+	Dir       string // asking for a DirAccessFile passphrase, not a login
+	Next      string
+	CSRFToken string
+	Error     string
+	Action    string
+	SSOURL    string // OIDC login, next to the form
+}
+
+// handleLogin shows the login form and, on POST, checks the credentials
+// with Config.Login and sets the session cookie.
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	data := loginPageData{
+		Next:   r.FormValue("next"),
+		Action: basePath(r) + loginPath,
+	}
+	if s.oidc != nil {
+		// With no login form the page is the provider's
+		if s.config.Login == nil || r.FormValue("sso") != "" {
+			s.startOIDC(w, r, data.Next)
+			return
+		}
+		data.SSOURL = basePath(r) + loginPath + "?sso=1&next=" + url.QueryEscape(data.Next)
+	}
+	status := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		user := r.PostFormValue("user")
+		if user != "" && s.config.Login(user, r.PostFormValue("password")) {
+			value, expires := s.sessions.sign(user)
+			s.setSessionCookie(w, r, value, expires)
+			getRequestInfo(r).identity = user
+			http.Redirect(w, r, loginRedirectTarget(r, data.Next), http.StatusSeeOther)
+			return
+		}
+		logHTTPError(r, areaAuth, http.StatusUnauthorized, "Login failed", "user "+user)
+		data.Error = "Wrong user name or password"
+		status = http.StatusUnauthorized
+	default:
+		methodNotAllowed(w, r, loginMethods)
+		return
+	}
+	data.CSRFToken = csrfToken(w, r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginPage.Execute(w, data); err != nil {
+		reqLogf(r, levelWarn, areaRequest, "Failed to render login page: %v", err)
+	}
+}
+
+// handleLogout clears the session cookie. The cookie is the whole session,
+// so a copy taken before logging out stays valid until it expires. OIDC
+// sessions go on to log out at the provider, which could otherwise log
+// the browser straight back in.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	claims, _ := s.session(r)
+	s.setSessionCookie(w, r, "", time.Time{})
+	if claims.External {
+		if u := s.oidcLogoutURL(r.Context()); u != "" {
+			http.Redirect(w, r, u, http.StatusSeeOther)
+			return
+		}
+	}
+	http.Redirect(w, r, basePath(r)+loginPath, http.StatusSeeOther)
+}
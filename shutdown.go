@@ -0,0 +1,84 @@
+package fileserver
+
+import (
+	"context"
+	"time"
+)
+
+// drainStallTimeout is how long a transfer may go without progress before
+// it is cut during shutdown.
+const drainStallTimeout = 30 * time.Second
+
+// Shutdown stops accepting connections immediately. Listings and other short
+// requests are cancelled once ctx expires; file transfers keep going for as
+// long as they make progress, up to the configured drain timeout.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	if s.stopBackground != nil {
+		s.stopBackground()
+	}
+	s.stopVirtualHosts()
+	if s.healthServer != nil {
+		if err := s.healthServer.Shutdown(ctx); err != nil {
+			warnf(areaServer, "Health server shutdown error: %v", err)
+		}
+	}
+	if s.pprofServer != nil {
+		// A running profile would hold up Shutdown for its whole duration
+		s.pprofServer.Close()
+	}
+	if s.httpServer == nil {
+		s.backgroundDone.Wait()
+		if s.tracer != nil {
+			s.tracer.shutdown(ctx)
+		}
+		return nil
+	}
+	// Background savers flush their state once stopped
+	defer s.backgroundDone.Wait()
+
+	drainTimeout := s.config.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = time.Minute
+	}
+	drainCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if s.tracer != nil {
+		// Runs after the requests end, in what is left of the drain window
+		defer s.tracer.shutdown(drainCtx)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.httpServer.Shutdown(drainCtx)
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	shortDeadline := ctx.Done()
+	for {
+		select {
+		case err := <-done:
+			if err == context.DeadlineExceeded {
+				remaining := s.transfers.count()
+				s.httpServer.Close()
+				warnf(areaServer, "Drain timeout of %s reached, cut off %d transfers", drainTimeout, remaining)
+				return nil
+			}
+			return err
+
+		case <-shortDeadline:
+			shortDeadline = nil
+			if s.cancelRequests != nil {
+				s.cancelRequests()
+			}
+			if n := s.transfers.count(); n > 0 {
+				infof(areaServer, "Draining, %d transfers remaining (up to %s)", n, drainTimeout)
+			}
+
+		case <-ticker.C:
+			s.transfers.abortStalled(drainStallTimeout)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits from the sitemaps.org protocol, per sitemap file
+const (
+	sitemapMaxURLs  = 50000
+	sitemapMaxBytes = 10 << 20
+	// A generated sitemap is reused for this long
+	sitemapCacheTTL = 10 * time.Minute
+	// Cap on the entries walked, so a huge tree cannot pin the server
+	sitemapMaxScanned = 2000000
+)
+
+const (
+	sitemapHeader = xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n"
+	sitemapFooter = "</urlset>\n"
+)
+
+// sitemapCache holds the rendered sitemap files for one base URL. With a
+// single file pages[0] is /sitemap.xml; with more, /sitemap.xml is an index
+// of /sitemap.xml?page=N.
+type sitemapCache struct {
+	mu      sync.Mutex
+	at      time.Time
+	baseURL string
+	pages   [][]byte
+	lastmod []time.Time // newest file per page
+}
+
+// sitemapBaseURL is where sitemap and playlist links point: PublicURL
+// when set, so a spoofed Host cannot end up in a cached sitemap, and
+// otherwise the scheme and host the client used.
+func (s *Server) sitemapBaseURL(r *http.Request) string {
+	if s.config.PublicURL != "" {
+		return strings.TrimSuffix(s.config.PublicURL, "/")
+	}
+	return requestBaseURL(r) + basePath(r)
+}
+
+func validatePublicURL(v string) error {
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("public URL must be http:// or https:// with a host and no query: %q", v)
+	}
+	return nil
+}
+
+// sitemapPages walks the whole tree, leaving out hidden and denied paths,
+// and renders it into sitemap files within the protocol limits. built is
+// when the walk ran, for Last-Modified.
+func (s *Server) sitemapPages(ctx context.Context, r *http.Request, baseURL string) (pages [][]byte, lastmod []time.Time, built time.Time) {
+	c := &s.sitemap
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.at) < sitemapCacheTTL && c.baseURL == baseURL {
+		return c.pages, c.lastmod, c.at
+	}
+
+	built = time.Now()
+	var buf bytes.Buffer
+	count := 0
+	var newest time.Time
+	flush := func() {
+		buf.WriteString(sitemapFooter)
+		pages = append(pages, bytes.Clone(buf.Bytes()))
+		lastmod = append(lastmod, newest)
+		buf.Reset()
+		count, newest = 0, time.Time{}
+	}
+
+	scanned, truncated := 0, false
+	var entry bytes.Buffer
+	// The sitemap is public: only what anonymous users may read
+	err := s.apiWalk(context.WithValue(ctx, aclUserKey, ""), s.rootDir, func(p string, d fs.DirEntry) error {
+		if scanned++; scanned > sitemapMaxScanned {
+			truncated = true
+			return fs.SkipAll
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entry.Reset()
+		entry.WriteString("<url><loc>")
+		xml.EscapeText(&entry, []byte(baseURL+urlPath("/"+p)))
+		entry.WriteString("</loc><lastmod>")
+		entry.WriteString(info.ModTime().UTC().Format(time.RFC3339))
+		entry.WriteString("</lastmod></url>\n")
+
+		if count == sitemapMaxURLs || buf.Len()+entry.Len()+len(sitemapFooter) > sitemapMaxBytes {
+			flush()
+		}
+		if count == 0 {
+			buf.WriteString(sitemapHeader)
+		}
+		buf.Write(entry.Bytes())
+		count++
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	if count > 0 || len(pages) == 0 {
+		if count == 0 {
+			buf.WriteString(sitemapHeader)
+		}
+		flush()
+	}
+	if err != nil || truncated {
+		reqLogf(r, levelWarn, areaListing, "Sitemap cut short after %d entries: %v", scanned, err)
+	}
+
+	// A client that went away says nothing about the tree
+	if ctx.Err() != context.Canceled {
+		c.at, c.baseURL, c.pages, c.lastmod = built, baseURL, pages, lastmod
+	}
+	return pages, lastmod, built
+}
+
+// sitemapIndex lists the sitemap files when there is more than one.
+func sitemapIndex(baseURL string, lastmod []time.Time) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for i, mod := range lastmod {
+		buf.WriteString("<sitemap><loc>")
+		xml.EscapeText(&buf, []byte(fmt.Sprintf("%s/sitemap.xml?page=%d", baseURL, i+1)))
+		buf.WriteString("</loc>")
+		if !mod.IsZero() {
+			buf.WriteString("<lastmod>" + mod.UTC().Format(time.RFC3339) + "</lastmod>")
+		}
+		buf.WriteString("</sitemap>\n")
+	}
+	buf.WriteString("</sitemapindex>\n")
+	return buf.Bytes()
+}
+
+// handleSitemap serves /sitemap.xml when -sitemap is set.
+func (s *Server) handleSitemap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, readMethods)
+		return
+	}
+	// The walk skips locked directories below the root, not the root
+	if !s.checkDirAccess(w, r, "/") {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.config.Timeouts.DirRead)
+	defer cancel()
+	baseURL := s.sitemapBaseURL(r)
+	pages, lastmod, built := s.sitemapPages(ctx, r, baseURL)
+
+	body := pages[0]
+	if v := r.URL.Query().Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > len(pages) || len(pages) == 1 {
+			httpError(w, r, areaRequest, http.StatusNotFound, "Not found", "sitemap page "+v)
+			return
+		}
+		body = pages[n-1]
+	} else if len(pages) > 1 {
+		body = sitemapIndex(baseURL, lastmod)
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(sitemapCacheTTL.Seconds())))
+	http.ServeContent(w, r, "", built, bytes.NewReader(body))
+}
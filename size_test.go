@@ -0,0 +1,75 @@
+package fileserver
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size       int64
+		binary, si string
+	}{
+		{0, "0 B", "0 B"},
+		{999, "999 B", "999 B"},
+		{1000, "1000 B", "1.0 kB"},
+		{1023, "1023 B", "1.0 kB"},
+		{1024, "1.0 KiB", "1.0 kB"},
+		{1536, "1.5 KiB", "1.5 kB"},
+		{999_949, "976.5 KiB", "999.9 kB"},
+		{999_950, "976.5 KiB", "1.0 MB"},
+		{1_048_575, "1.0 MiB", "1.0 MB"},
+		{1 << 20, "1.0 MiB", "1.0 MB"},
+		{1 << 30, "1.0 GiB", "1.1 GB"},
+		{1_000_000_000_000, "931.3 GiB", "1.0 TB"},
+		{math.MaxInt64, "8.0 EiB", "9.2 EB"},
+	}
+	for _, tt := range tests {
+		if got := FormatSize(tt.size); got != tt.binary {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.size, got, tt.binary)
+		}
+		if got := FormatSizeSI(tt.size); got != tt.si {
+			t.Errorf("FormatSizeSI(%d) = %q, want %q", tt.size, got, tt.si)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1000, "1,000"},
+		{1024, "1,024"},
+		{1_234_567, "1,234,567"},
+		{-1000, "-1,000"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.size); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestListingSizeUnits(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"default", Config{}, "1.0 KiB"},
+		{"-si", Config{SISizes: true}, "1.0 kB"},
+		{"-exact-sizes", Config{SISizes: true, ExactSizes: true}, "1,024 B"},
+	}
+	for _, tt := range tests {
+		s, h := newTestServer(t, tt.cfg)
+		writeFiles(t, s.rootDir, map[string]string{"f.bin": strings.Repeat("x", 1024)})
+		if w := serve(h, http.MethodGet, "/", nil); !strings.Contains(w.Body.String(), tt.want) {
+			t.Errorf("listing with %s lacks %q", tt.name, tt.want)
+		}
+	}
+}
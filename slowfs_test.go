@@ -0,0 +1,210 @@
+package fileserver
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// slowStorage serves an in-memory tree as the storage under root, like a
+// network mount: directory reads block until unblock, if set, is closed,
+// and each entry's Info takes statDelay.
+type slowStorage struct {
+	root      string
+	fsys      fstest.MapFS
+	unblock   chan struct{}
+	statDelay time.Duration
+	stats     atomic.Int64 // Info calls made
+}
+
+func (s *slowStorage) name(op, full string) (string, error) {
+	rel, err := filepath.Rel(s.root, full)
+	if err != nil || !fs.ValidPath(filepath.ToSlash(rel)) {
+		return "", &fs.PathError{Op: op, Path: full, Err: fs.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (s *slowStorage) wait() {
+	if s.unblock != nil {
+		<-s.unblock
+	}
+}
+
+func (s *slowStorage) Stat(full string) (fs.FileInfo, error) {
+	name, err := s.name("stat", full)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Stat(s.fsys, name)
+}
+
+func (s *slowStorage) Open(full string) (fs.File, error) {
+	name, err := s.name("open", full)
+	if err != nil {
+		return nil, err
+	}
+	f, err := s.fsys.Open(name)
+	if dir, ok := f.(fs.ReadDirFile); ok {
+		return &slowDir{dir, s}, nil
+	}
+	return f, err
+}
+
+func (s *slowStorage) ReadDir(full string) ([]fs.DirEntry, error) {
+	name, err := s.name("readdir", full)
+	if err != nil {
+		return nil, err
+	}
+	s.wait()
+	entries, err := fs.ReadDir(s.fsys, name)
+	return s.slowEntries(entries), err
+}
+
+func (s *slowStorage) Sub(full string) (fs.FS, error) {
+	name, err := s.name("sub", full)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Sub(s.fsys, name)
+}
+
+func (s *slowStorage) slowEntries(entries []fs.DirEntry) []fs.DirEntry {
+	for i, e := range entries {
+		entries[i] = slowEntry{e, s}
+	}
+	return entries
+}
+
+type slowDir struct {
+	fs.ReadDirFile
+	s *slowStorage
+}
+
+func (d *slowDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	d.s.wait()
+	entries, err := d.ReadDirFile.ReadDir(n)
+	return d.s.slowEntries(entries), err
+}
+
+type slowEntry struct {
+	fs.DirEntry
+	s *slowStorage
+}
+
+func (e slowEntry) Info() (fs.FileInfo, error) {
+	time.Sleep(e.s.statDelay)
+	e.s.stats.Add(1)
+	return e.DirEntry.Info()
+}
+
+// newSlowServer serves slow's tree from an empty root.
+func newSlowServer(t testing.TB, cfg Config, slow *slowStorage) (*Server, http.Handler) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.RootDir, slow.root = root, root
+	s, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewServerFromConfig: %v", err)
+	}
+	s.storage = slow
+	h := s.Handler()
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s, h
+}
+
+// TestHungDirReadsBounded lists directories on a mount whose reads never
+// return: each request gives up at -dir-read-timeout, and past
+// -max-dir-reads stuck reads new requests get 503 instead of parking yet
+// another goroutine.
+func TestHungDirReadsBounded(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := range 20 {
+		fsys[fmt.Sprintf("d%d/f.txt", i)] = &fstest.MapFile{}
+	}
+	const maxReads = 4
+	slow := &slowStorage{fsys: fsys, unblock: make(chan struct{})}
+	s, h := newSlowServer(t, Config{MaxDirReads: maxReads, Timeouts: Timeouts{DirRead: 20 * time.Millisecond}}, slow)
+	base := runtime.NumGoroutine()
+
+	for i := range 20 {
+		want := http.StatusRequestTimeout
+		if i >= maxReads {
+			want = http.StatusServiceUnavailable
+		}
+		if w := serve(h, http.MethodGet, fmt.Sprintf("/d%d/", i), nil); w.Code != want {
+			t.Errorf("listing %d: status %d, want %d", i, w.Code, want)
+		}
+	}
+	if n := s.dirReader.outstanding(); n != maxReads {
+		t.Errorf("%d reads outstanding, want %d", n, maxReads)
+	}
+	// A little slack for runtime goroutines such as timers
+	if n := runtime.NumGoroutine(); n > base+maxReads+2 {
+		t.Errorf("%d goroutines after 20 hung listings, started with %d", n, base)
+	}
+
+	close(slow.unblock)
+	waitFor(t, "the hung reads to finish", func() bool { return s.dirReader.outstanding() == 0 })
+	waitFor(t, "their goroutines to exit", func() bool { return runtime.NumGoroutine() <= base+2 })
+	if w := serve(h, http.MethodGet, "/d0/", nil); w.Code != http.StatusOK {
+		t.Errorf("listing once the mount recovers: status %d", w.Code)
+	}
+}
+
+func slowListing(entries int) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := range entries {
+		fsys[fmt.Sprintf("big/f%05d.txt", i)] = &fstest.MapFile{}
+	}
+	return fsys
+}
+
+// TestSlowStatCanceled cancels a listing whose entries take long to stat:
+// the workers stop picking up entries and exit with the request.
+func TestSlowStatCanceled(t *testing.T) {
+	const entries = 3000
+	slow := &slowStorage{fsys: slowListing(entries), statDelay: 2 * time.Millisecond}
+	_, h := newSlowServer(t, Config{StatWorkers: 4}, slow)
+	base := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(30*time.Millisecond, cancel)
+	r := httptest.NewRequestWithContext(ctx, http.MethodGet, "/big/", nil)
+	start := time.Now()
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	// Stat'ing everything would take 1.5s
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("canceled listing took %v", elapsed)
+	}
+	if n := slow.stats.Load(); n >= entries {
+		t.Errorf("stat'ed all %d entries after the request was canceled", n)
+	}
+	waitFor(t, "the stat workers to exit", func() bool { return runtime.NumGoroutine() <= base+1 })
+}
+
+// BenchmarkSlowStat lists 200 entries that take 1ms each to stat, as on a
+// high-latency mount, with one worker and with the default pool.
+func BenchmarkSlowStat(b *testing.B) {
+	for _, workers := range []int{1, DefaultStatWorkers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			slow := &slowStorage{fsys: slowListing(200), statDelay: time.Millisecond}
+			_, h := newSlowServer(b, Config{StatWorkers: workers}, slow)
+			for b.Loop() {
+				if w := serve(h, http.MethodGet, "/big/", nil); w.Code != http.StatusOK {
+					b.Fatalf("status %d", w.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package fileserver
+
+import (
+	"context"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultStatWorkers is how many directory entries are stat'ed at once
+// while building a listing.
+const DefaultStatWorkers = 16
+
+// entryInfos calls Info on every entry, StatWorkers at a time: on a network
+// mount each call is a round trip, so a serial loop over thousands of
+// entries takes seconds. infos[i] belongs to entries[i]; it is nil where
+// errs[i] says why, including entries skipped once ctx ended.
+func (s *Server) entryInfos(ctx context.Context, entries []fs.DirEntry) (infos []fs.FileInfo, errs []error) {
+	infos = make([]fs.FileInfo, len(entries))
+	errs = make([]error, len(entries))
+	s.parallel(ctx, len(entries), func(i int) {
+		infos[i], errs[i] = entries[i].Info()
+	}, func(i int, err error) {
+		errs[i] = err
+	})
+	return infos, errs
+}
+
+// parallel calls fn for 0..n-1 with up to StatWorkers at a time. Once ctx
+// ends, the remaining indexes go to skip instead.
+func (s *Server) parallel(ctx context.Context, n int, fn func(i int), skip func(i int, err error)) {
+	run := func(i int) {
+		if err := ctx.Err(); err != nil {
+			skip(i, err)
+			return
+		}
+		fn(i)
+	}
+
+	workers := min(s.config.StatWorkers, n)
+	if workers <= 1 {
+		for i := range n {
+			run(i)
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= n {
+					return
+				}
+				run(i)
+			}
+		})
+	}
+	wg.Wait()
+}
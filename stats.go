@@ -0,0 +1,224 @@
+package fileserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type downloadStat struct {
+	Path         string    `json:"path"`
+	Downloads    int64     `json:"downloads"`
+	Bytes        int64     `json:"bytes"`
+	LastDownload time.Time `json:"lastDownload"`
+}
+
+// downloadStats counts downloads per path. A client fetching the same path
+// repeatedly within the window (typically resumed or chunked Range
+// requests) counts as one download; all bytes are always counted.
+type downloadStats struct {
+	file   string
+	window time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*downloadStat
+	seen    map[string]time.Time // client + path -> last counted
+	dirty   bool
+}
+
+func newDownloadStats(file string, window time.Duration) (*downloadStats, error) {
+	ds := &downloadStats{
+		file:    file,
+		window:  window,
+		entries: make(map[string]*downloadStat),
+		seen:    make(map[string]time.Time),
+	}
+	if file == "" {
+		return ds, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return ds, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stats file: %v", err)
+	}
+	var saved []downloadStat
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse stats file %s: %v", file, err)
+	}
+	for i := range saved {
+		ds.entries[saved[i].Path] = &saved[i]
+	}
+	return ds, nil
+}
+
+func (ds *downloadStats) record(client, path string, bytes int64) {
+	now := time.Now()
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	entry, ok := ds.entries[path]
+	if !ok {
+		entry = &downloadStat{Path: path}
+		ds.entries[path] = entry
+	}
+	entry.Bytes += bytes
+	entry.LastDownload = now
+
+	key := client + "\x00" + path
+	if last, ok := ds.seen[key]; !ok || now.Sub(last) > ds.window {
+		entry.Downloads++
+	}
+	ds.seen[key] = now
+	ds.dirty = true
+}
+
+func (ds *downloadStats) top(n int) []downloadStat {
+	ds.mu.Lock()
+	list := make([]downloadStat, 0, len(ds.entries))
+	for _, entry := range ds.entries {
+		list = append(list, *entry)
+	}
+	ds.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Downloads != list[j].Downloads {
+			return list[i].Downloads > list[j].Downloads
+		}
+		return list[i].Path < list[j].Path
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// save writes the counters atomically if anything changed.
+func (ds *downloadStats) save() error {
+	if ds.file == "" {
+		return nil
+	}
+
+	ds.mu.Lock()
+	if !ds.dirty {
+		ds.mu.Unlock()
+		return nil
+	}
+	list := make([]downloadStat, 0, len(ds.entries))
+	for _, entry := range ds.entries {
+		list = append(list, *entry)
+	}
+	ds.dirty = false
+	ds.mu.Unlock()
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ds.file, data)
+}
+
+func (ds *downloadStats) pruneSeen() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	for key, last := range ds.seen {
+		if time.Since(last) > ds.window {
+			delete(ds.seen, key)
+		}
+	}
+}
+
+// run persists the counters every minute and once more on shutdown.
+func (ds *downloadStats) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ds.pruneSeen()
+			if err := ds.save(); err != nil {
+				warnf(areaServer, "Failed to save download stats: %v", err)
+			}
+		case <-ctx.Done():
+			if err := ds.save(); err != nil {
+				warnf(areaServer, "Failed to save download stats: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// recordDownload counts a finished file response toward the stats.
+func (s *Server) recordDownload(r *http.Request, tw *transferWriter, fullPath string) {
+	if s.stats == nil {
+		return
+	}
+	status, bytes := tw.written()
+	if (status != http.StatusOK && status != http.StatusPartialContent) || bytes == 0 {
+		return
+	}
+	rel, err := filepath.Rel(s.rootDir, fullPath)
+	if err != nil {
+		return
+	}
+	s.stats.record(clientIP(r), "/"+filepath.ToSlash(rel), bytes)
+}
+
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+func (s *Server) handleStatsTop(w http.ResponseWriter, r *http.Request) {
+	n := 20
+	if v := r.URL.Query().Get("n"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 1 {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid n parameter", v)
+			return
+		}
+		n = min(parsed, 1000)
+	}
+	top := s.stats.top(n)
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if wantsJSON(r) {
+		// Superseded by /_api/v1/stats/top; kept for one release
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+basePath(r)+apiV1Prefix+"stats/top>; rel=\"successor-version\"")
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(top); err != nil {
+			reqLogf(r, levelWarn, areaRequest, "Failed to write stats: %v", err)
+		}
+		return
+	}
+
+	type row struct {
+		downloadStat
+		URL      string
+		BytesStr string
+		LastStr  string
+	}
+	rows := make([]row, len(top))
+	for i, stat := range top {
+		rows[i] = row{stat, basePath(r) + urlPath(stat.Path), s.formatSize(stat.Bytes), stat.LastDownload.Format("2006-01-02 15:04:05")}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.template.ExecuteTemplate(w, "stats.html", rows); err != nil {
+		reqLogf(r, levelError, areaRequest, "Template execution error: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+type statusReport struct {
+	State           string `json:"state"`
+	Message         string `json:"message"`
+	UptimeSeconds   int64  `json:"uptimeSeconds"`
+	ActiveConns     int64  `json:"activeConnections"`
+	MaxConns        int    `json:"maxConnections,omitempty"`
+	ConnLimitWaits  int64  `json:"connectionLimitWaits,omitempty"`
+	ActiveTransfers int    `json:"activeTransfers"`
+	DirReads        int    `json:"outstandingDirReads"`
+	FSCalls         int64  `json:"outstandingFsCalls"`
+	BytesServed     int64  `json:"bytesServed"`
+	MountHealthy    bool   `json:"mountHealthy"`
+	// Free space and the headroom writes must leave, with a minimum set
+	Space *spaceStatus `json:"space,omitempty"`
+}
+
+func (s *Server) statusSnapshot() statusReport {
+	report := statusReport{
+		State:           "serving",
+		UptimeSeconds:   int64(time.Since(s.startTime).Seconds()),
+		ActiveConns:     s.activeConns.Load(),
+		ActiveTransfers: s.transfers.count(),
+		DirReads:        s.dirReader.outstanding(),
+		FSCalls:         s.fsCalls.running.Load(),
+		BytesServed:     s.transfers.bytesServed.Load(),
+	}
+	report.MountHealthy = s.mount.unavailable() == nil
+	if s.space != nil {
+		report.Space = s.space.status()
+	}
+	if s.connLimit != nil {
+		report.MaxConns = s.connLimit.max
+		report.ConnLimitWaits = s.connLimit.waits.Load()
+	}
+	report.Message = fmt.Sprintf("serving, %d active transfers", report.ActiveTransfers)
+	if s.draining.Load() {
+		report.State = "draining"
+		report.Message = fmt.Sprintf("draining, %d transfers remaining", report.ActiveTransfers)
+	}
+	return report
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if err := json.NewEncoder(w).Encode(s.statusSnapshot()); err != nil {
+		reqLogf(r, levelWarn, areaRequest, "Failed to write status: %v", err)
+	}
+}
+
+// LogStatus writes a one-line snapshot to the log (triggered by SIGUSR1).
+func (s *Server) LogStatus() {
+	report := s.statusSnapshot()
+	infof(areaServer, "Status: %s, uptime %s, %d connections, %d transfers, %d directory reads outstanding, %s served, mount healthy: %v",
+		report.State, (time.Duration(report.UptimeSeconds) * time.Second).String(),
+		report.ActiveConns, report.ActiveTransfers, report.DirReads, s.formatSize(report.BytesServed), report.MountHealthy)
+}
+
+func (s *Server) trackConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.activeConns.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		s.activeConns.Add(-1)
+	}
+}
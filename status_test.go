@@ -0,0 +1,24 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestStatusOptIn(t *testing.T) {
+	_, h := newTestServer(t, Config{})
+	if w := serve(h, http.MethodGet, "/_status", nil); w.Code != http.StatusNotFound {
+		t.Errorf("/_status without -status: status %d, want 404", w.Code)
+	}
+
+	_, h = newTestServer(t, Config{Status: true})
+	w := serve(h, http.MethodGet, "/_status", nil)
+	var report statusReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); w.Code != http.StatusOK || err != nil {
+		t.Fatalf("/_status with -status: status %d, body %s", w.Code, w.Body)
+	}
+	if report.State != "serving" || !report.MountHealthy {
+		t.Errorf("report = %+v", report)
+	}
+}
@@ -0,0 +1,106 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// storage is where served files come from. Names are full paths under the
+// server root, as built by handleRequest, so logs and relative-path
+// calculations work the same whatever backs them.
+type storage interface {
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (fs.File, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	// Sub returns the tree rooted at name, used for archive downloads
+	Sub(name string) (fs.FS, error)
+}
+
+// osStorage serves the local filesystem. Open returns *os.File so file
+// bodies keep using sendfile.
+type osStorage struct{}
+
+func (osStorage) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osStorage) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osStorage) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osStorage) Sub(name string) (fs.FS, error)             { return os.DirFS(name), nil }
+
+// singleFileStorage serves a root that is one file. The root path is the
+// file; nothing exists below it.
+type singleFileStorage struct {
+	path string
+}
+
+func (s singleFileStorage) check(op, name string) error {
+	if name != s.path {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return nil
+}
+
+func (s singleFileStorage) Stat(name string) (fs.FileInfo, error) {
+	if err := s.check("stat", name); err != nil {
+		return nil, err
+	}
+	return os.Stat(name)
+}
+
+func (s singleFileStorage) Open(name string) (fs.File, error) {
+	if err := s.check("open", name); err != nil {
+		return nil, err
+	}
+	return os.Open(name)
+}
+
+func (s singleFileStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+}
+
+func (s singleFileStorage) Sub(name string) (fs.FS, error) {
+	return nil, &fs.PathError{Op: "sub", Path: name, Err: syscall.ENOTDIR}
+}
+
+// openRoot resolves a -root value to its storage and the absolute root that
+// request paths are joined onto. The server and the offline tools share it
+// so digests and other per-path data agree. A file root is served as an
+// archive when it is one, unless singleFile asks for the file itself.
+func openRoot(root string, singleFile bool) (storage, string, error) {
+	if isS3Root(root) {
+		st, err := openS3Storage(root)
+		if err != nil {
+			return nil, "", err
+		}
+		return st, st.root, nil
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get absolute path: %v", err)
+	}
+	// A single .zip or .tar file is served read-only as if extracted; any
+	// other file is served alone
+	if info, err := os.Stat(absRoot); err == nil && info.Mode().IsRegular() {
+		if singleFile || !isArchiveFile(absRoot) {
+			return singleFileStorage{path: absRoot}, absRoot, nil
+		}
+		st, err := openArchiveStorage(absRoot)
+		if err != nil {
+			return nil, "", err
+		}
+		return st, absRoot, nil
+	}
+	// Verify the root directory exists and is accessible
+	if err := validateRootDirectory(absRoot); err != nil {
+		return nil, "", err
+	}
+	// Contain requests in the real directory, with the case and symlinks
+	// the filesystem reports rather than as typed on the command line
+	realRoot, err := filepath.EvalSymlinks(absRoot)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve root directory: %v", err)
+	}
+	return osStorage{}, realRoot, nil
+}
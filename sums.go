@@ -0,0 +1,87 @@
+package fileserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSumsBudget caps the bytes a ?sums=sha256 request may hash; files
+// with a cached digest do not count.
+const DefaultSumsBudget = 4 << 30
+
+// SumsFile is the manifest name written by the hash command.
+const SumsFile = "SHA256SUMS"
+
+var sumsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+
+// sumsLine formats one sha256sum line. Like coreutils, a name with a
+// backslash or line break is escaped and the line starts with a backslash,
+// which sha256sum -c understands.
+func sumsLine(sum, name string) string {
+	if strings.ContainsAny(name, "\\\n\r") {
+		return `\` + sum + "  " + sumsEscaper.Replace(name) + "\n"
+	}
+	return sum + "  " + name + "\n"
+}
+
+// writeSums answers ?sums=sha256 with a manifest of the regular files in
+// the listing, for sha256sum -c. Digests come from the checksum cache, so
+// only new or changed files are read; those must fit in the budget, and
+// larger trees are left to "fileserver hash -write-sums".
+func (s *Server) writeSums(w http.ResponseWriter, r *http.Request, fullPath, requestPath string, files []FileInfo) {
+	if algo := r.URL.Query().Get("sums"); algo != "sha256" {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Unsupported checksum algorithm, use sha256", algo)
+		return
+	}
+	budget := s.config.SumsBudget
+	if budget <= 0 {
+		budget = DefaultSumsBudget
+	}
+
+	var regular []FileInfo
+	var uncached int64
+	for _, f := range files {
+		if f.IsDir || f.info == nil || !f.info.Mode().IsRegular() {
+			continue
+		}
+		regular = append(regular, f)
+		if _, ok := s.digests.cached(filepath.Join(fullPath, filepath.FromSlash(f.Name)), f.info); !ok {
+			uncached += f.Size
+		}
+	}
+	if uncached > budget {
+		httpError(w, r, areaRequest, http.StatusForbidden, "Too much data to hash on request, see fileserver hash -write-sums",
+			fmt.Sprintf("%s needs %d uncached bytes hashed, budget %d", requestPath, uncached, budget))
+		return
+	}
+
+	var manifest strings.Builder
+	done := requestTiming(r).track("hash")
+	for _, f := range regular {
+		name := filepath.Join(fullPath, filepath.FromSlash(f.Name))
+		sum, err := s.digests.sha256(r.Context(), s.storage, name, f.info)
+		if errors.Is(err, context.DeadlineExceeded) {
+			done()
+			w.Header().Set("Retry-After", "30")
+			httpError(w, r, areaIO, http.StatusServiceUnavailable, "Checksums are being computed, retry shortly", "")
+			return
+		}
+		if err != nil {
+			done()
+			httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to compute checksum", fmt.Sprintf("hashing %s: %v", name, err))
+			return
+		}
+		manifest.WriteString(sumsLine(sum, f.Name))
+	}
+	done()
+
+	s.emitTiming(w, r)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", contentDisposition("inline", SumsFile))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Write([]byte(manifest.String()))
+}
@@ -0,0 +1,158 @@
+package fileserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	tailPollInterval = time.Second
+	// Sessions end when the file has not grown for this long
+	tailIdleTimeout   = 10 * time.Minute
+	tailDefaultBack   = 4 << 10
+	tailMaxBack       = 1 << 20
+	tailMaxChunk      = 1 << 20
+	tailRetryAfterSec = 30
+)
+
+// handleTail streams a text file as it grows, like tail -f: the last few KB
+// first (?from=N bytes before the end), then appended data, polled once a
+// second. A truncated or replaced file (log rotation) is reopened from its
+// start. r must carry the request's base context, not the storage timeout.
+func (s *Server) handleTail(w http.ResponseWriter, r *http.Request, fullPath string) {
+	if s.config.MaxTailSessions <= 0 {
+		httpError(w, r, areaRequest, http.StatusNotFound, "Tailing is disabled", "")
+		return
+	}
+	if _, ok := s.storage.(osStorage); !ok {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Tailing needs a local directory root", "")
+		return
+	}
+	back := int64(tailDefaultBack)
+	if v := r.URL.Query().Get("from"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid from parameter", v)
+			return
+		}
+		back = min(n, tailMaxBack)
+	}
+	if n := s.tailSessions.Add(1); n > int64(s.config.MaxTailSessions) {
+		s.tailSessions.Add(-1)
+		w.Header().Set("Retry-After", strconv.Itoa(tailRetryAfterSec))
+		httpError(w, r, areaRequest, http.StatusServiceUnavailable, "Too many tail sessions", fmt.Sprintf("%d sessions open", n-1))
+		return
+	}
+	defer s.tailSessions.Add(-1)
+
+	file, info, err := openTail(fullPath)
+	if err != nil {
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to open file", fmt.Sprintf("opening %s: %v", fullPath, err))
+		return
+	}
+	defer func() { file.Close() }()
+
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	if !looksLikeText(head[:n]) {
+		httpError(w, r, areaRequest, http.StatusUnsupportedMediaType, "Only text files can be tailed", "")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// The server's write timeout would end the stream; each write gets
+	// its own deadline instead
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	offset := max(0, info.Size()-back)
+	if offset > 0 {
+		// Start on a line boundary
+		buf := make([]byte, min(back, info.Size()))
+		n, _ := file.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			offset += int64(i) + 1
+		}
+	}
+	send := func(size int64) error {
+		for offset < size {
+			chunk := min(size-offset, tailMaxChunk)
+			if s.config.Timeouts.Write > 0 {
+				rc.SetWriteDeadline(time.Now().Add(s.config.Timeouts.Write))
+			}
+			n, err := io.Copy(w, io.NewSectionReader(file, offset, chunk))
+			offset += n
+			if err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	reqLogf(r, levelDebug, areaRequest, "Tailing %s", fullPath)
+	if err := send(info.Size()); err != nil {
+		return
+	}
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	lastGrowth := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := os.Stat(fullPath)
+		if os.IsNotExist(err) {
+			// Rotated away; the new file usually appears shortly
+		} else if err != nil {
+			reqLogf(r, levelWarn, areaIO, "Failed to stat %s while tailing: %v", fullPath, err)
+			return
+		} else if !os.SameFile(info, current) || current.Size() < offset {
+			reopened, reopenedInfo, err := openTail(fullPath)
+			if err != nil {
+				reqLogf(r, levelWarn, areaIO, "Failed to reopen %s while tailing: %v", fullPath, err)
+				return
+			}
+			reqLogf(r, levelDebug, areaRequest, "Reopened %s after rotation or truncation", fullPath)
+			file.Close()
+			file, info, offset = reopened, reopenedInfo, 0
+			current = reopenedInfo
+		}
+		if current != nil && current.Size() > offset {
+			if err := send(current.Size()); err != nil {
+				return
+			}
+			lastGrowth = time.Now()
+		}
+		if time.Since(lastGrowth) > tailIdleTimeout {
+			reqLogf(r, levelDebug, areaRequest, "Ending tail of %s after %s without growth", fullPath, tailIdleTimeout)
+			return
+		}
+	}
+}
+
+func openTail(fullPath string) (*os.File, fs.FileInfo, error) {
+	file, err := os.Open(fullPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
@@ -0,0 +1,118 @@
+package fileserver
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// templateFuncs are available to the embedded and custom templates, so
+// templates can format the raw Size and ModTime fields themselves.
+var templateFuncs = template.FuncMap{
+	"formatSize":  FormatSize,
+	"formatBytes": formatBytes,
+	"ago":         timeAgo,
+	"formatDate":  func(layout string, t time.Time) string { return t.Format(layout) },
+	"pathEscape":  url.PathEscape,
+	"urlPath":     urlPath,
+	"category":    fileCategory,
+	"icon":        fileIcon,
+}
+
+const TemplateHelp = `Custom templates (-templates DIR) replace embedded templates of the same
+name: directory.html (PageData) and stats.html.
+
+PageData fields: Title, BasePath, CurrentPath, ParentPath, Files, Header,
+Footer, Assets, mapping embedded stylesheet names to their cacheable URLs,
+e.g. {{index .Assets "directory.css"}}, Categories (Name, Count, Active and
+URL of each ?type= filter toggle), FilterQuery, the query string that
+keeps the filter on directory links, and Glob and GlobClear, the active
+?glob= pattern and the query string without it. User is the
+authenticated identity, "" for anonymous requests; LoginURL and LogoutURL
+are set when a login page exists, to show write controls only to users.
+FileInfo fields: Name, Size, ModTime, IsDir, and the preformatted SizeStr
+and ModStr.
+
+Functions:
+  formatSize SIZE          1536 -> "1.5 KiB" (or 1500 -> "1.5 kB" with -si)
+  formatBytes SIZE         1536 -> "1,536"
+  ago TIME                 "5 minutes ago"
+  formatDate LAYOUT TIME   Go layout, e.g. (formatDate "2006-01-02" .ModTime)
+  pathEscape NAME          escape a name for use in a URL path segment
+  urlPath PATH             escape a path, keeping its slashes, e.g. (urlPath .ParentPath)
+  category NAME ISDIR      folder, image, video, audio, archive, document, code, text or file
+  icon NAME ISDIR          an emoji for the category
+
+Example:
+  {{range .Files}}<a href="{{pathEscape .Name}}">{{icon .Name .IsDir}} {{.Name}}</a>
+  {{formatSize .Size}}, {{ago .ModTime}}{{end}}
+`
+
+var categoryExtensions = map[string]string{
+	".jpg": "image", ".jpeg": "image", ".png": "image", ".gif": "image", ".webp": "image", ".svg": "image", ".bmp": "image", ".heic": "image",
+	".mp4": "video", ".mkv": "video", ".webm": "video", ".mov": "video", ".avi": "video",
+	".mp3": "audio", ".flac": "audio", ".ogg": "audio", ".wav": "audio", ".m4a": "audio", ".opus": "audio",
+	".zip": "archive", ".tar": "archive", ".gz": "archive", ".tgz": "archive", ".zst": "archive", ".xz": "archive", ".bz2": "archive", ".7z": "archive", ".rar": "archive",
+	".pdf": "document", ".doc": "document", ".docx": "document", ".odt": "document", ".xls": "document", ".xlsx": "document", ".ppt": "document", ".pptx": "document",
+	".go": "code", ".py": "code", ".js": "code", ".ts": "code", ".c": "code", ".h": "code", ".rs": "code", ".java": "code", ".sh": "code", ".html": "code", ".css": "code", ".json": "code", ".yaml": "code", ".yml": "code",
+	".txt": "text", ".md": "text", ".log": "text", ".csv": "text",
+}
+
+var categoryIcons = map[string]string{
+	"folder": "📁", "image": "🖼️", "video": "🎬", "audio": "🎵", "archive": "📦",
+	"document": "📄", "code": "📝", "text": "📃", "file": "📄",
+}
+
+func fileCategory(name string, isDir bool) string {
+	if isDir {
+		return "folder"
+	}
+	if category, ok := categoryExtensions[strings.ToLower(path.Ext(name))]; ok {
+		return category
+	}
+	return "file"
+}
+
+func fileIcon(name string, isDir bool) string {
+	return categoryIcons[fileCategory(name, isDir)]
+}
+
+// timeAgo renders t relative to now in the largest whole unit, e.g.
+// "3 hours ago" or, for clock skew and future dates, "in 2 days".
+func timeAgo(t time.Time) string {
+	return timeAgoAt(t, time.Now())
+}
+
+func timeAgoAt(t, now time.Time) string {
+	d, future := now.Sub(t), false
+	if d < 0 {
+		d, future = -d, true
+	}
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{365 * 24 * time.Hour, "year"},
+		{30 * 24 * time.Hour, "month"},
+		{7 * 24 * time.Hour, "week"},
+		{24 * time.Hour, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	}
+	for _, unit := range units {
+		if n := int(d / unit.size); n >= 1 {
+			amount := fmt.Sprintf("%d %ss", n, unit.name)
+			if n == 1 {
+				amount = "1 " + unit.name
+			}
+			if future {
+				return "in " + amount
+			}
+			return amount + " ago"
+		}
+	}
+	return "just now"
+}
@@ -0,0 +1,47 @@
+package fileserver
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// writeTextListing answers ?format=txt: one name per line, directories
+// ending in "/", for piping into grep or xargs. With abs=1 lines are paths
+// from the root. A name with a line break would split into two lines, so
+// such entries are left out and counted in X-Omitted-Entries.
+func (s *Server) writeTextListing(w http.ResponseWriter, r *http.Request, requestPath string, files []FileInfo, truncated bool) {
+	prefix := ""
+	if r.URL.Query().Get("abs") == "1" {
+		prefix = requestPath
+	}
+
+	var buf bytes.Buffer
+	omitted := 0
+	for _, f := range files {
+		if strings.ContainsAny(f.Name, "\r\n") {
+			omitted++
+			continue
+		}
+		buf.WriteString(prefix)
+		buf.WriteString(f.Name)
+		if f.IsDir {
+			buf.WriteByte('/')
+		}
+		buf.WriteByte('\n')
+	}
+
+	if omitted > 0 {
+		reqLogf(r, levelWarn, areaListing, "Omitted %d entries with line breaks in their names from the text listing of %s", omitted, requestPath)
+		w.Header().Set("X-Omitted-Entries", strconv.Itoa(omitted))
+	}
+	if truncated {
+		w.Header().Set("X-Listing-Truncated", "1")
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	s.emitTiming(w, r)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,218 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultThumbnailerConcurrency = 2
+	DefaultThumbnailerTimeout     = 30 * time.Second
+
+	thumbnailMinSize     = 32
+	thumbnailMaxSize     = 1024
+	thumbnailStderrLimit = 16 << 10
+)
+
+// videoIcon stands in for a thumbnail the command could not make.
+const videoIcon = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 64 64"><rect x="4" y="12" width="56" height="40" rx="6" fill="#5b6b7f"/><path d="M26 22v20l17-10z" fill="#fff"/></svg>`
+
+// thumbnailer makes video thumbnails with -thumbnailer-cmd, run as
+// "cmd INPUT OUTPUT SIZE" to write a JPEG of at most SIZE pixels on its
+// longer side. Results are cached on disk by file, size and mtime; at
+// most concurrency commands run at once, and requests for a thumbnail
+// being made wait for that run.
+type thumbnailer struct {
+	command string // as configured
+	path    string // resolved, "" when the command was not found
+	dir     string
+	timeout time.Duration
+	slots   chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]*thumbnailJob
+}
+
+type thumbnailJob struct {
+	done chan struct{}
+	err  error
+}
+
+func newThumbnailer(command, dir string, concurrency int, timeout time.Duration) (*thumbnailer, error) {
+	if dir == "" {
+		base, err := os.UserCacheDir()
+		if err != nil {
+			base = os.TempDir()
+		}
+		dir = filepath.Join(base, "fileserver", "thumbnails")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create thumbnail cache directory: %v", err)
+	}
+	// Left over from commands cut short by a restart
+	if stale, err := filepath.Glob(filepath.Join(dir, "tmp-*")); err == nil {
+		for _, name := range stale {
+			os.Remove(name)
+		}
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultThumbnailerConcurrency
+	}
+	if timeout <= 0 {
+		timeout = DefaultThumbnailerTimeout
+	}
+	t := &thumbnailer{command: command, dir: dir, timeout: timeout,
+		slots: make(chan struct{}, concurrency), inflight: make(map[string]*thumbnailJob)}
+	if path, err := exec.LookPath(command); err != nil {
+		warnf(areaServer, "Failed to find -thumbnailer-cmd, videos get a generic icon: %v", err)
+	} else {
+		t.path = path
+	}
+	return t, nil
+}
+
+func isVideo(name string) bool {
+	return fileCategory(name, false) == "video" || strings.HasPrefix(mime.TypeByExtension(strings.ToLower(filepath.Ext(name))), "video/")
+}
+
+// thumbnail returns the cached thumbnail of fullPath, making it first if
+// needed.
+func (t *thumbnailer) thumbnail(ctx context.Context, r *http.Request, fullPath string, info fs.FileInfo, size int) (string, error) {
+	if t.path == "" {
+		return "", fmt.Errorf("command %s not found", t.command)
+	}
+	key := sha256.Sum256(fmt.Appendf(nil, "%s\x00%d\x00%d\x00%d", fullPath, info.Size(), info.ModTime().UnixNano(), size))
+	out := filepath.Join(t.dir, hex.EncodeToString(key[:])+".jpg")
+	if _, err := os.Stat(out); err == nil {
+		return out, nil
+	}
+
+	t.mu.Lock()
+	job, running := t.inflight[out]
+	if !running {
+		job = &thumbnailJob{done: make(chan struct{})}
+		t.inflight[out] = job
+		// Carries on if this request goes away, so a retry finds it
+		go func() {
+			job.err = t.run(r, fullPath, out, size)
+			t.mu.Lock()
+			delete(t.inflight, out)
+			t.mu.Unlock()
+			close(job.done)
+		}()
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-job.done:
+		return out, job.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (t *thumbnailer) run(r *http.Request, fullPath, out string, size int) error {
+	t.slots <- struct{}{}
+	defer func() { <-t.slots }()
+
+	tmp, err := os.CreateTemp(t.dir, "tmp-*.jpg")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, t.path, fullPath, tmp.Name(), strconv.Itoa(size))
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedBuffer{buf: &stderr, max: thumbnailStderrLimit}
+	err = cmd.Run()
+
+	name := filepath.Base(t.path)
+	lines := bufio.NewScanner(&stderr)
+	for lines.Scan() {
+		reqLogf(r, levelDebug, areaIO, "%s: %s", name, lines.Text())
+	}
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", t.timeout)
+	}
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(tmp.Name()); err != nil || info.Size() == 0 {
+		return fmt.Errorf("wrote no thumbnail")
+	}
+	return os.Rename(tmp.Name(), out)
+}
+
+// limitedBuffer keeps the first max bytes written and discards the rest,
+// so a chatty command cannot fill memory.
+type limitedBuffer struct {
+	buf *bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// serveThumbnail answers ?thumb=SIZE on a video. A thumbnail that cannot
+// be made is replaced by a generic video icon, never an error, so
+// listings showing thumbnails don't break.
+func (s *Server) serveThumbnail(w http.ResponseWriter, r *http.Request, fullPath string, info fs.FileInfo) {
+	size, err := strconv.Atoi(r.URL.Query().Get("thumb"))
+	if err != nil || size < thumbnailMinSize || size > thumbnailMaxSize {
+		httpError(w, r, areaRequest, http.StatusBadRequest,
+			fmt.Sprintf("Invalid thumbnail size, want %d to %d", thumbnailMinSize, thumbnailMaxSize), "")
+		return
+	}
+	if !isVideo(info.Name()) {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Thumbnails are only made for videos", "")
+		return
+	}
+	if _, local := s.storage.(osStorage); !local {
+		s.serveVideoIcon(w, r)
+		return
+	}
+
+	out, err := s.thumbnails.thumbnail(r.Context(), r, fullPath, info, size)
+	if err != nil {
+		reqLogf(r, levelWarn, areaIO, "Failed to make thumbnail of %s: %v", fullPath, err)
+		s.serveVideoIcon(w, r)
+		return
+	}
+	thumb, err := os.Open(out)
+	if err != nil {
+		s.serveVideoIcon(w, r)
+		return
+	}
+	defer thumb.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", info.ModTime(), thumb)
+}
+
+func (s *Server) serveVideoIcon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// Short, so a fixed command is picked up soon
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	w.Write([]byte(videoIcon))
+}
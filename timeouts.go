@@ -0,0 +1,77 @@
+package fileserver
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultRequestTimeout    = 30 * time.Second
+	DefaultReadTimeout       = 30 * time.Second
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultWriteTimeout      = 60 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultDirReadTimeout    = 30 * time.Second
+	DefaultStatTimeout       = 10 * time.Second
+)
+
+// Timeouts groups the server's deadlines. WriteTimeout bounds ordinary
+// responses; for file and archive bodies it acts as an idle timeout that is
+// pushed forward as bytes flow, so slow but steady downloads are not cut.
+type Timeouts struct {
+	Request    time.Duration
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	DirRead    time.Duration
+	// Stat bounds a single stat or open of a served file
+	Stat time.Duration
+}
+
+func (t *Timeouts) setDefaults() {
+	defaults := []struct {
+		value *time.Duration
+		def   time.Duration
+	}{
+		{&t.Request, DefaultRequestTimeout},
+		{&t.Read, DefaultReadTimeout},
+		{&t.ReadHeader, DefaultReadHeaderTimeout},
+		{&t.Write, DefaultWriteTimeout},
+		{&t.Idle, DefaultIdleTimeout},
+		{&t.DirRead, DefaultDirReadTimeout},
+		{&t.Stat, DefaultStatTimeout},
+	}
+	for _, d := range defaults {
+		if *d.value == 0 {
+			*d.value = d.def
+		}
+	}
+}
+
+func (t Timeouts) validate() error {
+	values := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"request", t.Request},
+		{"read", t.Read},
+		{"read-header", t.ReadHeader},
+		{"write", t.Write},
+		{"idle", t.Idle},
+		{"dir-read", t.DirRead},
+		{"stat", t.Stat},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("-%s-timeout must not be negative: %s", v.name, v.value)
+		}
+	}
+	if t.ReadHeader > t.Read {
+		return fmt.Errorf("-read-header-timeout (%s) must not exceed -read-timeout (%s)", t.ReadHeader, t.Read)
+	}
+	if t.DirRead > t.Request {
+		return fmt.Errorf("-dir-read-timeout (%s) must not exceed -request-timeout (%s)", t.DirRead, t.Request)
+	}
+	return nil
+}
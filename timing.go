@@ -0,0 +1,77 @@
+package fileserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type timingMetric struct {
+	name string
+	dur  time.Duration
+}
+
+// serverTiming collects named phase durations for one request. Handlers
+// record phases as they go and call emit once, right before the body
+// starts, since headers cannot change afterwards.
+type serverTiming struct {
+	mu      sync.Mutex
+	metrics []timingMetric
+	emitted bool
+	span    *span // phases become its child spans when traced
+}
+
+// requestTiming returns the request's timing collector.
+func requestTiming(r *http.Request) *serverTiming {
+	info := getRequestInfo(r)
+	if info.timing == nil {
+		info.timing = &serverTiming{span: info.span}
+	}
+	return info.timing
+}
+
+// track starts timing a phase; call the returned func when it ends.
+func (t *serverTiming) track(name string) func() {
+	start := time.Now()
+	return func() {
+		end := time.Now()
+		t.mu.Lock()
+		t.metrics = append(t.metrics, timingMetric{name, end.Sub(start)})
+		t.mu.Unlock()
+		if t.span != nil {
+			t.span.tracer.child(t.span, name, start, end)
+		}
+	}
+}
+
+func (t *serverTiming) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.metrics))
+	for i, m := range t.metrics {
+		parts[i] = fmt.Sprintf("%s;dur=%.1f", m.name, float64(m.dur.Microseconds())/1000)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// emitTiming adds the Server-Timing header (when enabled) and logs the
+// breakdown at debug level. Later calls are no-ops.
+func (s *Server) emitTiming(w http.ResponseWriter, r *http.Request) {
+	t := requestTiming(r)
+	t.mu.Lock()
+	if t.emitted || len(t.metrics) == 0 {
+		t.emitted = true
+		t.mu.Unlock()
+		return
+	}
+	t.emitted = true
+	t.mu.Unlock()
+
+	value := t.String()
+	if s.config.ServerTiming {
+		w.Header().Set("Server-Timing", value)
+	}
+	reqLogf(r, levelDebug, areaRequest, "Timing for %s: %s", r.URL.Path, value)
+}
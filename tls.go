@@ -0,0 +1,122 @@
+package fileserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// buildTLSConfig returns nil when TLS is not configured.
+func buildTLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if cfg.ClientCAFile != "" {
+			return nil, fmt.Errorf("-client-ca requires -tls-cert and -tls-key")
+		}
+		if cfg.TLSMinVersion != "" || len(cfg.TLSCipherSuites) > 0 || len(cfg.TLSCurves) > 0 {
+			return nil, fmt.Errorf("-tls-min-version, -tls-ciphers and -tls-curves require -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, fmt.Errorf("both -tls-cert and -tls-key must be set")
+	}
+
+	// Fail at startup rather than on the first handshake
+	if _, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair: %v", err)
+	}
+
+	// Listing h2 up front makes every Serve call on the shared http.Server
+	// set up HTTP/2 the same way, whichever of several listeners starts first
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12, NextProtos: []string{"h2", "http/1.1"}}
+	if err := applyTLSPolicy(tlsConfig, cfg); err != nil {
+		return nil, err
+	}
+
+	if cfg.ClientCAFile != "" {
+		pemData, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("no certificates found in client CA file: %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// requireClientCert records the verified certificate identity on the request
+// and enforces the optional CN/SAN allowlist. Chain validation (unknown CA,
+// expiry) already happened during the handshake.
+func (s *Server) requireClientCert(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(s.config.ClientAllow))
+	for _, name := range s.config.ClientAllow {
+		allowed[strings.ToLower(name)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			httpError(w, r, areaAuth, http.StatusUnauthorized, "Client certificate required", "")
+			return
+		}
+
+		cert := r.TLS.PeerCertificates[0]
+		names := certificateNames(cert)
+
+		identity := cert.Subject.CommonName
+		if identity == "" && len(names) > 0 {
+			identity = names[0]
+		}
+		getRequestInfo(r).identity = identity
+
+		if len(allowed) > 0 {
+			permitted := false
+			for _, name := range names {
+				if allowed[strings.ToLower(name)] {
+					permitted = true
+					break
+				}
+			}
+			if !permitted {
+				httpError(w, r, areaAuth, http.StatusForbidden, "Access denied", fmt.Sprintf("client certificate not in allowlist: %s (%s)", cert.Subject, clientIP(r)))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func certificateNames(cert *x509.Certificate) []string {
+	var names []string
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// tlsErrorLogWriter makes handshake rejections (unknown CA, expired client
+// certificate) stand out from the rest of net/http's error log.
+type tlsErrorLogWriter struct{}
+
+func (tlsErrorLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	if strings.Contains(msg, "TLS handshake error") && strings.Contains(msg, "certificate") {
+		warnf(areaAuth, "Rejected TLS client: %s", strings.TrimPrefix(msg, "http: "))
+	} else {
+		warnf(areaServer, "%s", msg)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,105 @@
+package fileserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519":         tls.X25519,
+	"X25519MLKEM768": tls.X25519MLKEM768,
+	"P-256":          tls.CurveP256,
+	"P-384":          tls.CurveP384,
+	"P-521":          tls.CurveP521,
+}
+
+// acceptedNames lists a table's keys for error messages.
+func acceptedNames[V any](table map[string]V) string {
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyTLSPolicy sets the minimum version, TLS 1.2 cipher suites and curve
+// preferences. Only the suites Go considers secure can be chosen.
+func applyTLSPolicy(tlsConfig *tls.Config, cfg Config) error {
+	if cfg.TLSMinVersion != "" {
+		version, ok := tlsVersions[cfg.TLSMinVersion]
+		if !ok {
+			return fmt.Errorf("unknown TLS version %q for -tls-min-version, accepted: %s", cfg.TLSMinVersion, acceptedNames(tlsVersions))
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if len(cfg.TLSCipherSuites) > 0 {
+		suites := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			for _, version := range suite.SupportedVersions {
+				if version == tls.VersionTLS12 {
+					suites[suite.Name] = suite.ID
+				}
+			}
+		}
+		for _, name := range cfg.TLSCipherSuites {
+			id, ok := suites[name]
+			if !ok {
+				return fmt.Errorf("unknown TLS 1.2 cipher suite %q for -tls-ciphers, accepted: %s", name, acceptedNames(suites))
+			}
+			tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+		}
+		// net/http refuses to start HTTP/2 without one of these
+		if !slices.Contains(tlsConfig.CipherSuites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) &&
+			!slices.Contains(tlsConfig.CipherSuites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+			return fmt.Errorf("-tls-ciphers must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, which HTTP/2 requires")
+		}
+		if tlsConfig.MinVersion >= tls.VersionTLS13 {
+			warnf(areaServer, "-tls-ciphers has no effect with -tls-min-version 1.3; TLS 1.3 suites are not configurable")
+		}
+	}
+
+	for _, name := range cfg.TLSCurves {
+		curve, ok := tlsCurves[name]
+		if !ok {
+			return fmt.Errorf("unknown curve %q for -tls-curves, accepted: %s", name, acceptedNames(tlsCurves))
+		}
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, curve)
+	}
+	return nil
+}
+
+// tlsPolicySummary describes the effective handshake policy for the log.
+func tlsPolicySummary(tlsConfig *tls.Config) string {
+	version := "1.2"
+	if tlsConfig.MinVersion >= tls.VersionTLS13 {
+		version = "1.3"
+	}
+	ciphers := "Go defaults"
+	if len(tlsConfig.CipherSuites) > 0 {
+		names := make([]string, len(tlsConfig.CipherSuites))
+		for i, id := range tlsConfig.CipherSuites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		ciphers = strings.Join(names, ", ")
+	}
+	curves := "Go defaults"
+	if len(tlsConfig.CurvePreferences) > 0 {
+		names := make([]string, len(tlsConfig.CurvePreferences))
+		for i, id := range tlsConfig.CurvePreferences {
+			for name, curve := range tlsCurves {
+				if curve == id {
+					names[i] = name
+				}
+			}
+		}
+		curves = strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("minimum TLS %s, TLS 1.2 ciphers: %s, curves: %s", version, ciphers, curves)
+}
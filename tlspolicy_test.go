@@ -0,0 +1,132 @@
+package fileserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed ECDSA certificate for localhost and
+// its key, returning their paths.
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// handshake runs a TLS handshake between a server with serverConfig and a
+// client with clientConfig, returning the client's error.
+func handshake(serverConfig, clientConfig *tls.Config) error {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		tls.Server(serverConn, serverConfig).Handshake()
+	}()
+	clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	return tls.Client(clientConn, clientConfig).Handshake()
+}
+
+func TestTLSPolicyHandshake(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := func(cfg Config) *tls.Config {
+		cfg.TLSCertFile, cfg.TLSKeyFile = certFile, keyFile
+		tlsConfig, err := buildTLSConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		return tlsConfig
+	}
+	client := func(min, max uint16, suites ...uint16) *tls.Config {
+		return &tls.Config{InsecureSkipVerify: true, MinVersion: min, MaxVersion: max, CipherSuites: suites}
+	}
+
+	defaults := serverConfig(Config{})
+	only13 := serverConfig(Config{TLSMinVersion: "1.3"})
+	pinned := serverConfig(Config{TLSCipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}})
+
+	tests := []struct {
+		name   string
+		server *tls.Config
+		client *tls.Config
+		ok     bool
+	}{
+		{"TLS 1.3", defaults, client(tls.VersionTLS13, tls.VersionTLS13), true},
+		{"TLS 1.2 AES-GCM", defaults, client(tls.VersionTLS12, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), true},
+		{"TLS 1.0", defaults, client(tls.VersionTLS10, tls.VersionTLS10), false},
+		{"TLS 1.1", defaults, client(tls.VersionTLS11, tls.VersionTLS11), false},
+		{"TLS 1.2 RC4", defaults, client(tls.VersionTLS12, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA), false},
+		{"TLS 1.2 CBC-SHA256", defaults, client(tls.VersionTLS12, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256), false},
+		{"TLS 1.2 against -tls-min-version 1.3", only13, client(tls.VersionTLS12, tls.VersionTLS12), false},
+		{"TLS 1.3 against -tls-min-version 1.3", only13, client(tls.VersionTLS12, tls.VersionTLS13), true},
+		{"suite outside -tls-ciphers", pinned, client(tls.VersionTLS12, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256), false},
+		{"suite in -tls-ciphers", pinned, client(tls.VersionTLS12, tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), true},
+	}
+	// The weak clients do connect to a server that allows them, so the
+	// refusals above come from the policy
+	permissive := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS10,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+	}
+	for _, tt := range tests {
+		if !tt.ok && tt.server == defaults {
+			if err := handshake(permissive, tt.client); err != nil {
+				t.Errorf("%s: no handshake even with a permissive server: %v", tt.name, err)
+			}
+		}
+		err := handshake(tt.server, tt.client)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: handshake error %v, want success %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestTLSPolicyRejectsWeakSuites(t *testing.T) {
+	for _, name := range []string{"TLS_RSA_WITH_RC4_128_SHA", "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA", "TLS_AES_128_GCM_SHA256"} {
+		if err := applyTLSPolicy(&tls.Config{}, Config{TLSCipherSuites: []string{name, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}}); err == nil {
+			t.Errorf("-tls-ciphers accepted %s", name)
+		}
+	}
+}
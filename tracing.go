@@ -0,0 +1,360 @@
+package fileserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans are exported as OTLP/HTTP JSON, which needs no client library.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanStatusError  = 2
+
+	traceBatchSize  = 512
+	traceMaxPending = 4096
+	traceInterval   = 5 * time.Second
+)
+
+// traceSpanNames names the child spans of serverTiming phases.
+var traceSpanNames = map[string]string{
+	"open": "open file",
+	"dir":  "ReadDir",
+	"stat": "stat entries",
+	"tmpl": "render template",
+	"read": "read file",
+	"hash": "hash file",
+	"copy": "copy file",
+}
+
+type spanAttr struct {
+	key   string
+	value any // string, int64 or bool
+}
+
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte // zero for a trace started here
+	name     string
+	kind     int
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	failed   bool
+}
+
+// tracer batches finished spans and posts them to an OTLP collector in
+// the background. Spans beyond traceMaxPending are dropped rather than
+// slowing requests down.
+type tracer struct {
+	endpoint string
+	service  string
+	ratio    float64
+	client   *http.Client
+
+	mu      sync.Mutex
+	pending []*span
+	dropped int64
+	failing bool // warned about a failed export
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newTracer exports to endpoint, an OTLP/HTTP collector such as
+// http://localhost:4318 (the /v1/traces path is added when missing).
+func newTracer(endpoint, service string, ratio float64) (*tracer, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, want e.g. http://localhost:4318", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+	if ratio == 0 {
+		ratio = 1
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("invalid trace sample ratio %g, want 0 to 1", ratio)
+	}
+	if service == "" {
+		service = "fileserver"
+	}
+	t := &tracer{
+		endpoint: u.String(),
+		service:  service,
+		ratio:    ratio,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go t.run()
+	return t, nil
+}
+
+// parseTraceparent reads a W3C traceparent header.
+func parseTraceparent(h string) (traceID [16]byte, parentID [8]byte, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 {
+		return
+	}
+	if n, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil || n != 16 || len(parts[1]) != 32 {
+		return
+	}
+	if n, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil || n != 8 || len(parts[2]) != 16 {
+		return
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 || traceID == [16]byte{} || parentID == [8]byte{} {
+		return
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// startRequest starts the server span for r, continuing the caller's
+// trace from traceparent. It returns nil when the trace is not sampled:
+// the caller's decision when it sent one, the sample ratio otherwise.
+func (t *tracer) startRequest(r *http.Request) *span {
+	sp := &span{tracer: t, name: r.Method, kind: spanKindServer, start: time.Now()}
+	if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("Traceparent")); ok {
+		if !sampled {
+			return nil
+		}
+		sp.traceID, sp.parentID = traceID, parentID
+	} else {
+		if t.ratio < 1 && mrand.Float64() >= t.ratio {
+			return nil
+		}
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.spanID[:])
+	depth := int64(0)
+	if p := strings.Trim(r.URL.Path, "/"); p != "" {
+		depth = int64(strings.Count(p, "/") + 1)
+	}
+	sp.attrs = append(sp.attrs,
+		spanAttr{"http.request.method", r.Method},
+		spanAttr{"url.path", r.URL.Path},
+		spanAttr{"fileserver.path_depth", depth})
+	return sp
+}
+
+// child records a finished phase of parent's request.
+func (t *tracer) child(parent *span, phase string, start, end time.Time) {
+	name := traceSpanNames[phase]
+	if name == "" {
+		name = phase
+	}
+	sp := &span{tracer: t, traceID: parent.traceID, parentID: parent.spanID, name: name, kind: spanKindInternal, start: start, end: end}
+	rand.Read(sp.spanID[:])
+	t.finish(sp)
+}
+
+func (t *tracer) finish(sp *span) {
+	if sp.end.IsZero() {
+		sp.end = time.Now()
+	}
+	t.mu.Lock()
+	if len(t.pending) >= traceMaxPending {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.pending = append(t.pending, sp)
+	full := len(t.pending) >= traceBatchSize
+	t.mu.Unlock()
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(traceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+		case <-t.kick:
+		}
+		t.flush(context.Background())
+	}
+}
+
+// flush exports everything pending, a batch at a time.
+func (t *tracer) flush(ctx context.Context) {
+	for {
+		t.mu.Lock()
+		n := min(len(t.pending), traceBatchSize)
+		batch := t.pending[:n:n]
+		t.pending = t.pending[n:]
+		dropped := t.dropped
+		t.dropped = 0
+		t.mu.Unlock()
+		if dropped > 0 {
+			warnf(areaServer, "Dropped %d trace spans: the OTLP exporter fell behind", dropped)
+		}
+		if n == 0 {
+			return
+		}
+		err := t.export(ctx, batch)
+		t.mu.Lock()
+		warn, recovered := err != nil && !t.failing, err == nil && t.failing
+		t.failing = err != nil
+		t.mu.Unlock()
+		switch {
+		case warn:
+			warnf(areaServer, "Failed to export trace spans to %s: %v", t.endpoint, err)
+		case recovered:
+			infof(areaServer, "Exporting trace spans to %s again", t.endpoint)
+		}
+		if err != nil || ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// shutdown stops the background exporter and sends what is left, giving
+// up when ctx ends.
+func (t *tracer) shutdown(ctx context.Context) {
+	close(t.stop)
+	<-t.done
+	t.flush(ctx)
+}
+
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+	BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttrs(attrs []spanAttr) []otlpAttr {
+	out := make([]otlpAttr, 0, len(attrs))
+	for _, a := range attrs {
+		var v otlpValue
+		switch x := a.value.(type) {
+		case string:
+			v.StringValue = &x
+		case int64:
+			s := strconv.FormatInt(x, 10)
+			v.IntValue = &s
+		case bool:
+			v.BoolValue = &x
+		}
+		out = append(out, otlpAttr{a.key, v})
+	}
+	return out
+}
+
+func (t *tracer) export(ctx context.Context, spans []*span) error {
+	type otlpSpan struct {
+		TraceID      string         `json:"traceId"`
+		SpanID       string         `json:"spanId"`
+		ParentSpanID string         `json:"parentSpanId,omitempty"`
+		Name         string         `json:"name"`
+		Kind         int            `json:"kind"`
+		Start        string         `json:"startTimeUnixNano"`
+		End          string         `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr     `json:"attributes,omitempty"`
+		Status       map[string]int `json:"status,omitempty"`
+	}
+	out := make([]otlpSpan, len(spans))
+	for i, sp := range spans {
+		out[i] = otlpSpan{
+			TraceID:    hex.EncodeToString(sp.traceID[:]),
+			SpanID:     hex.EncodeToString(sp.spanID[:]),
+			Name:       sp.name,
+			Kind:       sp.kind,
+			Start:      strconv.FormatInt(sp.start.UnixNano(), 10),
+			End:        strconv.FormatInt(sp.end.UnixNano(), 10),
+			Attributes: otlpAttrs(sp.attrs),
+		}
+		if sp.parentID != [8]byte{} {
+			out[i].ParentSpanID = hex.EncodeToString(sp.parentID[:])
+		}
+		if sp.failed {
+			out[i].Status = map[string]int{"code": spanStatusError}
+		}
+	}
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttrs([]spanAttr{
+				{"service.name", t.service},
+				{"service.version", Build().Version},
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]string{"name": "fileserver"},
+				"spans": out,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// traceRequests wraps every request in a server span, with the phases
+// handlers time through requestTiming as its children.
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sp := s.tracer.startRequest(r)
+		if sp == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		info := getRequestInfo(r)
+		info.span = sp
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		sp.attrs = append(sp.attrs,
+			spanAttr{"http.response.status_code", int64(rec.status)},
+			spanAttr{"fileserver.bytes", rec.bytes},
+			spanAttr{"fileserver.cache_hit", info.cacheHit})
+		sp.failed = rec.status >= 500
+		s.tracer.finish(sp)
+	})
+}
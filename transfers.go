@@ -0,0 +1,216 @@
+package fileserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errTransferAborted = errors.New("transfer aborted during shutdown")
+
+// transfer is one in-flight response body large enough to deserve the
+// extended shutdown drain (file downloads and archives).
+type transfer struct {
+	path         string
+	bytes        atomic.Int64
+	lastProgress atomic.Int64 // unix nanoseconds
+	aborted      atomic.Bool
+	rc           *http.ResponseController
+	started      time.Time
+}
+
+type transferTracker struct {
+	// Write deadline pushed forward on every chunk, replacing the server's
+	// fixed WriteTimeout for bodies that are making progress
+	writeTimeout time.Duration
+	io           *ioTuner
+
+	mu          sync.Mutex
+	active      map[*transfer]struct{}
+	bytesServed atomic.Int64
+}
+
+func newTransferTracker(writeTimeout time.Duration, tuning IOTuning) *transferTracker {
+	return &transferTracker{writeTimeout: writeTimeout, io: newIOTuner(tuning), active: make(map[*transfer]struct{})}
+}
+
+// begin registers a transfer and returns a ResponseWriter that accounts for
+// the bytes written through it. The returned func must be called when the
+// body is finished.
+func (tt *transferTracker) begin(w http.ResponseWriter, path string) (*transferWriter, func()) {
+	t := &transfer{path: path, rc: http.NewResponseController(w), started: time.Now()}
+	t.lastProgress.Store(time.Now().UnixNano())
+
+	tt.mu.Lock()
+	tt.active[t] = struct{}{}
+	tt.mu.Unlock()
+
+	return &transferWriter{ResponseWriter: w, t: t, tt: tt}, func() {
+		tt.mu.Lock()
+		delete(tt.active, t)
+		tt.mu.Unlock()
+	}
+}
+
+func (tt *transferTracker) count() int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return len(tt.active)
+}
+
+// abortStalled cuts transfers that have not moved a byte within stall.
+// Setting an expired write deadline also unblocks a write that is stuck on a
+// client that stopped reading.
+func (tt *transferTracker) abortStalled(stall time.Duration) int {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+
+	aborted := 0
+	cutoff := time.Now().Add(-stall).UnixNano()
+	for t := range tt.active {
+		if t.lastProgress.Load() < cutoff && !t.aborted.Swap(true) {
+			t.rc.SetWriteDeadline(time.Now())
+			warnf(areaIO, "Aborting stalled transfer of %s after %s", t.path, FormatSize(t.bytes.Load()))
+			aborted++
+		}
+	}
+	return aborted
+}
+
+type transferWriter struct {
+	http.ResponseWriter
+	t      *transfer
+	tt     *transferTracker
+	client *clientCounter // optional per-client bandwidth accounting
+	status int
+}
+
+func (tw *transferWriter) WriteHeader(status int) {
+	if tw.status == 0 {
+		tw.status = status
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+// written reports the response status and body bytes so far.
+func (tw *transferWriter) written() (int, int64) {
+	status := tw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status, tw.t.bytes.Load()
+}
+
+func (tw *transferWriter) account(n int64) {
+	if n > 0 {
+		tw.t.bytes.Add(n)
+		tw.tt.bytesServed.Add(n)
+		if tw.client != nil {
+			tw.client.bytes.Add(n)
+		}
+		tw.t.lastProgress.Store(time.Now().UnixNano())
+	}
+}
+
+// extendDeadline gives the next write a fresh WriteTimeout.
+func (tw *transferWriter) extendDeadline() {
+	if tw.tt.writeTimeout > 0 {
+		tw.t.rc.SetWriteDeadline(time.Now().Add(tw.tt.writeTimeout))
+	}
+}
+
+func (tw *transferWriter) Write(p []byte) (int, error) {
+	if tw.t.aborted.Load() {
+		return 0, errTransferAborted
+	}
+	tw.extendDeadline()
+	n, err := tw.ResponseWriter.Write(p)
+	tw.account(int64(n))
+	return n, err
+}
+
+// transferChunk bounds how long a single sendfile call can run without
+// progress being recorded.
+const transferChunk = 512 << 10
+
+// ReadFrom keeps the kernel sendfile path that ServeContent gets from the
+// underlying connection, copying in chunks so progress stays visible. Files
+// are copied with the server's IOTuning; ServeContent has already seeked to
+// the start of the range.
+func (tw *transferWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, _ := tw.ResponseWriter.(io.ReaderFrom)
+	tuner := tw.tt.io
+
+	var file *os.File
+	remaining := int64(-1)
+	switch r := src.(type) {
+	case *os.File:
+		file = r
+	case *io.LimitedReader:
+		if f, isFile := r.R.(*os.File); isFile {
+			file, remaining = f, r.N
+		}
+	}
+	if file == nil || (rf == nil && tuner.CopyBuffer <= 0) {
+		return io.Copy(struct{ io.Writer }{tw}, src)
+	}
+
+	var offset int64
+	if tuner.hinted() {
+		offset, _ = file.Seek(0, io.SeekCurrent)
+		tuner.start(file, offset)
+	}
+	var total int64
+	for remaining != 0 {
+		if tw.t.aborted.Load() {
+			return total, errTransferAborted
+		}
+		chunk := int64(transferChunk)
+		if remaining > 0 && remaining < chunk {
+			chunk = remaining
+		}
+		tw.extendDeadline()
+		n, err := tuner.copyChunk(tw.ResponseWriter, rf, file, chunk)
+		total += n
+		tw.account(n)
+		if tuner.hinted() {
+			tuner.sent(file, offset, n)
+			offset += n
+		}
+		if remaining > 0 {
+			remaining -= n
+		}
+		if err != nil {
+			return total, err
+		}
+		if n < chunk {
+			break // EOF
+		}
+	}
+	if src, ok := src.(*io.LimitedReader); ok {
+		src.N -= total
+	}
+	return total, nil
+}
+
+func (tw *transferWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// beginTransfer starts tracking a file or archive body for r, charging its
+// bytes to the requesting client when bandwidth accounting is enabled.
+func (s *Server) beginTransfer(w http.ResponseWriter, r *http.Request, path string) (*transferWriter, func()) {
+	tw, done := s.transfers.begin(w, path)
+	if s.bandwidth == nil {
+		return tw, done
+	}
+	tw.client = s.bandwidth.acquire(accountingClient(r))
+	return tw, func() {
+		tw.client.refs.Add(-1)
+		done()
+	}
+}
@@ -0,0 +1,112 @@
+package fileserver
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// Names written on macOS arrive decomposed (NFD) while links typed or
+// pasted elsewhere are usually composed (NFC). With -normalize-unicode a
+// failed lookup is retried in the other form. The tables cover Latin, Greek,
+// Cyrillic and Hangul, and composition only joins a mark to the character
+// right before it, which is how both forms appear in practice.
+
+var compositions = func() map[[2]rune]rune {
+	m := make(map[[2]rune]rune, len(decompositions))
+	for composed, pair := range decompositions {
+		m[pair] = composed
+	}
+	return m
+}()
+
+// Hangul syllable arithmetic from the Unicode standard, section 3.12
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+func decomposeRune(b *strings.Builder, r rune) {
+	if s := r - hangulSBase; s >= 0 && s < hangulSCount {
+		b.WriteRune(hangulLBase + s/hangulNCount)
+		b.WriteRune(hangulVBase + s%hangulNCount/hangulTCount)
+		if t := s % hangulTCount; t != 0 {
+			b.WriteRune(hangulTBase + t)
+		}
+		return
+	}
+	if pair, ok := decompositions[r]; ok {
+		decomposeRune(b, pair[0])
+		b.WriteRune(pair[1])
+		return
+	}
+	b.WriteRune(r)
+}
+
+// toNFD returns s fully decomposed.
+func toNFD(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		decomposeRune(&b, r)
+	}
+	return b.String()
+}
+
+func composePair(a, b rune) (rune, bool) {
+	if l, v := a-hangulLBase, b-hangulVBase; l >= 0 && l < hangulLCount && v >= 0 && v < hangulVCount {
+		return hangulSBase + (l*hangulVCount+v)*hangulTCount, true
+	}
+	if s, t := a-hangulSBase, b-hangulTBase; s >= 0 && s < hangulSCount && s%hangulTCount == 0 && t > 0 && t < hangulTCount {
+		return a + t, true
+	}
+	r, ok := compositions[[2]rune{a, b}]
+	return r, ok
+}
+
+// toNFC returns s composed.
+func toNFC(s string) string {
+	runes := []rune(toNFD(s))
+	out := runes[:0]
+	for _, r := range runes {
+		if n := len(out); n > 0 {
+			if composed, ok := composePair(out[n-1], r); ok {
+				out[n-1] = composed
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+// canonicalPath returns the spelling of requestPath that names a file:
+// requestPath itself or, with NormalizeUnicode, the other normalization
+// form when only that exists. Deny patterns, users file rules and
+// passphrase locks are all checked on the result, so a rule written for
+// one spelling also holds for the other.
+func (s *Server) canonicalPath(ctx context.Context, requestPath string) string {
+	if !s.config.NormalizeUnicode {
+		return requestPath
+	}
+	if _, err := s.stat(ctx, s.fsPath(requestPath)); !os.IsNotExist(err) {
+		return requestPath
+	}
+	for _, alt := range []string{toNFC(requestPath), toNFD(requestPath)} {
+		if alt == requestPath {
+			continue
+		}
+		if _, err := s.stat(ctx, s.fsPath(alt)); err == nil {
+			return alt
+		} else if !os.IsNotExist(err) {
+			break
+		}
+	}
+	return requestPath
+}
@@ -0,0 +1,75 @@
+package fileserver
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	cafeNFC = "Caf\u00e9"
+	cafeNFD = "Cafe\u0301"
+)
+
+func TestNormalizationForms(t *testing.T) {
+	if got := toNFD(cafeNFC); got != cafeNFD {
+		t.Errorf("toNFD(%q) = %q, want %q", cafeNFC, got, cafeNFD)
+	}
+	if got := toNFC(cafeNFD); got != cafeNFC {
+		t.Errorf("toNFC(%q) = %q, want %q", cafeNFD, got, cafeNFC)
+	}
+	if got := toNFC("각"); got != "각" {
+		t.Errorf("toNFC of Hangul jamo = %q, want %q", got, "각")
+	}
+}
+
+// TestNormalizedPolicy checks that a deny pattern, users file rule or
+// passphrase lock on the NFC spelling of a path cannot be bypassed by
+// requesting its NFD spelling, which -normalize-unicode serves.
+func TestNormalizedPolicy(t *testing.T) {
+	hash, err := HashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"open/" + cafeNFC + ".txt":                "public",
+		"denied/" + cafeNFC + "/secret.txt":       "secret",
+		"locked/" + cafeNFC + "/secret.txt":       "secret",
+		"locked/" + cafeNFC + "/" + DirAccessFile: hash,
+		"private/" + cafeNFC + "/secret.txt":      "secret",
+		"users.json": `{"users": {}, "rules": [
+			{"user": "*", "path": "/private/` + cafeNFC + `", "access": "deny"},
+			{"user": "*", "path": "/", "access": "read"}]}`,
+	})
+	_, h := newTestServer(t, Config{
+		RootDir:          root,
+		NormalizeUnicode: true,
+		DirPasswords:     true,
+		Deny:             []string{"/denied/" + cafeNFC},
+		UsersFile:        filepath.Join(root, "users.json"),
+	})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/open/" + cafeNFC + ".txt", http.StatusOK},
+		{"/open/" + cafeNFD + ".txt", http.StatusOK},
+		{"/open/" + cafeNFD + ".txt/", http.StatusMovedPermanently},
+		{"/missing/" + cafeNFD + ".txt", http.StatusNotFound},
+		{"/denied/" + cafeNFC + "/secret.txt", http.StatusNotFound},
+		{"/denied/" + cafeNFD + "/secret.txt", http.StatusNotFound},
+		{"/locked/" + cafeNFC + "/secret.txt", http.StatusUnauthorized},
+		{"/locked/" + cafeNFD + "/secret.txt", http.StatusUnauthorized},
+		// Anonymous, so asked to log in
+		{"/private/" + cafeNFC + "/secret.txt", http.StatusUnauthorized},
+		{"/private/" + cafeNFD + "/secret.txt", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := serve(h, http.MethodGet, urlPath(tt.path), nil)
+		if w.Code != tt.want {
+			t.Errorf("GET %q: status %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// handlePut stores the request body at requestPath with -uploads,
+// replacing any file there: 201 for a new file, 204 for a replaced one,
+// with the stored digest in contentSHA256Header. The body goes through
+// createAtomic, so readers never see it half written, and is checked
+// against the digest the client sent there, if any. Missing parent
+// directories are created. Once a write starts, its outcome goes to the
+// OnWrite hooks as op "upload".
+func (s *Server) handlePut(w http.ResponseWriter, r *http.Request, requestPath string) {
+	if strings.HasSuffix(requestPath, "/") {
+		httpError(w, r, areaRequest, http.StatusConflict, "Cannot upload to a directory", "PUT "+requestPath)
+		return
+	}
+	expect, err := parseContentSHA256(r.Header.Get(contentSHA256Header))
+	if err != nil {
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Invalid "+contentSHA256Header, err.Error())
+		return
+	}
+	limit := s.fileSizeLimit(requestPath)
+	if limit > 0 && r.ContentLength > limit {
+		httpError(w, r, areaRequest, http.StatusRequestEntityTooLarge, "File too large", fmt.Sprintf("%d bytes for %s", r.ContentLength, requestPath))
+		return
+	}
+
+	fullPath := s.fsPath(requestPath)
+	dir := filepath.Dir(fullPath)
+	existing, missing, err := s.uploadParent(dir)
+	switch {
+	case errors.Is(err, syscall.ENOTDIR):
+		httpError(w, r, areaRequest, http.StatusConflict, "Parent is not a directory", "PUT "+requestPath)
+		return
+	case errors.Is(err, errOutsideRoot):
+		httpError(w, r, areaRequest, http.StatusForbidden, "Access denied", fmt.Sprintf("upload to %s through a symlink leaving the root", requestPath))
+		return
+	case err != nil:
+		httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to write file", fmt.Sprintf("resolving %s: %v", dir, err))
+		return
+	}
+	if s.space != nil {
+		if err := s.space.check(existing, r.ContentLength); err != nil {
+			writeSpaceError(w, r, err)
+			return
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := s.mkdir(missing[i]); err != nil {
+			httpError(w, r, areaIO, http.StatusInternalServerError, "Failed to write file", err.Error())
+			return
+		}
+	}
+
+	existed := false
+	if info, err := os.Lstat(fullPath); err == nil {
+		if info.IsDir() {
+			httpError(w, r, areaRequest, http.StatusConflict, "Cannot upload to a directory", "PUT "+requestPath)
+			return
+		}
+		existed = true
+	}
+
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	body := s.uploadBody(w, r)
+
+	f, err := s.createAtomic(fullPath, expect)
+	if err != nil {
+		s.writeDone(r, "upload", fullPath, err)
+		writeFailed(w, r, requestPath, err)
+		return
+	}
+	var dst io.Writer = f
+	if s.space != nil {
+		dst = s.space.guardWriter(dir, f)
+	}
+	written, err := io.Copy(dst, body)
+	if err != nil {
+		f.abort()
+		s.writeDone(r, "upload", fullPath, err)
+		var tooLarge *http.MaxBytesError
+		var lowSpace *lowSpaceError
+		if errors.As(err, &lowSpace) {
+			writeSpaceError(w, r, err)
+			return
+		}
+		if errors.As(err, &tooLarge) {
+			httpError(w, r, areaRequest, http.StatusRequestEntityTooLarge, "File too large", fmt.Sprintf("over %d bytes for %s", limit, requestPath))
+			return
+		}
+		httpError(w, r, areaRequest, http.StatusBadRequest, "Upload incomplete", fmt.Sprintf("reading body for %s: %v", requestPath, err))
+		return
+	}
+	sum, err := f.commit()
+	s.writeDone(r, "upload", fullPath, err)
+	if err != nil {
+		writeFailed(w, r, requestPath, err)
+		return
+	}
+	reqLogf(r, levelInfo, areaIO, "Stored %s (%s)", requestPath, FormatSize(written))
+	w.Header().Set(contentSHA256Header, sum)
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+var errOutsideRoot = errors.New("path leaves the root")
+
+// uploadParent finds the deepest existing ancestor of dir, which must be a
+// directory under the root once symlinks are resolved, and the
+// directories below it still to create, deepest first.
+func (s *Server) uploadParent(dir string) (existing string, missing []string, err error) {
+	existing = dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return "", nil, syscall.ENOTDIR
+			}
+			break
+		}
+		if !os.IsNotExist(err) || existing == s.rootDir {
+			return "", nil, err
+		}
+		missing = append(missing, existing)
+		existing = filepath.Dir(existing)
+	}
+	realDir, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", nil, err
+	}
+	if !withinRoot(s.rootDir, realDir) {
+		return "", nil, errOutsideRoot
+	}
+	return existing, missing, nil
+}
+
+// mkdir creates a directory for an upload with -upload-dir-mode and
+// -upload-owner. One created meanwhile by a concurrent upload is fine.
+func (s *Server) mkdir(dir string) error {
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		if info, statErr := os.Stat(dir); statErr == nil && info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	return s.writePerms.apply(dir, true)
+}
+
+// uploadBody is the request body with the server's read timeout turned
+// into a deadline per read, like downloads get per write, so a long upload
+// that keeps making progress is not cut off.
+func (s *Server) uploadBody(w http.ResponseWriter, r *http.Request) io.Reader {
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		return r.Body
+	}
+	return &deadlineReader{r: r.Body, rc: rc, timeout: s.config.Timeouts.Read}
+}
+
+type deadlineReader struct {
+	r       io.Reader
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+	if d.timeout > 0 {
+		d.rc.SetReadDeadline(time.Now().Add(d.timeout))
+	}
+	return d.r.Read(p)
+}
@@ -0,0 +1,266 @@
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// put uploads body to target on h.
+func put(h http.Handler, target string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPut, target, body)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// tempFiles lists the upload temp files left in dir.
+func tempFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if isWriteTemp(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestPutDisabled(t *testing.T) {
+	_, h := newTestServer(t, Config{})
+	w := put(h, "/new.txt", strings.NewReader("data"), nil)
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != readMethods {
+		t.Errorf("PUT without -uploads: status %d, Allow %q", w.Code, w.Header().Get("Allow"))
+	}
+}
+
+func TestPut(t *testing.T) {
+	s, h := newTestServer(t, Config{Uploads: true})
+
+	if w := serve(h, http.MethodOptions, "/new.txt", nil); w.Header().Get("Allow") != uploadMethods {
+		t.Errorf("OPTIONS Allow = %q, want %q", w.Header().Get("Allow"), uploadMethods)
+	}
+	if w := put(h, "/new.txt", strings.NewReader("first"), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT new file: status %d: %s", w.Code, w.Body)
+	}
+	if w := put(h, "/new.txt", strings.NewReader("second"), nil); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT existing file: status %d: %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodGet, "/new.txt", nil); w.Body.String() != "second" {
+		t.Errorf("GET after PUT = %q, want %q", w.Body, "second")
+	}
+	if w := put(h, "/a/b/c.txt", strings.NewReader("nested"), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT below missing directories: status %d: %s", w.Code, w.Body)
+	}
+	if names := tempFiles(t, s.rootDir); names != nil {
+		t.Errorf("temp files left behind: %v", names)
+	}
+
+	// Without -upload-file-mode uploads get the umask's permissions
+	ref := filepath.Join(t.TempDir(), "ref")
+	if err := os.WriteFile(ref, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	refInfo, _ := os.Stat(ref)
+	info, _ := os.Stat(filepath.Join(s.rootDir, "new.txt"))
+	if info.Mode() != refInfo.Mode() {
+		t.Errorf("uploaded file mode %v, want %v", info.Mode(), refInfo.Mode())
+	}
+}
+
+func TestPutRefused(t *testing.T) {
+	outside := t.TempDir()
+	s, h := newTestServer(t, Config{Uploads: true, MaxFileSizeOverrides: map[string]int64{"/small": 4}})
+	writeFiles(t, s.rootDir, map[string]string{"dir/file.txt": "x", "small/.keep": ""})
+	if err := os.Symlink(outside, filepath.Join(s.rootDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/dir/", http.StatusConflict},
+		{"/dir", http.StatusConflict},
+		{"/dir/file.txt/child", http.StatusConflict},
+		{"/dir/file.txt/child/grandchild", http.StatusConflict},
+		{"/escape/file.txt", http.StatusForbidden},
+		{"/escape/new/file.txt", http.StatusForbidden},
+		{"/small/big.txt", http.StatusRequestEntityTooLarge},
+		{"/.fileserver-tmp-x", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := put(h, tt.path, strings.NewReader("too much"), nil); w.Code != tt.want {
+			t.Errorf("PUT %s: status %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Errorf("upload through a symlink wrote outside the root: %v", entries)
+	}
+}
+
+func TestPutConflict(t *testing.T) {
+	s, h := newTestServer(t, Config{Uploads: true})
+	fullPath := s.fsPath("/busy.txt")
+	if !s.writeLocks.tryLock(fullPath) {
+		t.Fatal("lock held before any write")
+	}
+	if w := put(h, "/busy.txt", strings.NewReader("data"), nil); w.Code != http.StatusConflict {
+		t.Errorf("PUT while another write holds the lock: status %d, want 409", w.Code)
+	}
+	s.writeLocks.unlock(fullPath)
+	if w := put(h, "/busy.txt", strings.NewReader("data"), nil); w.Code != http.StatusCreated {
+		t.Errorf("PUT after the lock is released: status %d, want 201", w.Code)
+	}
+}
+
+type failingReader struct{ sent bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		return copy(p, "partial"), nil
+	}
+	return 0, errors.New("connection reset")
+}
+
+func TestPutAborted(t *testing.T) {
+	s, h := newTestServer(t, Config{Uploads: true})
+	writeFiles(t, s.rootDir, map[string]string{"keep.txt": "original"})
+	if w := put(h, "/keep.txt", &failingReader{}, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("PUT with a broken body: status %d, want 400", w.Code)
+	}
+	if data, _ := os.ReadFile(filepath.Join(s.rootDir, "keep.txt")); string(data) != "original" {
+		t.Errorf("aborted upload replaced the file with %q", data)
+	}
+	if names := tempFiles(t, s.rootDir); names != nil {
+		t.Errorf("temp files left behind: %v", names)
+	}
+}
+
+func TestPutAccess(t *testing.T) {
+	root := t.TempDir()
+	token := func(name string) string {
+		sum := sha256.Sum256([]byte(name))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	writeFiles(t, root, map[string]string{
+		"incoming/.keep": "",
+		"users.json": `{"users": {"writer": {"tokens": ["` + token("w") + `"]}, "reader": {"tokens": ["` + token("r") + `"]}},
+			"rules": [{"user": "writer", "path": "/incoming", "access": "write"},
+				{"user": "*", "path": "/", "access": "read"}]}`,
+	})
+	_, h := newTestServer(t, Config{RootDir: root, Uploads: true, UsersFile: filepath.Join(root, "users.json")})
+
+	tests := []struct {
+		token, path string
+		want        int
+	}{
+		{"", "/incoming/a.txt", http.StatusUnauthorized},
+		{"r", "/incoming/a.txt", http.StatusForbidden},
+		{"w", "/a.txt", http.StatusForbidden},
+		{"w", "/incoming/a.txt", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.token != "" {
+			header.Set("Authorization", "Bearer "+tt.token)
+		}
+		if w := put(h, tt.path, strings.NewReader("data"), header); w.Code != tt.want {
+			t.Errorf("PUT %s as %q: status %d, want %d", tt.path, tt.token, w.Code, tt.want)
+		}
+	}
+}
+
+func digestHeader(value string) http.Header {
+	header := http.Header{}
+	header.Set(contentSHA256Header, value)
+	return header
+}
+
+func TestPutChecksum(t *testing.T) {
+	s, h := newTestServer(t, Config{Uploads: true})
+	sum := sha256.Sum256([]byte("payload"))
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 64)
+
+	w := put(h, "/f.bin", strings.NewReader("payload"), digestHeader(strings.ToUpper(good)))
+	if w.Code != http.StatusCreated || w.Header().Get(contentSHA256Header) != good {
+		t.Fatalf("PUT with matching digest: status %d, digest %q", w.Code, w.Header().Get(contentSHA256Header))
+	}
+	fullPath := s.fsPath("/f.bin")
+	info, _ := os.Stat(fullPath)
+	if cached, ok := s.digests.cached(fullPath, info); !ok || cached != good {
+		t.Errorf("digest cache after upload = %q, %v", cached, ok)
+	}
+
+	w = put(h, "/f.bin", strings.NewReader("tampered"), digestHeader(good))
+	var reply errorReply
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); w.Code != http.StatusUnprocessableEntity || err != nil {
+		t.Fatalf("PUT with mismatching digest: status %d, body %s", w.Code, w.Body)
+	}
+	tampered := sha256.Sum256([]byte("tampered"))
+	if reply.ExpectedSHA256 != good || reply.ActualSHA256 != hex.EncodeToString(tampered[:]) {
+		t.Errorf("mismatch reply has expected %q, actual %q", reply.ExpectedSHA256, reply.ActualSHA256)
+	}
+	if data, _ := os.ReadFile(fullPath); string(data) != "payload" {
+		t.Errorf("mismatching upload replaced the file with %q", data)
+	}
+	if names := tempFiles(t, s.rootDir); names != nil {
+		t.Errorf("temp files left behind: %v", names)
+	}
+
+	for _, value := range []string{"abc", bad + "0", strings.Repeat("g", 64)} {
+		if w := put(h, "/f.bin", strings.NewReader("x"), digestHeader(value)); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT with digest %q: status %d, want 400", value, w.Code)
+		}
+	}
+}
+
+func TestPutPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix permissions")
+	}
+	s, h := newTestServer(t, Config{Uploads: true, UploadFileMode: 0o640, UploadDirMode: 0o750})
+	if err := os.Mkdir(filepath.Join(s.rootDir, "existing"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if w := put(h, "/existing/new/deeper/f.txt", strings.NewReader("data"), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d: %s", w.Code, w.Body)
+	}
+
+	tests := []struct {
+		path string
+		want os.FileMode
+	}{
+		{"existing", os.ModeDir | 0o755},
+		{"existing/new", os.ModeDir | 0o750},
+		{"existing/new/deeper", os.ModeDir | 0o750},
+		{"existing/new/deeper/f.txt", 0o640},
+	}
+	for _, tt := range tests {
+		info, err := os.Stat(filepath.Join(s.rootDir, tt.path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != tt.want {
+			t.Errorf("mode of %s = %v, want %v", tt.path, info.Mode(), tt.want)
+		}
+	}
+}
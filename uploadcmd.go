@@ -0,0 +1,99 @@
+package fileserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultOnUploadConcurrency = 2
+	DefaultOnUploadTimeout     = 10 * time.Minute
+)
+
+// uploadCmd runs -on-upload-cmd after each successful upload, in the
+// background: the response never waits for it. At most concurrency run at
+// once; the rest wait their turn.
+type uploadCmd struct {
+	path    string
+	timeout time.Duration
+	slots   chan struct{}
+
+	runs     atomic.Int64
+	failures atomic.Int64
+}
+
+func newUploadCmd(command string, concurrency int, timeout time.Duration) (*uploadCmd, error) {
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find -on-upload-cmd: %v", err)
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultOnUploadConcurrency
+	}
+	if timeout <= 0 {
+		timeout = DefaultOnUploadTimeout
+	}
+	return &uploadCmd{path: path, timeout: timeout, slots: make(chan struct{}, concurrency)}, nil
+}
+
+// hook is the WriteHook starting the command for each successful upload.
+func (c *uploadCmd) hook(s *Server) WriteHook {
+	return func(r *http.Request, op, rel string, err error) {
+		if op != "upload" || err != nil {
+			return
+		}
+		fullPath := s.fsPath(rel)
+		go c.run(r, fullPath, rel)
+	}
+}
+
+// run calls the command as "cmd ABSPATH RELPATH", with the same and the
+// size, uploader and request ID in FILESERVER_UPLOAD_* variables. Its
+// output goes to the log under the upload's request ID.
+func (c *uploadCmd) run(r *http.Request, fullPath, rel string) {
+	c.slots <- struct{}{}
+	defer func() { <-c.slots }()
+	c.runs.Add(1)
+
+	var size int64
+	if info, err := os.Stat(fullPath); err == nil {
+		size = info.Size()
+	}
+	info := getRequestInfo(r)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, c.path, fullPath, rel)
+	cmd.Env = append(os.Environ(),
+		"FILESERVER_UPLOAD_PATH="+fullPath,
+		"FILESERVER_UPLOAD_REL="+rel,
+		"FILESERVER_UPLOAD_SIZE="+strconv.FormatInt(size, 10),
+		"FILESERVER_UPLOAD_USER="+info.identity,
+		"FILESERVER_REQUEST_ID="+info.id,
+	)
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	err := cmd.Run()
+
+	name := filepath.Base(c.path)
+	lines := bufio.NewScanner(&output)
+	for lines.Scan() {
+		reqLogf(r, levelInfo, areaServer, "%s: %s", name, lines.Text())
+	}
+	if ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", c.timeout)
+	}
+	if err != nil {
+		c.failures.Add(1)
+		reqLogf(r, levelWarn, areaServer, "Failed to run upload command for %s: %v", rel, err)
+	}
+}
@@ -0,0 +1,50 @@
+package fileserver
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestOnUploadCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\nif [ \"$2\" = /fail.txt ]; then exit 1; fi\n" +
+		"echo \"$1|$2|$FILESERVER_UPLOAD_SIZE|$FILESERVER_UPLOAD_USER\" > " + out + ".tmp && mv " + out + ".tmp " + out + "\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s, h := newTestServer(t, Config{
+		Uploads:     true,
+		OnUploadCmd: script,
+		Auth:        func(w http.ResponseWriter, r *http.Request) (string, bool) { return "tester", true },
+	})
+
+	if w := put(h, "/in/clip.mp4", strings.NewReader("12345"), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d", w.Code)
+	}
+	var got []byte
+	waitFor(t, "the upload command", func() bool {
+		got, _ = os.ReadFile(out)
+		return got != nil
+	})
+	want := s.fsPath("/in/clip.mp4") + "|/in/clip.mp4|5|tester\n"
+	if string(got) != want {
+		t.Errorf("upload command saw %q, want %q", got, want)
+	}
+
+	if w := put(h, "/fail.txt", strings.NewReader("x"), nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT: status %d", w.Code)
+	}
+	waitFor(t, "the failure to be counted", func() bool { return s.uploadCmd.failures.Load() == 1 })
+	if runs := s.uploadCmd.runs.Load(); runs != 2 {
+		t.Errorf("upload command ran %d times, want 2", runs)
+	}
+}
@@ -0,0 +1,93 @@
+package fileserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var hrefPattern = regexp.MustCompile(`href="([^"]*)"`)
+
+// hrefs lists the links in an HTML page, leaving out the stylesheets.
+func hrefs(body string) []string {
+	var links []string
+	for _, m := range hrefPattern.FindAllStringSubmatch(body, -1) {
+		if !strings.HasPrefix(m[1], DefaultAssetsPrefix) {
+			links = append(links, m[1])
+		}
+	}
+	return links
+}
+
+func TestListingLinks(t *testing.T) {
+	s, h := newTestServer(t, Config{})
+	writeFiles(t, s.rootDir, map[string]string{
+		"a/b/c.txt":    "",
+		"a/b/x#1?.txt": "",
+		"a/b/50%.txt":  "",
+		"a/b/d/e.txt":  "",
+	})
+
+	body := serve(h, http.MethodGet, "/a/b/", nil).Body.String()
+	want := []string{"/a/", "/a/b/50%25.txt", "/a/b/c.txt", "/a/b/d/", "/a/b/x%231%3F.txt"}
+	got := hrefs(body)
+	for _, link := range want {
+		if !strings.Contains(strings.Join(got, " "), link) {
+			t.Errorf("listing links %v lack %s", got, link)
+		}
+	}
+	for _, link := range got {
+		if strings.Contains(link, `\`) {
+			t.Errorf("listing link %q has a backslash", link)
+		}
+	}
+
+	var reply struct {
+		Data struct {
+			Entries []apiEntry `json:"entries"`
+		} `json:"data"`
+	}
+	w := serve(h, http.MethodGet, apiV1Prefix+"list?path=/a/b", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &reply); err != nil || len(reply.Data.Entries) != 4 {
+		t.Fatalf("JSON listing: %v: %s", err, w.Body)
+	}
+	for _, e := range reply.Data.Entries {
+		if want := "/a/b/" + e.Name; e.Path != want {
+			t.Errorf("JSON path %q, want %q", e.Path, want)
+		}
+	}
+}
+
+// TestBackslashNames checks that a backslash never separates directories
+// in a request: on Unix it is part of a name, on Windows it is refused.
+func TestBackslashNames(t *testing.T) {
+	s, h := newTestServer(t, Config{})
+	writeFiles(t, s.rootDir, map[string]string{"a/b/c.txt": "nested"})
+
+	// Must not resolve to /a/b/c.txt
+	w := serve(h, http.MethodGet, "/a%5Cb%5Cc.txt", nil)
+	if w.Body.String() == "nested" {
+		t.Fatalf("backslashes were taken as separators")
+	}
+	if runtime.GOOS == "windows" {
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("escaped backslash: status %d, want 400", w.Code)
+		}
+		return
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("escaped backslash: status %d, want 404", w.Code)
+	}
+
+	writeFiles(t, s.rootDir, map[string]string{filepath.Join(`back\slash`, "f.txt"): "x"})
+	body := serve(h, http.MethodGet, "/back%5Cslash/", nil).Body.String()
+	links := hrefs(body)
+	if !slices.Contains(links, "/") || !slices.Contains(links, "/back%5Cslash/f.txt") {
+		t.Errorf("links in a directory named with a backslash = %q, want the parent / and /back%%5Cslash/f.txt", links)
+	}
+}
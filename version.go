@@ -0,0 +1,73 @@
+package fileserver
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set at build time, e.g.
+// -ldflags "-X schrojf/fileserver.version=1.2.0 -X schrojf/fileserver.commit=abc1234".
+// Anything left empty is filled from the module build info.
+var (
+	version   = ""
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildInfo identifies the running build.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+// Build returns the version information injected at build time, falling
+// back to the module build info.
+func Build() BuildInfo {
+	b := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" && info.Main.Version != "(devel)" {
+			b.Version = strings.TrimPrefix(info.Main.Version, "v")
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && b.Commit == "":
+				b.Commit = setting.Value
+			case setting.Key == "vcs.time" && b.BuildDate == "":
+				b.BuildDate = setting.Value
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "dev"
+	}
+	if len(b.Commit) > 12 {
+		b.Commit = b.Commit[:12]
+	}
+	return b
+}
+
+func (b BuildInfo) String() string {
+	s := "fileserver " + b.Version
+	var details []string
+	if b.Commit != "" {
+		details = append(details, "commit "+b.Commit)
+	}
+	if b.BuildDate != "" {
+		details = append(details, "built "+b.BuildDate)
+	}
+	details = append(details, b.GoVersion)
+	return s + " (" + strings.Join(details, ", ") + ")"
+}
+
+// serverHeader advertises the build in the Server response header.
+func serverHeader(next http.Handler) http.Handler {
+	value := "fileserver/" + Build().Version
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", value)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,119 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// VirtualHost serves Root to requests for Host instead of the default
+// root. UsersFile and Deny replace the server's when set.
+type VirtualHost struct {
+	Host      string
+	Root      string
+	UsersFile string
+	Deny      []string // nil keeps Config.Deny
+}
+
+// normalizeHost lowercases a Host header and strips its port, IPv6
+// brackets and any trailing dot, so "Media.LAN.:8080" is "media.lan".
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+// newVirtualHosts builds a Server per virtual host from base, the
+// configuration as given to NewServerFromConfig. Each has its own root,
+// path checks, mount monitor, users file and deny list; listeners,
+// profiling, tracing, the access log and the persisted state files
+// belong to parent alone.
+func newVirtualHosts(base Config, parent *Server) (map[string]*Server, error) {
+	vhosts := make(map[string]*Server, len(base.VirtualHosts))
+	for _, vh := range base.VirtualHosts {
+		host := normalizeHost(vh.Host)
+		if host == "" || vh.Root == "" {
+			return nil, fmt.Errorf("invalid virtual host %q, want HOST=ROOT", vh.Host+"="+vh.Root)
+		}
+		if vhosts[host] != nil {
+			return nil, fmt.Errorf("virtual host %s given twice", host)
+		}
+		cfg := base
+		cfg.RootDir = vh.Root
+		cfg.VirtualHosts, cfg.VhostStrict = nil, false
+		if vh.UsersFile != "" {
+			cfg.UsersFile = vh.UsersFile
+		}
+		if vh.Deny != nil {
+			cfg.Deny = vh.Deny
+		}
+		cfg.Listeners, cfg.HealthAddr, cfg.HealthListener = nil, "", nil
+		cfg.Pprof, cfg.PprofAddr, cfg.OTelEndpoint, cfg.OpenBrowser = false, "", "", false
+		cfg.AccessLogFile, cfg.StatsFile, cfg.ChecksumCacheFile, cfg.DirCountsFile = "", "", "", ""
+		cfg.Bandwidth, cfg.BandwidthFile = false, ""
+		v, err := NewServerFromConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("virtual host %s: %v", host, err)
+		}
+		v.vhost = host
+		v.accessLogger, v.tracer = parent.accessLogger, parent.tracer
+		vhosts[host] = v
+	}
+	return vhosts, nil
+}
+
+// routeVirtualHosts hands requests for a virtual host to its server and
+// the rest to next, the default root.
+func (s *Server) routeVirtualHosts(next http.Handler) http.Handler {
+	handlers := make(map[string]http.Handler, len(s.vhosts))
+	for host, v := range s.vhosts {
+		handlers[host] = v.Handler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := handlers[normalizeHost(r.Host)]; h != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectUnknownHosts answers requests that matched no virtual host with
+// 421 under -vhost-strict. Health checks, which rarely send a known name,
+// pass.
+func (s *Server) rejectUnknownHosts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" {
+			httpError(w, r, areaRequest, http.StatusMisdirectedRequest, "Unknown host", fmt.Sprintf("no virtual host %q", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// virtualHostNames lists the virtual hosts in order, for the banner.
+func (s *Server) virtualHostNames() []string {
+	names := make([]string, 0, len(s.vhosts))
+	for host := range s.vhosts {
+		names = append(names, host)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stopVirtualHosts stops the virtual hosts' background work on shutdown;
+// their requests drain with the parent's listeners.
+func (s *Server) stopVirtualHosts() {
+	for _, v := range s.vhosts {
+		v.draining.Store(true)
+		if v.stopBackground != nil {
+			v.stopBackground()
+		}
+	}
+	for _, v := range s.vhosts {
+		v.backgroundDone.Wait()
+	}
+}
@@ -0,0 +1,98 @@
+package fileserver
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// writePerms sets the mode and owner of files and directories the server
+// creates, per -upload-file-mode, -upload-dir-mode and -upload-owner,
+// instead of leaving them to the umask. Uploads apply it to every file and
+// directory they create, including parents made implicitly.
+type writePerms struct {
+	fileMode, dirMode fs.FileMode // 0: leave as created
+	uid, gid          int         // -1: leave as created
+
+	chownWarned sync.Once
+}
+
+func newWritePerms(fileMode, dirMode fs.FileMode, owner string) (*writePerms, error) {
+	if fileMode&^fs.ModePerm != 0 || dirMode&^fs.ModePerm != 0 {
+		return nil, fmt.Errorf("upload modes may only hold permission bits: %v, %v", fileMode, dirMode)
+	}
+	if fileMode == 0 && dirMode == 0 && owner == "" {
+		return nil, nil
+	}
+	p := &writePerms{fileMode: fileMode, dirMode: dirMode, uid: -1, gid: -1}
+	if owner != "" {
+		var err error
+		if p.uid, p.gid, err = lookupOwner(owner); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// lookupOwner resolves "user", "user:group" or ":group", by name or
+// numeric ID.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+	uid, gid = -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return 0, 0, fmt.Errorf("unknown upload owner %q", userName)
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("upload owner %q has no numeric ID", userName)
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return 0, 0, fmt.Errorf("unknown upload group %q", groupName)
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("upload group %q has no numeric ID", groupName)
+		}
+	}
+	if uid == -1 && gid == -1 {
+		return 0, 0, fmt.Errorf("invalid upload owner %q, want user:group", owner)
+	}
+	return uid, gid, nil
+}
+
+// apply sets the configured mode and owner on a newly created path. A
+// failed chown, usually for lack of privileges, is logged once and
+// otherwise ignored so it doesn't fail every write.
+func (p *writePerms) apply(path string, isDir bool) error {
+	if p == nil {
+		return nil
+	}
+	mode := p.fileMode
+	if isDir {
+		mode = p.dirMode
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %v", path, err)
+		}
+	}
+	if p.uid != -1 || p.gid != -1 {
+		if err := os.Lchown(path, p.uid, p.gid); err != nil {
+			p.chownWarned.Do(func() {
+				warnf(areaServer, "Failed to set owner of uploads (further failures are not logged): %v", err)
+			})
+		}
+	}
+	return nil
+}